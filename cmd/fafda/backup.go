@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/backup"
+	"fafda/internal/github"
+)
+
+// backupCmd uploads a metadata snapshot right away.
+func backupCmd(cfg *config.Config, st *storage) int {
+	if st.db == nil {
+		log.Error().Msg("backups need the bolt store, nothing to back up")
+		return 2
+	}
+
+	store, err := github.NewBackupStore(cfg.Backup.Release)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load backup store")
+		return 2
+	}
+
+	snapshot, err := backup.Backup(st.db, store, cfg.Backup.Passphrase, cfg.Backup.Keep)
+	if err != nil {
+		log.Error().Err(err).Msg("backup failed")
+		return 1
+	}
+	fmt.Println(snapshot)
+	return 0
+}
+
+// restoreCmd replaces the local db with the newest snapshot.
+func restoreCmd(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	output := flags.String("o", dbFile(cfg), "where to write the restored db")
+	_ = flags.Parse(args)
+
+	store, err := github.NewBackupStore(cfg.Backup.Release)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load backup store")
+		return 2
+	}
+
+	snapshot, err := backup.Restore(store, cfg.Backup.Passphrase, *output)
+	if err != nil {
+		log.Error().Err(err).Msg("restore failed")
+		return 1
+	}
+	log.Info().Str("snapshot", snapshot).Str("db", *output).Msg("metadata restored")
+	return 0
+}
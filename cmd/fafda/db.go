@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal/bolt"
+)
+
+// dbCmd reports bucket usage of the bolt db or compacts it, a running
+// server does the same through its admin endpoints.
+func dbCmd(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: db stats|compact")
+		return 2
+	}
+	if dbType(cfg) != dbTypeBolt {
+		log.Error().Msgf("db only supports the bolt store, not %s", dbType(cfg))
+		return 2
+	}
+
+	switch args[0] {
+	case "stats":
+		return dbStats(cfg, args[1:])
+	case "compact":
+		return dbCompact(cfg)
+	}
+	log.Error().Msgf("unknown db command %q", args[0])
+	return 2
+}
+
+func dbStats(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("db stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the stats as JSON")
+	_ = flags.Parse(args)
+
+	db, err := bbolt.Open(dbFile(cfg), 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open bolt, is the server running?")
+		return 2
+	}
+	defer db.Close()
+
+	stats, err := bolt.ReadStats(db)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read stats")
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(stats)
+	} else {
+		err = stats.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write stats")
+		return 2
+	}
+	return 0
+}
+
+func dbCompact(cfg *config.Config) int {
+	opts := &bbolt.Options{Timeout: time.Second}
+	db, err := bbolt.Open(dbFile(cfg), 0600, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open bolt, is the server running?")
+		return 2
+	}
+	handle := bolt.NewHandle(db, opts)
+	defer handle.Close()
+
+	result, err := handle.Compact()
+	if err != nil {
+		log.Error().Err(err).Msg("compaction failed")
+		return 1
+	}
+	log.Info().
+		Int64("before", result.Before).
+		Int64("after", result.After).
+		Dur("took", result.Took).
+		Msg("db compacted")
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/quota"
+	"fafda/internal/versions"
+)
+
+type duEntry struct {
+	Path string `json:"path"`
+	internal.Usage
+}
+
+// duCmd prints what a directory and the ones below it take up, read from
+// the usage the store keeps so nothing is walked. With -quotas it prints
+// the configured quotas instead.
+func duCmd(cfg *config.Config, st *storage, args []string) int {
+	flags := flag.NewFlagSet("du", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the usage as JSON")
+	depth := flags.Int("depth", 0, "how many levels of directories below path to print")
+	quotas := flags.Bool("quotas", false, "print the configured quotas and their usage")
+	_ = flags.Parse(args)
+	if flags.NArg() > 1 {
+		log.Error().Msg("usage: du [-json] [-depth n] [-quotas] [path]")
+		return 2
+	}
+	if st.usage == nil {
+		log.Error().Msg("usage accounting only supports the bolt store")
+		return 2
+	}
+	root := "/"
+	if flags.NArg() == 1 {
+		root = path.Clean("/" + flags.Arg(0))
+	}
+
+	var out any
+	var err error
+	if *quotas {
+		out, err = quota.New(versions.Usage(st.usage), cfg.Quotas).Report()
+	} else {
+		out, err = duWalk(st, root, *depth)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", root).Msg("failed to read usage")
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(out)
+	} else {
+		err = duWrite(out)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write usage")
+		return 2
+	}
+	return 0
+}
+
+// duWalk returns the usage of root and of the directories below it down
+// to depth, deepest first like du prints them.
+func duWalk(st *storage, root string, depth int) ([]duEntry, error) {
+	usage, err := st.usage.Usage(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []duEntry
+	if depth > 0 {
+		nodes, err := st.metafs.Ls(root, 0, 0)
+		if err != nil && !errors.Is(err, internal.ErrIsNotDir) {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if !node.IsDir() {
+				continue
+			}
+			below, err := duWalk(st, node.Path(), depth-1)
+			// Virtual directories like /.snapshots take up nothing
+			if errors.Is(err, internal.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, below...)
+		}
+	}
+	return append(entries, duEntry{Path: root, Usage: usage}), nil
+}
+
+func duWrite(out any) error {
+	switch out := out.(type) {
+	case []duEntry:
+		for _, e := range out {
+			if _, err := fmt.Printf("%14d bytes %8d files %6d dirs  %s\n", e.Bytes, e.Files, e.Dirs, e.Path); err != nil {
+				return err
+			}
+		}
+	case []quota.Status:
+		for _, s := range out {
+			if _, err := fmt.Printf(
+				"%14d/%-14s bytes %8d/%-8s files  %s\n",
+				s.Usage.Bytes, limitText(s.MaxBytes), s.Usage.Files+s.Usage.Dirs, limitText(s.MaxFiles), s.Path,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func limitText(limit int64) string {
+	if limit == 0 {
+		return "-"
+	}
+	return fmt.Sprint(limit)
+}
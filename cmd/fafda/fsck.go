@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal/bolt"
+)
+
+// fsckCmd checks the bolt db for records that don't add up, the exit code
+// is 1 when problems were found and left in place. The db is opened for
+// writing either way, a database still to be migrated is checked in a
+// migration that is rolled back without -repair.
+func fsckCmd(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "drop dangling records and move orphans to /lost+found")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	_ = flags.Parse(args)
+
+	if dbType(cfg) != dbTypeBolt {
+		log.Error().Msgf("fsck only supports the bolt store, not %s", dbType(cfg))
+		return 2
+	}
+
+	db, err := bbolt.Open(dbFile(cfg), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open bolt, is the server running?")
+		return 2
+	}
+	defer db.Close()
+
+	report, err := bolt.Fsck(db, bolt.FsckOptions{Repair: *repair})
+	if err != nil {
+		log.Error().Err(err).Msg("fsck failed")
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write report")
+		return 2
+	}
+
+	if !report.Repaired() {
+		return 1
+	}
+	return 0
+}
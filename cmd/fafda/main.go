@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -10,15 +11,19 @@ import (
 
 	zl "github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"go.etcd.io/bbolt"
+	"github.com/spf13/afero"
 
 	"fafda/config"
 	"fafda/internal"
-	"fafda/internal/bolt"
+	"fafda/internal/backup"
 	"fafda/internal/filesystem"
 	"fafda/internal/ftp"
 	"fafda/internal/github"
 	"fafda/internal/http"
+	"fafda/internal/quota"
+	"fafda/internal/scrub"
+	"fafda/internal/trash"
+	"fafda/internal/versions"
 )
 
 const name = "fafda"
@@ -28,6 +33,7 @@ var (
 	showVersion  = flag.Bool("version", false, "print version information and exit")
 	configFile   = flag.String("config", "", "path to nefarious configuration file")
 	listReleases = flag.String("list-releases", "", "comma-separated list of GitHub tokens to fetch releases information")
+	memoryMode   = flag.Bool("memory", false, "serve an ephemeral in-memory filesystem, nothing is persisted")
 )
 
 func main() {
@@ -63,37 +69,109 @@ func main() {
 		log.Fatal().Err(err).Msgf("failed to load config")
 	}
 
-	dbFile := cfg.DBFile
-	if dbFile == "" {
-		dbFile = name + ".db"
+	// These write a new db and must not hold a lock on the current one
+	switch flag.Arg(0) {
+	case "restore":
+		os.Exit(restoreCmd(cfg, flag.Args()[1:]))
+	case "rebuild-metadata":
+		os.Exit(rebuildCmd(cfg, flag.Args()[1:]))
+	case "meta":
+		os.Exit(metaCmd(cfg, flag.Args()[1:]))
+	case "migrate":
+		os.Exit(migrateCmd(cfg, flag.Args()[1:]))
+	case "fsck":
+		os.Exit(fsckCmd(cfg, flag.Args()[1:]))
+	case "db":
+		os.Exit(dbCmd(cfg, flag.Args()[1:]))
 	}
 
-	db, err := bbolt.Open(dbFile, 0600, nil)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("failed to open bolt")
+	st := openStorage(cfg)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "", "serve":
+		serve(cfg, st)
+	case "scrub":
+		os.Exit(scrubCmd(st, flag.Args()[1:]))
+	case "backup":
+		os.Exit(backupCmd(cfg, st))
+	case "trash":
+		os.Exit(trashCmd(cfg, st, flag.Args()[1:]))
+	case "versions":
+		os.Exit(versionsCmd(cfg, st, flag.Args()[1:]))
+	case "snapshot":
+		os.Exit(snapshotCmd(cfg, st, flag.Args()[1:]))
+	case "du":
+		os.Exit(duCmd(cfg, st, flag.Args()[1:]))
+	default:
+		log.Fatal().Msgf("unknown command %q", cmd)
 	}
+}
 
-	metafs, err := bolt.NewMetaFs(db)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("failed to open bolt data provider")
+func serve(cfg *config.Config, st *storage) {
+	if cfg.Scrub.Interval > 0 {
+		go scrub.Schedule(context.Background(), cfg.Scrub.Interval, st.metafs, st.driver, scrub.Options{Deep: cfg.Scrub.Deep})
 	}
 
-	driver, err := github.NewDriver(cfg.GitHub, db)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("failed to load github driver")
+	if cfg.Backup.Interval > 0 && st.db == nil {
+		log.Warn().Msg("scheduled backups only support the bolt store, skipping")
+	}
+	if cfg.Backup.Interval > 0 && st.db != nil {
+		store, err := github.NewBackupStore(cfg.Backup.Release)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to load backup store")
+		}
+		go backup.Schedule(context.Background(), cfg.Backup.Interval, st.db, store, cfg.Backup.Passphrase, cfg.Backup.Keep)
+	}
+
+	var vs *versions.Store
+	var versioner internal.Versioner
+	if cfg.Versions.Keep > 0 || cfg.Versions.Window > 0 {
+		var err error
+		if vs, err = versions.New(st.metafs, st.driver, cfg.Versions.Keep, cfg.Versions.Window); err != nil {
+			log.Fatal().Err(err).Msgf("failed to enable versioning")
+		}
+		versioner = vs
+		go vs.Schedule(context.Background(), 24*time.Hour)
+	}
+
+	var quotas *quota.Quotas
+	var limiter internal.Quota
+	if len(cfg.Quotas) > 0 && st.usage == nil {
+		log.Warn().Msg("quotas only support the bolt store, skipping")
+	}
+	if st.usage != nil {
+		quotas = quota.New(versions.Usage(st.usage), cfg.Quotas)
+	}
+	if len(cfg.Quotas) > 0 && quotas != nil {
+		limiter = quotas
 	}
 
-	fs := filesystem.New(driver, metafs)
+	// Versions are only reached through the store, even once it is off
+	var meta internal.MetaFileSystem = versions.Hide(st.metafs)
+	fs := filesystem.New(st.driver, meta, versioner, limiter)
+	newFs := func(config.FTPUser) afero.Fs { return fs }
+
+	var tr *trash.Trash
+	if cfg.Trash.Retention > 0 {
+		tr = trash.New(meta, st.driver, cfg.Trash.Retention)
+		go tr.Schedule(context.Background(), min(cfg.Trash.Retention, time.Hour))
+		newFs = func(u config.FTPUser) afero.Fs {
+			return filesystem.New(st.driver, tr.For(u.Username, u.Uid, u.Gid), versioner, limiter)
+		}
+		// HTTP has no user of its own, it doesn't get to see any trash
+		meta = tr.For("", 0, 0)
+		fs = filesystem.New(st.driver, meta, versioner, limiter)
+	}
 
 	if cfg.HTTPServer.Addr != "" {
 		go func() {
-			if err := http.Serv(cfg.HTTPServer, fs); err != nil {
+			if err := http.Serv(cfg.HTTPServer, cfg.FTPServer.Users, fs, meta, st.db, tr, vs, st.snaps, quotas); err != nil {
 				log.Fatal().Err(err).Msgf("failed to start http server")
 			}
 		}()
 	}
 
-	if err := ftp.Serv(cfg.FTPServer, fs); err != nil {
+	if err := ftp.Serv(cfg.FTPServer, newFs); err != nil {
 		log.Fatal().Err(err).Msgf("failed to start ftp server")
 	}
 }
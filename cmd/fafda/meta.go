@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/metaio"
+)
+
+// metaCmd exports, imports or converts the namespace and asset map.
+func metaCmd(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: meta export|import|convert")
+		return 2
+	}
+
+	switch args[0] {
+	case "export":
+		return metaExport(cfg, args[1:])
+	case "import":
+		return metaImport(cfg, args[1:])
+	case "convert":
+		return metaConvert(cfg, args[1:])
+	}
+	log.Error().Msgf("unknown meta command %q", args[0])
+	return 2
+}
+
+func metaExport(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("meta export", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write, - for stdout")
+	_ = flags.Parse(args)
+
+	ms, err := openMeta(dbType(cfg), dbFile(cfg), false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open metadata store")
+		return 2
+	}
+	defer ms.metafs.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create output")
+			return 2
+		}
+		defer file.Close()
+		w = file
+	}
+
+	stats, err := metaio.Export(w, ms.metafs, ms.assets)
+	if err != nil {
+		log.Error().Err(err).Msg("export failed")
+		return 1
+	}
+	log.Info().Int("nodes", stats.Nodes).Int("assets", stats.Assets).Msg("metadata exported")
+	return 0
+}
+
+func metaImport(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("meta import", flag.ExitOnError)
+	input := flags.String("i", "-", "file to read, - for stdin")
+	output := flags.String("o", dbFile(cfg), "db to import into, must be new or empty")
+	typ := flags.String("type", dbType(cfg), "type of the db to import into, bolt or sqlite")
+	_ = flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to open input")
+			return 2
+		}
+		defer file.Close()
+		r = file
+	}
+
+	ms, err := openMeta(*typ, *output, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open metadata store")
+		return 2
+	}
+	defer ms.metafs.Close()
+
+	stats, err := metaio.Import(r, ms.metafs, ms.assets)
+	if err != nil {
+		log.Error().Err(err).Int("nodes", stats.Nodes).Msg("import failed")
+		return 1
+	}
+	log.Info().Int("nodes", stats.Nodes).Int("assets", stats.Assets).Str("db", *output).Msg("metadata imported")
+	return 0
+}
+
+// metaConvert copies the bolt store into a new SQLite database.
+func metaConvert(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("meta convert", flag.ExitOnError)
+	input := flags.String("i", dbFile(cfg), "bolt db to read")
+	output := flags.String("o", name+".sqlite", "sqlite db to create")
+	_ = flags.Parse(args)
+
+	if _, err := os.Stat(*output); err == nil {
+		log.Error().Str("db", *output).Msg("output already exists")
+		return 2
+	}
+
+	src, err := openMeta(dbTypeBolt, *input, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open bolt store")
+		return 2
+	}
+	defer src.metafs.Close()
+
+	dst, err := openMeta(dbTypeSQLite, *output, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open sqlite store")
+		return 2
+	}
+	defer dst.metafs.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := metaio.Export(pw, src.metafs, src.assets)
+		_ = pw.CloseWithError(err)
+	}()
+
+	stats, err := metaio.Import(pr, dst.metafs, dst.assets)
+	if err != nil {
+		log.Error().Err(err).Int("nodes", stats.Nodes).Msg("convert failed")
+		return 1
+	}
+	log.Info().
+		Int("nodes", stats.Nodes).
+		Int("assets", stats.Assets).
+		Str("db", *output).
+		Msg("converted, set dbType: sqlite and dbFile to use it")
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal/bolt"
+)
+
+// migrateCmd upgrades the db schema, serving does the same on startup.
+func migrateCmd(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "show pending migrations without applying them")
+	backup := flags.String("backup", dbFile(cfg)+".pre-migrate", "copy the db here first, empty to skip")
+	_ = flags.Parse(args)
+
+	if dbType(cfg) != dbTypeBolt {
+		fmt.Printf("nothing to do, the %s store upgrades its schema on open\n", dbType(cfg))
+		return 0
+	}
+
+	db, err := bbolt.Open(dbFile(cfg), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open bolt, is the server running?")
+		return 2
+	}
+	defer db.Close()
+
+	from, results, err := bolt.Migrate(db, bolt.MigrateOptions{DryRun: *dryRun, Backup: *backup})
+	for _, r := range results {
+		fmt.Printf("v%d %s: %d records changed\n", r.Version, r.Description, r.Changed)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("migration failed")
+		return 1
+	}
+
+	switch {
+	case len(results) == 0:
+		fmt.Printf("schema is at version %d, nothing to do\n", bolt.SchemaVersion())
+	case *dryRun:
+		fmt.Printf("dry run, schema left at version %d\n", from)
+	default:
+		fmt.Printf("schema migrated from version %d to %d\n", from, bolt.SchemaVersion())
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/github"
+)
+
+// rebuildCmd recovers metadata into a fresh db from the manifests stored
+// next to the parts, it never touches the current db.
+func rebuildCmd(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("rebuild-metadata", flag.ExitOnError)
+	output := flags.String("o", dbFile(cfg)+".rebuilt", "where to write the rebuilt db")
+	typ := flags.String("type", dbType(cfg), "type of the rebuilt db, bolt or sqlite")
+	_ = flags.Parse(args)
+
+	if _, err := os.Stat(*output); err == nil {
+		log.Error().Str("db", *output).Msg("output already exists")
+		return 2
+	}
+
+	ms, err := openMeta(*typ, *output, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open metadata store")
+		return 2
+	}
+	defer ms.metafs.Close()
+
+	driver, err := github.NewDriver(cfg.GitHub, ms.assets)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load github driver")
+		return 2
+	}
+
+	restored, err := driver.Rebuild(ms.metafs)
+	if err != nil {
+		log.Error().Err(err).Int("files", restored).Msg("rebuild failed")
+		return 1
+	}
+	log.Info().Int("files", restored).Str("db", *output).Msg("metadata rebuilt, point dbFile at it to use it")
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/internal/scrub"
+)
+
+// scrubCmd verifies every stored file and prints a report, the exit code
+// is 1 when any file is missing or corrupt.
+func scrubCmd(st *storage, args []string) int {
+	flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	deep := flags.Bool("deep", false, "download every file and verify its checksums")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	_ = flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := scrub.Run(ctx, st.metafs, st.driver, scrub.Options{Deep: *deep})
+	if err != nil {
+		log.Error().Err(err).Msg("scrub failed")
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write report")
+		return 2
+	}
+
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/bolt"
+	"fafda/internal/quota"
+	"fafda/internal/versions"
+)
+
+// snapshotCmd takes, lists, deletes or restores snapshots of the
+// namespace while the server is stopped, a running one does the same
+// through its admin endpoints.
+func snapshotCmd(cfg *config.Config, st *storage, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: snapshot create|list|delete|restore")
+		return 2
+	}
+	if st.snaps == nil {
+		log.Error().Msg("snapshots only support the bolt store")
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return snapshotCreate(st.snaps, args[1:])
+	case "list":
+		return snapshotList(st.snaps, args[1:])
+	case "delete":
+		return snapshotDelete(st.snaps, args[1:])
+	case "restore":
+		return snapshotRestore(cfg, st, args[1:])
+	}
+	log.Error().Msgf("unknown snapshot command %q", args[0])
+	return 2
+}
+
+func snapshotCreate(snaps *bolt.Snapshots, args []string) int {
+	if len(args) != 1 && len(args) != 2 {
+		log.Error().Msg("usage: snapshot create <name> [path]")
+		return 2
+	}
+	root := "/"
+	if len(args) == 2 {
+		root = args[1]
+	}
+
+	info, err := snaps.Create(args[0], root)
+	if err != nil {
+		log.Error().Err(err).Str("name", args[0]).Str("path", root).Msg("failed to create snapshot")
+		return 1
+	}
+	log.Info().
+		Str("name", info.Name).
+		Str("path", info.Path).
+		Int("files", info.Files).
+		Int("dirs", info.Dirs).
+		Int64("bytes", info.Bytes).
+		Msg("snapshot created")
+	return 0
+}
+
+func snapshotList(snaps *bolt.Snapshots, args []string) int {
+	flags := flag.NewFlagSet("snapshot list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the snapshots as JSON")
+	_ = flags.Parse(args)
+
+	infos, err := snaps.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list snapshots")
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(infos)
+	} else {
+		for _, info := range infos {
+			if _, err = fmt.Printf(
+				"%s  %-20s %8d files %6d dirs %12d bytes  %s\n",
+				info.Created.Format(time.RFC3339), info.Name, info.Files, info.Dirs, info.Bytes, info.Path,
+			); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write snapshots")
+		return 2
+	}
+	return 0
+}
+
+func snapshotDelete(snaps *bolt.Snapshots, args []string) int {
+	if len(args) != 1 {
+		log.Error().Msg("usage: snapshot delete <name>")
+		return 2
+	}
+	if err := snaps.Delete(args[0]); err != nil {
+		log.Error().Err(err).Str("name", args[0]).Msg("failed to delete snapshot")
+		return 1
+	}
+	log.Info().Str("name", args[0]).Msg("snapshot deleted")
+	return 0
+}
+
+func snapshotRestore(cfg *config.Config, st *storage, args []string) int {
+	flags := flag.NewFlagSet("snapshot restore", flag.ExitOnError)
+	sub := flags.String("path", "/", "path within the snapshot to restore")
+	to := flags.String("to", "", "where to restore to, must not exist yet (required)")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || *to == "" {
+		log.Error().Msg("usage: snapshot restore [-path sub] -to <target> <name>")
+		return 2
+	}
+
+	// The server holds restores against the quotas too
+	restored, err := st.snaps.Restore(flags.Arg(0), *sub, st.metafs, *to, quota.New(versions.Usage(st.usage), cfg.Quotas))
+	if err != nil {
+		log.Error().Err(err).Int("restored", restored).Str("name", flags.Arg(0)).Msg("failed to restore snapshot")
+		return 1
+	}
+	log.Info().Int("restored", restored).Str("name", flags.Arg(0)).Str("to", *to).Msg("snapshot restored")
+	return 0
+}
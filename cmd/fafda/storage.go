@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/cache"
+	"fafda/internal/github"
+	"fafda/internal/memory"
+	"fafda/internal/metaio"
+	"fafda/internal/sqlite"
+)
+
+const (
+	dbTypeBolt   = "bolt"
+	dbTypeSQLite = "sqlite"
+)
+
+type storage struct {
+	db     *bolt.Handle          // nil unless the bolt store is used
+	snaps  *bolt.Snapshots       // nil unless the bolt store is used
+	usage  internal.UsageCounter // nil unless the bolt store is used
+	metafs internal.MetaFileSystem
+	driver internal.StorageDriver
+}
+
+type metaAssets interface {
+	github.Assets
+	metaio.AssetStore
+}
+
+// metaStore is the namespace and asset map of one database file.
+type metaStore struct {
+	db     *bolt.Handle // nil for sqlite
+	metafs internal.MetaFileSystem
+	assets metaAssets
+}
+
+func dbType(cfg *config.Config) string {
+	if cfg.DBType == "" {
+		return dbTypeBolt
+	}
+	return cfg.DBType
+}
+
+func dbFile(cfg *config.Config) string {
+	if cfg.DBFile != "" {
+		return cfg.DBFile
+	}
+	if dbType(cfg) == dbTypeSQLite {
+		return name + ".sqlite"
+	}
+	return name + ".db"
+}
+
+// openMeta opens file as the given store type, bolt databases are
+// migrated first when migrate is set.
+func openMeta(typ, file string, migrate bool) (*metaStore, error) {
+	switch typ {
+	case dbTypeBolt:
+		opts := &bbolt.Options{Timeout: time.Second}
+		db, err := bbolt.Open(file, 0600, opts)
+		if err != nil {
+			return nil, fmt.Errorf("open bolt, is the server running? %w", err)
+		}
+
+		if migrate {
+			from, results, err := bolt.Migrate(db, bolt.MigrateOptions{Backup: file + ".pre-migrate"})
+			for _, r := range results {
+				log.Info().
+					Int("version", r.Version).
+					Int("changed", r.Changed).
+					Msgf("migrated: %s", r.Description)
+			}
+			if err != nil {
+				_ = db.Close()
+				return nil, fmt.Errorf("migrate bolt from version %d: %w", from, err)
+			}
+		}
+
+		handle := bolt.NewHandle(db, opts)
+		metafs, err := bolt.NewMetaFs(handle)
+		if err != nil {
+			return nil, err
+		}
+		assets, err := github.NewAssetStore(handle)
+		if err != nil {
+			_ = handle.Close()
+			return nil, err
+		}
+		return &metaStore{db: handle, metafs: metafs, assets: assets}, nil
+
+	case dbTypeSQLite:
+		db, err := sqlite.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("open sqlite: %w", err)
+		}
+		metafs, err := sqlite.NewMetaFs(db)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return &metaStore{metafs: metafs, assets: sqlite.NewAssetStore(db)}, nil
+	}
+	return nil, fmt.Errorf("unknown db type %q, expected %s or %s", typ, dbTypeBolt, dbTypeSQLite)
+}
+
+func openStorage(cfg *config.Config) *storage {
+	if *memoryMode {
+		log.Warn().Msg("running in memory mode, everything is lost on exit")
+		return &storage{metafs: memory.NewMetaFs(), driver: memory.NewDriver()}
+	}
+
+	ms, err := openMeta(dbType(cfg), dbFile(cfg), true)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to open metadata store")
+	}
+
+	driver, err := github.NewDriver(cfg.GitHub, ms.assets)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to load github driver")
+	}
+
+	// Read past the cache, it only knows nodes
+	usage, _ := ms.metafs.(internal.UsageCounter)
+
+	metafs := ms.metafs
+	if cfg.Cache.Nodes > 0 {
+		metafs = cache.New(metafs, cfg.Cache.Nodes, cfg.Cache.Dirs)
+	}
+
+	var snaps *bolt.Snapshots
+	if ms.db != nil {
+		if snaps, err = bolt.NewSnapshots(ms.db, driver); err != nil {
+			log.Fatal().Err(err).Msgf("failed to open snapshots")
+		}
+		metafs = bolt.WithSnapshots(metafs, snaps)
+	}
+
+	return &storage{db: ms.db, snaps: snaps, usage: usage, metafs: metafs, driver: driver}
+}
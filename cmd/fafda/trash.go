@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/trash"
+)
+
+// trashCmd lists, restores or purges deleted entries while the server is
+// stopped, a running one does the same through its admin endpoints.
+func trashCmd(cfg *config.Config, st *storage, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: trash list|restore|purge")
+		return 2
+	}
+	tr := trash.New(st.metafs, st.driver, cfg.Trash.Retention)
+
+	switch args[0] {
+	case "list":
+		return trashList(tr, args[1:])
+	case "restore":
+		return trashRestore(tr, args[1:])
+	case "purge":
+		return trashPurge(tr)
+	}
+	log.Error().Msgf("unknown trash command %q", args[0])
+	return 2
+}
+
+func trashList(tr *trash.Trash, args []string) int {
+	flags := flag.NewFlagSet("trash list", flag.ExitOnError)
+	user := flags.String("user", "", "only list what this user deleted")
+	asJSON := flags.Bool("json", false, "print the entries as JSON")
+	_ = flags.Parse(args)
+
+	entries, err := tr.List(*user)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list trash")
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(entries)
+	} else {
+		for _, e := range entries {
+			if _, err = fmt.Printf(
+				"%s  %-10s %12d  %s\n    %s\n",
+				e.Deleted.Format(time.RFC3339), e.User, e.Size, e.Original, e.Path,
+			); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write entries")
+		return 2
+	}
+	return 0
+}
+
+func trashRestore(tr *trash.Trash, args []string) int {
+	if len(args) != 1 {
+		log.Error().Msg("usage: trash restore <path in trash>")
+		return 2
+	}
+	original, err := tr.Restore(args[0])
+	if err != nil {
+		log.Error().Err(err).Str("path", args[0]).Msg("failed to restore")
+		return 1
+	}
+	log.Info().Str("path", original).Msg("restored")
+	return 0
+}
+
+func trashPurge(tr *trash.Trash) int {
+	purged, err := tr.Purge()
+	if err != nil {
+		log.Error().Err(err).Int("entries", purged).Msg("failed to purge trash")
+		return 1
+	}
+	log.Info().Int("entries", purged).Msg("trash purged")
+	return 0
+}
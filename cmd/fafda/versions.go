@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal/versions"
+)
+
+// versionsCmd lists, restores or prunes the earlier versions of files, a
+// running server lists them over http and restores through its admin
+// endpoints.
+func versionsCmd(cfg *config.Config, st *storage, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: versions list|restore|prune")
+		return 2
+	}
+	vs, err := versions.New(st.metafs, st.driver, cfg.Versions.Keep, cfg.Versions.Window)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open versions")
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		return versionsList(vs, args[1:])
+	case "restore":
+		return versionsRestore(vs, args[1:])
+	case "prune":
+		return versionsPrune(vs)
+	}
+	log.Error().Msgf("unknown versions command %q", args[0])
+	return 2
+}
+
+func versionsList(vs *versions.Store, args []string) int {
+	flags := flag.NewFlagSet("versions list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the versions as JSON")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Error().Msg("usage: versions list [-json] <path>")
+		return 2
+	}
+
+	list, err := vs.List(flags.Arg(0))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list versions")
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(list)
+	} else {
+		for _, v := range list {
+			if _, err = fmt.Printf(
+				"%s  %12d  written %s\n",
+				v.Id, v.Size, v.ModTime.Format(time.RFC3339),
+			); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write versions")
+		return 2
+	}
+	return 0
+}
+
+func versionsRestore(vs *versions.Store, args []string) int {
+	if len(args) != 2 {
+		log.Error().Msg("usage: versions restore <path> <version>")
+		return 2
+	}
+	if err := vs.Restore(args[0], args[1]); err != nil {
+		log.Error().Err(err).Str("path", args[0]).Str("version", args[1]).Msg("failed to restore")
+		return 1
+	}
+	log.Info().Str("path", args[0]).Str("version", args[1]).Msg("restored")
+	return 0
+}
+
+func versionsPrune(vs *versions.Store) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dropped, err := vs.Prune(ctx)
+	if err != nil {
+		log.Error().Err(err).Int("versions", dropped).Msg("failed to prune versions")
+		return 1
+	}
+	log.Info().Int("versions", dropped).Msg("versions pruned")
+	return 0
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -20,28 +21,73 @@ type GitHubRelease struct {
 type GitHub struct {
 	PartSize    int64           `koanf:"partSize"`
 	Concurrency int             `koanf:"concurrency"`
+	Checksums   []string        `koanf:"checksums"`
 	Releases    []GitHubRelease `koanf:"releases"`
 }
 
 type FTPUser struct {
 	Username string `koanf:"username"`
 	Password string `koanf:"password"`
+	Uid      int    `koanf:"uid"`
+	Gid      int    `koanf:"gid"`
 }
 
 type FTPServer struct {
-	Addr  string    `koanf:"addr"`
-	Users []FTPUser `koanf:"users"`
+	Addr               string    `koanf:"addr"`
+	Users              []FTPUser `koanf:"users"`
+	EnforcePermissions bool      `koanf:"enforcePermissions"`
 }
 
 type HTTPServer struct {
-	Addr string `koanf:"addr"`
+	Addr               string `koanf:"addr"`
+	AdminToken         string `koanf:"adminToken"`
+	EnforcePermissions bool   `koanf:"enforcePermissions"`
+}
+
+type Cache struct {
+	Nodes int `koanf:"nodes"`
+	Dirs  int `koanf:"dirs"`
+}
+
+type Trash struct {
+	Retention time.Duration `koanf:"retention"`
+}
+
+type Versions struct {
+	Keep   int           `koanf:"keep"`
+	Window time.Duration `koanf:"window"`
+}
+
+type Scrub struct {
+	Interval time.Duration `koanf:"interval"`
+	Deep     bool          `koanf:"deep"`
+}
+
+type Backup struct {
+	Interval   time.Duration `koanf:"interval"`
+	Passphrase string        `koanf:"passphrase"`
+	Keep       int           `koanf:"keep"`
+	Release    GitHubRelease `koanf:"release"`
+}
+
+type Quota struct {
+	Path  string `koanf:"path"`
+	Bytes int64  `koanf:"bytes"`
+	Files int64  `koanf:"files"`
 }
 
 type Config struct {
 	DBFile     string     `koanf:"dbFile"`
+	DBType     string     `koanf:"dbType"`
 	GitHub     GitHub     `koanf:"github"`
 	FTPServer  FTPServer  `koanf:"ftpServer"`
 	HTTPServer HTTPServer `koanf:"httpServer"`
+	Cache      Cache      `koanf:"cache"`
+	Trash      Trash      `koanf:"trash"`
+	Versions   Versions   `koanf:"versions"`
+	Scrub      Scrub      `koanf:"scrub"`
+	Backup     Backup     `koanf:"backup"`
+	Quotas     []Quota    `koanf:"quotas"`
 }
 
 var k = koanf.New(".")
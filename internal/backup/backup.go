@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.etcd.io/bbolt"
+
+	"fafda/internal/bolt"
+)
+
+const (
+	namePrefix = "fafda-meta-"
+	nameSuffix = ".bin"
+	timeLayout = "20060102T150405.000Z"
+)
+
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// Store keeps sealed snapshots somewhere other than the local disk.
+type Store interface {
+	Upload(name string, data []byte) error
+	List() ([]string, error)
+	Download(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+// Backup snapshots db, encrypts it and uploads it to store. When keep is
+// positive only the newest keep snapshots are retained.
+func Backup(db bolt.DB, store Store, passphrase string, keep int) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("backup passphrase is empty")
+	}
+
+	var buf bytes.Buffer
+	if err := db.View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(&buf)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+
+	sealed, err := seal(buf.Bytes(), passphrase)
+	if err != nil {
+		return "", fmt.Errorf("encrypt: %w", err)
+	}
+
+	name := namePrefix + time.Now().UTC().Format(timeLayout) + nameSuffix
+	if err := store.Upload(name, sealed); err != nil {
+		return "", fmt.Errorf("upload: %w", err)
+	}
+
+	if keep > 0 {
+		if err := prune(store, keep); err != nil {
+			return name, fmt.Errorf("prune: %w", err)
+		}
+	}
+	return name, nil
+}
+
+// Restore downloads the newest snapshot into dbFile. An existing file is
+// kept next to it with a .pre-restore suffix.
+func Restore(store Store, passphrase, dbFile string) (string, error) {
+	names, err := snapshots(store)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", ErrNoSnapshot
+	}
+	name := names[len(names)-1]
+
+	rc, err := store.Download(name)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", name, err)
+	}
+	sealed, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", name, err)
+	}
+
+	plain, err := open(sealed, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	tmpFile := dbFile + ".restore"
+	if err := os.WriteFile(tmpFile, plain, 0600); err != nil {
+		return "", err
+	}
+	if err := check(tmpFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return "", fmt.Errorf("snapshot %s is not a valid database: %w", name, err)
+	}
+
+	if _, err := os.Stat(dbFile); err == nil {
+		if err := os.Rename(dbFile, dbFile+".pre-restore"); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmpFile, dbFile); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// check reopens a restored database and walks it so a bad snapshot never
+// replaces a working one.
+func check(dbFile string) error {
+	db, err := bbolt.Open(dbFile, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// snapshots lists snapshot names oldest first.
+func snapshots(store Store) ([]string, error) {
+	all, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+
+	var names []string
+	for _, name := range all {
+		if strings.HasPrefix(name, namePrefix) && strings.HasSuffix(name, nameSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func prune(store Store, keep int) error {
+	names, err := snapshots(store)
+	if err != nil {
+		return err
+	}
+	if len(names) <= keep {
+		return nil
+	}
+
+	var errs []error
+	for _, name := range names[:len(names)-keep] {
+		if err := store.Delete(name); err != nil {
+			errs = append(errs, fmt.Errorf("delete %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Schedule takes a snapshot every interval until ctx is done.
+func Schedule(ctx context.Context, interval time.Duration, db bolt.DB, store Store, passphrase string, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		name, err := Backup(db, store, passphrase, keep)
+		if err != nil {
+			log.Error().Err(err).Str("component", "backup").Msg("backup failed")
+			continue
+		}
+		log.Info().Str("component", "backup").Str("snapshot", name).Msg("backup uploaded")
+	}
+}
@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+type memStore struct {
+	assets map[string][]byte
+}
+
+func (s *memStore) Upload(name string, data []byte) error {
+	s.assets[name] = data
+	return nil
+}
+
+func (s *memStore) List() ([]string, error) {
+	var names []string
+	for name := range s.assets {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s *memStore) Download(name string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.assets[name])), nil
+}
+
+func (s *memStore) Delete(name string) error {
+	delete(s.assets, name)
+	return nil
+}
+
+func setupTestDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func put(t *testing.T, db *bbolt.DB, key, value string) {
+	t.Helper()
+	err := db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("files"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64))
+	if got != want {
+		t.Fatalf("pbkdf2() = %s, want %s", got, want)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := seal([]byte("metadata"), "secret")
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		sealed     []byte
+		passphrase string
+		wantErr    error
+	}{
+		{name: "right passphrase", sealed: sealed, passphrase: "secret"},
+		{name: "wrong passphrase", sealed: sealed, passphrase: "guess", wantErr: ErrDecrypt},
+		{name: "tampered", sealed: tampered, passphrase: "secret", wantErr: ErrDecrypt},
+		{name: "garbage", sealed: []byte("SQLite format 3"), passphrase: "secret", wantErr: ErrNotSnapshot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := open(tt.sealed, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(plain) != "metadata" {
+				t.Errorf("open() = %q, want %q", plain, "metadata")
+			}
+		})
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	db := setupTestDB(t)
+	store := &memStore{assets: map[string][]byte{}}
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		put(t, db, "/file", value)
+		if _, err := Backup(db, store, "secret", 2); err != nil {
+			t.Fatalf("Backup() error = %v", err)
+		}
+	}
+	if len(store.assets) != 2 {
+		t.Fatalf("kept %d snapshots, want 2", len(store.assets))
+	}
+
+	target := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if _, err := Restore(store, "wrong", target); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("Restore() error = %v, want %v", err, ErrDecrypt)
+	}
+	if _, err := Restore(store, "secret", target); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if old, err := os.ReadFile(target + ".pre-restore"); err != nil || string(old) != "old" {
+		t.Errorf("previous db not kept aside: %q, %v", old, err)
+	}
+
+	restored, err := bbolt.Open(target, 0600, nil)
+	if err != nil {
+		t.Fatalf("open restored db: %v", err)
+	}
+	defer restored.Close()
+
+	var value string
+	_ = restored.View(func(tx *bbolt.Tx) error {
+		value = string(tx.Bucket([]byte("files")).Get([]byte("/file")))
+		return nil
+	})
+	if value != "v3" {
+		t.Errorf("restored value = %q, want %q", value, "v3")
+	}
+}
+
+func TestRestoreNoSnapshot(t *testing.T) {
+	store := &memStore{assets: map[string][]byte{"unrelated.zip": nil}}
+	target := filepath.Join(t.TempDir(), "restored.db")
+	if _, err := Restore(store, "secret", target); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("Restore() error = %v, want %v", err, ErrNoSnapshot)
+	}
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Sealed snapshot layout: magic | salt | nonce | AES-256-GCM ciphertext.
+// The key is derived from the passphrase with PBKDF2-HMAC-SHA256.
+var magic = []byte("FAFDABK1")
+
+const (
+	saltSize   = 16
+	keySize    = 32
+	iterations = 600_000
+)
+
+var (
+	ErrNotSnapshot = errors.New("not a fafda snapshot")
+	ErrDecrypt     = errors.New("snapshot decryption failed, wrong passphrase or damaged snapshot")
+)
+
+func seal(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+saltSize+len(nonce))
+	header = append(header, magic...)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	// The header is authenticated too, so a swapped salt fails to open
+	return gcm.Seal(header, nonce, plain, header), nil
+}
+
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < len(magic)+saltSize || !bytes.Equal(sealed[:len(magic)], magic) {
+		return nil, ErrNotSnapshot
+	}
+	salt := sealed[len(magic) : len(magic)+saltSize]
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(magic) + saltSize + gcm.NonceSize()
+	if len(sealed) < headerSize {
+		return nil, ErrNotSnapshot
+	}
+	header := sealed[:headerSize]
+	nonce := header[len(magic)+saltSize:]
+
+	plain, err := gcm.Open(nil, nonce, sealed[headerSize:], header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, keySize))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 implements RFC 8018 with HMAC-SHA256.
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
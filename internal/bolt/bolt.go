@@ -2,6 +2,7 @@ package bolt
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -10,32 +11,62 @@ import (
 	"strings"
 	"time"
 
-	nanoid "github.com/matoous/go-nanoid/v2"
 	"go.etcd.io/bbolt"
 
 	"fafda/internal"
 )
 
-var fileBucket = []byte("files")
+// Nodes live in the inodes bucket under a stable id and directories link
+// to their children through dirents, keyed by parent id + child name. A
+// node's path is never stored, it is whatever path it was looked up by,
+// so renames only move one dirent and listings only touch the children.
+var (
+	inodeBucket  = []byte("inodes")
+	direntBucket = []byte("dirents")
+)
+
+const rootIno uint64 = 1
 
 type MetaFs struct {
-	db *bbolt.DB
+	db DB
 }
 
-func NewMetaFs(db *bbolt.DB) (internal.MetaFileSystem, error) {
+func NewMetaFs(db DB) (internal.MetaFileSystem, error) {
 	metafs := &MetaFs{db: db}
 
 	err := db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(fileBucket)
+		if err := checkSchema(tx); err != nil {
+			return err
+		}
+
+		inodes, err := tx.CreateBucketIfNotExists(inodeBucket)
 		if err != nil {
-			return fmt.Errorf("failed to create file bucket %w", err)
+			return fmt.Errorf("failed to create inode bucket %w", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(direntBucket); err != nil {
+			return fmt.Errorf("failed to create dirent bucket %w", err)
+		}
+		usage, err := tx.CreateBucketIfNotExists(usageBucket)
+		if err != nil {
+			return fmt.Errorf("failed to create usage bucket %w", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(parentBucket); err != nil {
+			return fmt.Errorf("failed to create parent bucket %w", err)
+		}
+		if usage.Get(inoKey(rootIno)) == nil {
+			if err := usage.Put(inoKey(rootIno), encodeUsage(usageRecord{})); err != nil {
+				return err
+			}
 		}
 
-		if data := bucket.Get([]byte("/")); data == nil {
-			root := NewFile("/", true)
-			if err := metafs.put(bucket, "/", root); err != nil {
+		if inodes.Get(inoKey(rootIno)) == nil {
+			t := newTree(tx)
+			if err := t.putNode(rootIno, internal.NewNode("/", true)); err != nil {
 				return err
 			}
+			if inodes.Sequence() < rootIno {
+				return inodes.SetSequence(rootIno)
+			}
 		}
 
 		return nil
@@ -49,94 +80,34 @@ func NewMetaFs(db *bbolt.DB) (internal.MetaFileSystem, error) {
 	return metafs, nil
 }
 
-func NewFile(path string, isDir bool) *internal.Node {
-	now := time.Now()
-	mode := os.FileMode(0644)
-	if isDir {
-		mode = os.FileMode(0755) | os.ModeDir
-	}
-
-	node := &internal.Node{}
-
-	if !isDir {
-		node.SetId(nanoid.Must())
-	}
-
-	return node.
-		SetPath(path).
-		SetIsDir(isDir).
-		SetSize(0).
-		SetMode(mode).
-		SetCreatedAt(now).
-		SetModTime(now)
-}
-
 func (mf *MetaFs) Name() string {
 	return "boltdb"
 }
 
-func (mf *MetaFs) get(bucket *bbolt.Bucket, path string) (*internal.Node, error) {
-	data := bucket.Get([]byte(path))
-	if data == nil {
-		return nil, internal.ErrNotFound
-	}
-	return decodeNode(data)
-}
-
-func (mf *MetaFs) put(bucket *bbolt.Bucket, path string, node *internal.Node) error {
-	data, err := encodeNode(node)
-	if err != nil {
-		return err
-	}
-	return bucket.Put([]byte(path), data)
-}
-
-func (mf *MetaFs) checkParentDir(bucket *bbolt.Bucket, pathStr string) error {
-	parent := path.Dir(pathStr)
-	if parent == "/" {
-		return nil
-	}
-
-	parentNode, err := mf.get(bucket, parent)
-	if err != nil {
-		return internal.ErrNotFound
-	}
-
-	if !parentNode.IsDir() {
-		return internal.ErrNotFound
-	}
-	return nil
-}
-
-func (mf *MetaFs) rename(tx *bbolt.Tx, b *bbolt.Bucket, data []byte, oldpath, newpath string) error {
-	node, err := decodeNode(data)
-	if err != nil {
-		return err
-	}
-
-	node.SetPath(newpath)
-	if err := b.Delete([]byte(oldpath)); err != nil {
-		return err
-	}
-
-	return mf.put(b, newpath, node)
-}
-
 func (mf *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
-	file := NewFile(pathStr, isDir)
+	pathStr = path.Clean(pathStr)
+	file := internal.NewNode(pathStr, isDir)
 
 	err := mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
+		t := newTree(tx)
 
-		if bucket.Get([]byte(pathStr)) != nil {
+		if _, err := t.lookup(pathStr); err == nil {
 			return internal.ErrAlreadyExist
 		}
 
-		if err := mf.checkParentDir(bucket, pathStr); err != nil {
+		parent, name, err := t.parent(pathStr)
+		if err != nil {
 			return err
 		}
 
-		return mf.put(bucket, pathStr, file)
+		ino, err := t.inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := t.putNode(ino, file); err != nil {
+			return err
+		}
+		return t.add(parent, name, ino, file)
 	})
 
 	if err != nil {
@@ -146,15 +117,11 @@ func (mf *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
 	return file, nil
 }
 
-func (mf *MetaFs) Stat(path string) (*internal.Node, error) {
-	if path == "" || path == "/" {
-		return NewFile("/", true), nil
-	}
-
+func (mf *MetaFs) Stat(pathStr string) (*internal.Node, error) {
 	var file *internal.Node
 	err := mf.db.View(func(tx *bbolt.Tx) error {
 		var err error
-		file, err = mf.get(tx.Bucket(fileBucket), path)
+		_, file, err = newTree(tx).resolve(pathStr)
 		return err
 	})
 
@@ -162,80 +129,35 @@ func (mf *MetaFs) Stat(path string) (*internal.Node, error) {
 }
 
 func (mf *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
-	info, err := mf.Stat(pathStr)
-	if err != nil {
-		return nil, err
-	}
-	if !info.IsDir() {
-		return nil, internal.ErrIsNotDir
-	}
-
 	var files []internal.Node
-	cleanPath := path.Clean(pathStr)
+	err := mf.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		files, err = newTree(tx).ls(pathStr, limit, offset)
+		return err
+	})
+	return files, err
+}
 
-	var prefix []byte
-	if cleanPath == "/" {
-		prefix = []byte("/")
-	} else {
-		prefix = []byte(cleanPath + "/")
+func (mf *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	after, err := internal.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
 	}
 
+	var files []internal.Node
+	var next string
 	err = mf.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
-		c := bucket.Cursor()
-
-		skipped := 0
-		for k, v := c.Seek(prefix); k != nil; k, v = c.Next() {
-			if cleanPath == "/" {
-				if string(k) == "/" {
-					continue
-				}
-				parts := strings.Split(strings.TrimPrefix(string(k), "/"), "/")
-				if len(parts) > 1 {
-					continue
-				}
-			} else {
-				if !strings.HasPrefix(string(k), string(prefix)) {
-					break
-				}
-				relPath := strings.TrimPrefix(string(k), string(prefix))
-				if strings.Contains(relPath, "/") {
-					continue
-				}
-			}
-
-			if skipped < offset {
-				skipped++
-				continue
-			}
-
-			if limit != -1 && limit > 0 && len(files) >= limit {
-				break
-			}
-
-			file, err := decodeNode(v)
-			if err != nil {
-				return err
-			}
-			files = append(files, *file)
-		}
-		return nil
+		var err error
+		files, next, err = newTree(tx).lsAfter(pathStr, limit, after)
+		return err
 	})
-
-	return files, err
+	return files, next, err
 }
 
 func (mf *MetaFs) Chtimes(path string, mtime time.Time) error {
-	return mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
-
-		node, err := mf.get(bucket, path)
-		if err != nil {
-			return err
-		}
-
+	return mf.update(path, func(node *internal.Node) error {
 		node.SetModTime(mtime)
-		return mf.put(bucket, path, node)
+		return nil
 	})
 }
 
@@ -289,62 +211,72 @@ func (mf *MetaFs) Rename(oldpath, newpath string) error {
 	}
 
 	return mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
-		if exist := bucket.Get([]byte(newpath)); exist != nil {
+		t := newTree(tx)
+
+		if _, err := t.lookup(newpath); err == nil {
 			return internal.ErrAlreadyExist
 		}
 
-		data := bucket.Get([]byte(oldpath))
-		if data == nil {
-			return internal.ErrNotFound
+		oldParent, oldName, err := t.parent(oldpath)
+		if err != nil {
+			return err
 		}
-
-		if err := mf.checkParentDir(bucket, newpath); err != nil {
+		ino, err := t.child(oldParent, oldName)
+		if err != nil {
 			return err
 		}
 
-		if err := mf.rename(tx, bucket, data, oldpath, newpath); err != nil {
+		newParent, newName, err := t.parent(newpath)
+		if err != nil {
 			return err
 		}
 
-		// Handle renaming of child nodes
-		prefix := []byte(oldpath + "/")
-		newPrefix := []byte(newpath + "/")
-		c := bucket.Cursor()
-		var filesToMove [][]byte
-		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
-			filesToMove = append(filesToMove, k)
+		node, err := t.node(ino, oldpath)
+		if err != nil {
+			return err
 		}
-		for _, f := range filesToMove {
-			newKey := append(newPrefix, f[len(prefix):]...)
-			if err := mf.rename(tx, bucket, bucket.Get(f), string(f), string(newKey)); err != nil {
-				return err
-			}
+		if err := t.drop(oldParent, oldName, ino, node); err != nil {
+			return err
 		}
-		return nil
+		return t.add(newParent, newName, ino, node)
 	})
 }
 
-func (mf *MetaFs) Remove(path string) error {
+func (mf *MetaFs) Remove(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
 	return mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
+		t := newTree(tx)
 
-		node, err := mf.get(bucket, path)
+		ino, node, err := t.resolve(pathStr)
 		if err != nil {
 			return err
 		}
 
 		if node.IsDir() {
-			prefix := path + "/"
-			c := bucket.Cursor()
-			k, _ := c.Seek([]byte(prefix))
-
-			if k != nil && strings.HasPrefix(string(k), prefix) {
+			empty := true
+			if err := t.children(ino, func(string, uint64) (bool, error) {
+				empty = false
+				return false, nil
+			}); err != nil {
+				return err
+			}
+			if !empty {
 				return internal.ErrNotEmpty
 			}
 		}
 
-		return bucket.Delete([]byte(path))
+		parent, name, err := t.parent(pathStr)
+		if err != nil {
+			return err
+		}
+		if err := t.drop(parent, name, ino, node); err != nil {
+			return err
+		}
+		return t.release(ino)
 	})
 }
 
@@ -355,40 +287,231 @@ func (mf *MetaFs) RemoveAll(pathStr string) error {
 	}
 
 	return mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
+		t := newTree(tx)
 
-		if data := bucket.Get([]byte(pathStr)); data == nil {
+		ino, node, err := t.resolve(pathStr)
+		if errors.Is(err, internal.ErrNotFound) {
 			return nil
 		}
+		if err != nil {
+			return err
+		}
+
+		parent, name, err := t.parent(pathStr)
+		if err != nil {
+			return err
+		}
+		if err := t.drop(parent, name, ino, node); err != nil {
+			return err
+		}
+		return t.removeTree(ino)
+	})
+}
+
+func (mf *MetaFs) Sync(path string, size int64) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if !node.IsDir() {
+			node.SetSize(size).SetDigest(internal.Digest{})
+		}
+		node.SetModTime(time.Now())
+		return nil
+	})
+}
+
+func (mf *MetaFs) SetDigest(path string, digest internal.Digest) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		node.SetDigest(digest)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Chmod(path string, mode os.FileMode) error {
+	return mf.update(path, func(node *internal.Node) error {
+		node.Chmod(mode)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Chown(path string, uid, gid int) error {
+	return mf.update(path, func(node *internal.Node) error {
+		node.Chown(uid, gid)
+		return nil
+	})
+}
+
+func (mf *MetaFs) GetXattr(path, name string) (string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return node.Xattr(name)
+}
+
+func (mf *MetaFs) SetXattr(path, name, value string) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if err := internal.CheckXattr(node, name, value); err != nil {
+			return err
+		}
+		node.SetXattr(name, value)
+		return nil
+	})
+}
+
+func (mf *MetaFs) ListXattr(path string) ([]string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return node.XattrNames(), nil
+}
+
+func (mf *MetaFs) RemoveXattr(path, name string) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if _, err := node.Xattr(name); err != nil {
+			return err
+		}
+		node.RemoveXattr(name)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Put(node *internal.Node) error {
+	pathStr := path.Clean(node.Path())
+	if pathStr == "/" && !node.IsDir() {
+		return internal.ErrInvalidRootOperation
+	}
 
-		prefix := pathStr + "/"
-		c := bucket.Cursor()
+	return mf.db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
 
-		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
-			if err := bucket.Delete(k); err != nil {
+		if ino, existing, err := t.resolve(pathStr); err == nil {
+			if existing.IsDir() != node.IsDir() {
+				return internal.ErrAlreadyExist
+			}
+			if err := t.putNode(ino, node); err != nil {
 				return err
 			}
+			return t.resized(ino, pathStr, existing, node)
 		}
 
-		return bucket.Delete([]byte(pathStr))
+		parent, name, err := t.parent(pathStr)
+		if err != nil {
+			return err
+		}
+		ino, err := t.inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := t.putNode(ino, node); err != nil {
+			return err
+		}
+		return t.add(parent, name, ino, node)
 	})
 }
 
-func (mf *MetaFs) Sync(path string, size int64) error {
-	return mf.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(fileBucket)
+func (mf *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	dst = path.Clean(dst)
+
+	var file *internal.Node
+	err := mf.db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
 
-		node, err := mf.get(bucket, path)
+		_, node, err := t.resolve(src)
 		if err != nil {
 			return err
 		}
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		if _, err := t.lookup(dst); err == nil {
+			return internal.ErrAlreadyExist
+		}
 
-		if !node.IsDir() {
-			node.SetSize(size)
+		parent, name, err := t.parent(dst)
+		if err != nil {
+			return err
 		}
-		node.SetModTime(time.Now())
+		ino, err := t.inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		file = node.CopyTo(dst)
+		if err := t.putNode(ino, file); err != nil {
+			return err
+		}
+		return t.add(parent, name, ino, file)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (mf *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	pathStr = path.Clean(pathStr)
+	link := internal.NewSymlink(pathStr, target)
+
+	err := mf.db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
+
+		if _, err := t.lookup(pathStr); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := t.parent(pathStr)
+		if err != nil {
+			return err
+		}
+		ino, err := t.inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := t.putNode(ino, link); err != nil {
+			return err
+		}
+		return t.add(parent, name, ino, link)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// Link adds a dirent to the inode of oldpath, the entries share the node
+// and it counts them.
+func (mf *MetaFs) Link(oldpath, newpath string) error {
+	return mf.db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
 
-		return mf.put(bucket, path, node)
+		ino, node, err := t.resolve(oldpath)
+		if err != nil {
+			return err
+		}
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		if node.IsSymlink() {
+			return internal.ErrNotSupported
+		}
+		if _, err := t.lookup(newpath); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := t.parent(newpath)
+		if err != nil {
+			return err
+		}
+		if err := t.putNode(ino, node.SetLinks(node.Links()+1)); err != nil {
+			return err
+		}
+		return t.add(parent, name, ino, node)
 	})
 }
 
@@ -396,6 +519,313 @@ func (mf *MetaFs) Close() error {
 	return mf.db.Close()
 }
 
+// update applies fn to the node at path and stores it.
+func (mf *MetaFs) update(pathStr string, fn func(node *internal.Node) error) error {
+	return mf.db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
+
+		ino, node, err := t.resolve(pathStr)
+		if err != nil {
+			return err
+		}
+		before := *node
+		if err := fn(node); err != nil {
+			return err
+		}
+		if err := t.putNode(ino, node); err != nil {
+			return err
+		}
+		return t.resized(ino, pathStr, &before, node)
+	})
+}
+
+// tree gives path based access to the inode layout within a transaction.
+type tree struct {
+	inodes  *bbolt.Bucket
+	dirents *bbolt.Bucket
+	usage   *bbolt.Bucket
+	parents *bbolt.Bucket
+}
+
+func newTree(tx *bbolt.Tx) *tree {
+	return &tree{
+		inodes:  tx.Bucket(inodeBucket),
+		dirents: tx.Bucket(direntBucket),
+		usage:   tx.Bucket(usageBucket),
+		parents: tx.Bucket(parentBucket),
+	}
+}
+
+func inoKey(ino uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, ino)
+}
+
+func direntKey(parent uint64, name string) []byte {
+	return append(inoKey(parent), name...)
+}
+
+// lookup walks the dirents from the root, one read per path component.
+func (t *tree) lookup(pathStr string) (uint64, error) {
+	pathStr = path.Clean("/" + pathStr)
+	if pathStr == "/" {
+		return rootIno, nil
+	}
+
+	ino := rootIno
+	for _, name := range strings.Split(pathStr[1:], "/") {
+		var err error
+		if ino, err = t.child(ino, name); err != nil {
+			return 0, err
+		}
+	}
+	return ino, nil
+}
+
+func (t *tree) resolve(pathStr string) (uint64, *internal.Node, error) {
+	ino, err := t.lookup(pathStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	node, err := t.node(ino, path.Clean("/"+pathStr))
+	return ino, node, err
+}
+
+// parent resolves the directory that holds pathStr, which must exist.
+func (t *tree) parent(pathStr string) (uint64, string, error) {
+	pathStr = path.Clean("/" + pathStr)
+	ino, node, err := t.resolve(path.Dir(pathStr))
+	if err != nil || !node.IsDir() {
+		return 0, "", internal.ErrNotFound
+	}
+	return ino, path.Base(pathStr), nil
+}
+
+func (t *tree) child(parent uint64, name string) (uint64, error) {
+	data := t.dirents.Get(direntKey(parent, name))
+	if data == nil {
+		return 0, internal.ErrNotFound
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// children calls fn for every child of ino in name order until fn
+// returns false.
+func (t *tree) children(ino uint64, fn func(name string, child uint64) (bool, error)) error {
+	return t.childrenAfter(ino, "", fn)
+}
+
+// childrenAfter is children starting past the given name, seeking straight
+// to it so resuming a listing costs nothing for the entries before.
+func (t *tree) childrenAfter(ino uint64, after string, fn func(name string, child uint64) (bool, error)) error {
+	prefix := inoKey(ino)
+	c := t.dirents.Cursor()
+
+	k, v := c.Seek(direntKey(ino, after))
+	if after != "" && k != nil && bytes.Equal(k, direntKey(ino, after)) {
+		k, v = c.Next()
+	}
+
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		more, err := fn(string(k[len(prefix):]), binary.BigEndian.Uint64(v))
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tree) ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	ino, dir, err := t.resolve(pathStr)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir() {
+		return nil, internal.ErrIsNotDir
+	}
+
+	var files []internal.Node
+	skipped := 0
+	err = t.children(ino, func(name string, child uint64) (bool, error) {
+		if skipped < offset {
+			skipped++
+			return true, nil
+		}
+		if limit > 0 && len(files) >= limit {
+			return false, nil
+		}
+
+		file, err := t.node(child, path.Join(dir.Path(), name))
+		if err != nil {
+			return false, err
+		}
+		files = append(files, *file)
+		return true, nil
+	})
+	return files, err
+}
+
+// lsAfter lists the children of pathStr past the name after, the cursor
+// returned is empty once nothing is left.
+func (t *tree) lsAfter(pathStr string, limit int, after string) ([]internal.Node, string, error) {
+	ino, dir, err := t.resolve(pathStr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.IsDir() {
+		return nil, "", internal.ErrIsNotDir
+	}
+
+	var files []internal.Node
+	var next string
+	err = t.childrenAfter(ino, after, func(name string, child uint64) (bool, error) {
+		if limit > 0 && len(files) >= limit {
+			// Only hand out a cursor when something is left
+			next = internal.EncodeCursor(files[len(files)-1].Name())
+			return false, nil
+		}
+
+		file, err := t.node(child, path.Join(dir.Path(), name))
+		if err != nil {
+			return false, err
+		}
+		files = append(files, *file)
+		return true, nil
+	})
+	return files, next, err
+}
+
+func (t *tree) node(ino uint64, pathStr string) (*internal.Node, error) {
+	data := t.inodes.Get(inoKey(ino))
+	if data == nil {
+		return nil, internal.ErrNotFound
+	}
+	node, err := decodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	return node.SetPath(pathStr), nil
+}
+
+func (t *tree) putNode(ino uint64, node *internal.Node) error {
+	// Paths are derived from dirents, storing one would only go stale
+	stored := *node
+	data, err := encodeNode(stored.SetPath(""))
+	if err != nil {
+		return err
+	}
+	return t.inodes.Put(inoKey(ino), data)
+}
+
+// link puts the dirent and, in trees that keep one, its parents entry.
+func (t *tree) link(parent uint64, name string, ino uint64) error {
+	if err := t.unlink(parent, name); err != nil {
+		return err
+	}
+	if t.parents != nil {
+		if err := t.parents.Put(parentKey(ino, parent, name), nil); err != nil {
+			return err
+		}
+	}
+	return t.dirents.Put(direntKey(parent, name), inoKey(ino))
+}
+
+func (t *tree) unlink(parent uint64, name string) error {
+	key := direntKey(parent, name)
+	if data := t.dirents.Get(key); t.parents != nil && len(data) == 8 {
+		if err := t.parents.Delete(parentKey(binary.BigEndian.Uint64(data), parent, name)); err != nil {
+			return err
+		}
+	}
+	return t.dirents.Delete(key)
+}
+
+// add links ino into parent and counts it there.
+func (t *tree) add(parent uint64, name string, ino uint64, node *internal.Node) error {
+	if err := t.link(parent, name, ino); err != nil {
+		return err
+	}
+	if node.IsDir() {
+		if err := t.setParent(ino, parent); err != nil {
+			return err
+		}
+	}
+	usage, err := t.entryUsage(ino, node)
+	if err != nil {
+		return err
+	}
+	return t.account(parent, usage)
+}
+
+// drop unlinks ino from parent and takes what it counted there back.
+func (t *tree) drop(parent uint64, name string, ino uint64, node *internal.Node) error {
+	usage, err := t.entryUsage(ino, node)
+	if err != nil {
+		return err
+	}
+	if err := t.unlink(parent, name); err != nil {
+		return err
+	}
+	return t.account(parent, usage.Neg())
+}
+
+// resized counts the change in size of the file ino, reached by pathStr,
+// in every directory it is in.
+func (t *tree) resized(ino uint64, pathStr string, before, after *internal.Node) error {
+	if after.IsDir() {
+		return nil
+	}
+	delta := fileUsage(after).Add(fileUsage(before).Neg())
+	if delta == (internal.Usage{}) {
+		return nil
+	}
+	parent, _, err := t.parent(pathStr)
+	if err != nil {
+		return err
+	}
+	return t.accountFile(ino, after, parent, delta)
+}
+
+// removeTree deletes ino and everything below it, its own dirent is left
+// to the caller.
+func (t *tree) removeTree(ino uint64) error {
+	type entry struct {
+		name string
+		ino  uint64
+	}
+	var entries []entry
+	if err := t.children(ino, func(name string, child uint64) (bool, error) {
+		entries = append(entries, entry{name, child})
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := t.unlink(ino, e.name); err != nil {
+			return err
+		}
+		if err := t.removeTree(e.ino); err != nil {
+			return err
+		}
+	}
+	return t.release(ino)
+}
+
+// release gives up one entry of ino, a file with hard links only loses a
+// link and the node goes with its last one.
+func (t *tree) release(ino uint64) error {
+	node, err := t.node(ino, "")
+	if err == nil && !node.IsDir() && node.Links() > 1 {
+		return t.putNode(ino, node.SetLinks(node.Links()-1))
+	}
+	if t.usage != nil {
+		if err := t.usage.Delete(inoKey(ino)); err != nil {
+			return err
+		}
+	}
+	return t.inodes.Delete(inoKey(ino))
+}
+
 func encodeNode(node *internal.Node) ([]byte, error) {
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(node); err != nil {
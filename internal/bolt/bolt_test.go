@@ -1119,3 +1119,308 @@ func TestVirtualFileSystemOperations(t *testing.T) {
 		}
 	})
 }
+
+func TestDigest(t *testing.T) {
+	provider, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := provider.Create("/dir", true); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := provider.Create("/dir/file.txt", false); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	digest := internal.Digest{SHA256: "abc", MD5: "def"}
+	if err := provider.SetDigest("/dir/file.txt", digest); err != nil {
+		t.Fatalf("SetDigest() error = %v", err)
+	}
+	if err := provider.SetDigest("/dir", digest); !errors.Is(err, internal.ErrIsDir) {
+		t.Errorf("SetDigest() on directory error = %v, want %v", err, internal.ErrIsDir)
+	}
+
+	// Digest survives renames
+	if err := provider.Rename("/dir", "/moved"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	node, err := provider.Stat("/moved/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Digest() != digest {
+		t.Errorf("Digest() = %+v, want %+v", node.Digest(), digest)
+	}
+
+	// New content invalidates it
+	if err := provider.Sync("/moved/file.txt", 10); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if node, _ = provider.Stat("/moved/file.txt"); !node.Digest().IsZero() {
+		t.Errorf("Digest() = %+v after Sync, want zero", node.Digest())
+	}
+}
+
+func TestPut(t *testing.T) {
+	provider, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	file := internal.NewNode("/dir/file.txt", false).SetId("restored-id").SetSize(42)
+
+	tests := []struct {
+		name    string
+		node    *internal.Node
+		wantErr error
+	}{
+		{name: "new file", node: file},
+		{name: "replace file", node: file},
+		{name: "missing parent", node: internal.NewNode("/missing/file.txt", false), wantErr: internal.ErrNotFound},
+		{name: "file over directory", node: internal.NewNode("/dir", false), wantErr: internal.ErrAlreadyExist},
+		{name: "file as root", node: internal.NewNode("/", false), wantErr: internal.ErrInvalidRootOperation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := provider.Put(tt.node); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Put() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	node, err := provider.Stat("/dir/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Id() != "restored-id" || node.Size() != 42 {
+		t.Errorf("Stat() = id %q size %d, want id %q size 42", node.Id(), node.Size(), "restored-id")
+	}
+}
+
+func TestRenameKeepsInodes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.MkdirAll("/a/b/c"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := provider.Touch(fmt.Sprintf("/a/b/c/file%d", i)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	inodes := func() map[string]string {
+		records := map[string]string{}
+		_ = db.View(func(tx *bbolt.Tx) error {
+			return tx.Bucket(inodeBucket).ForEach(func(k, v []byte) error {
+				records[string(k)] = string(v)
+				return nil
+			})
+		})
+		return records
+	}
+
+	before := inodes()
+	if err := provider.Rename("/a", "/z"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	after := inodes()
+
+	if len(before) != len(after) {
+		t.Fatalf("inode count changed from %d to %d", len(before), len(after))
+	}
+	for k, v := range before {
+		if after[k] != v {
+			t.Errorf("inode %x rewritten by rename", k)
+		}
+	}
+
+	node, err := provider.Stat("/z/b/c/file3")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Path() != "/z/b/c/file3" {
+		t.Errorf("Path() = %q, want %q", node.Path(), "/z/b/c/file3")
+	}
+	if _, err := provider.Stat("/a/b/c/file3"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() old path error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestLsCursor(t *testing.T) {
+	provider, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"b", "d", "f", "h"} {
+		if err := provider.Touch("/dir/" + name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := provider.Touch("/dir.txt"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	var got []string
+	cursor := ""
+	for page := 0; ; page++ {
+		nodes, next, err := provider.LsCursor("/dir", 2, cursor)
+		if err != nil {
+			t.Fatalf("LsCursor() error = %v", err)
+		}
+		for _, node := range nodes {
+			got = append(got, node.Name())
+		}
+		if page == 0 {
+			// Entries before the cursor must not shift the listing
+			for _, name := range []string{"a", "c", "g"} {
+				if err := provider.Touch("/dir/" + name); err != nil {
+					t.Fatalf("Touch() error = %v", err)
+				}
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	want := "b,d,f,g,h"
+	if strings.Join(got, ",") != want {
+		t.Errorf("LsCursor() pages = %v, want %v", strings.Join(got, ","), want)
+	}
+
+	if _, _, err := provider.LsCursor("/dir", 2, "not base64!"); !errors.Is(err, internal.ErrInvalidCursor) {
+		t.Errorf("LsCursor() bad cursor error = %v, want %v", err, internal.ErrInvalidCursor)
+	}
+	if _, _, err := provider.LsCursor("/dir.txt", 2, ""); !errors.Is(err, internal.ErrIsNotDir) {
+		t.Errorf("LsCursor() on file error = %v, want %v", err, internal.ErrIsNotDir)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	provider, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/file", "/dir/link"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	if err := provider.Link("/file", "/other"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+
+	// Entries share the inode, a change through one is seen by all
+	if err := provider.Sync("/dir/link", 42); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	file, err := provider.Stat("/file")
+	if err != nil || file.Size() != 42 || file.Links() != 3 {
+		t.Fatalf("Stat() = %v, %v, want 42 bytes and 3 links", file, err)
+	}
+
+	if err := provider.RemoveAll("/dir"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := provider.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	other, err := provider.Stat("/other")
+	if err != nil || other.Id() != file.Id() || other.Links() != 1 {
+		t.Fatalf("Stat() of the last link = %v, %v, want id %s and 1 link", other, err, file.Id())
+	}
+
+	link, err := provider.Symlink("../other", "/symlink")
+	if err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	stored, err := provider.Stat("/symlink")
+	if err != nil || !stored.IsSymlink() || stored.Target() != "../other" || stored.Id() != "" {
+		t.Errorf("Stat() of a symlink = %+v, %v, want %+v", stored, err, link)
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		wantErr  error
+	}{
+		{name: "existing", old: "/other", new: "/symlink", wantErr: internal.ErrAlreadyExist},
+		{name: "directory", old: "/", new: "/root", wantErr: internal.ErrIsDir},
+		{name: "symlink", old: "/symlink", new: "/hard", wantErr: internal.ErrNotSupported},
+		{name: "missing", old: "/missing", new: "/hard", wantErr: internal.ErrNotFound},
+		{name: "missing parent", old: "/other", new: "/dir/hard", wantErr: internal.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := provider.Link(tt.old, tt.new); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Link() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	provider, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/file", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for name, value := range map[string]string{"origin": "https://example.com", "content-type": "text/plain"} {
+		if err := provider.SetXattr("/file", name, value); err != nil {
+			t.Fatalf("SetXattr() error = %v", err)
+		}
+	}
+	if err := provider.Rename("/file", "/renamed"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	// Renames keep the attributes and hard links share them
+	for _, p := range []string{"/renamed", "/link"} {
+		value, err := provider.GetXattr(p, "origin")
+		if err != nil || value != "https://example.com" {
+			t.Errorf("GetXattr(%s) = %q, %v, want %q", p, value, err, "https://example.com")
+		}
+	}
+	if err := provider.RemoveXattr("/link", "origin"); err != nil {
+		t.Fatalf("RemoveXattr() error = %v", err)
+	}
+	names, err := provider.ListXattr("/renamed")
+	if err != nil || len(names) != 1 || names[0] != "content-type" {
+		t.Errorf("ListXattr() = %v, %v, want [content-type]", names, err)
+	}
+
+	if _, err := provider.GetXattr("/renamed", "origin"); !errors.Is(err, internal.ErrNoXattr) {
+		t.Errorf("GetXattr() of a removed one error = %v, want %v", err, internal.ErrNoXattr)
+	}
+	if err := provider.RemoveXattr("/renamed", "origin"); !errors.Is(err, internal.ErrNoXattr) {
+		t.Errorf("RemoveXattr() of a removed one error = %v, want %v", err, internal.ErrNoXattr)
+	}
+	if err := provider.SetXattr("/renamed", "", "value"); !errors.Is(err, internal.ErrInvalidXattr) {
+		t.Errorf("SetXattr() without a name error = %v, want %v", err, internal.ErrInvalidXattr)
+	}
+	if err := provider.SetXattr("/missing", "origin", "value"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("SetXattr() of a missing file error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
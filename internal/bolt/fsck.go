@@ -0,0 +1,699 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// assetBucket is owned by github.AssetStore, fsck only looks at its keys
+// to match them against the file nodes.
+var assetBucket = []byte("assets")
+
+const lostFound = "lost+found"
+
+type FsckKind string
+
+const (
+	FsckCorrupt      FsckKind = "corrupt"       // node record that does not decode
+	FsckDangling     FsckKind = "dangling"      // dirent to a missing node or under a non-directory
+	FsckOrphan       FsckKind = "orphan"        // node not reachable from the root
+	FsckNoAssets     FsckKind = "no-assets"     // file with a size but no parts
+	FsckUnusedAssets FsckKind = "unused-assets" // parts no file refers to
+	FsckRefs         FsckKind = "refs"          // part counted in more or fewer asset lists than it is in
+	FsckLinks        FsckKind = "links"         // file counting more or fewer hard links than entries
+	FsckUsage        FsckKind = "usage"         // directory usage record that doesn't add up
+	FsckParents      FsckKind = "parents"       // index of the directories nodes are in that doesn't match the dirents
+	FsckSchema       FsckKind = "schema"        // database still to be migrated
+)
+
+type FsckProblem struct {
+	Kind     FsckKind `json:"kind"`
+	Ino      uint64   `json:"ino,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	FileId   string   `json:"fileId,omitempty"`
+	Detail   string   `json:"detail"`
+	Repaired bool     `json:"repaired"`
+}
+
+type FsckReport struct {
+	Nodes    int           `json:"nodes"`
+	Assets   int           `json:"assets"`
+	Problems []FsckProblem `json:"problems"`
+}
+
+func (r *FsckReport) OK() bool { return len(r.Problems) == 0 }
+
+// Repaired reports whether every problem found was fixed.
+func (r *FsckReport) Repaired() bool {
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *FsckReport) WriteText(w io.Writer) error {
+	repaired := 0
+	for _, p := range r.Problems {
+		where := p.Path
+		if where == "" {
+			where = fmt.Sprintf("ino %d", p.Ino)
+		}
+		if p.Kind == FsckUnusedAssets {
+			where = p.FileId
+		}
+		if p.Kind == FsckRefs {
+			where = "assets"
+		}
+		if p.Kind == FsckSchema {
+			where = "database"
+		}
+
+		status := ""
+		if p.Repaired {
+			status = " (repaired)"
+			repaired++
+		}
+		if _, err := fmt.Fprintf(w, "%-13s %s: %s%s\n", p.Kind, where, p.Detail, status); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(
+		w, "checked %d nodes and %d asset records, %d problems, %d repaired\n",
+		r.Nodes, r.Assets, len(r.Problems), repaired,
+	)
+	return err
+}
+
+type FsckOptions struct {
+	// Repair drops dangling records and moves orphans to /lost+found.
+	Repair bool
+}
+
+// Fsck checks that every node is reachable from the root through valid
+// dirents and that file nodes and asset records match up. Without Repair
+// the database is only read. A database that still needs migrating is
+// migrated first and checked as it would be after, without Repair that
+// is rolled back along with the rest.
+func Fsck(db *bbolt.DB, opts FsckOptions) (*FsckReport, error) {
+	report := &FsckReport{}
+
+	var from int
+	var outdated bool
+	if err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		from, err = readVersion(tx)
+		outdated = from < SchemaVersion() && !isEmpty(tx)
+		return err
+	}); err != nil {
+		return report, err
+	}
+
+	run := db.View
+	if opts.Repair || outdated {
+		run = db.Update
+	}
+
+	err := run(func(tx *bbolt.Tx) error {
+		if isEmpty(tx) {
+			return nil
+		}
+		if outdated {
+			for _, m := range migrations[from:] {
+				if _, err := m.Up(tx); err != nil {
+					return fmt.Errorf("migration %d: %w", m.Version, err)
+				}
+			}
+			if err := writeVersion(tx, SchemaVersion()); err != nil {
+				return err
+			}
+			report.Problems = append(report.Problems, FsckProblem{
+				Kind:     FsckSchema,
+				Detail:   fmt.Sprintf("at version %d, checked as migrated to %d", from, SchemaVersion()),
+				Repaired: opts.Repair,
+			})
+		}
+		if err := checkSchema(tx); err != nil {
+			return err
+		}
+
+		c := &checker{
+			tx:     tx,
+			tree:   newTree(tx),
+			assets: tx.Bucket(assetBucket),
+			report: report,
+			repair: opts.Repair,
+			nodes:  map[uint64]*internal.Node{},
+			links:  map[uint64][]dirent{},
+			paths:  map[uint64]string{},
+			names:  map[uint64]string{},
+		}
+		if err := c.run(); err != nil {
+			return err
+		}
+		if outdated && !opts.Repair {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+
+	return report, err
+}
+
+type dirent struct {
+	parent uint64
+	name   string
+	ino    uint64
+}
+
+func (d dirent) key() []byte {
+	return direntKey(d.parent, d.name)
+}
+
+type checker struct {
+	*tree
+	tx     *bbolt.Tx
+	assets *bbolt.Bucket
+	report *FsckReport
+	repair bool
+
+	nodes map[uint64]*internal.Node
+	links map[uint64][]dirent // valid dirents by child
+	names map[uint64]string   // last known name of nodes behind a dangling dirent
+	dirs  map[uint64][]dirent // valid dirents by parent, in name order
+	paths map[uint64]string   // where each node ended up
+}
+
+func (c *checker) problem(p FsckProblem) {
+	p.Repaired = c.repair
+	c.report.Problems = append(c.report.Problems, p)
+}
+
+func (c *checker) run() error {
+	steps := []func() error{c.loadNodes, c.loadDirents, c.reattach, c.checkLinks, c.checkAssets, c.checkRefs, c.checkUsage, c.checkParents}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) loadNodes() error {
+	var corrupt [][]byte
+	err := c.inodes.ForEach(func(k, v []byte) error {
+		ino := binary.BigEndian.Uint64(k)
+		node, err := decodeNode(v)
+		if err != nil {
+			c.problem(FsckProblem{Kind: FsckCorrupt, Ino: ino, Detail: err.Error()})
+			corrupt = append(corrupt, k)
+			return nil
+		}
+		c.nodes[ino] = node
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if root, ok := c.nodes[rootIno]; !ok || !root.IsDir() {
+		c.problem(FsckProblem{Kind: FsckCorrupt, Ino: rootIno, Path: "/", Detail: "root directory missing, recreated empty"})
+		if c.repair {
+			root := internal.NewNode("/", true)
+			if err := c.putNode(rootIno, root); err != nil {
+				return err
+			}
+			c.nodes[rootIno] = root
+		}
+	}
+	c.report.Nodes = len(c.nodes)
+
+	if !c.repair {
+		return nil
+	}
+	for _, k := range corrupt {
+		if err := c.inodes.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) loadDirents() error {
+	c.dirs = map[uint64][]dirent{}
+
+	var dangling []dirent
+	err := c.dirents.ForEach(func(k, v []byte) error {
+		d := dirent{
+			parent: binary.BigEndian.Uint64(k),
+			name:   string(k[8:]),
+			ino:    binary.BigEndian.Uint64(v),
+		}
+
+		parent, ok := c.nodes[d.parent]
+		switch {
+		case c.nodes[d.ino] == nil || d.ino == rootIno:
+			c.problem(FsckProblem{
+				Kind:   FsckDangling,
+				Ino:    d.ino,
+				Detail: fmt.Sprintf("entry %q under %d points to a missing node", d.name, d.parent),
+			})
+		case !ok || !parent.IsDir():
+			c.names[d.ino] = d.name
+			c.problem(FsckProblem{
+				Kind:   FsckDangling,
+				Ino:    d.ino,
+				Detail: fmt.Sprintf("entry %q is under %d, which is not a directory", d.name, d.parent),
+			})
+		default:
+			c.links[d.ino] = append(c.links[d.ino], d)
+			c.dirs[d.parent] = append(c.dirs[d.parent], d)
+			return nil
+		}
+		dangling = append(dangling, d)
+		return nil
+	})
+	if err != nil || !c.repair {
+		return err
+	}
+
+	for _, d := range dangling {
+		if err := c.unlink(d.parent, d.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk records the paths of everything below ino that was not seen yet.
+func (c *checker) walk(ino uint64, pathStr string) {
+	c.paths[ino] = pathStr
+	for _, d := range c.dirs[ino] {
+		if _, seen := c.paths[d.ino]; !seen {
+			c.walk(d.ino, path.Join(pathStr, d.name))
+		}
+	}
+}
+
+// reattach finds the nodes the root can't reach and moves the top of each
+// unreachable subtree to /lost+found, the rest follows along.
+func (c *checker) reattach() error {
+	c.walk(rootIno, "/")
+
+	var lostIno uint64
+	for {
+		var unreached []uint64
+		for ino := range c.nodes {
+			if _, ok := c.paths[ino]; !ok {
+				unreached = append(unreached, ino)
+			}
+		}
+		if len(unreached) == 0 {
+			return nil
+		}
+		sort.Slice(unreached, func(i, j int) bool { return unreached[i] < unreached[j] })
+
+		// A top has no valid entry under another unreached directory,
+		// when all of them do they form a cycle and the lowest is cut out
+		var tops []uint64
+		for _, ino := range unreached {
+			if len(c.links[ino]) == 0 {
+				tops = append(tops, ino)
+			}
+		}
+		if len(tops) == 0 {
+			tops = unreached[:1]
+		}
+
+		if c.repair && lostIno == 0 {
+			var err error
+			if lostIno, err = c.lostFound(); err != nil {
+				return err
+			}
+		}
+
+		for _, ino := range tops {
+			name := fmt.Sprintf("#%d", ino)
+			detail := "not reachable from /"
+			if last, ok := c.names[ino]; ok {
+				detail = fmt.Sprintf("%q not reachable from /", last)
+			}
+			if links := c.links[ino]; len(links) > 0 {
+				detail = fmt.Sprintf("%q is in a directory cycle", links[0].name)
+			}
+
+			pathStr := path.Join("/", lostFound, name)
+			if c.repair {
+				for _, d := range c.links[ino] {
+					if err := c.unlink(d.parent, d.name); err != nil {
+						return err
+					}
+				}
+				if err := c.link(lostIno, name, ino); err != nil {
+					return err
+				}
+			}
+
+			c.problem(FsckProblem{Kind: FsckOrphan, Ino: ino, Path: pathStr, Detail: detail})
+			c.walk(ino, pathStr)
+		}
+	}
+}
+
+// lostFound returns the /lost+found directory, creating it if needed.
+func (c *checker) lostFound() (uint64, error) {
+	ino, err := c.child(rootIno, lostFound)
+	if err == nil {
+		if node := c.nodes[ino]; node == nil || !node.IsDir() {
+			return 0, fmt.Errorf("/%s exists and is not a directory", lostFound)
+		}
+		return ino, nil
+	}
+
+	if ino, err = c.inodes.NextSequence(); err != nil {
+		return 0, err
+	}
+	if err := c.putNode(ino, internal.NewNode("/"+lostFound, true)); err != nil {
+		return 0, err
+	}
+	return ino, c.link(rootIno, lostFound, ino)
+}
+
+func (c *checker) checkAssets() error {
+	inos := make([]uint64, 0, len(c.nodes))
+	for ino := range c.nodes {
+		inos = append(inos, ino)
+	}
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+
+	used := map[string]bool{}
+	for _, ino := range inos {
+		node := c.nodes[ino]
+		if node.IsDir() || node.IsSymlink() {
+			continue
+		}
+		if c.assets != nil && c.assets.Get([]byte(node.Id())) != nil {
+			used[node.Id()] = true
+			continue
+		}
+		if node.Size() == 0 {
+			continue
+		}
+
+		c.problem(FsckProblem{
+			Kind:   FsckNoAssets,
+			Ino:    ino,
+			Path:   c.paths[ino],
+			FileId: node.Id(),
+			Detail: fmt.Sprintf("%d bytes recorded but no parts, dropped", node.Size()),
+		})
+		if c.repair {
+			if err := c.dropFile(ino); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.assets == nil {
+		return nil
+	}
+	if err := snapshotFiles(c.tx, func(node *internal.Node) {
+		used[node.Id()] = true
+	}); err != nil {
+		return err
+	}
+
+	var unused [][]byte
+	err := c.assets.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		c.report.Assets++
+		if !used[string(k)] {
+			c.problem(FsckProblem{Kind: FsckUnusedAssets, FileId: string(k), Detail: "no file refers to these parts"})
+			unused = append(unused, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.repair {
+		for _, k := range unused {
+			if _, err := partIds(c.assets.Get(k)); err != nil {
+				// Never counted, there is nothing to take back
+				if err := c.assets.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := dropAssets(c.assets, string(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRefs compares the counts in the refs bucket with the asset lists.
+func (c *checker) checkRefs() error {
+	if c.assets == nil {
+		return nil
+	}
+	counts, err := countAllRefs(c.assets)
+	if err != nil {
+		return err
+	}
+
+	stored := map[int]int{}
+	if refs := c.assets.Bucket(refBucket); refs != nil {
+		err := refs.ForEach(func(k, _ []byte) error {
+			if len(k) == 8 {
+				id := int(binary.BigEndian.Uint64(k))
+				stored[id] = readRef(refs, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var ids []int
+	for id, count := range counts {
+		if stored[id] != count {
+			ids = append(ids, id)
+		}
+	}
+	for id := range stored {
+		if _, ok := counts[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		c.problem(FsckProblem{
+			Kind:   FsckRefs,
+			Detail: fmt.Sprintf("part %d counted %d times, in %d asset lists", id, stored[id], counts[id]),
+		})
+	}
+
+	if len(ids) == 0 || !c.repair {
+		return nil
+	}
+	return writeRefs(c.assets, counts)
+}
+
+// dropFile removes a file node along with the entries it is reached by.
+func (c *checker) dropFile(ino uint64) error {
+	for _, d := range c.links[ino] {
+		if err := c.unlink(d.parent, d.name); err != nil {
+			return err
+		}
+	}
+	if pathStr, ok := c.paths[ino]; ok && len(c.links[ino]) == 0 {
+		// Reattached to /lost+found
+		parent, name, err := c.parent(pathStr)
+		if err != nil {
+			return err
+		}
+		if err := c.unlink(parent, name); err != nil {
+			return err
+		}
+	}
+	return c.inodes.Delete(inoKey(ino))
+}
+
+// checkLinks compares the hard links files count with their entries.
+func (c *checker) checkLinks() error {
+	inos := make([]uint64, 0, len(c.nodes))
+	for ino := range c.nodes {
+		inos = append(inos, ino)
+	}
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+
+	for _, ino := range inos {
+		node := c.nodes[ino]
+		if node.IsDir() {
+			continue
+		}
+		// Orphans were given a single entry in /lost+found
+		entries := max(len(c.links[ino]), 1)
+		if node.Links() == entries {
+			continue
+		}
+
+		c.problem(FsckProblem{
+			Kind:   FsckLinks,
+			Ino:    ino,
+			Path:   c.paths[ino],
+			Detail: fmt.Sprintf("counts %d links but has %d entries", node.Links(), entries),
+		})
+		if c.repair {
+			node.SetLinks(entries)
+			if err := c.putNode(ino, node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkUsage counts what is below every directory again, after the other
+// steps had their say, and compares it with the usage records.
+func (c *checker) checkUsage() error {
+	if c.usage == nil {
+		c.problem(FsckProblem{Kind: FsckUsage, Path: "/", Detail: "usage records missing, recounted"})
+		if !c.repair {
+			return nil
+		}
+		_, err := countAllUsage(c.tx)
+		return err
+	}
+
+	counted := map[uint64]usageRecord{}
+	if _, err := c.countUsage(rootIno, 0, counted); err != nil {
+		return err
+	}
+
+	details := map[uint64]string{}
+	for ino, want := range counted {
+		got, ok, err := c.record(ino)
+		switch {
+		case err != nil:
+			details[ino] = err.Error()
+		case !ok:
+			details[ino] = "no usage record"
+		case got.Usage != want.Usage:
+			details[ino] = fmt.Sprintf(
+				"counts %d bytes, %d files and %d directories below, found %d, %d and %d",
+				got.Bytes, got.Files, got.Dirs, want.Bytes, want.Files, want.Dirs,
+			)
+		case got.parent != want.parent:
+			details[ino] = fmt.Sprintf("recorded under %d but is in %d", got.parent, want.parent)
+		}
+	}
+
+	var stale [][]byte
+	err := c.usage.ForEach(func(k, _ []byte) error {
+		ino := binary.BigEndian.Uint64(k)
+		if _, ok := counted[ino]; ok {
+			return nil
+		}
+		// Orphans are only counted once they are reattached
+		if node := c.nodes[ino]; node != nil && node.IsDir() && !c.repair {
+			return nil
+		}
+		c.problem(FsckProblem{Kind: FsckUsage, Ino: ino, Detail: "usage record of a directory that doesn't exist, dropped"})
+		stale = append(stale, k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	inos := make([]uint64, 0, len(details))
+	for ino := range details {
+		inos = append(inos, ino)
+	}
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+	for _, ino := range inos {
+		c.problem(FsckProblem{Kind: FsckUsage, Ino: ino, Path: c.paths[ino], Detail: details[ino]})
+	}
+
+	if !c.repair {
+		return nil
+	}
+	for _, k := range stale {
+		if err := c.usage.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, ino := range inos {
+		if err := c.usage.Put(inoKey(ino), encodeUsage(counted[ino])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParents compares the parents index with the dirents left after the
+// other steps.
+func (c *checker) checkParents() error {
+	if c.parents == nil {
+		c.problem(FsckProblem{Kind: FsckParents, Path: "/", Detail: "parents index missing, rebuilt"})
+		if !c.repair {
+			return nil
+		}
+		_, err := indexParents(c.tx)
+		return err
+	}
+
+	want := map[string]bool{}
+	if err := c.dirents.ForEach(func(k, v []byte) error {
+		if len(v) == 8 {
+			want[string(parentKey(binary.BigEndian.Uint64(v), binary.BigEndian.Uint64(k), string(k[8:])))] = true
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	stale := 0
+	if err := c.parents.ForEach(func(k, _ []byte) error {
+		if want[string(k)] {
+			delete(want, string(k))
+		} else {
+			stale++
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if stale == 0 && len(want) == 0 {
+		return nil
+	}
+
+	c.problem(FsckProblem{
+		Kind:   FsckParents,
+		Path:   "/",
+		Detail: fmt.Sprintf("%d entries missing and %d stale, rebuilt", len(want), stale),
+	})
+	if !c.repair {
+		return nil
+	}
+	_, err := indexParents(c.tx)
+	return err
+}
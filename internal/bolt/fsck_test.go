@@ -0,0 +1,359 @@
+package bolt
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// setupBrokenDB builds a namespace and then damages it behind the
+// MetaFs' back the way a crash or a bug could.
+func setupBrokenDB(t *testing.T) (*bbolt.DB, internal.MetaFileSystem) {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+
+	if err := provider.MkdirAll("/a/b"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, p := range []string{"/a/b/file", "/ok", "/empty", "/lost"} {
+		if err := provider.Touch(p); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	for _, p := range []string{"/a/b/file", "/ok", "/lost"} {
+		if err := provider.Sync(p, 10); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	var ids []string
+	for _, p := range []string{"/a/b/file", "/ok"} {
+		node, err := provider.Stat(p)
+		if err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		ids = append(ids, node.Id())
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		assets, err := tx.CreateBucketIfNotExists(assetBucket)
+		if err != nil {
+			return err
+		}
+		for _, id := range append(ids, "unused") {
+			if err := assets.Put([]byte(id), []byte("parts")); err != nil {
+				return err
+			}
+		}
+
+		t := newTree(tx)
+		// Cut /a loose, its subtree is left without a way in
+		if err := t.unlink(rootIno, "a"); err != nil {
+			return err
+		}
+		if err := t.link(rootIno, "ghost", 999); err != nil {
+			return err
+		}
+		return t.inodes.Put(inoKey(500), []byte("not gob"))
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return db, provider
+}
+
+func TestFsck(t *testing.T) {
+	db, provider := setupBrokenDB(t)
+
+	report, err := Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+
+	kinds := map[FsckKind]int{}
+	for _, p := range report.Problems {
+		kinds[p.Kind]++
+		if p.Repaired {
+			t.Errorf("Fsck() marked %s repaired without Repair", p.Kind)
+		}
+	}
+	want := map[FsckKind]int{FsckCorrupt: 1, FsckDangling: 1, FsckOrphan: 1, FsckNoAssets: 1, FsckUnusedAssets: 1}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("Fsck() found %d %s problems, want %d", kinds[kind], kind, n)
+		}
+	}
+	if _, err := provider.Stat("/lost"); err != nil {
+		t.Errorf("Fsck() changed the db without Repair: %v", err)
+	}
+
+	report, err = Fsck(db, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck() repair error = %v", err)
+	}
+	if !report.Repaired() {
+		t.Errorf("Fsck() repair left problems: %+v", report.Problems)
+	}
+
+	report, err = Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() after repair error = %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Fsck() after repair found %+v", report.Problems)
+	}
+
+	nodes, err := provider.Ls("/"+lostFound, 0, 0)
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("Ls() lost+found = %v, %v, want one entry", nodes, err)
+	}
+	orphan := nodes[0].Path()
+	if _, err := provider.Stat(orphan + "/b/file"); err != nil {
+		t.Errorf("Stat() reattached file error = %v", err)
+	}
+	if _, err := provider.Stat("/lost"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() file without parts error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if _, err := provider.Stat("/empty"); err != nil {
+		t.Errorf("Stat() empty file error = %v", err)
+	}
+}
+
+func TestFsckRefs(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	if _, err := snaps.Create("daily", "/"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		// Part 1 loses a count, part 99 is counted without being listed
+		assets := tx.Bucket(assetBucket)
+		if err := addRefs(assets, []int{1}, -1); err != nil {
+			return err
+		}
+		return addRefs(assets, []int{99}, 1)
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	report, err := Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	if len(report.Problems) != 2 || report.Problems[0].Kind != FsckRefs || report.Problems[1].Kind != FsckRefs {
+		t.Fatalf("Fsck() = %+v, want two refs problems", report.Problems)
+	}
+
+	if _, err := Fsck(db, FsckOptions{Repair: true}); err != nil {
+		t.Fatalf("Fsck() repair error = %v", err)
+	}
+	report, err = Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() after repair error = %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Fsck() after repair found %+v", report.Problems)
+	}
+	node, err := provider.Stat("/docs/a")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if got := partsOf(t, db, node.Id()); got[1] != 2 {
+		t.Errorf("parts after repair = %v, want part 1 in 2 lists", got)
+	}
+}
+
+func TestFsckLinks(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, p := range []string{"/one", "/two"} {
+		if err := provider.Link("/file", p); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	// Links have no parts however long their target is
+	if _, err := provider.Symlink("/some/long/target", "/symlink"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	// Only the entry goes, the usage records still add up
+	err = db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
+		if err := t.unlink(rootIno, "two"); err != nil {
+			return err
+		}
+		return t.account(rootIno, internal.Usage{Files: -1})
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	report, err := Fsck(db, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != FsckLinks {
+		t.Fatalf("Fsck() = %+v, want a single %s problem", report.Problems, FsckLinks)
+	}
+
+	node, err := provider.Stat("/one")
+	if err != nil || node.Links() != 2 {
+		t.Errorf("Stat() after repair = %v, %v, want 2 links", node, err)
+	}
+	if report, err := Fsck(db, FsckOptions{}); err != nil || !report.OK() {
+		t.Errorf("Fsck() after repair = %+v, %v", report, err)
+	}
+}
+
+func TestFsckUsage(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.MkdirAll("/a/b"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/a/b/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Sync("/a/b/file", 10); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	file, err := provider.Stat("/a/b/file")
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		assets, err := tx.CreateBucketIfNotExists(assetBucket)
+		if err != nil {
+			return err
+		}
+		if err := assets.Put([]byte(file.Id()), []byte("parts")); err != nil {
+			return err
+		}
+
+		t := newTree(tx)
+		ino, err := t.lookup("/a")
+		if err != nil {
+			return err
+		}
+		if err := t.usage.Put(inoKey(ino), encodeUsage(usageRecord{parent: rootIno})); err != nil {
+			return err
+		}
+		return t.usage.Put(inoKey(999), encodeUsage(usageRecord{parent: rootIno}))
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	report, err := Fsck(db, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	if len(report.Problems) != 2 {
+		t.Fatalf("Fsck() = %+v, want 2 %s problems", report.Problems, FsckUsage)
+	}
+	for _, p := range report.Problems {
+		if p.Kind != FsckUsage {
+			t.Errorf("Fsck() found %+v, want %s", p, FsckUsage)
+		}
+	}
+
+	want := internal.Usage{Bytes: 10, Files: 1, Dirs: 2}
+	if got, err := provider.(*MetaFs).Usage("/a"); err != nil || got != want {
+		t.Errorf("Usage() after repair = %+v, %v, want %+v", got, err, want)
+	}
+	if report, err := Fsck(db, FsckOptions{}); err != nil || !report.OK() {
+		t.Errorf("Fsck() after repair = %+v, %v", report, err)
+	}
+}
+
+func TestFsckParents(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.MkdirAll("/a"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/file", "/a/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	// The entry in /a is forgotten and one that was never there shows up
+	err = db.Update(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
+		a, err := t.lookup("/a")
+		if err != nil {
+			return err
+		}
+		ino, err := t.lookup("/file")
+		if err != nil {
+			return err
+		}
+		if err := t.parents.Delete(parentKey(ino, a, "link")); err != nil {
+			return err
+		}
+		return t.parents.Put(parentKey(ino, a, "gone"), nil)
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	report, err := Fsck(db, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != FsckParents {
+		t.Fatalf("Fsck() = %+v, want a single %s problem", report.Problems, FsckParents)
+	}
+
+	if report, err := Fsck(db, FsckOptions{}); err != nil || !report.OK() {
+		t.Errorf("Fsck() after repair = %+v, %v", report, err)
+	}
+
+	// Growing the file shows in /a again
+	if err := provider.Sync("/file", 10); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	want := internal.Usage{Bytes: 10, Files: 1, Dirs: 1}
+	if got, err := provider.(*MetaFs).Usage("/a"); err != nil || got != want {
+		t.Errorf("Usage() after repair = %+v, %v, want %+v", got, err, want)
+	}
+}
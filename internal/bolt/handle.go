@@ -0,0 +1,240 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxSize bounds how much a single compaction transaction copies.
+const compactTxSize = 64 << 20
+
+// DB is the part of *bbolt.DB the stores use. A *Handle implements it too,
+// which is what lets a running server swap in a compacted file.
+type DB interface {
+	View(fn func(*bbolt.Tx) error) error
+	Update(fn func(*bbolt.Tx) error) error
+	Close() error
+}
+
+// Handle shares one bolt database between the stores of a running server
+// and can replace the file underneath them. mu guards db against the
+// swap, writes is held by Compact while it copies so nothing written
+// then gets lost.
+type Handle struct {
+	mu     sync.RWMutex
+	writes sync.RWMutex
+	db     *bbolt.DB
+	opts   *bbolt.Options
+}
+
+// NewHandle wraps db, opts are used to reopen the file after a compaction.
+func NewHandle(db *bbolt.DB, opts *bbolt.Options) *Handle {
+	return &Handle{db: db, opts: opts}
+}
+
+func (h *Handle) View(fn func(*bbolt.Tx) error) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.db.View(fn)
+}
+
+func (h *Handle) Update(fn func(*bbolt.Tx) error) error {
+	h.writes.RLock()
+	defer h.writes.RUnlock()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.db.Update(fn)
+}
+
+func (h *Handle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.db.Close()
+}
+
+type BucketStats struct {
+	Name  string `json:"name"`
+	Keys  int    `json:"keys"`
+	Depth int    `json:"depth"`
+	Pages int    `json:"pages"` // branch, leaf and overflow pages
+	InUse int    `json:"inUse"` // bytes holding data
+	Alloc int    `json:"alloc"` // bytes of the pages it owns
+}
+
+type Stats struct {
+	Size      int64         `json:"size"`
+	PageSize  int           `json:"pageSize"`
+	FreePages int           `json:"freePages"` // free and pending, reused before the file grows
+	Buckets   []BucketStats `json:"buckets"`
+}
+
+func (s *Stats) WriteText(w io.Writer) error {
+	for _, b := range s.Buckets {
+		if _, err := fmt.Fprintf(
+			w, "%-10s %10d keys %8d pages %12d/%d bytes in use, depth %d\n",
+			b.Name, b.Keys, b.Pages, b.InUse, b.Alloc, b.Depth,
+		); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(
+		w, "file is %d bytes, %d free pages of %d bytes\n",
+		s.Size, s.FreePages, s.PageSize,
+	)
+	return err
+}
+
+func (h *Handle) Stats() (*Stats, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return ReadStats(h.db)
+}
+
+// ReadStats reports key counts and page usage per top level bucket.
+func ReadStats(db *bbolt.DB) (*Stats, error) {
+	dbStats := db.Stats()
+	stats := &Stats{
+		PageSize:  db.Info().PageSize,
+		FreePages: dbStats.FreePageN + dbStats.PendingPageN,
+	}
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		stats.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			s := b.Stats()
+			stats.Buckets = append(stats.Buckets, BucketStats{
+				Name:  string(name),
+				Keys:  s.KeyN,
+				Depth: s.Depth,
+				Pages: s.BranchPageN + s.BranchOverflowN + s.LeafPageN + s.LeafOverflowN,
+				InUse: s.BranchInuse + s.LeafInuse + s.InlineBucketInuse,
+				Alloc: s.BranchAlloc + s.LeafAlloc,
+			})
+			return nil
+		})
+	})
+
+	sort.Slice(stats.Buckets, func(i, j int) bool { return stats.Buckets[i].Name < stats.Buckets[j].Name })
+	return stats, err
+}
+
+type CompactResult struct {
+	Before int64         `json:"before"`
+	After  int64         `json:"after"`
+	Took   time.Duration `json:"took"`
+	// Swap is how long reads waited too, for the new file to be opened
+	Swap time.Duration `json:"swap"`
+}
+
+// Compact rewrites the database into a new file and swaps it in place of
+// the current one. Writes wait for all of it, reads only for the swap.
+// If anything fails before the swap the current file is kept as it was
+// and the new one is removed.
+func (h *Handle) Compact() (*CompactResult, error) {
+	h.writes.Lock()
+	defer h.writes.Unlock()
+
+	started := time.Now()
+	file := h.db.Path()
+	tmp := file + ".compact"
+	old := file + ".pre-compact"
+	// Gone by the time this runs unless something failed
+	defer func() { _ = os.Remove(tmp) }()
+
+	before, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	err = compactInto(h.db, tmp)
+	h.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	swapped := time.Now()
+
+	if err := h.db.Close(); err != nil {
+		return nil, err
+	}
+
+	// Keep the old file until the new one opened fine, so a failed swap
+	// can always go back to it
+	swapErr := os.Rename(file, old)
+	if swapErr == nil {
+		if swapErr = os.Rename(tmp, file); swapErr != nil {
+			_ = os.Rename(old, file)
+		}
+	}
+
+	db, err := bbolt.Open(file, 0600, h.opts)
+	if err == nil && swapErr == nil {
+		_ = os.Remove(old)
+	}
+	if err != nil && swapErr == nil {
+		// The compacted file does not open, put the old one back
+		_ = os.Rename(file, tmp)
+		_ = os.Rename(old, file)
+		db, err = bbolt.Open(file, 0600, h.opts)
+		swapErr = errors.New("compacted file failed to open, kept the old one")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reopen %s: %w", file, err)
+	}
+	h.db = db
+	if swapErr != nil {
+		return nil, fmt.Errorf("swap: %w", swapErr)
+	}
+
+	after, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactResult{Before: before, After: after, Took: time.Since(started), Swap: time.Since(swapped)}, nil
+}
+
+func compactInto(src *bbolt.DB, file string) error {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		return err
+	}
+	if err := bbolt.Compact(dst, src, compactTxSize); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	err = dst.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("check compacted file: %w", err)
+	}
+	return dst.Close()
+}
+
+func fileSize(file string) (int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,103 @@
+package bolt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestCompact(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	handle := NewHandle(db, nil)
+	provider, err := NewMetaFs(handle)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.Mkdir("/bulk"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	name := strings.Repeat("x", 200)
+	for i := 0; i < 2000; i++ {
+		if err := provider.Touch(fmt.Sprintf("/bulk/%s%d", name, i)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := provider.Touch("/keep"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.RemoveAll("/bulk"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	stats, err := handle.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	keys := map[string]int{}
+	for _, b := range stats.Buckets {
+		keys[b.Name] = b.Keys
+	}
+	if keys["inodes"] != 2 || keys["dirents"] != 1 {
+		t.Errorf("Stats() keys = %v, want 2 inodes and 1 dirent", keys)
+	}
+
+	result, err := handle.Compact()
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if result.After >= result.Before {
+		t.Errorf("Compact() size %d -> %d, want it to shrink", result.Before, result.After)
+	}
+
+	// The stores keep working on the swapped in file
+	if _, err := provider.Stat("/keep"); err != nil {
+		t.Errorf("Stat() after compact error = %v", err)
+	}
+	if err := provider.Touch("/new"); err != nil {
+		t.Errorf("Touch() after compact error = %v", err)
+	}
+}
+
+func TestCompactFailure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	handle := NewHandle(db, nil)
+	provider, err := NewMetaFs(handle)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+	if err := provider.Touch("/keep"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	// The old file can't be moved aside, so the swap fails
+	if err := os.MkdirAll(filepath.Join(file+".pre-compact", "busy"), 0700); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := handle.Compact(); err == nil {
+		t.Fatalf("Compact() succeeded")
+	}
+	if _, err := os.Stat(file + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("Stat() of the compacted file error = %v, want it removed", err)
+	}
+	if _, err := provider.Stat("/keep"); err != nil {
+		t.Errorf("Stat() after failed compact error = %v", err)
+	}
+	if err := provider.Touch("/new"); err != nil {
+		t.Errorf("Touch() after failed compact error = %v", err)
+	}
+}
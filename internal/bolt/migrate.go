@@ -0,0 +1,363 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+var (
+	schemaBucket = []byte("schema")
+	versionKey   = []byte("version")
+
+	// fileBucket held nodes keyed by full path before schema version 2
+	fileBucket = []byte("files")
+)
+
+var (
+	ErrSchemaOutdated = errors.New("database schema is outdated, run migrations first")
+	ErrSchemaTooNew   = errors.New("database schema is newer than this build")
+	errDryRun         = errors.New("dry run")
+)
+
+// Migration upgrades the database from Version-1 to Version and returns
+// how many records it rewrote. Each one runs in its own transaction
+// together with the version bump, so an interrupted upgrade resumes from
+// the last completed step.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *bbolt.Tx) (int, error)
+}
+
+// migrations must stay ordered by Version without gaps, append only.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "re-encode nodes with the current layout",
+		Up:          reencodeNodes,
+	},
+	{
+		Version:     2,
+		Description: "move nodes from paths to inodes and dirents",
+		Up:          pathsToInodes,
+	},
+	{
+		Version:     3,
+		Description: "count the asset lists every uploaded part is in",
+		Up:          countRefs,
+	},
+	{
+		Version:     4,
+		Description: "count what is below every directory",
+		Up:          countAllUsage,
+	},
+	{
+		Version:     5,
+		Description: "index the directories every node is in",
+		Up:          indexParents,
+	},
+}
+
+// SchemaVersion is the version this build reads and writes.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+type MigrateOptions struct {
+	// DryRun runs every pending migration and rolls it back
+	DryRun bool
+	// Backup is where the database is copied before migrating, empty skips it
+	Backup string
+}
+
+type MigrationResult struct {
+	Version     int
+	Description string
+	Changed     int
+}
+
+// Migrate brings db up to SchemaVersion. A new database is stamped with
+// the current version without running anything.
+func Migrate(db *bbolt.DB, opts MigrateOptions) (int, []MigrationResult, error) {
+	var from int
+	var fresh bool
+	if err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		from, err = readVersion(tx)
+		fresh = from == 0 && isEmpty(tx)
+		return err
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	if from > SchemaVersion() {
+		return from, nil, fmt.Errorf("%w: %d > %d", ErrSchemaTooNew, from, SchemaVersion())
+	}
+	if from == SchemaVersion() {
+		return from, nil, nil
+	}
+	if fresh {
+		if opts.DryRun {
+			return from, nil, nil
+		}
+		return from, nil, db.Update(func(tx *bbolt.Tx) error {
+			return writeVersion(tx, SchemaVersion())
+		})
+	}
+
+	if opts.DryRun {
+		var results []MigrationResult
+		err := db.Update(func(tx *bbolt.Tx) error {
+			for _, m := range migrations[from:] {
+				changed, err := m.Up(tx)
+				if err != nil {
+					return fmt.Errorf("migration %d: %w", m.Version, err)
+				}
+				results = append(results, MigrationResult{m.Version, m.Description, changed})
+			}
+			return errDryRun
+		})
+		if !errors.Is(err, errDryRun) {
+			return from, results, err
+		}
+		return from, results, nil
+	}
+
+	if opts.Backup != "" {
+		if err := db.View(func(tx *bbolt.Tx) error {
+			return tx.CopyFile(opts.Backup, 0600)
+		}); err != nil {
+			return from, nil, fmt.Errorf("backup before migrating: %w", err)
+		}
+	}
+
+	var results []MigrationResult
+	for _, m := range migrations[from:] {
+		var changed int
+		err := db.Update(func(tx *bbolt.Tx) error {
+			var err error
+			if changed, err = m.Up(tx); err != nil {
+				return err
+			}
+			return writeVersion(tx, m.Version)
+		})
+		if err != nil {
+			return from, results, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		results = append(results, MigrationResult{m.Version, m.Description, changed})
+	}
+	return from, results, nil
+}
+
+// checkSchema stamps a new database and refuses one at another version.
+func checkSchema(tx *bbolt.Tx) error {
+	version, err := readVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version == SchemaVersion():
+		return nil
+	case version > SchemaVersion():
+		return fmt.Errorf("%w: %d > %d", ErrSchemaTooNew, version, SchemaVersion())
+	case version == 0 && isEmpty(tx):
+		return writeVersion(tx, SchemaVersion())
+	}
+	return fmt.Errorf("%w: %d < %d", ErrSchemaOutdated, version, SchemaVersion())
+}
+
+// isEmpty reports whether no layout was ever written to the database.
+func isEmpty(tx *bbolt.Tx) bool {
+	return tx.Bucket(fileBucket) == nil && tx.Bucket(inodeBucket) == nil
+}
+
+func readVersion(tx *bbolt.Tx) (int, error) {
+	bucket := tx.Bucket(schemaBucket)
+	if bucket == nil {
+		return 0, nil
+	}
+	data := bucket.Get(versionKey)
+	if data == nil {
+		return 0, nil
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("corrupt schema version %x", data)
+	}
+	return int(binary.BigEndian.Uint64(data)), nil
+}
+
+func writeVersion(tx *bbolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists(schemaBucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(versionKey, binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+// forEachRewrite calls fn with every record of the bucket and stores what
+// it returns, counting the records that changed.
+func forEachRewrite(tx *bbolt.Tx, name []byte, fn func(k, v []byte) ([]byte, error)) (int, error) {
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return 0, nil
+	}
+
+	type record struct{ k, v []byte }
+	var updates []record
+	err := bucket.ForEach(func(k, v []byte) error {
+		updated, err := fn(k, v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if !bytes.Equal(updated, v) {
+			updates = append(updates, record{bytes.Clone(k), updated})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, u := range updates {
+		if err := bucket.Put(u.k, u.v); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
+
+func reencodeNodes(tx *bbolt.Tx) (int, error) {
+	return forEachRewrite(tx, fileBucket, func(_, v []byte) ([]byte, error) {
+		node, err := decodeNode(v)
+		if err != nil {
+			return nil, err
+		}
+		return encodeNode(node)
+	})
+}
+
+// pathsToInodes gives every node an inode and links it into its parent.
+// Keys sort parents before their children, so a single pass does it. A
+// node whose parent is missing goes to /lost+found under its old path,
+// escaped, once everything else is in place.
+func pathsToInodes(tx *bbolt.Tx) (int, error) {
+	files := tx.Bucket(fileBucket)
+	if files == nil {
+		return 0, nil
+	}
+
+	inodes, err := tx.CreateBucketIfNotExists(inodeBucket)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.CreateBucketIfNotExists(direntBucket); err != nil {
+		return 0, err
+	}
+	if err := inodes.SetSequence(rootIno); err != nil {
+		return 0, err
+	}
+	t := newTree(tx)
+
+	type legacy struct {
+		path string
+		node *internal.Node
+	}
+	inos := map[string]uint64{"/": rootIno}
+	moved := 0
+	var orphans []legacy
+	put := func(parent uint64, name string, l legacy) error {
+		ino, err := inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		if l.node.IsDir() {
+			inos[l.path] = ino
+		}
+		if err := t.putNode(ino, l.node); err != nil {
+			return err
+		}
+		moved++
+		return t.link(parent, name, ino)
+	}
+
+	err = files.ForEach(func(k, v []byte) error {
+		node, err := decodeNode(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+
+		pathStr := string(k)
+		if pathStr == "/" {
+			moved++
+			return t.putNode(rootIno, node)
+		}
+
+		parent, ok := inos[path.Dir(pathStr)]
+		if !ok {
+			orphans = append(orphans, legacy{pathStr, node})
+			return nil
+		}
+		return put(parent, path.Base(pathStr), legacy{pathStr, node})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if inodes.Get(inoKey(rootIno)) == nil {
+		if err := t.putNode(rootIno, internal.NewNode("/", true)); err != nil {
+			return 0, err
+		}
+	}
+
+	// Orphans below an orphaned directory follow it there
+	var lostIno uint64
+	for _, o := range orphans {
+		if parent, ok := inos[path.Dir(o.path)]; ok {
+			if err := put(parent, path.Base(o.path), o); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if lostIno == 0 {
+			if lostIno, err = legacyLostFound(t, inos); err != nil {
+				return 0, err
+			}
+		}
+		if err := put(lostIno, url.PathEscape(o.path[1:]), o); err != nil {
+			return 0, err
+		}
+	}
+
+	return moved, tx.DeleteBucket(fileBucket)
+}
+
+// legacyLostFound returns the /lost+found directory of a tree being moved
+// to inodes, creating it if the old one didn't have it.
+func legacyLostFound(t *tree, inos map[string]uint64) (uint64, error) {
+	pathStr := "/" + lostFound
+	if ino, ok := inos[pathStr]; ok {
+		return ino, nil
+	}
+	if _, err := t.child(rootIno, lostFound); err == nil {
+		return 0, fmt.Errorf("%s exists and is not a directory", pathStr)
+	}
+
+	ino, err := t.inodes.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if err := t.putNode(ino, internal.NewNode(pathStr, true)); err != nil {
+		return 0, err
+	}
+	inos[pathStr] = ino
+	return ino, t.link(rootIno, lostFound, ino)
+}
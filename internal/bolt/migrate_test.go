@@ -0,0 +1,243 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// legacyNode is how nodes were stored before digests existed.
+type legacyNode struct {
+	Id        string
+	Path      string
+	Name      string
+	IsDir     bool
+	Size      int64
+	Mode      os.FileMode
+	CreatedAt time.Time
+	ModTime   time.Time
+}
+
+func (n legacyNode) GobEncode() ([]byte, error) {
+	type alias legacyNode
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(alias(n))
+	return buf.Bytes(), err
+}
+
+// setupLegacyDB creates a database the way releases without a schema
+// version left it.
+func setupLegacyDB(t *testing.T, extra ...legacyNode) (*bbolt.DB, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	now := time.Now()
+	nodes := []legacyNode{
+		{Path: "/", IsDir: true, Mode: os.ModeDir | 0755, CreatedAt: now, ModTime: now},
+		{Path: "/dir", IsDir: true, Mode: os.ModeDir | 0755, CreatedAt: now, ModTime: now},
+		{Id: "file-id", Path: "/dir/file.txt", Size: 42, Mode: 0644, CreatedAt: now, ModTime: now},
+	}
+	nodes = append(nodes, extra...)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket(fileBucket)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(node); err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(node.Path), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return db, file
+}
+
+func version(t *testing.T, db *bbolt.DB) int {
+	t.Helper()
+	var v int
+	if err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		v, err = readVersion(tx)
+		return err
+	}); err != nil {
+		t.Fatalf("readVersion() error = %v", err)
+	}
+	return v
+}
+
+func TestMigrateFresh(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "fresh.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := NewMetaFs(db); err != nil {
+		t.Fatalf("NewMetaFs() error = %v", err)
+	}
+	if v := version(t, db); v != SchemaVersion() {
+		t.Errorf("version = %d, want %d", v, SchemaVersion())
+	}
+
+	_, results, err := Migrate(db, MigrateOptions{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("Migrate() = %v, %v, want nothing to do", results, err)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	db, file := setupLegacyDB(t)
+
+	if _, err := NewMetaFs(db); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("NewMetaFs() error = %v, want %v", err, ErrSchemaOutdated)
+	}
+	// NewMetaFs closes the db on failure
+	db, err := bbolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+
+	from, results, err := Migrate(db, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Migrate(dry run) error = %v", err)
+	}
+	if from != 0 || len(results) != SchemaVersion() || results[1].Changed != 3 {
+		t.Errorf("Migrate(dry run) = %d, %+v, want 3 nodes moved from version 0", from, results)
+	}
+	if v := version(t, db); v != 0 {
+		t.Fatalf("dry run changed version to %d", v)
+	}
+
+	backup := file + ".bak"
+	if _, _, err := Migrate(db, MigrateOptions{Backup: backup}); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if v := version(t, db); v != SchemaVersion() {
+		t.Errorf("version = %d, want %d", v, SchemaVersion())
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("backup not written: %v", err)
+	}
+
+	_ = db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(fileBucket) != nil {
+			t.Error("legacy files bucket left behind")
+		}
+		return nil
+	})
+
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("NewMetaFs() after migrating error = %v", err)
+	}
+	if nodes, err := provider.Ls("/dir", 0, 0); err != nil || len(nodes) != 1 || nodes[0].Path() != "/dir/file.txt" {
+		t.Errorf("Ls() = %v, %v, want /dir/file.txt", nodes, err)
+	}
+	node, err := provider.Stat("/dir/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Id() != "file-id" || node.Size() != 42 {
+		t.Errorf("Stat() = id %q size %d, want file-id and 42", node.Id(), node.Size())
+	}
+	want := internal.Usage{Bytes: 42, Files: 1, Dirs: 2}
+	if usage, err := provider.(*MetaFs).Usage("/"); err != nil || usage != want {
+		t.Errorf("Usage() = %+v, %v, want %+v", usage, err, want)
+	}
+
+	// Running again is a no-op
+	if _, results, err := Migrate(db, MigrateOptions{}); err != nil || len(results) != 0 {
+		t.Errorf("Migrate() again = %v, %v, want nothing to do", results, err)
+	}
+}
+
+func TestMigrateOrphans(t *testing.T) {
+	now := time.Now()
+	db, _ := setupLegacyDB(t,
+		legacyNode{Path: "/gone/orphan", IsDir: true, Mode: os.ModeDir | 0755, CreatedAt: now, ModTime: now},
+		legacyNode{Id: "child-id", Path: "/gone/orphan/child", Size: 7, Mode: 0644, CreatedAt: now, ModTime: now},
+	)
+
+	if _, _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("NewMetaFs() error = %v", err)
+	}
+	node, err := provider.Stat("/lost+found/gone%2Forphan/child")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Id() != "child-id" {
+		t.Errorf("Stat() = id %q, want child-id", node.Id())
+	}
+
+	// Nothing was uploaded, the sizes having no parts is expected
+	report, err := Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	for _, p := range report.Problems {
+		if p.Kind != FsckNoAssets {
+			t.Errorf("Fsck() found %+v, want the tree to hold together", p)
+		}
+	}
+}
+
+func TestFsckLegacy(t *testing.T) {
+	db, _ := setupLegacyDB(t)
+
+	report, err := Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	if len(report.Problems) == 0 || report.Problems[0].Kind != FsckSchema || report.Nodes != 3 {
+		t.Errorf("Fsck() = %+v, want 3 nodes and the schema to migrate", report)
+	}
+	if v := version(t, db); v != 0 {
+		t.Fatalf("Fsck() without repair changed version to %d", v)
+	}
+
+	if _, err := Fsck(db, FsckOptions{Repair: true}); err != nil {
+		t.Fatalf("Fsck(repair) error = %v", err)
+	}
+	if v := version(t, db); v != SchemaVersion() {
+		t.Errorf("version = %d, want %d", v, SchemaVersion())
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	db, _ := setupLegacyDB(t)
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		return writeVersion(tx, SchemaVersion()+1)
+	}); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if _, _, err := Migrate(db, MigrateOptions{}); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate() error = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
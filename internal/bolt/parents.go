@@ -0,0 +1,54 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.etcd.io/bbolt"
+)
+
+// The parents bucket is the dirents the other way around, keyed by child
+// id + parent id + name with nothing stored, so the directories a hard
+// linked file is in are found without going through every dirent. An
+// entry counts once for every name it has in a directory.
+var parentBucket = []byte("parents")
+
+func parentKey(ino, parent uint64, name string) []byte {
+	return append(binary.BigEndian.AppendUint64(inoKey(ino), parent), name...)
+}
+
+// parentsOf returns the directory of every entry of ino.
+func (t *tree) parentsOf(ino uint64) []uint64 {
+	var parents []uint64
+	prefix := inoKey(ino)
+	c := t.parents.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) >= 16 {
+			parents = append(parents, binary.BigEndian.Uint64(k[8:]))
+		}
+	}
+	return parents
+}
+
+// indexParents fills the parents bucket from scratch out of the dirents.
+func indexParents(tx *bbolt.Tx) (int, error) {
+	if tx.Bucket(parentBucket) != nil {
+		if err := tx.DeleteBucket(parentBucket); err != nil {
+			return 0, err
+		}
+	}
+	parents, err := tx.CreateBucket(parentBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	err = tx.Bucket(direntBucket).ForEach(func(k, v []byte) error {
+		if len(k) < 8 || len(v) != 8 {
+			return nil
+		}
+		indexed++
+		return parents.Put(parentKey(binary.BigEndian.Uint64(v), binary.BigEndian.Uint64(k), string(k[8:])), nil)
+	})
+	return indexed, err
+}
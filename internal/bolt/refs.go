@@ -0,0 +1,176 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+
+	"go.etcd.io/bbolt"
+)
+
+// Uploaded parts can be in the asset lists of several files, copies and
+// snapshots share them. The refs bucket, nested in assets, counts the
+// lists every part is in so it is only deleted once the last one is gone.
+var refBucket = []byte("refs")
+
+// partRef is what counting needs of a github.Asset, gob fills in the
+// fields both have.
+type partRef struct {
+	Id   int
+	Name string
+}
+
+// partIds returns the uploaded parts of an asset list, holes have neither
+// an id nor a name.
+func partIds(record []byte) ([]int, error) {
+	var parts []partRef
+	if err := gob.NewDecoder(bytes.NewReader(record)).Decode(&parts); err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	ids := make([]int, 0, len(parts))
+	for _, part := range parts {
+		if part.Id == 0 && part.Name == "" || seen[part.Id] {
+			continue
+		}
+		seen[part.Id] = true
+		ids = append(ids, part.Id)
+	}
+	return ids, nil
+}
+
+func refKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// CountRefs adds delta to the count of every part in the asset list
+// record, assets being the bucket holding the lists.
+func CountRefs(assets *bbolt.Bucket, record []byte, delta int) error {
+	if record == nil {
+		return nil
+	}
+	ids, err := partIds(record)
+	if err != nil {
+		return err
+	}
+	return addRefs(assets, ids, delta)
+}
+
+func addRefs(assets *bbolt.Bucket, ids []int, delta int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	refs, err := assets.CreateBucketIfNotExists(refBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		count := int64(readRef(refs, id)) + int64(delta)
+		if count <= 0 {
+			err = refs.Delete(refKey(id))
+		} else {
+			err = refs.Put(refKey(id), binary.BigEndian.AppendUint64(nil, uint64(count)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefCount is how many asset lists in assets have part id.
+func RefCount(assets *bbolt.Bucket, id int) int {
+	refs := assets.Bucket(refBucket)
+	if refs == nil {
+		return 0
+	}
+	return readRef(refs, id)
+}
+
+func readRef(refs *bbolt.Bucket, id int) int {
+	data := refs.Get(refKey(id))
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// copyAssets lets toId share the asset list of fromId, if it has one.
+func copyAssets(assets *bbolt.Bucket, fromId, toId string) error {
+	if assets == nil {
+		return nil
+	}
+	record := bytes.Clone(assets.Get([]byte(fromId)))
+	if record == nil {
+		return nil
+	}
+	if err := CountRefs(assets, record, 1); err != nil {
+		return err
+	}
+	return assets.Put([]byte(toId), record)
+}
+
+// dropAssets forgets the asset list of fileId.
+func dropAssets(assets *bbolt.Bucket, fileId string) error {
+	if assets == nil {
+		return nil
+	}
+	if err := CountRefs(assets, assets.Get([]byte(fileId)), -1); err != nil {
+		return err
+	}
+	return assets.Delete([]byte(fileId))
+}
+
+// countAllRefs is the count of every part over the asset lists in
+// assets. Lists that don't decode count for nothing.
+func countAllRefs(assets *bbolt.Bucket) (map[int]int, error) {
+	counts := map[int]int{}
+	err := assets.ForEach(func(_, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		ids, err := partIds(v)
+		if err != nil {
+			return nil
+		}
+		for _, id := range ids {
+			counts[id]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// countRefs fills the refs bucket from the asset lists already stored.
+func countRefs(tx *bbolt.Tx) (int, error) {
+	assets := tx.Bucket(assetBucket)
+	if assets == nil {
+		return 0, nil
+	}
+	counts, err := countAllRefs(assets)
+	if err != nil {
+		return 0, err
+	}
+	return len(counts), writeRefs(assets, counts)
+}
+
+// writeRefs replaces the refs bucket with counts.
+func writeRefs(assets *bbolt.Bucket, counts map[int]int) error {
+	if assets.Bucket(refBucket) != nil {
+		if err := assets.DeleteBucket(refBucket); err != nil {
+			return err
+		}
+	}
+	refs, err := assets.CreateBucket(refBucket)
+	if err != nil {
+		return err
+	}
+	for id, count := range counts {
+		if err := refs.Put(refKey(id), binary.BigEndian.AppendUint64(nil, uint64(count))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
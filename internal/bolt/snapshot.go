@@ -0,0 +1,449 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid/v2"
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// Every snapshot is a bucket of its own under snapshots holding a copy of
+// the inodes and dirents below the directory it was taken of, which is
+// its root. Files in it get new ids with a copy of the asset list of the
+// original, so the snapshot shares the stored parts instead of copying
+// them and reads go through the storage driver like any other file.
+var (
+	snapshotBucket  = []byte("snapshots")
+	snapshotInfoKey = []byte("info")
+)
+
+var ErrSnapshotName = errors.New("snapshot names may not be empty or contain /")
+
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // directory the snapshot was taken of
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+	Dirs    int       `json:"dirs"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Snapshots takes, lists and restores point-in-time copies of directory
+// trees of the MetaFs sharing its db. driver stores the content of the
+// files, deleting a snapshot goes through it.
+type Snapshots struct {
+	db     DB
+	driver internal.StorageDriver
+}
+
+func NewSnapshots(db DB, driver internal.StorageDriver) (*Snapshots, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshots{db: db, driver: driver}, nil
+}
+
+// snapshotTree is the tree of snapshot name, rooted at the directory it
+// was taken of.
+func snapshotTree(tx *bbolt.Tx, name string) (*tree, error) {
+	snaps := tx.Bucket(snapshotBucket)
+	if snaps == nil {
+		return nil, internal.ErrNotFound
+	}
+	b := snaps.Bucket([]byte(name))
+	if b == nil {
+		return nil, internal.ErrNotFound
+	}
+	return &tree{inodes: b.Bucket(inodeBucket), dirents: b.Bucket(direntBucket)}, nil
+}
+
+// Create takes a snapshot of the directory at root in one transaction, so
+// it sees the tree as it was at a single point in time.
+func (s *Snapshots) Create(name, root string) (*SnapshotInfo, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, ErrSnapshotName
+	}
+	info := &SnapshotInfo{Name: name, Path: path.Clean("/" + root), Created: time.Now().UTC()}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		snaps, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		if err != nil {
+			return err
+		}
+		if snaps.Bucket([]byte(name)) != nil {
+			return internal.ErrAlreadyExist
+		}
+
+		live := newTree(tx)
+		ino, node, err := live.resolve(info.Path)
+		if err != nil {
+			return err
+		}
+		if !node.IsDir() {
+			return internal.ErrIsNotDir
+		}
+
+		b, err := snaps.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		snap := &tree{}
+		if snap.inodes, err = b.CreateBucket(inodeBucket); err != nil {
+			return err
+		}
+		if snap.dirents, err = b.CreateBucket(direntBucket); err != nil {
+			return err
+		}
+		if err := snap.inodes.SetSequence(rootIno); err != nil {
+			return err
+		}
+
+		c := &treeCopy{from: live, to: snap, assets: tx.Bucket(assetBucket), linked: map[uint64]uint64{}}
+		if err := c.copy(ino, rootIno, node); err != nil {
+			return err
+		}
+		info.Files, info.Dirs, info.Bytes = c.files, c.dirs, c.bytes
+
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(snapshotInfoKey, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// treeCopy copies a tree into another one, giving files new ids that
+// share the asset list of the original. Hard links within the tree stay
+// links of the one copy.
+type treeCopy struct {
+	from, to *tree
+	assets   *bbolt.Bucket
+	linked   map[uint64]uint64 // copies of files with hard links
+
+	files, dirs int
+	bytes       int64
+}
+
+func (c *treeCopy) copy(from, to uint64, node *internal.Node) error {
+	if node.IsSymlink() {
+		return c.to.putNode(to, node)
+	}
+	if !node.IsDir() {
+		id := nanoid.Must()
+		if err := copyAssets(c.assets, node.Id(), id); err != nil {
+			return err
+		}
+		if node.Links() > 1 {
+			c.linked[from] = to
+		}
+		c.files++
+		c.bytes += node.Size()
+		return c.to.putNode(to, node.SetId(id).SetLinks(1))
+	}
+
+	c.dirs++
+	if err := c.to.putNode(to, node); err != nil {
+		return err
+	}
+
+	type entry struct {
+		name string
+		ino  uint64
+	}
+	var entries []entry
+	if err := c.from.children(from, func(name string, child uint64) (bool, error) {
+		entries = append(entries, entry{name, child})
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if ino, ok := c.linked[e.ino]; ok {
+			copied, err := c.to.node(ino, "")
+			if err != nil {
+				return err
+			}
+			if err := c.to.putNode(ino, copied.SetLinks(copied.Links()+1)); err != nil {
+				return err
+			}
+			if err := c.to.link(to, e.name, ino); err != nil {
+				return err
+			}
+			continue
+		}
+
+		child, err := c.from.node(e.ino, "")
+		if err != nil {
+			return err
+		}
+		ino, err := c.to.inodes.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := c.to.link(to, e.name, ino); err != nil {
+			return err
+		}
+		if err := c.copy(e.ino, ino, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFiles calls fn with every file node of every snapshot.
+func snapshotFiles(tx *bbolt.Tx, fn func(node *internal.Node)) error {
+	snaps := tx.Bucket(snapshotBucket)
+	if snaps == nil {
+		return nil
+	}
+	return snaps.ForEachBucket(func(k []byte) error {
+		inodes := snaps.Bucket(k).Bucket(inodeBucket)
+		if inodes == nil {
+			return nil
+		}
+		return inodes.ForEach(func(_, v []byte) error {
+			node, err := decodeNode(v)
+			if err != nil {
+				return fmt.Errorf("snapshot %s: %w", k, err)
+			}
+			if !node.IsDir() && !node.IsSymlink() {
+				fn(node)
+			}
+			return nil
+		})
+	})
+}
+
+// List returns every snapshot, oldest first.
+func (s *Snapshots) List() ([]SnapshotInfo, error) {
+	var infos []SnapshotInfo
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		snaps := tx.Bucket(snapshotBucket)
+		if snaps == nil {
+			return nil
+		}
+		return snaps.ForEachBucket(func(k []byte) error {
+			var info SnapshotInfo
+			if err := json.Unmarshal(snaps.Bucket(k).Get(snapshotInfoKey), &info); err != nil {
+				return fmt.Errorf("snapshot %s: %w", k, err)
+			}
+			infos = append(infos, info)
+			return nil
+		})
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos, err
+}
+
+// Delete drops snapshot name and then the content of its files, which
+// takes them out of the counts of their parts. Parts no other file lists
+// any more are deleted along with it. Metadata goes first, a failure
+// leaves asset lists behind for fsck rather than files without content.
+func (s *Snapshots) Delete(name string) error {
+	var ids []string
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		snap, err := snapshotTree(tx, name)
+		if err != nil {
+			return err
+		}
+
+		err = snap.inodes.ForEach(func(_, v []byte) error {
+			node, err := decodeNode(v)
+			if err != nil || node.IsDir() || node.IsSymlink() {
+				return err
+			}
+			ids = append(ids, node.Id())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(snapshotBucket).DeleteBucket([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := internal.DropContent(s.driver, id); err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Restore copies sub, a path within snapshot name, to the path to of
+// meta, which must not exist yet. The restored files share their parts
+// with the snapshot. What is restored is held against quota, nil for
+// none, and shows up all at once: it is put together next to to and
+// renamed into place, a failure takes it all back. It returns how many
+// entries were restored.
+func (s *Snapshots) Restore(name, sub string, meta internal.MetaFileSystem, to string, quota internal.Quota) (int, error) {
+	sub = path.Clean("/" + sub)
+	to = path.Clean("/" + to)
+
+	if _, err := meta.Stat(to); err == nil {
+		return 0, internal.ErrAlreadyExist
+	} else if !errors.Is(err, internal.ErrNotFound) {
+		return 0, err
+	}
+	if err := meta.MkdirAll(path.Dir(to)); err != nil {
+		return 0, err
+	}
+	tmp := path.Join(path.Dir(to), fmt.Sprintf(".%s.restore-%d", path.Base(to), time.Now().UnixNano()))
+
+	r := &restoreList{linked: map[uint64]*internal.Node{}}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		snap, err := snapshotTree(tx, name)
+		if err != nil {
+			return err
+		}
+		ino, node, err := snap.resolve(sub)
+		if err != nil {
+			return err
+		}
+		r.tree = snap
+		return r.collect(ino, node.SetPath(tmp))
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Quotas read usage on their own, outside of any transaction here
+	if quota != nil {
+		if err := quota.Check(to, r.usage); err != nil {
+			return 0, err
+		}
+	}
+
+	// Asset lists go in first, a node is only visible once its parts are
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		// Deleted since, its asset lists may be gone
+		if _, err := snapshotTree(tx, name); err != nil {
+			return err
+		}
+		assets := tx.Bucket(assetBucket)
+		for _, c := range r.copies {
+			if err := copyAssets(assets, c[0], c[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.put(meta, tmp, to); err != nil {
+		if uerr := s.undoRestore(r, meta, tmp); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("undo restore to %s: %w", to, uerr))
+		}
+		return 0, err
+	}
+	return len(r.nodes) + len(r.links), nil
+}
+
+// put creates what r holds at tmp and then moves it to to.
+func (r *restoreList) put(meta internal.MetaFileSystem, tmp, to string) error {
+	for _, node := range r.nodes {
+		if err := meta.Put(node); err != nil {
+			return err
+		}
+	}
+	for _, link := range r.links {
+		if err := meta.Link(link[0], link[1]); err != nil {
+			return err
+		}
+	}
+	return meta.Rename(tmp, to)
+}
+
+// undoRestore removes what a failed restore left at tmp, past any trash,
+// and then the asset lists it copied.
+func (s *Snapshots) undoRestore(r *restoreList, meta internal.MetaFileSystem, tmp string) error {
+	var err error
+	if d, ok := meta.(interface{ Discard(string) error }); ok {
+		err = d.Discard(tmp)
+	} else {
+		err = meta.RemoveAll(tmp)
+	}
+	if err != nil && !errors.Is(err, internal.ErrNotFound) {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		assets := tx.Bucket(assetBucket)
+		for _, node := range r.nodes {
+			if node.IsDir() || node.IsSymlink() {
+				continue
+			}
+			if err := dropAssets(assets, node.Id()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// restoreList is what a restore puts back, nodes in the order they can
+// be created in and then the hard links between them.
+type restoreList struct {
+	tree *tree
+
+	nodes  []*internal.Node
+	copies [][2]string               // asset lists to copy, snapshot id and new id
+	links  [][2]string               // existing path, new path
+	linked map[uint64]*internal.Node // where files with hard links went first
+	usage  internal.Usage            // what it all takes up
+}
+
+// collect adds node and everything below it, files get new ids to copy
+// their asset lists to.
+func (r *restoreList) collect(ino uint64, node *internal.Node) error {
+	if first, ok := r.linked[ino]; ok {
+		r.links = append(r.links, [2]string{first.Path(), node.Path()})
+		r.usage = r.usage.Add(fileUsage(first))
+		return nil
+	}
+	if node.IsSymlink() {
+		r.nodes = append(r.nodes, node)
+		r.usage = r.usage.Add(fileUsage(node))
+		return nil
+	}
+	if !node.IsDir() {
+		id := nanoid.Must()
+		r.copies = append(r.copies, [2]string{node.Id(), id})
+		if node.Links() > 1 {
+			r.linked[ino] = node
+		}
+		r.nodes = append(r.nodes, node.SetId(id).SetLinks(1))
+		r.usage = r.usage.Add(fileUsage(node))
+		return nil
+	}
+
+	r.nodes = append(r.nodes, node)
+	r.usage = r.usage.Add(internal.Usage{Dirs: 1})
+	return r.tree.children(ino, func(name string, child uint64) (bool, error) {
+		childNode, err := r.tree.node(child, path.Join(node.Path(), name))
+		if err != nil {
+			return false, err
+		}
+		return true, r.collect(child, childNode)
+	})
+}
@@ -0,0 +1,460 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+	"fafda/internal/memory"
+)
+
+// setupSnapshots builds a small namespace whose files have asset lists.
+func setupSnapshots(t *testing.T) (*bbolt.DB, internal.MetaFileSystem, *Snapshots) {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+	snaps, err := NewSnapshots(db, &partDriver{StorageDriver: memory.NewDriver(), db: db, deleted: map[int]bool{}})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := provider.MkdirAll("/docs/old"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for i, p := range []string{"/docs/a", "/docs/old/b", "/top"} {
+		if err := provider.Touch(p); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if err := provider.Sync(p, 10); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		node, err := provider.Stat(p)
+		if err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		err = db.Update(func(tx *bbolt.Tx) error {
+			assets, err := tx.CreateBucketIfNotExists(assetBucket)
+			if err != nil {
+				return err
+			}
+			record := partList(t, i+1)
+			if err := CountRefs(assets, record, 1); err != nil {
+				return err
+			}
+			return assets.Put([]byte(node.Id()), record)
+		})
+		if err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	return db, provider, snaps
+}
+
+// partDriver stores no content, purging drops the asset list of a file
+// and notes the parts no other list has, those the github driver deletes.
+type partDriver struct {
+	internal.StorageDriver
+	db      *bbolt.DB
+	deleted map[int]bool
+}
+
+func (d *partDriver) Purge(fileId string) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		assets := tx.Bucket(assetBucket)
+		record := assets.Get([]byte(fileId))
+		if record == nil {
+			return nil
+		}
+		ids, err := partIds(record)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if RefCount(assets, id) <= 1 {
+				d.deleted[id] = true
+			}
+		}
+		return dropAssets(assets, fileId)
+	})
+}
+
+// partList is an asset list of the uploaded parts ids, as the github
+// driver stores them.
+func partList(t *testing.T, ids ...int) []byte {
+	t.Helper()
+	parts := make([]*partRef, len(ids))
+	for i, id := range ids {
+		parts[i] = &partRef{Id: id, Name: fmt.Sprintf("part-%d", id)}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(parts); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// partsOf returns the parts fileId lists and how many lists each is in.
+func partsOf(t *testing.T, db *bbolt.DB, fileId string) map[int]int {
+	t.Helper()
+	var parts map[int]int
+	err := db.View(func(tx *bbolt.Tx) error {
+		assets := tx.Bucket(assetBucket)
+		record := assets.Get([]byte(fileId))
+		if record == nil {
+			return nil
+		}
+		ids, err := partIds(record)
+		parts = map[int]int{}
+		for _, id := range ids {
+			parts[id] = RefCount(assets, id)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	return parts
+}
+
+func TestSnapshotIsPointInTime(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	view := WithSnapshots(provider, snaps)
+
+	info, err := snaps.Create("daily", "/docs")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if info.Files != 2 || info.Dirs != 2 || info.Bytes != 20 {
+		t.Errorf("Create() = %+v, want 2 files, 2 dirs and 20 bytes", info)
+	}
+	if _, err := snaps.Create("daily", "/"); !errors.Is(err, internal.ErrAlreadyExist) {
+		t.Errorf("Create() of a taken name error = %v, want %v", err, internal.ErrAlreadyExist)
+	}
+	if _, err := snaps.Create("bad/name", "/"); !errors.Is(err, ErrSnapshotName) {
+		t.Errorf("Create() of a bad name error = %v, want %v", err, ErrSnapshotName)
+	}
+
+	// Changes to the live tree don't reach the snapshot
+	if err := provider.RemoveAll("/docs/old"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := provider.Sync("/docs/a", 99); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	node, err := view.Stat(SnapshotDir + "/daily/old/b")
+	if err != nil {
+		t.Fatalf("Stat() in snapshot error = %v", err)
+	}
+	if node.Path() != SnapshotDir+"/daily/old/b" || node.Size() != 10 {
+		t.Errorf("Stat() in snapshot = %s of %d bytes, want %s/daily/old/b of 10", node.Path(), node.Size(), SnapshotDir)
+	}
+	// Removing /docs/old only dropped the metadata, its list still counts
+	if got := partsOf(t, db, node.Id()); got[2] != 2 || len(got) != 1 {
+		t.Errorf("snapshot file parts = %v, want part 2 in 2 lists", got)
+	}
+	a, err := view.Stat(SnapshotDir + "/daily/a")
+	if err != nil || a.Size() != 10 {
+		t.Errorf("Stat() of a changed file in snapshot = %v, %v, want 10 bytes", a, err)
+	}
+
+	nodes, err := view.Ls(SnapshotDir, 0, 0)
+	if err != nil || len(nodes) != 1 || nodes[0].Name() != "daily" {
+		t.Errorf("Ls(%s) = %v, %v, want daily", SnapshotDir, nodes, err)
+	}
+	nodes, next, err := view.LsCursor(SnapshotDir+"/daily", 1, "")
+	if err != nil || len(nodes) != 1 || nodes[0].Path() != SnapshotDir+"/daily/a" || next == "" {
+		t.Errorf("LsCursor() in snapshot = %v, %q, %v, want a and a cursor", nodes, next, err)
+	}
+}
+
+func TestSnapshotIsReadOnly(t *testing.T) {
+	_, provider, snaps := setupSnapshots(t)
+	view := WithSnapshots(provider, snaps)
+	if _, err := snaps.Create("daily", "/"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"touch", func() error { return view.Touch(SnapshotDir + "/daily/new") }},
+		{"mkdir", func() error { return view.Mkdir(SnapshotDir + "/other") }},
+		{"remove", func() error { return view.Remove(SnapshotDir + "/daily/top") }},
+		{"remove all", func() error { return view.RemoveAll(SnapshotDir + "/daily") }},
+		{"rename out", func() error { return view.Rename(SnapshotDir+"/daily/top", "/moved") }},
+		{"rename in", func() error { return view.Rename("/top", SnapshotDir+"/daily/moved") }},
+		{"sync", func() error { return view.Sync(SnapshotDir+"/daily/top", 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, internal.ErrNotSupported) {
+				t.Errorf("error = %v, want %v", err, internal.ErrNotSupported)
+			}
+		})
+	}
+
+	if err := view.Touch("/live"); err != nil {
+		t.Errorf("Touch() outside snapshots error = %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	if _, err := snaps.Create("daily", "/"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := provider.RemoveAll("/docs"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+
+	restored, err := snaps.Restore("daily", "/docs", provider, "/back/docs", nil)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored != 4 {
+		t.Errorf("Restore() = %d, want 4", restored)
+	}
+	node, err := provider.Stat("/back/docs/old/b")
+	if err != nil {
+		t.Fatalf("Stat() restored file error = %v", err)
+	}
+	if node.Size() != 10 {
+		t.Errorf("restored file size = %d, want 10", node.Size())
+	}
+	if got := partsOf(t, db, node.Id()); got[2] != 3 || len(got) != 1 {
+		t.Errorf("restored file parts = %v, want part 2 in 3 lists", got)
+	}
+
+	if _, err := snaps.Restore("daily", "/top", provider, "/top", nil); !errors.Is(err, internal.ErrAlreadyExist) {
+		t.Errorf("Restore() over an existing path error = %v, want %v", err, internal.ErrAlreadyExist)
+	}
+	if _, err := snaps.Restore("weekly", "/", provider, "/weekly", nil); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Restore() of a missing snapshot error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+// limit is a quota on the number of entries anywhere.
+type limit int64
+
+func (l limit) Check(_ string, add internal.Usage) error {
+	if add.Files+add.Dirs > int64(l) {
+		return internal.ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (l limit) CheckMove(string, string) error { return nil }
+
+// failingLink is a MetaFs that can't make hard links.
+type failingLink struct {
+	internal.MetaFileSystem
+}
+
+func (failingLink) Link(string, string) error { return errors.New("link failed") }
+
+func TestSnapshotRestoreAllOrNothing(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	if err := provider.Link("/docs/a", "/docs/old/a"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := snaps.Create("daily", "/"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	a, err := provider.Stat("/docs/a")
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	before := partsOf(t, db, a.Id())
+
+	tests := []struct {
+		name  string
+		meta  internal.MetaFileSystem
+		quota internal.Quota
+	}{
+		{name: "over quota", meta: provider, quota: limit(4)},
+		{name: "failing", meta: failingLink{provider}, quota: limit(5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := snaps.Restore("daily", "/docs", tt.meta, "/back/docs", tt.quota); err == nil {
+				t.Fatalf("Restore() succeeded")
+			}
+			nodes, err := provider.Ls("/back", 0, 0)
+			if err != nil || len(nodes) != 0 {
+				t.Errorf("Ls() after Restore() = %v, %v, want nothing left behind", nodes, err)
+			}
+			if got := partsOf(t, db, a.Id()); fmt.Sprint(got) != fmt.Sprint(before) {
+				t.Errorf("parts after Restore() = %v, want %v", got, before)
+			}
+		})
+	}
+
+	if _, err := snaps.Restore("daily", "/docs", provider, "/back/docs", limit(5)); err != nil {
+		t.Errorf("Restore() within quota error = %v", err)
+	}
+}
+
+// dbQuota reads usage from db the way the bolt usage counter does, by
+// taking a transaction of its own.
+type dbQuota struct {
+	db *bbolt.DB
+}
+
+func (q dbQuota) Check(string, internal.Usage) error {
+	return q.db.Update(func(*bbolt.Tx) error { return nil })
+}
+
+func (q dbQuota) CheckMove(string, string) error { return nil }
+
+func TestSnapshotRestoreQuotaOutsideTx(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	if _, err := snaps.Create("daily", "/"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := snaps.Restore("daily", "/docs", provider, "/back/docs", dbQuota{db})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Restore() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Restore() blocked on the quota check")
+	}
+}
+
+func TestSnapshotAssetsAreUsed(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	info, err := snaps.Create("daily", "/")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	// Only the snapshot refers to these parts now
+	if err := provider.RemoveAll("/docs"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+
+	report, err := Fsck(db, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	unused := 0
+	for _, p := range report.Problems {
+		if p.Kind == FsckUnusedAssets {
+			unused++
+		}
+	}
+	// The asset lists of the deleted live files, the snapshot's are used
+	if unused != 2 {
+		t.Errorf("Fsck() found %d unused asset lists, want 2: %+v", unused, report.Problems)
+	}
+	node, err := WithSnapshots(provider, snaps).Stat(SnapshotDir + "/daily/docs/a")
+	if err != nil {
+		t.Fatalf("Stat() in snapshot error = %v", err)
+	}
+	// The live list is gone, only the snapshot's counts
+	if got := partsOf(t, db, node.Id()); got[1] != 1 || len(got) != 1 {
+		t.Errorf("snapshot file parts after repair = %v, want part 1 in 1 list", got)
+	}
+
+	if err := snaps.Delete(info.Name); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := partsOf(t, db, node.Id()); got != nil {
+		t.Errorf("snapshot file parts after Delete() = %v, want none", got)
+	}
+	// Parts 1 and 2 were only the snapshot's, /top still lists part 3
+	deleted := snaps.driver.(*partDriver).deleted
+	if !deleted[1] || !deleted[2] || deleted[3] {
+		t.Errorf("parts deleted = %v, want 1 and 2", deleted)
+	}
+	if infos, err := snaps.List(); err != nil || len(infos) != 0 {
+		t.Errorf("List() after Delete() = %v, %v, want none", infos, err)
+	}
+	if err := snaps.Delete(info.Name); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Delete() again error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestSnapshotLinks(t *testing.T) {
+	db, provider, snaps := setupSnapshots(t)
+	if err := provider.Link("/docs/a", "/docs/old/a"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/docs/a", "/outside"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := provider.Symlink("old/b", "/docs/b"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := snaps.Create("daily", "/docs"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Only the links within the snapshot count
+	view := WithSnapshots(provider, snaps)
+	first, err := view.Stat(SnapshotDir + "/daily/a")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	second, err := view.Stat(SnapshotDir + "/daily/old/a")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if first.Id() != second.Id() || first.Links() != 2 {
+		t.Errorf("snapshot links = %s and %s with %d links, want one file with 2", first.Id(), second.Id(), first.Links())
+	}
+	if got := partsOf(t, db, first.Id()); got[1] != 2 {
+		t.Errorf("snapshot parts = %v, want part 1 in 2 lists", got)
+	}
+
+	restored, err := snaps.Restore("daily", "/", provider, "/back", nil)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored != 6 {
+		t.Errorf("Restore() = %d, want 6", restored)
+	}
+	a, err := provider.Stat("/back/a")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	oldA, err := provider.Stat("/back/old/a")
+	if err != nil || oldA.Id() != a.Id() || a.Links() != 2 {
+		t.Errorf("restored links = %v, %v, want /back/old/a to share %s with 2 links", oldA, err, a.Id())
+	}
+	link, err := provider.Stat("/back/b")
+	if err != nil || link.Target() != "old/b" {
+		t.Errorf("restored symlink = %v, %v, want one to %q", link, err, "old/b")
+	}
+
+	if err := snaps.Delete("daily"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := partsOf(t, db, a.Id()); got[1] != 2 {
+		t.Errorf("parts after Delete() = %v, want part 1 in 2 lists", got)
+	}
+	if deleted := snaps.driver.(*partDriver).deleted; len(deleted) != 0 {
+		t.Errorf("parts deleted = %v, want none, the restored files list them", deleted)
+	}
+}
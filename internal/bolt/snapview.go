@@ -0,0 +1,347 @@
+package bolt
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// SnapshotDir is where WithSnapshots shows every snapshot as a read-only
+// directory.
+const SnapshotDir = "/.snapshots"
+
+type snapshotView struct {
+	internal.MetaFileSystem
+	snaps *Snapshots
+}
+
+// WithSnapshots serves the snapshots of snaps below SnapshotDir and
+// everything else from meta. Nothing below SnapshotDir can be changed.
+func WithSnapshots(meta internal.MetaFileSystem, snaps *Snapshots) internal.MetaFileSystem {
+	return &snapshotView{MetaFileSystem: meta, snaps: snaps}
+}
+
+// Unwrap returns the MetaFileSystem everything outside SnapshotDir is
+// served from.
+func (v *snapshotView) Unwrap() internal.MetaFileSystem {
+	return v.MetaFileSystem
+}
+
+// split tells whether pathStr is below SnapshotDir, and if so which
+// snapshot and which path within it, name is empty for SnapshotDir itself.
+func split(pathStr string) (name, rest string, ok bool) {
+	pathStr = path.Clean("/" + pathStr)
+	if pathStr == SnapshotDir {
+		return "", "", true
+	}
+	within, ok := strings.CutPrefix(pathStr, SnapshotDir+"/")
+	if !ok {
+		return "", "", false
+	}
+	name, rest, _ = strings.Cut(within, "/")
+	return name, "/" + rest, true
+}
+
+func readOnly(paths ...string) bool {
+	for _, p := range paths {
+		if _, _, ok := split(p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotDirs lists the snapshots as directories made when they were.
+func (v *snapshotView) snapshotDirs() ([]internal.Node, error) {
+	infos, err := v.snaps.List()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]internal.Node, len(infos))
+	for i, info := range infos {
+		nodes[i] = *internal.NewNode(path.Join(SnapshotDir, info.Name), true).
+			SetCreatedAt(info.Created).
+			SetModTime(info.Created)
+	}
+	// Listings are in name order everywhere else
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name() < nodes[j].Name() })
+	return nodes, nil
+}
+
+// within runs fn on the tree of snapshot name and puts the nodes it
+// returns back below SnapshotDir.
+func (v *snapshotView) within(name string, fn func(t *tree) ([]*internal.Node, error)) error {
+	return v.snaps.db.View(func(tx *bbolt.Tx) error {
+		t, err := snapshotTree(tx, name)
+		if err != nil {
+			return err
+		}
+		nodes, err := fn(t)
+		for _, node := range nodes {
+			node.SetPath(path.Join(SnapshotDir, name, node.Path()))
+		}
+		return err
+	})
+}
+
+func (v *snapshotView) Stat(pathStr string) (*internal.Node, error) {
+	name, rest, ok := split(pathStr)
+	if !ok {
+		return v.MetaFileSystem.Stat(pathStr)
+	}
+	if name == "" {
+		return internal.NewNode(SnapshotDir, true), nil
+	}
+
+	var node *internal.Node
+	err := v.within(name, func(t *tree) ([]*internal.Node, error) {
+		var err error
+		if _, node, err = t.resolve(rest); err != nil {
+			return nil, err
+		}
+		return []*internal.Node{node}, nil
+	})
+	return node, err
+}
+
+func (v *snapshotView) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	name, rest, ok := split(pathStr)
+	if !ok {
+		return v.MetaFileSystem.Ls(pathStr, limit, offset)
+	}
+	if name == "" {
+		nodes, err := v.snapshotDirs()
+		if err != nil {
+			return nil, err
+		}
+		nodes = nodes[min(offset, len(nodes)):]
+		if limit > 0 {
+			nodes = nodes[:min(limit, len(nodes))]
+		}
+		return nodes, nil
+	}
+
+	var files []internal.Node
+	err := v.within(name, func(t *tree) ([]*internal.Node, error) {
+		var err error
+		files, err = t.ls(rest, limit, offset)
+		return pointers(files), err
+	})
+	return files, err
+}
+
+func (v *snapshotView) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	name, rest, ok := split(pathStr)
+	if !ok {
+		return v.MetaFileSystem.LsCursor(pathStr, limit, cursor)
+	}
+	after, err := internal.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if name == "" {
+		nodes, err := v.snapshotDirs()
+		if err != nil {
+			return nil, "", err
+		}
+		start := 0
+		for start < len(nodes) && after != "" && nodes[start].Name() <= after {
+			start++
+		}
+		nodes = nodes[start:]
+		if limit > 0 && len(nodes) > limit {
+			nodes = nodes[:limit]
+			return nodes, internal.EncodeCursor(nodes[limit-1].Name()), nil
+		}
+		return nodes, "", nil
+	}
+
+	var files []internal.Node
+	var next string
+	err = v.within(name, func(t *tree) ([]*internal.Node, error) {
+		var err error
+		files, next, err = t.lsAfter(rest, limit, after)
+		return pointers(files), err
+	})
+	return files, next, err
+}
+
+func pointers(nodes []internal.Node) []*internal.Node {
+	ptrs := make([]*internal.Node, len(nodes))
+	for i := range nodes {
+		ptrs[i] = &nodes[i]
+	}
+	return ptrs
+}
+
+func (v *snapshotView) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	if readOnly(pathStr) {
+		return nil, internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Create(pathStr, isDir)
+}
+
+func (v *snapshotView) Chtimes(pathStr string, mtime time.Time) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Chtimes(pathStr, mtime)
+}
+
+func (v *snapshotView) Touch(pathStr string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Touch(pathStr)
+}
+
+func (v *snapshotView) Mkdir(pathStr string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Mkdir(pathStr)
+}
+
+func (v *snapshotView) MkdirAll(pathStr string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.MkdirAll(pathStr)
+}
+
+func (v *snapshotView) Remove(pathStr string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Remove(pathStr)
+}
+
+func (v *snapshotView) RemoveAll(pathStr string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.RemoveAll(pathStr)
+}
+
+func (v *snapshotView) Rename(oldpath, newpath string) error {
+	if readOnly(oldpath, newpath) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Rename(oldpath, newpath)
+}
+
+// Copy takes files out of a snapshot as well, nothing can be copied in.
+func (v *snapshotView) Copy(src, dst string) (*internal.Node, error) {
+	if readOnly(dst) {
+		return nil, internal.ErrNotSupported
+	}
+	if !readOnly(src) {
+		return v.MetaFileSystem.Copy(src, dst)
+	}
+
+	node, err := v.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return nil, internal.ErrIsDir
+	}
+	if _, err := v.MetaFileSystem.Stat(dst); err == nil {
+		return nil, internal.ErrAlreadyExist
+	} else if !errors.Is(err, internal.ErrNotFound) {
+		return nil, err
+	}
+
+	file := node.CopyTo(path.Clean(dst))
+	if err := v.MetaFileSystem.Put(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (v *snapshotView) Sync(pathStr string, size int64) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Sync(pathStr, size)
+}
+
+func (v *snapshotView) SetDigest(pathStr string, digest internal.Digest) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.SetDigest(pathStr, digest)
+}
+
+func (v *snapshotView) Put(node *internal.Node) error {
+	if readOnly(node.Path()) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Put(node)
+}
+
+func (v *snapshotView) Symlink(target, pathStr string) (*internal.Node, error) {
+	if readOnly(pathStr) {
+		return nil, internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Symlink(target, pathStr)
+}
+
+func (v *snapshotView) Link(oldpath, newpath string) error {
+	if readOnly(oldpath, newpath) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Link(oldpath, newpath)
+}
+
+func (v *snapshotView) Chmod(pathStr string, mode os.FileMode) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Chmod(pathStr, mode)
+}
+
+func (v *snapshotView) Chown(pathStr string, uid, gid int) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.Chown(pathStr, uid, gid)
+}
+
+func (v *snapshotView) GetXattr(pathStr, name string) (string, error) {
+	node, err := v.Stat(pathStr)
+	if err != nil {
+		return "", err
+	}
+	return node.Xattr(name)
+}
+
+func (v *snapshotView) SetXattr(pathStr, name, value string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.SetXattr(pathStr, name, value)
+}
+
+func (v *snapshotView) ListXattr(pathStr string) ([]string, error) {
+	node, err := v.Stat(pathStr)
+	if err != nil {
+		return nil, err
+	}
+	return node.XattrNames(), nil
+}
+
+func (v *snapshotView) RemoveXattr(pathStr, name string) error {
+	if readOnly(pathStr) {
+		return internal.ErrNotSupported
+	}
+	return v.MetaFileSystem.RemoveXattr(pathStr, name)
+}
@@ -0,0 +1,190 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+// The usage bucket keeps, for every directory, what is below it all the
+// way down along with its parent, so a change only has to walk up to the
+// root. Directories can't be hard linked and have a single parent. Every
+// entry of a file counts its size, hard links and copies included, and
+// symbolic links count as files without content.
+var usageBucket = []byte("usage")
+
+// usageRecord is parent, bytes, files and dirs, big endian.
+type usageRecord struct {
+	parent uint64
+	internal.Usage
+}
+
+func encodeUsage(r usageRecord) []byte {
+	data := binary.BigEndian.AppendUint64(nil, r.parent)
+	data = binary.BigEndian.AppendUint64(data, uint64(r.Bytes))
+	data = binary.BigEndian.AppendUint64(data, uint64(r.Files))
+	return binary.BigEndian.AppendUint64(data, uint64(r.Dirs))
+}
+
+func decodeUsage(data []byte) (usageRecord, error) {
+	if len(data) != 32 {
+		return usageRecord{}, fmt.Errorf("corrupt usage record %x", data)
+	}
+	return usageRecord{
+		parent: binary.BigEndian.Uint64(data),
+		Usage: internal.Usage{
+			Bytes: int64(binary.BigEndian.Uint64(data[8:])),
+			Files: int64(binary.BigEndian.Uint64(data[16:])),
+			Dirs:  int64(binary.BigEndian.Uint64(data[24:])),
+		},
+	}, nil
+}
+
+// fileUsage is what one entry of a file node adds to its directory.
+func fileUsage(node *internal.Node) internal.Usage {
+	if node.IsSymlink() {
+		return internal.Usage{Files: 1}
+	}
+	return internal.Usage{Bytes: node.Size(), Files: 1}
+}
+
+func (t *tree) record(dir uint64) (usageRecord, bool, error) {
+	data := t.usage.Get(inoKey(dir))
+	if data == nil {
+		return usageRecord{}, false, nil
+	}
+	r, err := decodeUsage(data)
+	return r, err == nil, err
+}
+
+// entryUsage is what the entry of ino adds to its directory.
+func (t *tree) entryUsage(ino uint64, node *internal.Node) (internal.Usage, error) {
+	if !node.IsDir() {
+		return fileUsage(node), nil
+	}
+	if t.usage == nil {
+		return internal.Usage{}, nil
+	}
+	r, _, err := t.record(ino)
+	return r.Add(internal.Usage{Dirs: 1}), err
+}
+
+// account adds delta to dir and every directory above it. Trees without
+// a usage bucket, snapshots and databases being migrated, count nothing.
+// A missing record stops the walk, fsck rebuilds it.
+func (t *tree) account(dir uint64, delta internal.Usage) error {
+	if t.usage == nil || delta == (internal.Usage{}) {
+		return nil
+	}
+	for dir != 0 {
+		r, ok, err := t.record(dir)
+		if err != nil || !ok {
+			return err
+		}
+		r.Usage = r.Add(delta)
+		if err := t.usage.Put(inoKey(dir), encodeUsage(r)); err != nil {
+			return err
+		}
+		dir = r.parent
+	}
+	return nil
+}
+
+// accountFile adds delta to every directory the file ino has an entry
+// in. Only files with hard links need their parents looked up, the
+// others are in parent.
+func (t *tree) accountFile(ino uint64, node *internal.Node, parent uint64, delta internal.Usage) error {
+	if t.usage == nil || node.Links() <= 1 {
+		return t.account(parent, delta)
+	}
+
+	for _, p := range t.parentsOf(ino) {
+		if err := t.account(p, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setParent records the directory dir is in, keeping what it holds.
+func (t *tree) setParent(dir, parent uint64) error {
+	if t.usage == nil {
+		return nil
+	}
+	r, _, err := t.record(dir)
+	if err != nil {
+		return err
+	}
+	r.parent = parent
+	return t.usage.Put(inoKey(dir), encodeUsage(r))
+}
+
+// countUsage adds up what is below ino and stores it in records keyed by
+// directory, along with everything further down. Entries to nodes that
+// are missing or don't decode are skipped, fsck reports them on its own.
+func (t *tree) countUsage(ino, parent uint64, records map[uint64]usageRecord) (internal.Usage, error) {
+	// Claimed before going down so a directory cycle ends here
+	records[ino] = usageRecord{parent: parent}
+
+	var total internal.Usage
+	err := t.children(ino, func(_ string, child uint64) (bool, error) {
+		node, err := t.node(child, "")
+		if err != nil {
+			return true, nil
+		}
+		if !node.IsDir() {
+			total = total.Add(fileUsage(node))
+			return true, nil
+		}
+		if _, seen := records[child]; seen {
+			return true, nil
+		}
+		below, err := t.countUsage(child, ino, records)
+		if err != nil {
+			return false, err
+		}
+		total = total.Add(below).Add(internal.Usage{Dirs: 1})
+		return true, nil
+	})
+	records[ino] = usageRecord{parent: parent, Usage: total}
+	return total, err
+}
+
+// countAllUsage is countUsage from the root, for a tree without records.
+func countAllUsage(tx *bbolt.Tx) (int, error) {
+	usage, err := tx.CreateBucketIfNotExists(usageBucket)
+	if err != nil {
+		return 0, err
+	}
+	t := newTree(tx)
+
+	records := map[uint64]usageRecord{}
+	if _, err := t.countUsage(rootIno, 0, records); err != nil {
+		return 0, err
+	}
+	for dir, r := range records {
+		if err := usage.Put(inoKey(dir), encodeUsage(r)); err != nil {
+			return 0, err
+		}
+	}
+	return len(records), nil
+}
+
+// Usage is what the entry at path takes up, a directory counts itself
+// along with everything below it like du does.
+func (mf *MetaFs) Usage(pathStr string) (internal.Usage, error) {
+	var usage internal.Usage
+	err := mf.db.View(func(tx *bbolt.Tx) error {
+		t := newTree(tx)
+		ino, node, err := t.resolve(pathStr)
+		if err != nil {
+			return err
+		}
+		usage, err = t.entryUsage(ino, node)
+		return err
+	})
+	return usage, err
+}
@@ -0,0 +1,109 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+)
+
+func TestUsage(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer provider.Close()
+	mf := provider.(*MetaFs)
+
+	wantUsage := func(t *testing.T, p string, want internal.Usage) {
+		t.Helper()
+		if got, err := mf.Usage(p); err != nil || got != want {
+			t.Errorf("Usage(%s) = %+v, %v, want %+v", p, got, err, want)
+		}
+	}
+
+	if err := provider.MkdirAll("/team/a/b"); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := provider.Touch("/team/a/b/file"); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if err := provider.Sync("/team/a/b/file", 100); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	wantUsage(t, "/", internal.Usage{Bytes: 100, Files: 1, Dirs: 4})
+	wantUsage(t, "/team/a", internal.Usage{Bytes: 100, Files: 1, Dirs: 2})
+	wantUsage(t, "/team/a/b/file", internal.Usage{Bytes: 100, Files: 1})
+
+	// Every entry of a file counts it, symbolic links count no content
+	if _, err := provider.Copy("/team/a/b/file", "/team/copy"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := provider.Link("/team/a/b/file", "/team/link"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	if _, err := provider.Symlink("a/b/file", "/team/symlink"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	wantUsage(t, "/team", internal.Usage{Bytes: 300, Files: 4, Dirs: 3})
+
+	// Growing a hard linked file shows in every directory it is in
+	if err := provider.Sync("/team/link", 150); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	wantUsage(t, "/team/a", internal.Usage{Bytes: 150, Files: 1, Dirs: 2})
+	wantUsage(t, "/team", internal.Usage{Bytes: 400, Files: 4, Dirs: 3})
+
+	if err := provider.Mkdir("/other"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := provider.Rename("/team/a", "/other/a"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	wantUsage(t, "/team", internal.Usage{Bytes: 250, Files: 3, Dirs: 1})
+	wantUsage(t, "/other", internal.Usage{Bytes: 150, Files: 1, Dirs: 3})
+
+	// The parent moved along, growing below it counts in the new place
+	if err := provider.Sync("/other/a/b/file", 200); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	wantUsage(t, "/other", internal.Usage{Bytes: 200, Files: 1, Dirs: 3})
+	wantUsage(t, "/team", internal.Usage{Bytes: 300, Files: 3, Dirs: 1})
+
+	if err := provider.Remove("/team/copy"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := provider.RemoveAll("/other"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	wantUsage(t, "/", internal.Usage{Bytes: 200, Files: 2, Dirs: 2})
+
+	node, err := provider.Stat("/team/link")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := provider.Put(node.SetSize(50)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := provider.Put(internal.NewNode("/team/put", false).SetSize(10)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	wantUsage(t, "/team", internal.Usage{Bytes: 60, Files: 3, Dirs: 1})
+
+	// Nothing was uploaded, only the usage records matter here
+	report, err := Fsck(db, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	for _, p := range report.Problems {
+		if p.Kind == FsckUsage {
+			t.Errorf("Fsck() found %+v, want the records to add up", p)
+		}
+	}
+}
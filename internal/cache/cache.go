@@ -0,0 +1,403 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fafda/internal"
+)
+
+// MetaFs keeps recently used nodes and directory listings of another
+// MetaFileSystem in memory. Writes go straight through and drop whatever
+// they could have made stale, so it is only correct as long as nothing
+// else writes to the wrapped store.
+type MetaFs struct {
+	next internal.MetaFileSystem
+
+	mu    sync.Mutex
+	nodes *lru[*internal.Node]     // nil for a path known not to exist
+	dirs  *lru[map[string]listing] // pages by the arguments they were listed with
+	gen   uint64                   // bumped by every invalidation
+
+	nodeHits, nodeMisses atomic.Int64
+	listHits, listMisses atomic.Int64
+}
+
+type listing struct {
+	nodes []internal.Node
+	next  string
+}
+
+type Stats struct {
+	Nodes      int     `json:"nodes"`
+	Dirs       int     `json:"dirs"`
+	NodeHits   int64   `json:"nodeHits"`
+	NodeMisses int64   `json:"nodeMisses"`
+	ListHits   int64   `json:"listHits"`
+	ListMisses int64   `json:"listMisses"`
+	HitRate    float64 `json:"hitRate"`
+}
+
+// New caches up to nodes nodes and the listings of up to dirs directories
+// of next.
+func New(next internal.MetaFileSystem, nodes, dirs int) *MetaFs {
+	return &MetaFs{
+		next:  next,
+		nodes: newLRU[*internal.Node](max(nodes, 1)),
+		dirs:  newLRU[map[string]listing](max(dirs, 1)),
+	}
+}
+
+func (c *MetaFs) Stats() Stats {
+	c.mu.Lock()
+	stats := Stats{Nodes: c.nodes.len(), Dirs: c.dirs.len()}
+	c.mu.Unlock()
+
+	stats.NodeHits = c.nodeHits.Load()
+	stats.NodeMisses = c.nodeMisses.Load()
+	stats.ListHits = c.listHits.Load()
+	stats.ListMisses = c.listMisses.Load()
+	if total := stats.NodeHits + stats.NodeMisses + stats.ListHits + stats.ListMisses; total > 0 {
+		stats.HitRate = float64(stats.NodeHits+stats.ListHits) / float64(total)
+	}
+	return stats
+}
+
+func (c *MetaFs) Name() string {
+	return c.next.Name()
+}
+
+func clean(pathStr string) string {
+	return path.Clean("/" + pathStr)
+}
+
+func copyNode(node *internal.Node) *internal.Node {
+	if node == nil {
+		return nil
+	}
+	copied := *node
+	return &copied
+}
+
+// generation is read before going to the wrapped store, a result is only
+// cached when nothing was invalidated meanwhile.
+func (c *MetaFs) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// invalidate drops the node at each path and the listing of its parent.
+func (c *MetaFs) invalidate(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, p := range paths {
+		p = clean(p)
+		c.nodes.remove(p)
+		c.dirs.remove(path.Dir(p))
+	}
+}
+
+// invalidateTree is invalidate for p and everything below it.
+func (c *MetaFs) invalidateTree(p string) {
+	p = clean(p)
+	below := func(key string) bool {
+		return key == p || strings.HasPrefix(key, p+"/") || p == "/"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.nodes.removeFunc(below)
+	c.dirs.removeFunc(below)
+	c.dirs.remove(path.Dir(p))
+}
+
+// invalidateLinked is invalidate for a change to the node at pathStr,
+// which its hard links see as well wherever they are. links is how many
+// entries the node had.
+func (c *MetaFs) invalidateLinked(pathStr string, links int) {
+	if links > 1 {
+		c.invalidateTree("/")
+		return
+	}
+	c.invalidate(pathStr)
+}
+
+// links is how many entries the node at pathStr has, 1 when unknown.
+func (c *MetaFs) links(pathStr string) int {
+	node, err := c.Stat(pathStr)
+	if err != nil {
+		return 1
+	}
+	return node.Links()
+}
+
+func (c *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	node, err := c.next.Create(pathStr, isDir)
+	c.invalidate(pathStr)
+	return node, err
+}
+
+func (c *MetaFs) Stat(pathStr string) (*internal.Node, error) {
+	key := clean(pathStr)
+
+	c.mu.Lock()
+	node, ok := c.nodes.get(key)
+	c.mu.Unlock()
+	if ok {
+		c.nodeHits.Add(1)
+		if node == nil {
+			return nil, internal.ErrNotFound
+		}
+		return copyNode(node), nil
+	}
+	c.nodeMisses.Add(1)
+
+	gen := c.generation()
+	node, err := c.next.Stat(pathStr)
+	if err != nil && !errors.Is(err, internal.ErrNotFound) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.gen == gen {
+		c.nodes.put(key, copyNode(node))
+	}
+	c.mu.Unlock()
+	return node, err
+}
+
+func (c *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	page, err := c.list(pathStr, "o"+strconv.Itoa(limit)+":"+strconv.Itoa(offset), func() (listing, error) {
+		nodes, err := c.next.Ls(pathStr, limit, offset)
+		return listing{nodes: nodes}, err
+	})
+	return page.nodes, err
+}
+
+func (c *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	page, err := c.list(pathStr, "c"+strconv.Itoa(limit)+":"+cursor, func() (listing, error) {
+		nodes, next, err := c.next.LsCursor(pathStr, limit, cursor)
+		return listing{nodes: nodes, next: next}, err
+	})
+	return page.nodes, page.next, err
+}
+
+// list serves a page of the directory at pathStr from the cache, or lists
+// it and also caches the nodes on it for the Stat calls that usually follow.
+func (c *MetaFs) list(pathStr, args string, fetch func() (listing, error)) (listing, error) {
+	dir := clean(pathStr)
+
+	c.mu.Lock()
+	if pages, ok := c.dirs.get(dir); ok {
+		if page, ok := pages[args]; ok {
+			c.mu.Unlock()
+			c.listHits.Add(1)
+			return listing{nodes: append([]internal.Node(nil), page.nodes...), next: page.next}, nil
+		}
+	}
+	c.mu.Unlock()
+	c.listMisses.Add(1)
+
+	gen := c.generation()
+	page, err := fetch()
+	if err != nil {
+		return page, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return page, nil
+	}
+
+	pages, ok := c.dirs.get(dir)
+	if !ok {
+		pages = map[string]listing{}
+		c.dirs.put(dir, pages)
+	}
+	pages[args] = listing{nodes: append([]internal.Node(nil), page.nodes...), next: page.next}
+	for i := range page.nodes {
+		c.nodes.put(path.Join(dir, page.nodes[i].Name()), copyNode(&page.nodes[i]))
+	}
+	return page, nil
+}
+
+func (c *MetaFs) Chtimes(pathStr string, mtime time.Time) error {
+	links := c.links(pathStr)
+	err := c.next.Chtimes(pathStr, mtime)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) Touch(pathStr string) error {
+	err := c.next.Touch(pathStr)
+	c.invalidate(pathStr)
+	return err
+}
+
+func (c *MetaFs) Mkdir(pathStr string) error {
+	err := c.next.Mkdir(pathStr)
+	c.invalidate(pathStr)
+	return err
+}
+
+func (c *MetaFs) MkdirAll(pathStr string) error {
+	err := c.next.MkdirAll(pathStr)
+
+	var created []string
+	for p := clean(pathStr); p != "/"; p = path.Dir(p) {
+		created = append(created, p)
+	}
+	c.invalidate(created...)
+	return err
+}
+
+func (c *MetaFs) Remove(pathStr string) error {
+	links := c.links(pathStr)
+	err := c.next.Remove(pathStr)
+	if links > 1 {
+		pathStr = "/"
+	}
+	c.invalidateTree(pathStr)
+	return err
+}
+
+// RemoveAll of a directory changes the link counts of the files in it
+// with hard links elsewhere, so finding any drops everything.
+func (c *MetaFs) RemoveAll(pathStr string) error {
+	linked := c.linkedBelow(pathStr)
+	err := c.next.RemoveAll(pathStr)
+	if linked {
+		pathStr = "/"
+	}
+	c.invalidateTree(pathStr)
+	return err
+}
+
+// linkedBelow reports whether pathStr or any file below it has more than
+// one entry. When that can't be told it says so as well.
+func (c *MetaFs) linkedBelow(pathStr string) bool {
+	node, err := c.Stat(pathStr)
+	if err != nil {
+		return !errors.Is(err, internal.ErrNotFound)
+	}
+	if !node.IsDir() {
+		return node.Links() > 1
+	}
+	children, err := c.Ls(pathStr, 0, 0)
+	if err != nil {
+		return true
+	}
+	for i := range children {
+		if children[i].IsDir() && c.linkedBelow(path.Join(pathStr, children[i].Name())) ||
+			!children[i].IsDir() && children[i].Links() > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MetaFs) Rename(oldpath, newpath string) error {
+	err := c.next.Rename(oldpath, newpath)
+	c.invalidateTree(oldpath)
+	c.invalidateTree(newpath)
+	return err
+}
+
+func (c *MetaFs) Close() error {
+	return c.next.Close()
+}
+
+func (c *MetaFs) Sync(pathStr string, size int64) error {
+	links := c.links(pathStr)
+	err := c.next.Sync(pathStr, size)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) SetDigest(pathStr string, digest internal.Digest) error {
+	links := c.links(pathStr)
+	err := c.next.SetDigest(pathStr, digest)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	node, err := c.next.Copy(src, dst)
+	c.invalidate(dst)
+	return node, err
+}
+
+func (c *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	node, err := c.next.Symlink(target, pathStr)
+	c.invalidate(pathStr)
+	return node, err
+}
+
+// Link changes the link count every entry of the file shows.
+func (c *MetaFs) Link(oldpath, newpath string) error {
+	err := c.next.Link(oldpath, newpath)
+	c.invalidateTree("/")
+	return err
+}
+
+func (c *MetaFs) Chmod(pathStr string, mode os.FileMode) error {
+	links := c.links(pathStr)
+	err := c.next.Chmod(pathStr, mode)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) Chown(pathStr string, uid, gid int) error {
+	links := c.links(pathStr)
+	err := c.next.Chown(pathStr, uid, gid)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) GetXattr(pathStr, name string) (string, error) {
+	node, err := c.Stat(pathStr)
+	if err != nil {
+		return "", err
+	}
+	return node.Xattr(name)
+}
+
+func (c *MetaFs) SetXattr(pathStr, name, value string) error {
+	links := c.links(pathStr)
+	err := c.next.SetXattr(pathStr, name, value)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) ListXattr(pathStr string) ([]string, error) {
+	node, err := c.Stat(pathStr)
+	if err != nil {
+		return nil, err
+	}
+	return node.XattrNames(), nil
+}
+
+func (c *MetaFs) RemoveXattr(pathStr, name string) error {
+	links := c.links(pathStr)
+	err := c.next.RemoveXattr(pathStr, name)
+	c.invalidateLinked(pathStr, links)
+	return err
+}
+
+func (c *MetaFs) Put(node *internal.Node) error {
+	err := c.next.Put(node)
+	c.invalidate(node.Path())
+	return err
+}
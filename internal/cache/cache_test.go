@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"fafda/internal"
+	"fafda/internal/memory"
+)
+
+func TestHitRate(t *testing.T) {
+	c := New(memory.NewMetaFs(), 100, 10)
+	if err := c.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := c.Stat("/file"); err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+	}
+	stats := c.Stats()
+	if stats.NodeMisses != 1 || stats.NodeHits != 3 {
+		t.Errorf("Stats() = %+v, want 1 miss and 3 hits", stats)
+	}
+
+	// A listing also warms the nodes on it
+	if _, err := c.Ls("/", 0, 0); err != nil {
+		t.Fatalf("Ls() error = %v", err)
+	}
+	if _, err := c.Ls("/", 0, 0); err != nil {
+		t.Fatalf("Ls() error = %v", err)
+	}
+	stats = c.Stats()
+	if stats.ListMisses != 1 || stats.ListHits != 1 {
+		t.Errorf("Stats() = %+v, want 1 list miss and 1 list hit", stats)
+	}
+	if stats.HitRate != 4.0/6 {
+		t.Errorf("HitRate = %v, want %v", stats.HitRate, 4.0/6)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := New(memory.NewMetaFs(), 2, 1)
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := c.Touch(p); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if _, err := c.Stat(p); err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+	}
+	if stats := c.Stats(); stats.Nodes != 2 {
+		t.Errorf("Stats().Nodes = %d, want 2", stats.Nodes)
+	}
+}
+
+// TestInvalidation checks every answer of the cache against the store it
+// wraps while random writes go through it.
+func TestInvalidation(t *testing.T) {
+	store := memory.NewMetaFs()
+	c := New(store, 1000, 100)
+	rng := rand.New(rand.NewSource(1))
+
+	paths := []string{"/a", "/b", "/a/x", "/a/y", "/b/x", "/a/x/deep", "/b/x/deep"}
+	pick := func() string { return paths[rng.Intn(len(paths))] }
+
+	ops := []func() string{
+		func() string { p := pick(); _ = c.Mkdir(p); return "mkdir " + p },
+		func() string { p := pick(); _ = c.MkdirAll(p); return "mkdirall " + p },
+		func() string { p := pick(); _ = c.Touch(p); return "touch " + p },
+		func() string { p := pick(); _ = c.Remove(p); return "remove " + p },
+		func() string { p := pick(); _ = c.RemoveAll(p); return "removeall " + p },
+		func() string { o, n := pick(), pick(); _ = c.Rename(o, n); return "rename " + o + " " + n },
+		func() string { p := pick(); _ = c.Sync(p, rng.Int63n(100)); return "sync " + p },
+		func() string { p := pick(); _ = c.SetDigest(p, internal.Digest{SHA256: "abc"}); return "digest " + p },
+		func() string {
+			p := pick()
+			_ = c.Chtimes(p, time.Unix(rng.Int63n(1e9), 0))
+			return "chtimes " + p
+		},
+		func() string {
+			p := pick()
+			_ = c.Put(internal.NewNode(p, false).SetSize(7))
+			return "put " + p
+		},
+	}
+
+	var history []string
+	for i := 0; i < 2000; i++ {
+		history = append(history, ops[rng.Intn(len(ops))]())
+
+		for _, p := range append(paths, "/") {
+			want, wantErr := store.Stat(p)
+			got, gotErr := c.Stat(p)
+			if !errors.Is(gotErr, wantErr) && !errors.Is(wantErr, gotErr) || fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("Stat(%s) = %v, %v, want %v, %v after\n%s", p, got, gotErr, want, wantErr, strings.Join(history, "\n"))
+			}
+
+			wantLs, wantErr := store.Ls(p, 0, 0)
+			gotLs, gotErr := c.Ls(p, 0, 0)
+			if !errors.Is(gotErr, wantErr) && !errors.Is(wantErr, gotErr) || fmt.Sprint(gotLs) != fmt.Sprint(wantLs) {
+				t.Fatalf("Ls(%s) = %v, %v, want %v, %v after\n%s", p, gotLs, gotErr, wantLs, wantErr, strings.Join(history, "\n"))
+			}
+		}
+	}
+
+	if stats := c.Stats(); stats.NodeHits == 0 || stats.ListHits == 0 {
+		t.Errorf("Stats() = %+v, want some hits", stats)
+	}
+}
+
+func TestRemoveAllHardLinks(t *testing.T) {
+	c := New(memory.NewMetaFs(), 100, 10)
+	if err := c.Mkdir("/d"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := c.Touch("/d/f"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := c.Link("/d/f", "/g"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	node, err := c.Stat("/g")
+	if err != nil || node.Links() != 2 {
+		t.Fatalf("Stat() = %v, %v, want 2 links", node, err)
+	}
+
+	if err := c.RemoveAll("/d"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if node, err := c.Stat("/g"); err != nil || node.Links() != 1 {
+		t.Fatalf("Stat() after RemoveAll = %v, %v, want 1 link", node, err)
+	}
+	// Removing the last entry has to let go of the content
+	ids, err := internal.RemovedIds(c, "/g")
+	if err != nil {
+		t.Fatalf("RemovedIds() error = %v", err)
+	}
+	if len(ids) != 1 || ids[0] != node.Id() {
+		t.Errorf("RemovedIds() = %v, want [%s]", ids, node.Id())
+	}
+}
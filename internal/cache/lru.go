@@ -0,0 +1,70 @@
+package cache
+
+import "container/list"
+
+// lru is a size bounded map that evicts the least recently used key, it
+// is not safe for concurrent use.
+type lru[V any] struct {
+	size  int
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type entry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](size int) *lru[V] {
+	return &lru[V]{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *lru[V]) get(key string) (V, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*entry[V]).value, true
+}
+
+func (c *lru[V]) put(key string, value V) {
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*entry[V]).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[V]{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry[V]).key)
+	}
+}
+
+func (c *lru[V]) remove(key string) {
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// removeFunc drops every key match returns true for.
+func (c *lru[V]) removeFunc(match func(key string) bool) {
+	for key, elem := range c.items {
+		if match(key) {
+			c.order.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *lru[V]) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,153 @@
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Op identifies the call a Rule applies to.
+type Op int
+
+const (
+	OpGetReader Op = iota + 1
+	OpGetWriter
+	OpGetSize
+	OpTruncate
+	OpRead
+	OpWrite
+	OpClose
+)
+
+// Fault is the kind of misbehaviour a Rule injects.
+type Fault int
+
+const (
+	// FaultLatency sleeps for Rule.Delay before running the call.
+	FaultLatency Fault = iota + 1
+	// FaultError fails the call with Rule.Err (ErrInjected when nil).
+	FaultError
+	// FaultShortRead makes OpRead return fewer bytes than asked for.
+	FaultShortRead
+	// FaultTruncate ends an OpRead stream early with io.EOF.
+	FaultTruncate
+	// FaultHang blocks the call until Release is called or Rule.Delay
+	// elapses, then fails it with Rule.Err.
+	FaultHang
+)
+
+// Rule fires on matching calls either by schedule or by probability.
+// When Every is positive the rule fires on every Every-th call once the
+// first After calls have passed, otherwise it fires with Probability.
+type Rule struct {
+	Op          Op
+	Fault       Fault
+	Probability float64
+	Every       int
+	After       int
+	Delay       time.Duration
+	Err         error
+
+	calls int
+}
+
+type Injector struct {
+	rules   []*Rule
+	rnd     *rand.Rand
+	calls   map[Op]int
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+func NewInjector(seed int64, rules ...Rule) *Injector {
+	inj := &Injector{
+		rules:   make([]*Rule, len(rules)),
+		rnd:     rand.New(rand.NewSource(seed)),
+		calls:   map[Op]int{},
+		release: make(chan struct{}),
+	}
+	for i := range rules {
+		rule := rules[i]
+		inj.rules[i] = &rule
+	}
+	return inj
+}
+
+// Calls returns how many times op has been seen.
+func (inj *Injector) Calls(op Op) int {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.calls[op]
+}
+
+// Release unblocks every call stuck in FaultHang, now and in the future.
+func (inj *Injector) Release() {
+	inj.once.Do(func() { close(inj.release) })
+}
+
+// next returns the first rule firing for op, or nil.
+func (inj *Injector) next(op Op) *Rule {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	inj.calls[op]++
+	for _, rule := range inj.rules {
+		if rule.Op != op {
+			continue
+		}
+		rule.calls++
+		if rule.calls <= rule.After {
+			continue
+		}
+		if rule.Every > 0 {
+			if (rule.calls-rule.After)%rule.Every == 0 {
+				return rule
+			}
+			continue
+		}
+		if rule.Probability > 0 && inj.rnd.Float64() < rule.Probability {
+			return rule
+		}
+	}
+	return nil
+}
+
+// inject applies the latency, error and hang faults common to every op
+// and hands the rule back so stream wrappers can apply the rest.
+func (inj *Injector) inject(op Op) (*Rule, error) {
+	rule := inj.next(op)
+	if rule == nil {
+		return nil, nil
+	}
+
+	switch rule.Fault {
+	case FaultLatency:
+		time.Sleep(rule.Delay)
+	case FaultError:
+		return rule, rule.err()
+	case FaultHang:
+		var timeout <-chan time.Time
+		if rule.Delay > 0 {
+			timer := time.NewTimer(rule.Delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-inj.release:
+		case <-timeout:
+		}
+		return rule, rule.err()
+	}
+	return rule, nil
+}
+
+func (r *Rule) err() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return ErrInjected
+}
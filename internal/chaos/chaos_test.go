@@ -0,0 +1,138 @@
+package chaos
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestInjectorSchedule(t *testing.T) {
+	inj := NewInjector(1, Rule{Op: OpGetSize, Fault: FaultError, Every: 2, After: 1})
+
+	var fired []int
+	for i := 1; i <= 6; i++ {
+		if _, err := inj.inject(OpGetSize); err != nil {
+			fired = append(fired, i)
+		}
+	}
+
+	want := []int{3, 5}
+	if len(fired) != len(want) {
+		t.Fatalf("fired on calls %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("fired on calls %v, want %v", fired, want)
+		}
+	}
+	if got := inj.Calls(OpGetSize); got != 6 {
+		t.Errorf("Calls() = %d, want 6", got)
+	}
+}
+
+func TestInjectorProbability(t *testing.T) {
+	count := func(seed int64) int {
+		inj := NewInjector(seed, Rule{Op: OpRead, Fault: FaultError, Probability: 0.5})
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if _, err := inj.inject(OpRead); err != nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	first := count(42)
+	if first < 400 || first > 600 {
+		t.Errorf("fired %d/1000 times with probability 0.5", first)
+	}
+	if second := count(42); second != first {
+		t.Errorf("same seed fired %d then %d times", first, second)
+	}
+}
+
+func TestInjectorCustomError(t *testing.T) {
+	want := errors.New("boom")
+	inj := NewInjector(1, Rule{Op: OpTruncate, Fault: FaultError, Every: 1, Err: want})
+
+	if _, err := inj.inject(OpTruncate); !errors.Is(err, want) {
+		t.Errorf("inject() error = %v, want %v", err, want)
+	}
+	if _, err := inj.inject(OpGetSize); err != nil {
+		t.Errorf("inject() on other op error = %v, want nil", err)
+	}
+}
+
+func TestInjectorHang(t *testing.T) {
+	t.Run("released", func(t *testing.T) {
+		inj := NewInjector(1, Rule{Op: OpRead, Fault: FaultHang, Every: 1})
+
+		done := make(chan error)
+		go func() {
+			_, err := inj.inject(OpRead)
+			done <- err
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("inject() returned before Release()")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		inj.Release()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrInjected) {
+				t.Errorf("inject() error = %v, want %v", err, ErrInjected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("inject() still hanging after Release()")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		inj := NewInjector(1, Rule{Op: OpRead, Fault: FaultHang, Every: 1, Delay: 10 * time.Millisecond})
+		if _, err := inj.inject(OpRead); !errors.Is(err, ErrInjected) {
+			t.Errorf("inject() error = %v, want %v", err, ErrInjected)
+		}
+	})
+}
+
+func TestReaderFaults(t *testing.T) {
+	data := bytes.Repeat([]byte("abcd"), 16)
+
+	t.Run("short reads", func(t *testing.T) {
+		inj := NewInjector(1, Rule{Op: OpRead, Fault: FaultShortRead, Every: 1})
+		r := NewReader(io.NopCloser(bytes.NewReader(data)), inj)
+
+		n, err := r.Read(make([]byte, 32))
+		if err != nil || n != 16 {
+			t.Errorf("Read() = %d, %v, want 16, nil", n, err)
+		}
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		if !bytes.Equal(got, data[16:]) {
+			t.Errorf("ReadAll() returned %d bytes, want %d", len(got), len(data)-16)
+		}
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		inj := NewInjector(1, Rule{Op: OpRead, Fault: FaultTruncate, Every: 1, After: 1})
+		r := NewReader(io.NopCloser(bytes.NewReader(data)), inj)
+
+		buf := make([]byte, 8)
+		if _, err := r.Read(buf); err != nil {
+			t.Fatalf("first Read() error = %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if n, err := r.Read(buf); n != 0 || err != io.EOF {
+				t.Errorf("Read() after truncation = %d, %v, want 0, EOF", n, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package chaos
+
+import (
+	"io"
+
+	"fafda/internal"
+)
+
+// Part mirrors partedio.PartReader so parts can be wrapped without
+// importing partedio.
+type Part interface {
+	GetSize() int
+	GetReader(start, end int) (io.ReadCloser, error)
+}
+
+type Driver struct {
+	driver internal.StorageDriver
+	inj    *Injector
+}
+
+func NewDriver(driver internal.StorageDriver, inj *Injector) *Driver {
+	return &Driver{driver: driver, inj: inj}
+}
+
+func (d *Driver) GetReader(fileId string, pos int64) (io.ReadCloser, error) {
+	if _, err := d.inj.inject(OpGetReader); err != nil {
+		return nil, err
+	}
+	reader, err := d.driver.GetReader(fileId, pos)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(reader, d.inj), nil
+}
+
+func (d *Driver) GetWriter(fileId string) (io.WriteCloser, error) {
+	if _, err := d.inj.inject(OpGetWriter); err != nil {
+		return nil, err
+	}
+	writer, err := d.driver.GetWriter(fileId)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{writer: writer, inj: d.inj}, nil
+}
+
+func (d *Driver) GetSize(fileId string) (int64, error) {
+	if _, err := d.inj.inject(OpGetSize); err != nil {
+		return 0, err
+	}
+	return d.driver.GetSize(fileId)
+}
+
+func (d *Driver) Truncate(fileId string) error {
+	if _, err := d.inj.inject(OpTruncate); err != nil {
+		return err
+	}
+	return d.driver.Truncate(fileId)
+}
+
+type part struct {
+	part Part
+	inj  *Injector
+}
+
+func NewPart(p Part, inj *Injector) Part {
+	return &part{part: p, inj: inj}
+}
+
+func (p *part) GetSize() int {
+	return p.part.GetSize()
+}
+
+func (p *part) GetReader(start, end int) (io.ReadCloser, error) {
+	if _, err := p.inj.inject(OpGetReader); err != nil {
+		return nil, err
+	}
+	reader, err := p.part.GetReader(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(reader, p.inj), nil
+}
+
+type Reader struct {
+	reader    io.ReadCloser
+	inj       *Injector
+	truncated bool
+}
+
+func NewReader(reader io.ReadCloser, inj *Injector) *Reader {
+	return &Reader{reader: reader, inj: inj}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.truncated {
+		return 0, io.EOF
+	}
+
+	rule, err := r.inj.inject(OpRead)
+	if err != nil {
+		return 0, err
+	}
+
+	if rule != nil {
+		switch rule.Fault {
+		case FaultTruncate:
+			r.truncated = true
+			return 0, io.EOF
+		case FaultShortRead:
+			if len(p) > 1 {
+				p = p[:len(p)/2]
+			}
+		}
+	}
+
+	return r.reader.Read(p)
+}
+
+func (r *Reader) Close() error {
+	if _, err := r.inj.inject(OpClose); err != nil {
+		_ = r.reader.Close()
+		return err
+	}
+	return r.reader.Close()
+}
+
+type Writer struct {
+	writer io.WriteCloser
+	inj    *Injector
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if _, err := w.inj.inject(OpWrite); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+func (w *Writer) Close() error {
+	if _, err := w.inj.inject(OpClose); err != nil {
+		_ = w.writer.Close()
+		return err
+	}
+	return w.writer.Close()
+}
+
+func (w *Writer) Digest() internal.Digest {
+	if digester, ok := w.writer.(internal.Digester); ok {
+		return digester.Digest()
+	}
+	return internal.Digest{}
+}
+
+func (w *Writer) SetPath(path string) {
+	if ps, ok := w.writer.(internal.PathSetter); ok {
+		ps.SetPath(path)
+	}
+}
@@ -0,0 +1,717 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Case is a single scenario. Run performs it against fs and describes what
+// it observed, the description and the kind of error returned must match
+// between afero.MemMapFs and the filesystem under test.
+type Case struct {
+	Name string
+	Run  func(fs afero.Fs) (string, error)
+}
+
+// Run plays every case against a fresh afero.MemMapFs and a fresh fs from
+// newFs. deviations maps case names to the reason the filesystem under
+// test intentionally behaves differently, those cases are skipped, and
+// reported as failures once they start matching the reference again.
+func Run(t *testing.T, newFs func(t *testing.T) afero.Fs, deviations map[string]string) {
+	known := map[string]bool{}
+	for _, c := range Cases {
+		known[c.Name] = true
+		t.Run(c.Name, func(t *testing.T) {
+			wantObs, wantErr := c.Run(afero.NewMemMapFs())
+			gotObs, gotErr := c.Run(newFs(t))
+			matches := gotObs == wantObs && errKind(gotErr) == errKind(wantErr)
+
+			if reason, ok := deviations[c.Name]; ok {
+				if matches {
+					t.Errorf("matches afero.MemMapFs, drop deviation %q", reason)
+					return
+				}
+				t.Skip(reason)
+			}
+
+			if errKind(gotErr) != errKind(wantErr) {
+				t.Errorf("error = %v, reference error = %v", gotErr, wantErr)
+			}
+			if gotObs != wantObs {
+				t.Errorf("observed %q, reference observed %q", gotObs, wantObs)
+			}
+		})
+	}
+
+	for name := range deviations {
+		if !known[name] {
+			t.Errorf("deviation for unknown case %q", name)
+		}
+	}
+}
+
+func errKind(err error) string {
+	switch {
+	case err == nil:
+		return "nil"
+	case errors.Is(err, io.EOF):
+		return "EOF"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not exist"
+	case errors.Is(err, fs.ErrExist):
+		return "exist"
+	default:
+		return "error"
+	}
+}
+
+func writeFile(afs afero.Fs, name string, data string) error {
+	f, err := afs.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readFile(afs afero.Fs, name string) (string, error) {
+	f, err := afs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+func statSize(afs afero.Fs, name string) (string, error) {
+	info, err := afs.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	// Directory sizes are implementation defined
+	if info.IsDir() {
+		return "dir", nil
+	}
+	return fmt.Sprintf("size=%d", info.Size()), nil
+}
+
+// setup runs steps in order and stops at the first error.
+func setup(steps ...func() error) error {
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return fmt.Errorf("setup: %w", err)
+		}
+	}
+	return nil
+}
+
+func mkdir(afs afero.Fs, name string) func() error {
+	return func() error { return afs.Mkdir(name, 0755) }
+}
+
+func touch(afs afero.Fs, name string, data string) func() error {
+	return func() error { return writeFile(afs, name, data) }
+}
+
+// readAt reads len bytes at off. MemMapFs returns a nil error for short
+// reads at the end of file, so io.EOF is folded into the observation.
+func readAt(f afero.File, off int64, n int) (string, error) {
+	buf := make([]byte, n)
+	n, err := f.ReadAt(buf, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return string(buf[:n]), err
+}
+
+func readN(f afero.File, n int) (string, error) {
+	buf := make([]byte, n)
+	n, err := io.ReadFull(f, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return string(buf[:n]), err
+}
+
+var Cases = []Case{
+	{
+		Name: "create/write-read",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := writeFile(afs, "/file", "hello world"); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "create/size",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := writeFile(afs, "/file", "hello world"); err != nil {
+				return "", err
+			}
+			return statSize(afs, "/file")
+		},
+	},
+	{
+		Name: "create/overwrite",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "a long first version"), touch(afs, "/file", "short")); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "create/empty",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := writeFile(afs, "/file", ""); err != nil {
+				return "", err
+			}
+			data, err := readFile(afs, "/file")
+			if err != nil {
+				return "", err
+			}
+			size, err := statSize(afs, "/file")
+			return data + " " + size, err
+		},
+	},
+	{
+		Name: "create/missing-parent",
+		Run: func(afs afero.Fs) (string, error) {
+			return "", writeFile(afs, "/missing/file", "data")
+		},
+	},
+	{
+		Name: "create/over-directory",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir")); err != nil {
+				return "", err
+			}
+			_, err := afs.Create("/dir")
+			return "", err
+		},
+	},
+	{
+		Name: "open/missing",
+		Run: func(afs afero.Fs) (string, error) {
+			_, err := afs.Open("/missing")
+			return "", err
+		},
+	},
+	{
+		Name: "open/name",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir"), touch(afs, "/dir/file", "data")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/dir/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return "", err
+			}
+			return f.Name() + " " + info.Name(), nil
+		},
+	},
+	{
+		Name: "open/truncate",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			f, err := afs.OpenFile("/file", os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+			return statSize(afs, "/file")
+		},
+	},
+	{
+		Name: "open/create-flag",
+		Run: func(afs afero.Fs) (string, error) {
+			f, err := afs.OpenFile("/file", os.O_WRONLY|os.O_CREATE, 0644)
+			if err != nil {
+				return "", err
+			}
+			if _, err := f.Write([]byte("data")); err != nil {
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "open/append",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "hello")); err != nil {
+				return "", err
+			}
+			f, err := afs.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return "", err
+			}
+			if _, err := f.Write([]byte(" world")); err != nil {
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "open/read-write",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "hello")); err != nil {
+				return "", err
+			}
+			f, err := afs.OpenFile("/file", os.O_RDWR, 0644)
+			if err != nil {
+				return "", err
+			}
+			return "", f.Close()
+		},
+	},
+	{
+		Name: "read/directory",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/dir")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			_, err = f.Read(make([]byte, 4))
+			return "", err
+		},
+	},
+	{
+		Name: "write/read-only",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			_, err = f.Write([]byte("more"))
+			return "", err
+		},
+	},
+	{
+		Name: "write/at",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.OpenFile("/file", os.O_WRONLY, 0644)
+			if err != nil {
+				return "", err
+			}
+			if _, err := f.WriteAt([]byte("AB"), 4); err != nil {
+				_ = f.Close()
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "write/truncate",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.OpenFile("/file", os.O_WRONLY, 0644)
+			if err != nil {
+				return "", err
+			}
+			if err := f.Truncate(4); err != nil {
+				_ = f.Close()
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/file")
+		},
+	},
+	{
+		Name: "seek/whence",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+
+			var out []string
+			for _, s := range []struct {
+				offset int64
+				whence int
+			}{
+				{2, io.SeekStart},
+				{1, io.SeekCurrent},
+				{-3, io.SeekEnd},
+				{0, io.SeekStart},
+			} {
+				pos, err := f.Seek(s.offset, s.whence)
+				if err != nil {
+					return strings.Join(out, " "), err
+				}
+				data, err := readN(f, 2)
+				if err != nil {
+					return strings.Join(out, " "), err
+				}
+				out = append(out, fmt.Sprintf("%d:%s", pos, data))
+			}
+			return strings.Join(out, " "), nil
+		},
+	},
+	{
+		Name: "seek/negative",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			_, err = f.Seek(-1, io.SeekStart)
+			return "", err
+		},
+	},
+	{
+		Name: "seek/past-end",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			pos, err := f.Seek(20, io.SeekStart)
+			if err != nil {
+				return "", err
+			}
+			n, err := f.Read(make([]byte, 4))
+			return fmt.Sprintf("%d:%d", pos, n), err
+		},
+	},
+	{
+		Name: "read-at/offset-untouched",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+
+			first, err := readN(f, 2)
+			if err != nil {
+				return "", err
+			}
+			at, err := readAt(f, 5, 3)
+			if err != nil {
+				return "", err
+			}
+			next, err := readN(f, 2)
+			return first + " " + at + " " + next, err
+		},
+	},
+	{
+		Name: "read-at/end",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "0123456789")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			return readAt(f, 8, 5)
+		},
+	},
+	{
+		Name: "readdir/all",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(
+				mkdir(afs, "/dir"),
+				touch(afs, "/dir/c", "c"),
+				touch(afs, "/dir/a", "a"),
+				mkdir(afs, "/dir/b"),
+				touch(afs, "/dir/b/nested", "nested"),
+			); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/dir")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			names, err := f.Readdirnames(-1)
+			sort.Strings(names)
+			return strings.Join(names, ","), err
+		},
+	},
+	{
+		Name: "readdir/pages",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(
+				mkdir(afs, "/dir"),
+				touch(afs, "/dir/a", "a"),
+				touch(afs, "/dir/b", "b"),
+				touch(afs, "/dir/c", "c"),
+			); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/dir")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+
+			var pages []string
+			for i := 0; i < 3; i++ {
+				infos, err := f.Readdir(2)
+				pages = append(pages, fmt.Sprintf("%d/%s", len(infos), errKind(err)))
+			}
+			infos, err := f.Readdir(-1)
+			pages = append(pages, fmt.Sprintf("%d/%s", len(infos), errKind(err)))
+			return strings.Join(pages, " "), nil
+		},
+	},
+	{
+		Name: "readdir/file",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			f, err := afs.Open("/file")
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			_, err = f.Readdir(-1)
+			return "", err
+		},
+	},
+	{
+		Name: "mkdir/existing",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir")); err != nil {
+				return "", err
+			}
+			return "", afs.Mkdir("/dir", 0755)
+		},
+	},
+	{
+		Name: "mkdir/missing-parent",
+		Run: func(afs afero.Fs) (string, error) {
+			return "", afs.Mkdir("/missing/dir", 0755)
+		},
+	},
+	{
+		Name: "mkdir/all",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := afs.MkdirAll("/a/b/c", 0755); err != nil {
+				return "", err
+			}
+			if err := afs.MkdirAll("/a/b", 0755); err != nil {
+				return "", err
+			}
+			return statSize(afs, "/a/b/c")
+		},
+	},
+	{
+		Name: "rename/file",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir"), touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			if err := afs.Rename("/file", "/dir/moved"); err != nil {
+				return "", err
+			}
+			if _, err := afs.Stat("/file"); !errors.Is(err, fs.ErrNotExist) {
+				return "old path still exists", err
+			}
+			return readFile(afs, "/dir/moved")
+		},
+	},
+	{
+		Name: "rename/directory",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(
+				mkdir(afs, "/dir"),
+				mkdir(afs, "/dir/sub"),
+				touch(afs, "/dir/sub/file", "data"),
+			); err != nil {
+				return "", err
+			}
+			if err := afs.Rename("/dir", "/moved"); err != nil {
+				return "", err
+			}
+			if _, err := afs.Stat("/dir/sub/file"); !errors.Is(err, fs.ErrNotExist) {
+				return "old path still exists", err
+			}
+			return readFile(afs, "/moved/sub/file")
+		},
+	},
+	{
+		Name: "rename/missing",
+		Run: func(afs afero.Fs) (string, error) {
+			return "", afs.Rename("/missing", "/other")
+		},
+	},
+	{
+		Name: "rename/overwrite",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/a", "a"), touch(afs, "/b", "b")); err != nil {
+				return "", err
+			}
+			if err := afs.Rename("/a", "/b"); err != nil {
+				return "", err
+			}
+			return readFile(afs, "/b")
+		},
+	},
+	{
+		Name: "remove/file",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			if err := afs.Remove("/file"); err != nil {
+				return "", err
+			}
+			_, err := afs.Stat("/file")
+			return "", err
+		},
+	},
+	{
+		Name: "remove/missing",
+		Run: func(afs afero.Fs) (string, error) {
+			return "", afs.Remove("/missing")
+		},
+	},
+	{
+		Name: "remove/non-empty-directory",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir"), touch(afs, "/dir/file", "data")); err != nil {
+				return "", err
+			}
+			return "", afs.Remove("/dir")
+		},
+	},
+	{
+		Name: "remove-all/tree",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(
+				mkdir(afs, "/dir"),
+				mkdir(afs, "/dir/sub"),
+				touch(afs, "/dir/sub/file", "data"),
+				touch(afs, "/dirfile", "data"),
+			); err != nil {
+				return "", err
+			}
+			if err := afs.RemoveAll("/dir"); err != nil {
+				return "", err
+			}
+			if _, err := afs.Stat("/dir/sub/file"); !errors.Is(err, fs.ErrNotExist) {
+				return "descendant still exists", err
+			}
+			// Siblings sharing the name prefix survive
+			return readFile(afs, "/dirfile")
+		},
+	},
+	{
+		Name: "remove-all/missing",
+		Run: func(afs afero.Fs) (string, error) {
+			return "", afs.RemoveAll("/missing")
+		},
+	},
+	{
+		Name: "chtimes",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if err := afs.Chtimes("/file", mtime, mtime); err != nil {
+				return "", err
+			}
+			info, err := afs.Stat("/file")
+			if err != nil {
+				return "", err
+			}
+			return info.ModTime().UTC().String(), nil
+		},
+	},
+	{
+		Name: "chmod",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(touch(afs, "/file", "data")); err != nil {
+				return "", err
+			}
+			if err := afs.Chmod("/file", 0600); err != nil {
+				return "", err
+			}
+			info, err := afs.Stat("/file")
+			if err != nil {
+				return "", err
+			}
+			return info.Mode().String(), nil
+		},
+	},
+	{
+		Name: "stat/directory",
+		Run: func(afs afero.Fs) (string, error) {
+			if err := setup(mkdir(afs, "/dir")); err != nil {
+				return "", err
+			}
+			info, err := afs.Stat("/dir")
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s dir=%v", info.Name(), info.IsDir()), nil
+		},
+	},
+}
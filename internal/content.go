@@ -0,0 +1,60 @@
+package internal
+
+import "path"
+
+// DropContent deletes the content of fileId, from the backend too where
+// driver can.
+func DropContent(driver StorageDriver, fileId string) error {
+	if purger, ok := driver.(Purger); ok {
+		return purger.Purge(fileId)
+	}
+	return driver.Truncate(fileId)
+}
+
+// RemovedIds lists the ids of p and every file below it whose content
+// goes when p is removed, files with hard links elsewhere keep theirs.
+func RemovedIds(meta MetaFileSystem, p string) ([]string, error) {
+	nodes, err := files(meta, p)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]int{}
+	var ids []string
+	for _, node := range nodes {
+		entries[node.Id()]++
+		if entries[node.Id()] == node.Links() {
+			ids = append(ids, node.Id())
+		}
+	}
+	return ids, nil
+}
+
+// files lists p and every file below it, symbolic links have no content
+// and are left out.
+func files(meta MetaFileSystem, p string) ([]*Node, error) {
+	node, err := meta.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsSymlink() {
+		return nil, nil
+	}
+	if !node.IsDir() {
+		return []*Node{node}, nil
+	}
+
+	children, _, err := meta.LsCursor(p, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*Node
+	for _, child := range children {
+		below, err := files(meta, path.Join(p, child.Name()))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, below...)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,18 @@
+package internal
+
+import "encoding/base64"
+
+// Listing cursors are the name of the last entry returned, encoded so
+// clients treat them as opaque.
+
+func EncodeCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func DecodeCursor(cursor string) (string, error) {
+	name, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || (cursor != "" && len(name) == 0) {
+		return "", ErrInvalidCursor
+	}
+	return string(name), nil
+}
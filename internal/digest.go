@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	DigestMD5   = "md5"
+	DigestCRC32 = "crc32"
+)
+
+// Digest holds hex encoded digests of a file's content, empty when unknown.
+type Digest struct {
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	CRC32  string `json:"crc32,omitempty"`
+}
+
+func (d Digest) IsZero() bool { return d == Digest{} }
+
+// Digester is implemented by writers that hash the content they store,
+// the digest is only complete once the writer is closed.
+type Digester interface {
+	Digest() Digest
+}
+
+// Hasher computes SHA-256 and any requested extra digests in one pass.
+type Hasher struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32  hash.Hash
+	writer io.Writer
+}
+
+func NewHasher(extra ...string) (*Hasher, error) {
+	h := &Hasher{sha256: sha256.New()}
+	writers := []io.Writer{h.sha256}
+	for _, algo := range extra {
+		switch algo {
+		case DigestMD5:
+			h.md5 = md5.New()
+			writers = append(writers, h.md5)
+		case DigestCRC32:
+			h.crc32 = crc32.NewIEEE()
+			writers = append(writers, h.crc32)
+		default:
+			return nil, fmt.Errorf("unknown checksum algorithm %q", algo)
+		}
+	}
+	h.writer = io.MultiWriter(writers...)
+	return h, nil
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p)
+}
+
+func (h *Hasher) Digest() Digest {
+	digest := Digest{SHA256: hex.EncodeToString(h.sha256.Sum(nil))}
+	if h.md5 != nil {
+		digest.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	}
+	if h.crc32 != nil {
+		digest.CRC32 = hex.EncodeToString(h.crc32.Sum(nil))
+	}
+	return digest
+}
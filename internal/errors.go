@@ -2,17 +2,41 @@ package internal
 
 import (
 	"errors"
+	"io/fs"
 	"os"
 )
 
 var (
 	ErrIsDir                = &os.PathError{Err: errors.New("is a directory")}
 	ErrIsNotDir             = &os.PathError{Err: errors.New("is not a directory")}
-	ErrNotFound             = &os.PathError{Err: errors.New("source or destination does not exist")}
+	ErrNotFound             = &os.PathError{Err: &kindError{"source or destination does not exist", fs.ErrNotExist}}
 	ErrNotEmpty             = &os.PathError{Err: errors.New("directory not empty")}
-	ErrInvalidSeek          = &os.PathError{Err: errors.New("invalid seek offset")}
-	ErrNotSupported         = &os.PathError{Err: errors.New("fs doesn't support this operation")}
-	ErrAlreadyExist         = &os.PathError{Err: errors.New("destination already exist")}
-	ErrInvalidOperation     = &os.PathError{Err: errors.New("invalid operation - hint: trying to move directory into itself")}
-	ErrInvalidRootOperation = &os.PathError{Err: errors.New("invalid operation - stop fucking with root directory")}
+	ErrInvalidSeek          = &os.PathError{Err: &kindError{"invalid seek offset", fs.ErrInvalid}}
+	ErrNotSupported         = &os.PathError{Err: &kindError{"fs doesn't support this operation", errors.ErrUnsupported}}
+	ErrAlreadyExist         = &os.PathError{Err: &kindError{"destination already exist", fs.ErrExist}}
+	ErrInvalidOperation     = &os.PathError{Err: &kindError{"invalid operation - hint: trying to move directory into itself", fs.ErrInvalid}}
+	ErrInvalidRootOperation = &os.PathError{Err: &kindError{"invalid operation - stop fucking with root directory", fs.ErrInvalid}}
+	ErrInvalidCursor        = &os.PathError{Err: &kindError{"invalid listing cursor", fs.ErrInvalid}}
+	ErrNotSymlink           = &os.PathError{Err: &kindError{"not a symbolic link", fs.ErrInvalid}}
+	ErrTooManyLinks         = &os.PathError{Err: errors.New("too many levels of symbolic links")}
+	ErrPermission           = &os.PathError{Err: &kindError{"permission denied", fs.ErrPermission}}
+	ErrNoXattr              = &os.PathError{Err: errors.New("no such attribute")}
+	ErrInvalidXattr         = &os.PathError{Err: &kindError{"invalid or too many attributes", fs.ErrInvalid}}
+	ErrQuotaExceeded        = &os.PathError{Err: errors.New("disk quota exceeded")}
 )
+
+// Reported by storage drivers when content backing a file is damaged.
+var (
+	ErrDataMissing = errors.New("stored data is missing")
+	ErrDataCorrupt = errors.New("stored data is corrupt")
+)
+
+// kindError keeps our messages while letting errors.Is match the
+// standard io/fs sentinels, so afero consumers can use fs.ErrNotExist.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
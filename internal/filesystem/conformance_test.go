@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal/cache"
+	"fafda/internal/conformance"
+	"fafda/internal/memory"
+)
+
+// Each entry is also documented next to the code responsible for it.
+var deviations = map[string]string{
+	"create/missing-parent":      "parent directories are never created implicitly",
+	"mkdir/missing-parent":       "parent directories are never created implicitly",
+	"create/over-directory":      "directories cannot be opened for writing",
+	"read/directory":             "reading a directory fails instead of returning EOF",
+	"seek/negative":              "negative offsets are rejected like os.File does",
+	"seek/past-end":              "reading past the end returns io.EOF like os.File does",
+	"rename/overwrite":           "rename never replaces an existing destination",
+	"remove/non-empty-directory": "remove refuses non-empty directories like os.Remove",
+	"chmod":                      "files are not marked temporary like MemMapFs creates them",
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) afero.Fs {
+		return setupTestFs(t, nil)
+	}, deviations)
+}
+
+func TestConformanceCached(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) afero.Fs {
+		return &Fs{driver: memory.NewDriver(), meta: cache.New(memory.NewMetaFs(), 1000, 100)}
+	}, deviations)
+}
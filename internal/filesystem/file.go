@@ -12,14 +12,19 @@ type File struct {
 
 	flag int
 
-	off      int64
-	dirCount int
-	written  int64
-	writer   io.WriteCloser
-	reader   io.ReadCloser
-
-	driver internal.StorageDriver
-	meta   internal.MetaFileSystem
+	off       int64
+	dirCursor string
+	dirDone   bool
+	base      int64 // where the write stream started
+	written   int64
+	writer    io.WriteCloser
+	writerAt  internal.WriteAtCloser
+	reader    io.ReadCloser
+
+	driver   internal.StorageDriver
+	meta     internal.MetaFileSystem
+	versions internal.Versioner
+	quota    internal.Quota
 }
 
 func NewFile(
@@ -27,25 +32,82 @@ func NewFile(
 	node *internal.Node,
 	metafs internal.MetaFileSystem,
 	driver internal.StorageDriver,
+	versions internal.Versioner,
+	quota internal.Quota,
 ) *File {
 	return &File{
 		Node: node,
 		flag: flag,
 
-		off:      0,
-		dirCount: 0,
-		written:  0,
-		writer:   nil,
-		reader:   nil,
+		off:       0,
+		dirCursor: "",
+		dirDone:   false,
+		base:      0,
+		written:   0,
+		writer:    nil,
+		writerAt:  nil,
+		reader:    nil,
+
+		driver:   driver,
+		meta:     metafs,
+		versions: versions,
+		quota:    quota,
+	}
+}
+
+// Name is the full path the file was opened with, as afero.File expects.
+// The FileInfo returned by Stat still reports the base name.
+func (f *File) Name() string { return f.Path() }
+
+// Truncate changes the size like os.File.Truncate, the offset stays where
+// it is. Growing fills with zeros.
+func (f *File) Truncate(size int64) error {
+	if f.IsDir() {
+		return internal.ErrIsDir
+	}
+	if !f.writable() {
+		return internal.ErrNotSupported
+	}
+	if size < 0 {
+		return internal.ErrInvalidSeek
+	}
+	if err := f.commit(); err != nil {
+		return err
+	}
+	if err := f.grow(size); err != nil {
+		return err
+	}
 
-		driver: driver,
-		meta:   metafs,
+	resizer, ok := f.driver.(internal.Resizer)
+	if !ok {
+		return internal.ErrNotSupported
 	}
+	if err := resizer.Resize(f.Id(), f.Path(), size); err != nil {
+		return err
+	}
+	if err := f.meta.Sync(f.Path(), size); err != nil {
+		return err
+	}
+	f.SetSize(size)
+	return nil
 }
 
-func (f *File) Truncate(_ int64) error                 { return internal.ErrNotSupported }
-func (f *File) WriteAt(_ []byte, _ int64) (int, error) { return 0, internal.ErrNotSupported }
-func (f *File) Sync() error                            { return nil }
+// Sync makes everything written so far visible to others, writing can go
+// on afterwards.
+func (f *File) Sync() error { return f.commit() }
+
+// grow asks the quota before the file reaches end, sizes are only recorded
+// on commit so anything up to the recorded size is already counted.
+func (f *File) grow(end int64) error {
+	if f.quota == nil || end <= f.Size() {
+		return nil
+	}
+	return f.quota.Check(f.Path(), internal.Usage{Bytes: end - f.Size()})
+}
+
+func (f *File) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
 
 func (f *File) Readdirnames(n int) ([]string, error) {
 	if !f.IsDir() {
@@ -67,7 +129,18 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 
 	// If n > 0, return at most n entries
 	// If n <= 0, return all remaining entries
-	files, err := f.meta.Ls(f.Path(), n, f.dirCount)
+	if f.dirDone {
+		if n > 0 {
+			return []os.FileInfo{}, io.EOF
+		}
+		return []os.FileInfo{}, nil
+	}
+
+	files, cursor, err := f.meta.LsCursor(f.Path(), n, f.dirCursor)
+	// A wrapper hiding entries can hand back an empty page before the end
+	for err == nil && len(files) == 0 && cursor != "" {
+		files, cursor, err = f.meta.LsCursor(f.Path(), n, cursor)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +150,8 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 		entries[i] = &file
 	}
 
-	f.dirCount += len(entries)
+	f.dirCursor = cursor
+	f.dirDone = cursor == ""
 
 	if n > 0 && len(entries) == 0 {
 		return entries, io.EOF
@@ -86,12 +160,20 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 	return entries, err
 }
 
+// Read fails on directories instead of returning io.EOF like MemMapFs, and
+// reports io.EOF past the end of file like os.File.
 func (f *File) Read(p []byte) (n int, err error) {
 	if f.IsDir() {
 		return 0, internal.ErrIsDir
 	}
+	if err := f.commit(); err != nil {
+		return 0, err
+	}
+	if f.off >= f.Size() {
+		return 0, io.EOF
+	}
 	if f.reader == nil {
-		if err = f.openReadStream(0); err != nil {
+		if err = f.openReadStream(f.off); err != nil {
 			return 0, err
 		}
 	}
@@ -101,17 +183,42 @@ func (f *File) Read(p []byte) (n int, err error) {
 		return n, err
 	}
 	f.off += int64(n)
+	// Storage gave up before the size recorded in metadata
+	if err == io.EOF && f.off < f.Size() {
+		return n, io.ErrUnexpectedEOF
+	}
 	return n, err
 }
 
+// ReadAt reads from its own stream, the offset used by Read is untouched.
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 	if f.IsDir() {
 		return 0, internal.ErrIsDir
 	}
-	if _, err := f.Seek(off, io.SeekCurrent); err != nil {
+	if off < 0 {
+		return 0, internal.ErrInvalidSeek
+	}
+	if err := f.commit(); err != nil {
 		return 0, err
 	}
-	return f.Read(p)
+	if off >= f.Size() {
+		return 0, io.EOF
+	}
+
+	reader, err := f.driver.GetReader(f.Id(), off)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	n, err = io.ReadFull(reader, p)
+	if off+int64(n) >= f.Size() && n < len(p) {
+		return n, io.EOF
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -121,32 +228,100 @@ func (f *File) WriteString(s string) (ret int, err error) {
 	return f.Write([]byte(s))
 }
 
+// Write streams new parts whenever it can: from offset 0 of a write-only
+// handle it replaces the file, at the end of the file it appends. Anywhere
+// else it goes through WriteAt.
 func (f *File) Write(p []byte) (int, error) {
 	if f.IsDir() {
 		return 0, internal.ErrIsDir
 	}
-
-	if !checkFlags(os.O_WRONLY, f.flag) {
+	if !f.writable() {
 		return 0, internal.ErrNotSupported
 	}
 
+	if f.flag&os.O_APPEND != 0 {
+		if f.writer == nil {
+			if err := f.commit(); err != nil {
+				return 0, err
+			}
+			f.off = f.Size()
+		}
+	} else if f.writer != nil && f.off != f.base+f.written {
+		// Seeked away from the stream
+		if err := f.commit(); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.grow(f.off + int64(len(p))); err != nil {
+		return 0, err
+	}
+
 	if f.writer == nil {
-		f.written = 0
-		if err := f.driver.Truncate(f.Id()); err != nil {
+		if err := f.commitWriterAt(); err != nil {
 			return 0, err
 		}
-		if writer, err := f.driver.GetWriter(f.Id()); err != nil {
+
+		var err error
+		switch {
+		case f.off == 0 && f.flag&os.O_RDWR == 0:
+			err = f.openWriteStream()
+		case f.off == f.Size():
+			err = f.openAppendStream()
+		default:
+			n, err := f.WriteAt(p, f.off)
+			f.off += int64(n)
+			return n, err
+		}
+		if err != nil {
 			return 0, err
-		} else {
-			f.writer = writer
 		}
 	}
+
 	n, err := f.writer.Write(p)
 	f.written += int64(n)
+	f.off += int64(n)
 
 	return n, err
 }
 
+// WriteAt rewrites the parts it touches once the file is closed or synced,
+// Read and ReadAt on the same file see the writes before that.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.IsDir() {
+		return 0, internal.ErrIsDir
+	}
+	if !f.writable() || f.flag&os.O_APPEND != 0 {
+		return 0, internal.ErrNotSupported
+	}
+	if off < 0 {
+		return 0, internal.ErrInvalidSeek
+	}
+	if err := f.grow(off + int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	if f.writerAt == nil {
+		if err := f.commitWriteStream(); err != nil {
+			return 0, err
+		}
+		rw, ok := f.driver.(internal.RangeWriter)
+		if !ok {
+			return 0, internal.ErrNotSupported
+		}
+		writerAt, err := rw.GetWriterAt(f.Id())
+		if err != nil {
+			return 0, err
+		}
+		if ps, ok := writerAt.(internal.PathSetter); ok {
+			ps.SetPath(f.Path())
+		}
+		f.writerAt = writerAt
+	}
+	return f.writerAt.WriteAt(p, off)
+}
+
+// Seek only moves the offset, the read stream is reopened lazily by Read.
+// Negative offsets are rejected like os.File does.
 func (f *File) Seek(offset int64, whence int) (int64, error) {
 	if f.IsDir() {
 		return 0, internal.ErrIsDir
@@ -160,41 +335,143 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		pos = f.off + offset
 	case io.SeekEnd:
-		pos = f.Size() - offset
+		pos = f.Size() + offset
+	default:
+		return 0, internal.ErrInvalidSeek
 	}
 	if pos < 0 {
 		return 0, internal.ErrInvalidSeek
 	}
+	if pos == f.off {
+		return pos, nil
+	}
 	if f.reader != nil {
 		if err := f.reader.Close(); err != nil {
 			return 0, err
 		}
 	}
 	f.reader = nil
-	if err := f.openReadStream(pos); err != nil {
-		return 0, err
-	}
+	f.off = pos
 
 	return pos, nil
 }
 
 func (f *File) Close() error {
-	if f.writer != nil {
-		if err := f.writer.Close(); err != nil {
+	if err := f.commit(); err != nil {
+		return err
+	}
+	if f.reader != nil {
+		if err := f.reader.Close(); err != nil {
 			return err
 		}
-		if err := f.meta.Sync(f.Path(), f.written); err != nil {
+		f.reader = nil
+	}
+
+	return nil
+}
+
+func (f *File) openWriteStream() error {
+	if f.versions != nil {
+		if err := f.versions.Save(f.Node); err != nil {
 			return err
 		}
-		f.writer = nil
+	}
+	if err := f.driver.Truncate(f.Id()); err != nil {
+		return err
+	}
+	writer, err := f.driver.GetWriter(f.Id())
+	if err != nil {
+		return err
+	}
+	f.setWriter(writer, 0)
+	return nil
+}
+
+func (f *File) openAppendStream() error {
+	appender, ok := f.driver.(internal.Appender)
+	if !ok {
+		return internal.ErrNotSupported
+	}
+	writer, err := appender.GetAppender(f.Id())
+	if err != nil {
+		return err
+	}
+	f.setWriter(writer, f.Size())
+	return nil
+}
+
+func (f *File) setWriter(writer io.WriteCloser, base int64) {
+	if ps, ok := writer.(internal.PathSetter); ok {
+		ps.SetPath(f.Path())
+	}
+	f.writer = writer
+	f.base = base
+	f.written = 0
+}
+
+// commit finishes pending writes and records the new size, content read
+// from before is stale after that.
+func (f *File) commit() error {
+	if f.writer == nil && f.writerAt == nil {
+		return nil
+	}
+	if err := f.commitWriteStream(); err != nil {
+		return err
+	}
+	if err := f.commitWriterAt(); err != nil {
+		return err
 	}
 	if f.reader != nil {
-		if err := f.reader.Close(); err != nil {
+		err := f.reader.Close()
+		f.reader = nil
+		return err
+	}
+	return nil
+}
+
+func (f *File) commitWriteStream() error {
+	if f.writer == nil {
+		return nil
+	}
+	writer := f.writer
+	f.writer = nil
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	size := f.base + f.written
+	if err := f.meta.Sync(f.Path(), size); err != nil {
+		return err
+	}
+	f.SetSize(size)
+
+	// The digest only covers the whole file when the stream wrote all of it
+	if digester, ok := writer.(internal.Digester); ok && f.base == 0 {
+		if err := f.meta.SetDigest(f.Path(), digester.Digest()); err != nil {
 			return err
 		}
-		f.reader = nil
 	}
+	return nil
+}
 
+func (f *File) commitWriterAt() error {
+	if f.writerAt == nil {
+		return nil
+	}
+	writerAt := f.writerAt
+	f.writerAt = nil
+
+	if err := writerAt.Close(); err != nil {
+		return err
+	}
+	size, err := f.driver.GetSize(f.Id())
+	if err != nil {
+		return err
+	}
+	if err := f.meta.Sync(f.Path(), size); err != nil {
+		return err
+	}
+	f.SetSize(size)
 	return nil
 }
 
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/spf13/afero"
@@ -13,71 +15,479 @@ import (
 )
 
 type Fs struct {
-	meta   internal.MetaFileSystem
-	driver internal.StorageDriver
+	meta     internal.MetaFileSystem
+	driver   internal.StorageDriver
+	versions internal.Versioner
+	quota    internal.Quota
 }
 
-func New(driver internal.StorageDriver, dp internal.MetaFileSystem) afero.Fs {
-	return pkg.NewLogFS(&Fs{driver: driver, meta: dp})
+// New serves files from driver and dp, versions keeps what overwrites
+// replace and is nil to discard it. quota is asked before anything grows,
+// nil leaves everything unlimited.
+func New(
+	driver internal.StorageDriver,
+	dp internal.MetaFileSystem,
+	versions internal.Versioner,
+	quota internal.Quota,
+) afero.Fs {
+	return pkg.NewLogFS(&Fs{driver: driver, meta: dp, versions: versions, quota: quota})
 }
 
-func (fs *Fs) Name() string                                  { return "WhyAreYouGayFs" }
-func (fs *Fs) Chown(_ string, _, _ int) error                { return internal.ErrNotSupported }
-func (fs *Fs) Chmod(_ string, _ os.FileMode) error           { return internal.ErrNotSupported }
-func (fs *Fs) Remove(path string) error                      { return fs.meta.Remove(path) }
-func (fs *Fs) RemoveAll(path string) error                   { return fs.meta.RemoveAll(path) }
-func (fs *Fs) Rename(oldname, newname string) error          { return fs.meta.Rename(oldname, newname) }
-func (fs *Fs) Stat(path string) (os.FileInfo, error)         { return fs.meta.Stat(path) }
-func (fs *Fs) Chtimes(path string, _, mtime time.Time) error { return fs.meta.Chtimes(path, mtime) }
-func (fs *Fs) Mkdir(path string, _ os.FileMode) error        { return fs.meta.Mkdir(path) }
-func (fs *Fs) MkdirAll(path string, _ os.FileMode) error     { return fs.meta.MkdirAll(path) }
+// Deviations from afero.MemMapFs, checked by the conformance suite:
+//   - parent directories are never created implicitly
+//   - Remove refuses non-empty directories and Rename refuses to overwrite
+//   - the permission bits given at creation are ignored, files start at
+//     0644 and directories at 0755 until Chmod
+//   - files are not marked temporary like MemMapFs creates them
+//   - writing from the start of an O_WRONLY file replaces all of it
+//   - directories cannot be opened for writing
+//
+// Symbolic links on the way to a path are followed, the last one is too
+// unless the call is about the link itself: Remove, RemoveAll, Rename,
+// Mkdir, Lstat and Readlink.
 
-func (fs *Fs) Create(path string) (afero.File, error) {
-	if err := fs.meta.Touch(path); err != nil {
+func (fs *Fs) Name() string { return "WhyAreYouGayFs" }
+
+func (fs *Fs) checkQuota(p string, add internal.Usage) error {
+	if fs.quota == nil {
+		return nil
+	}
+	return fs.quota.Check(p, add)
+}
+
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	return fs.meta.Chown(p, uid, gid)
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	return fs.meta.Chmod(p, mode)
+}
+
+func (fs *Fs) Remove(name string) error {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	return fs.remove(p, fs.meta.Remove)
+}
+
+func (fs *Fs) RemoveAll(name string) error {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	return fs.remove(p, fs.meta.RemoveAll)
+}
+
+// remove removes p with remove and then the content of the files that
+// lost their last entry. Where the metadata keeps what is removed, like
+// the trash, the content stays for it to delete when that is final.
+func (fs *Fs) remove(p string, remove func(string) error) error {
+	if _, ok := fs.meta.(discarder); ok {
+		return remove(p)
+	}
+
+	ids, err := internal.RemovedIds(fs.meta, p)
+	if err != nil && !errors.Is(err, internal.ErrNotFound) {
+		return err
+	}
+	if err := remove(p); err != nil {
+		return err
+	}
+	return fs.drop(ids)
+}
+
+// drop deletes the content of the files ids.
+func (fs *Fs) drop(ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		if err := internal.DropContent(fs.driver, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	oldpath, err := fs.resolve(oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := fs.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if fs.quota != nil {
+		if err := fs.quota.CheckMove(oldpath, newpath); err != nil {
+			return err
+		}
+	}
+	return fs.meta.Rename(oldpath, newpath)
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	p, err := fs.resolve(name, true)
+	if err != nil {
 		return nil, err
 	}
-	return fs.OpenFile(path, os.O_WRONLY, 0666)
+	return fs.meta.Stat(p)
 }
 
-func (fs *Fs) Open(path string) (afero.File, error) {
-	f, err := fs.meta.Stat(path)
+func (fs *Fs) Chtimes(name string, _, mtime time.Time) error {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	return fs.meta.Chtimes(p, mtime)
+}
+
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkQuota(p, internal.Usage{Dirs: 1}); err != nil {
+		return err
+	}
+	return fs.meta.Mkdir(p)
+}
+
+func (fs *Fs) MkdirAll(name string, _ os.FileMode) error {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	if fs.quota != nil {
+		var missing int64
+		for dir := p; dir != "/" && fs.missing(dir); dir = path.Dir(dir) {
+			missing++
+		}
+		if err := fs.checkQuota(p, internal.Usage{Dirs: missing}); err != nil {
+			return err
+		}
+	}
+	return fs.meta.MkdirAll(p)
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	if fs.quota != nil && fs.missing(p) {
+		if err := fs.quota.Check(p, internal.Usage{Files: 1}); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.meta.Touch(p); err != nil {
+		return nil, err
+	}
+	return fs.OpenFile(p, os.O_WRONLY, 0666)
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.meta.Stat(p)
 	if err != nil {
 		return nil, err
 	}
-	file := NewFile(os.O_RDONLY, f, fs.meta, fs.driver)
+	file := NewFile(os.O_RDONLY, f, fs.meta, fs.driver, fs.versions, fs.quota)
 
 	return file, nil
 }
 
 func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
-	allowedFlags := os.O_WRONLY | os.O_RDONLY | os.O_CREATE | os.O_TRUNC
+	allowedFlags := os.O_WRONLY | os.O_RDWR | os.O_RDONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND
 
 	if !checkFlags(flag, allowedFlags) {
 		return nil, fmt.Errorf("flag not supported")
 	}
 
-	f, err := fs.meta.Stat(name)
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.meta.Stat(p)
 	if err != nil {
 		if errors.Is(err, internal.ErrNotFound) && checkFlags(os.O_CREATE, flag) {
-			return fs.Create(name)
+			return fs.Create(p)
 		}
 		return nil, err
 	}
 
+	if f.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, internal.ErrIsDir
+	}
+
 	if checkFlags(os.O_TRUNC, flag) {
+		if fs.versions != nil {
+			if err = fs.versions.Save(f); err != nil {
+				return nil, err
+			}
+		}
 		if err = fs.driver.Truncate(f.Id()); err != nil {
 			return nil, err
 		}
 		if err = fs.meta.Sync(f.Path(), 0); err != nil {
 			return nil, err
 		}
+		f.SetSize(0)
 	}
 
-	file := NewFile(flag, f, fs.meta, fs.driver)
+	file := NewFile(flag, f, fs.meta, fs.driver, fs.versions, fs.quota)
 
 	return file, nil
 }
 
+// Copy makes dst, which must not exist yet, a file with the content of
+// src without reading it, the storage driver shares what it stored.
+func (fs *Fs) Copy(src, dst string) error {
+	copier, ok := fs.driver.(internal.Copier)
+	if !ok {
+		return internal.ErrNotSupported
+	}
+
+	src, err := fs.resolve(src, true)
+	if err != nil {
+		return err
+	}
+	dst, err = fs.resolve(dst, true)
+	if err != nil {
+		return err
+	}
+
+	from, err := fs.meta.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkQuota(dst, internal.Usage{Bytes: from.Size(), Files: 1}); err != nil {
+		return err
+	}
+	node, err := fs.meta.Copy(src, dst)
+	if err != nil {
+		return err
+	}
+	if err := copier.Copy(from.Id(), node.Id()); err != nil {
+		if derr := fs.discard(dst); derr != nil {
+			return errors.Join(err, fmt.Errorf("undo copy to %s: %w", dst, derr))
+		}
+		return err
+	}
+	return nil
+}
+
+// CopyOver copies src over the file at dst. The copy is made next to dst
+// first and swapped in for it once it worked, so a source that is missing
+// or doesn't fit the quota leaves dst as is. dst is moved aside rather
+// than truncated: other hard links of it keep their content, and it goes
+// back when the swap fails.
+func (fs *Fs) CopyOver(src, dst string) error {
+	dst, err := fs.resolve(dst, true)
+	if err != nil {
+		return err
+	}
+	existing, err := fs.meta.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if existing.IsDir() {
+		return internal.ErrIsDir
+	}
+
+	stamp := time.Now().UnixNano()
+	tmp := path.Join(path.Dir(dst), fmt.Sprintf(".%s.copy-%d", path.Base(dst), stamp))
+	if err := fs.Copy(src, tmp); err != nil {
+		return err
+	}
+
+	old := path.Join(path.Dir(dst), fmt.Sprintf(".%s.replaced-%d", path.Base(dst), stamp))
+	if err := fs.meta.Rename(dst, old); err != nil {
+		if derr := fs.discard(tmp); derr != nil {
+			return errors.Join(err, fmt.Errorf("undo copy to %s: %w", tmp, derr))
+		}
+		return err
+	}
+	if err := fs.meta.Rename(tmp, dst); err != nil {
+		if rerr := fs.meta.Rename(old, dst); rerr != nil {
+			return errors.Join(err, fmt.Errorf("copy left at %s and %s moved to %s: %w", tmp, dst, old, rerr))
+		}
+		if derr := fs.discard(tmp); derr != nil {
+			return errors.Join(err, fmt.Errorf("undo copy to %s: %w", tmp, derr))
+		}
+		return err
+	}
+
+	// The old content is versioned like any overwrite, unless other hard
+	// links still have it
+	if fs.versions != nil && existing.Links() == 1 {
+		if err := fs.versions.Save(existing); err != nil {
+			return fmt.Errorf("replaced file left at %s: %w", old, err)
+		}
+	}
+	return fs.discard(old)
+}
+
+// discarder is implemented by MetaFileSystems that keep what is removed,
+// like the trash, Discard deletes right away.
+type discarder interface {
+	Discard(pathStr string) error
+}
+
+// discard removes the record at p for good along with the content it held
+// alone, for undoing what was only just made and has nothing worth keeping.
+func (fs *Fs) discard(p string) error {
+	ids, err := internal.RemovedIds(fs.meta, p)
+	if err != nil {
+		return err
+	}
+	if d, ok := fs.meta.(discarder); ok {
+		err = d.Discard(p)
+	} else {
+		err = fs.meta.Remove(p)
+	}
+	if err != nil {
+		return err
+	}
+	return fs.drop(ids)
+}
+
+// LstatIfPossible is Stat of a symbolic link itself rather than of what
+// it points to.
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	node, err := fs.meta.Stat(p)
+	if err != nil {
+		return nil, true, err
+	}
+	return node, true, nil
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname, which
+// is kept as given and may be relative to the link's directory.
+func (fs *Fs) SymlinkIfPossible(oldname, newname string) error {
+	p, err := fs.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkQuota(p, internal.Usage{Files: 1}); err != nil {
+		return err
+	}
+	_, err = fs.meta.Symlink(oldname, p)
+	return err
+}
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	node, err := fs.meta.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if !node.IsSymlink() {
+		return "", internal.ErrNotSymlink
+	}
+	return node.Target(), nil
+}
+
+// Link makes newname a hard link to the file at oldname, following a
+// symbolic link there. Both share the content and metadata of the file.
+func (fs *Fs) Link(oldname, newname string) error {
+	oldpath, err := fs.resolve(oldname, true)
+	if err != nil {
+		return err
+	}
+	newpath, err := fs.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if fs.quota != nil {
+		node, err := fs.meta.Stat(oldpath)
+		if err != nil {
+			return err
+		}
+		if err := fs.quota.Check(newpath, internal.Usage{Bytes: node.Size(), Files: 1}); err != nil {
+			return err
+		}
+	}
+	return fs.meta.Link(oldpath, newpath)
+}
+
+// resolve returns the path name leads to once the symbolic links on the
+// way are followed, the last one only with follow. The part past a path
+// that does not exist is kept as is for the caller to create or fail on.
+func (fs *Fs) resolve(name string, follow bool) (string, error) {
+	name = path.Clean("/" + name)
+	// The store can't look through links, whatever it finds directly
+	// has none on the way
+	if node, err := fs.meta.Stat(name); err == nil && (!follow || !node.IsSymlink()) {
+		return name, nil
+	}
+
+	resolved := "/"
+	rest := components(name)
+	for hops := 0; len(rest) > 0; {
+		next := path.Join(resolved, rest[0])
+		rest = rest[1:]
+		if len(rest) == 0 && !follow {
+			return next, nil
+		}
+
+		node, err := fs.meta.Stat(next)
+		if errors.Is(err, internal.ErrNotFound) {
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !node.IsSymlink() {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > internal.MaxHops {
+			return "", internal.ErrTooManyLinks
+		}
+		target := node.Target()
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		resolved = "/"
+		rest = append(components(target), rest...)
+	}
+	return resolved, nil
+}
+
+// missing reports whether nothing is at p yet.
+func (fs *Fs) missing(p string) bool {
+	_, err := fs.meta.Stat(p)
+	return errors.Is(err, internal.ErrNotFound)
+}
+
+// components splits an absolute path into its names.
+func components(p string) []string {
+	p = path.Clean(p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
 func checkFlags(flag int, allowedFlags int) bool {
 	return flag == (flag & allowedFlags)
 }
@@ -0,0 +1,953 @@
+package filesystem
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/chaos"
+	"fafda/internal/memory"
+	"fafda/internal/quota"
+	"fafda/internal/trash"
+)
+
+func setupTestFs(t *testing.T, inj *chaos.Injector) *Fs {
+	driver := memory.NewDriver()
+	if inj != nil {
+		driver = chaos.NewDriver(driver, inj)
+	}
+	return &Fs{driver: driver, meta: memory.NewMetaFs()}
+}
+
+func writeTestFile(t *testing.T, fs *Fs, name string, data []byte) error {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func TestWriteWithFaults(t *testing.T) {
+	data := bytes.Repeat([]byte("fafda"), 100)
+
+	tests := []struct {
+		name     string
+		rule     chaos.Rule
+		wantErr  error
+		wantSize int64
+	}{
+		{
+			name:     "slow storage",
+			rule:     chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultLatency, Every: 1, Delay: time.Millisecond},
+			wantSize: int64(len(data)),
+		},
+		{
+			name:    "writer unavailable",
+			rule:    chaos.Rule{Op: chaos.OpGetWriter, Fault: chaos.FaultError, Every: 1},
+			wantErr: chaos.ErrInjected,
+		},
+		{
+			name:    "write fails",
+			rule:    chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultError, Every: 1},
+			wantErr: chaos.ErrInjected,
+		},
+		{
+			name:    "upload fails on close",
+			rule:    chaos.Rule{Op: chaos.OpClose, Fault: chaos.FaultError, Every: 1},
+			wantErr: chaos.ErrInjected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := setupTestFs(t, chaos.NewInjector(1, tt.rule))
+
+			err := writeTestFile(t, fs, "/file.bin", data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("write error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Failed uploads must not be reported with a size
+			info, err := fs.Stat("/file.bin")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if info.Size() != tt.wantSize {
+				t.Errorf("Size() = %d, want %d", info.Size(), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestReadWithFaults(t *testing.T) {
+	data := bytes.Repeat([]byte("fafda"), 1000)
+
+	tests := []struct {
+		name    string
+		rule    chaos.Rule
+		wantErr error
+	}{
+		{
+			name: "short reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultShortRead, Every: 1},
+		},
+		{
+			name: "slow reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultLatency, Probability: 0.5, Delay: time.Millisecond},
+		},
+		{
+			name:    "reader unavailable",
+			rule:    chaos.Rule{Op: chaos.OpGetReader, Fault: chaos.FaultError, Every: 1},
+			wantErr: chaos.ErrInjected,
+		},
+		{
+			name:    "truncated body",
+			rule:    chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultTruncate, Every: 1, After: 1},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "hung connection times out",
+			rule:    chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultHang, Every: 1, Delay: 10 * time.Millisecond},
+			wantErr: chaos.ErrInjected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := setupTestFs(t, nil)
+			if err := writeTestFile(t, fs, "/file.bin", data); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			fs.driver = chaos.NewDriver(fs.driver, chaos.NewInjector(1, tt.rule))
+
+			f, err := fs.Open("/file.bin")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer f.Close()
+
+			got, err := io.ReadAll(f)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, data) {
+				t.Errorf("ReadAll() got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestTruncateWithFaults(t *testing.T) {
+	fs := setupTestFs(t, chaos.NewInjector(1, chaos.Rule{Op: chaos.OpTruncate, Fault: chaos.FaultError, After: 1, Every: 1}))
+	if err := writeTestFile(t, fs, "/file.bin", []byte("fafda")); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+
+	if _, err := fs.OpenFile("/file.bin", os.O_WRONLY|os.O_TRUNC, 0666); !errors.Is(err, chaos.ErrInjected) {
+		t.Fatalf("OpenFile(O_TRUNC) error = %v, want %v", err, chaos.ErrInjected)
+	}
+
+	info, err := fs.Stat("/file.bin")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() != 5 {
+		t.Errorf("Size() = %d after failed truncate, want 5", info.Size())
+	}
+}
+
+func TestDigestRecordedOnClose(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	data := []byte("hello world")
+	if err := writeTestFile(t, fs, "/file.txt", data); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+
+	node, err := fs.meta.Stat("/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if got := node.Digest().SHA256; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Digest().SHA256 = %q, want %x", got, sum)
+	}
+
+	f, err := fs.OpenFile("/file.txt", os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile(O_TRUNC) error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if node, _ = fs.meta.Stat("/file.txt"); !node.Digest().IsZero() {
+		t.Errorf("Digest() = %+v after truncate, want zero", node.Digest())
+	}
+}
+
+func TestPartialWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		flag       int
+		write      func(f io.WriteSeeker) error
+		want       string
+		wantDigest bool
+	}{
+		{
+			name: "append",
+			flag: os.O_WRONLY | os.O_APPEND,
+			write: func(f io.WriteSeeker) error {
+				_, err := f.Write([]byte("6789"))
+				return err
+			},
+			want: "0123456789",
+		},
+		{
+			name: "resume at the end",
+			flag: os.O_WRONLY,
+			write: func(f io.WriteSeeker) error {
+				if _, err := f.Seek(6, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := f.Write([]byte("6789"))
+				return err
+			},
+			want: "0123456789",
+		},
+		{
+			name: "overwrite in the middle",
+			flag: os.O_RDWR,
+			write: func(f io.WriteSeeker) error {
+				if _, err := f.Seek(2, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := f.Write([]byte("AB"))
+				return err
+			},
+			want: "01AB45",
+		},
+		{
+			name: "write past the end",
+			flag: os.O_WRONLY,
+			write: func(f io.WriteSeeker) error {
+				_, err := f.(io.WriterAt).WriteAt([]byte("9"), 9)
+				return err
+			},
+			want: "012345\x00\x00\x009",
+		},
+		{
+			name: "replace from the start",
+			flag: os.O_WRONLY,
+			write: func(f io.WriteSeeker) error {
+				_, err := f.Write([]byte("abc"))
+				return err
+			},
+			want:       "abc",
+			wantDigest: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := setupTestFs(t, nil)
+			if err := writeTestFile(t, fs, "/file", []byte("012345")); err != nil {
+				t.Fatalf("setup failed: %v", err)
+			}
+
+			f, err := fs.OpenFile("/file", tt.flag, 0666)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if err := tt.write(f); err != nil {
+				_ = f.Close()
+				t.Fatalf("write error = %v", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			node, err := fs.meta.Stat("/file")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if node.Size() != int64(len(tt.want)) {
+				t.Errorf("Size() = %d, want %d", node.Size(), len(tt.want))
+			}
+			if node.Digest().IsZero() == tt.wantDigest {
+				t.Errorf("Digest() = %+v, want digest %v", node.Digest(), tt.wantDigest)
+			}
+
+			r, err := fs.Open("/file")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := writeTestFile(t, fs, "/file", []byte("0123456789")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	f, err := fs.OpenFile("/file", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt([]byte("AB"), 8); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := f.ReadAt(buf, 6); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if string(buf) != "67AB" {
+		t.Errorf("ReadAt() = %q, want %q", buf, "67AB")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    int
+		size    int64
+		want    string
+		wantErr error
+	}{
+		{name: "shrink", flag: os.O_WRONLY, size: 4, want: "0123"},
+		{name: "to zero", flag: os.O_RDWR, size: 0, want: ""},
+		{name: "grow", flag: os.O_WRONLY, size: 8, want: "012345\x00\x00"},
+		{name: "same size", flag: os.O_WRONLY, size: 6, want: "012345"},
+		{name: "read only", flag: os.O_RDONLY, size: 4, want: "012345", wantErr: internal.ErrNotSupported},
+		{name: "negative", flag: os.O_WRONLY, size: -1, want: "012345", wantErr: internal.ErrInvalidSeek},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := setupTestFs(t, nil)
+			if err := writeTestFile(t, fs, "/file", []byte("012345")); err != nil {
+				t.Fatalf("setup failed: %v", err)
+			}
+
+			f, err := fs.OpenFile("/file", tt.flag, 0666)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if err := f.Truncate(tt.size); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Truncate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			node, err := fs.meta.Stat("/file")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if node.Size() != int64(len(tt.want)) {
+				t.Errorf("Size() = %d, want %d", node.Size(), len(tt.want))
+			}
+
+			r, err := fs.Open("/file")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateThenWrite(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := writeTestFile(t, fs, "/file", []byte("0123456789")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	f, err := fs.OpenFile("/file", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(4); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	// The offset is not moved, writing there leaves a zero filled gap
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	if _, err := f.Write([]byte("AB")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	buf := make([]byte, 8)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if string(buf) != "0123\x00\x00AB" {
+		t.Errorf("ReadAt() = %q, want %q", buf, "0123\x00\x00AB")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := writeTestFile(t, fs, "/file", []byte("content")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := fs.Copy("/file", "/dir/copy"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	original, _ := fs.meta.Stat("/file")
+	copied, err := fs.meta.Stat("/dir/copy")
+	if err != nil {
+		t.Fatalf("Stat() copy error = %v", err)
+	}
+	if copied.Id() == original.Id() || copied.Size() != original.Size() || copied.Digest() != original.Digest() {
+		t.Errorf("copy = %s of %d bytes %+v, want a new id with the size and digest of %s", copied.Id(), copied.Size(), copied.Digest(), original.Id())
+	}
+
+	// Either can change without the other noticing
+	if err := writeTestFile(t, fs, "/dir/copy", []byte("changed")); err != nil {
+		t.Fatalf("Write() to copy error = %v", err)
+	}
+	for name, want := range map[string]string{"/file": "content", "/dir/copy": "changed"} {
+		r, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		got, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("ReadAll(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		src, dst string
+		wantErr  error
+	}{
+		{name: "existing", src: "/file", dst: "/dir/copy", wantErr: internal.ErrAlreadyExist},
+		{name: "directory", src: "/dir", dst: "/dir2", wantErr: internal.ErrIsDir},
+		{name: "missing", src: "/missing", dst: "/copy", wantErr: internal.ErrNotFound},
+		{name: "missing parent", src: "/file", dst: "/nowhere/copy", wantErr: internal.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fs.Copy(tt.src, tt.dst); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Copy() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopyOver(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	for name, content := range map[string]string{"/file": "content", "/taken": "old"} {
+		if err := writeTestFile(t, fs, name, []byte(content)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	if err := fs.CopyOver("/file", "/taken"); err != nil {
+		t.Fatalf("CopyOver() error = %v", err)
+	}
+	if got := readTestFile(t, fs, "/taken"); got != "content" {
+		t.Errorf("ReadFile() = %q, want %q", got, "content")
+	}
+	if nodes, err := fs.meta.Ls("/", 0, 0); err != nil || len(nodes) != 2 {
+		t.Errorf("Ls() = %v, %v, want the two files and no copy left behind", nodes, err)
+	}
+
+	if err := fs.CopyOver("/missing", "/taken"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("CopyOver() of a missing file error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if got := readTestFile(t, fs, "/taken"); got != "content" {
+		t.Errorf("ReadFile() after a failed CopyOver = %q, want %q", got, "content")
+	}
+	if err := fs.CopyOver("/file", "/free"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("CopyOver() onto nothing error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+// failingSwap fails to rename a copy into place.
+type failingSwap struct {
+	internal.MetaFileSystem
+}
+
+func (m failingSwap) Rename(oldpath, newpath string) error {
+	if strings.Contains(oldpath, ".copy-") {
+		return errors.New("rename failed")
+	}
+	return m.MetaFileSystem.Rename(oldpath, newpath)
+}
+
+func TestCopyOverKeepsOld(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	for name, content := range map[string]string{"/file": "content", "/taken": "old"} {
+		if err := writeTestFile(t, fs, name, []byte(content)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := fs.Link("/taken", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := fs.CopyOver("/file", "/taken"); err != nil {
+		t.Fatalf("CopyOver() error = %v", err)
+	}
+	if got := readTestFile(t, fs, "/taken"); got != "content" {
+		t.Errorf("ReadFile() = %q, want %q", got, "content")
+	}
+	if got := readTestFile(t, fs, "/link"); got != "old" {
+		t.Errorf("ReadFile() of the other hard link = %q, want %q", got, "old")
+	}
+	if node, err := fs.meta.Stat("/link"); err != nil || node.Links() != 1 {
+		t.Errorf("Stat() of the other hard link = %v, %v, want 1 link", node, err)
+	}
+
+	fs.meta = failingSwap{fs.meta}
+	if err := fs.CopyOver("/file", "/link"); err == nil {
+		t.Fatal("CopyOver() error = nil, want the rename's")
+	}
+	if got := readTestFile(t, fs, "/link"); got != "old" {
+		t.Errorf("ReadFile() after a failed swap = %q, want %q", got, "old")
+	}
+	if nodes, err := fs.meta.Ls("/", 0, 0); err != nil || len(nodes) != 3 {
+		t.Errorf("Ls() = %v, %v, want the three files and nothing left behind", nodes, err)
+	}
+}
+
+func TestRemoveDropsContent(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"/file", "/dir/file"} {
+		if err := writeTestFile(t, fs, name, []byte("content")); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := fs.Link("/file", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := fs.Copy("/file", "/copy"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	file, _ := fs.meta.Stat("/file")
+	inDir, _ := fs.meta.Stat("/dir/file")
+
+	stored := func(t *testing.T, id string) int64 {
+		t.Helper()
+		size, err := fs.driver.GetSize(id)
+		if err != nil {
+			t.Fatalf("GetSize() error = %v", err)
+		}
+		return size
+	}
+
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if stored(t, file.Id()) != 7 {
+		t.Error("content dropped while a hard link is left")
+	}
+	if err := fs.Remove("/link"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if stored(t, file.Id()) != 0 {
+		t.Error("content kept after the last entry was removed")
+	}
+	if got := readTestFile(t, fs, "/copy"); got != "content" {
+		t.Errorf("ReadFile() of the copy = %q, want %q", got, "content")
+	}
+
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if stored(t, inDir.Id()) != 0 {
+		t.Error("content kept after RemoveAll")
+	}
+
+	// The trash keeps the content until it deletes for good
+	fs.meta = trash.New(fs.meta, fs.driver, time.Hour).For("user", 0, 0)
+	copied, _ := fs.meta.Stat("/copy")
+	if err := fs.Remove("/copy"); err != nil {
+		t.Fatalf("Remove() to the trash error = %v", err)
+	}
+	if stored(t, copied.Id()) != 7 {
+		t.Error("content dropped when removing to the trash")
+	}
+}
+
+// failingCopier shares the content like the driver it wraps and then
+// fails anyway, once the metadata was made.
+type failingCopier struct {
+	internal.StorageDriver
+	copied []string
+}
+
+func (c *failingCopier) Copy(fromId, toId string) error {
+	if err := c.StorageDriver.(internal.Copier).Copy(fromId, toId); err != nil {
+		return err
+	}
+	c.copied = append(c.copied, toId)
+	return errors.New("copy failed")
+}
+
+func TestCopyRollback(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	fs.meta = trash.New(fs.meta, fs.driver, time.Hour).For("user", 0, 0)
+	if err := writeTestFile(t, fs, "/file", []byte("content")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	copier := &failingCopier{StorageDriver: fs.driver}
+	fs.driver = copier
+
+	if err := fs.Copy("/file", "/copy"); err == nil {
+		t.Fatal("Copy() error = nil, want the driver's")
+	}
+	for _, id := range copier.copied {
+		if size, _ := fs.driver.GetSize(id); size != 0 {
+			t.Errorf("GetSize() of the failed copy = %d, want its content dropped", size)
+		}
+	}
+	if len(copier.copied) != 1 {
+		t.Errorf("copied %d times, want once", len(copier.copied))
+	}
+	if _, err := fs.meta.Stat("/copy"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() of the failed copy error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if _, err := fs.meta.Stat(trash.Dir); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() of the trash error = %v, want the failed copy not to be in it", err)
+	}
+}
+
+func TestReaddirPagesPastHidden(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	fs.meta = trash.New(fs.meta, fs.driver, time.Hour).For("user", 0, 0)
+	for _, name := range []string{"/a", "/b"} {
+		if err := writeTestFile(t, fs, name, []byte("content")); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	// Puts /.trash first in the root
+	if err := fs.Remove("/b"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	dir, err := fs.Open("/")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer dir.Close()
+	var names []string
+	for {
+		page, err := dir.Readdirnames(1)
+		names = append(names, page...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames() error = %v", err)
+		}
+	}
+	if len(names) != 1 || names[0] != "a" {
+		t.Errorf("Readdirnames() one at a time = %v, want [a]", names)
+	}
+}
+
+func readTestFile(t *testing.T, fs *Fs, name string) string {
+	t.Helper()
+	r, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(%s) error = %v", name, err)
+	}
+	return string(data)
+}
+
+func TestSymlinks(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := writeTestFile(t, fs, "/dir/file", []byte("content")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	links := map[string]string{
+		"/abs":      "/dir/file",
+		"/dir/rel":  "file",
+		"/up":       "dir/../dir/rel",
+		"/dirlink":  "/dir",
+		"/dangling": "/dir/new",
+		"/loop":     "/loop2",
+		"/loop2":    "/loop",
+	}
+	for name, target := range links {
+		if err := fs.SymlinkIfPossible(target, name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	for _, name := range []string{"/abs", "/dir/rel", "/up", "/dirlink/file", "/dirlink/rel"} {
+		if got := readTestFile(t, fs, name); got != "content" {
+			t.Errorf("read through %s = %q, want %q", name, got, "content")
+		}
+	}
+
+	info, err := fs.Stat("/dirlink")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat(/dirlink) = %v, %v, want the directory", info, err)
+	}
+	info, lstat, err := fs.LstatIfPossible("/dirlink")
+	if err != nil || !lstat || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("LstatIfPossible(/dirlink) = %v, %v, %v, want the link", info, lstat, err)
+	}
+	if target, err := fs.ReadlinkIfPossible("/dir/rel"); err != nil || target != "file" {
+		t.Errorf("ReadlinkIfPossible(/dir/rel) = %q, %v, want %q", target, err, "file")
+	}
+	if _, err := fs.ReadlinkIfPossible("/dir/file"); !errors.Is(err, internal.ErrNotSymlink) {
+		t.Errorf("ReadlinkIfPossible() of a file error = %v, want %v", err, internal.ErrNotSymlink)
+	}
+	if _, err := fs.Stat("/loop"); !errors.Is(err, internal.ErrTooManyLinks) {
+		t.Errorf("Stat() of a loop error = %v, want %v", err, internal.ErrTooManyLinks)
+	}
+	if err := fs.SymlinkIfPossible("/dir", "/abs"); !errors.Is(err, internal.ErrAlreadyExist) {
+		t.Errorf("SymlinkIfPossible() over a link error = %v, want %v", err, internal.ErrAlreadyExist)
+	}
+
+	// Writing through a dangling link creates its target
+	if err := writeTestFile(t, fs, "/dangling", []byte("new")); err != nil {
+		t.Fatalf("write through /dangling: %v", err)
+	}
+	if got := readTestFile(t, fs, "/dir/new"); got != "new" {
+		t.Errorf("target of /dangling = %q, want %q", got, "new")
+	}
+
+	// Removing a link leaves what it points to alone
+	if err := fs.Remove("/dirlink"); err != nil {
+		t.Fatalf("Remove(/dirlink) error = %v", err)
+	}
+	if _, err := fs.Stat("/dir/file"); err != nil {
+		t.Errorf("Stat() after removing a link to its directory error = %v", err)
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := writeTestFile(t, fs, "/file", []byte("content")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := fs.Link("/file", "/dir/link"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	original, _ := fs.meta.Stat("/file")
+	linked, err := fs.meta.Stat("/dir/link")
+	if err != nil {
+		t.Fatalf("Stat() link error = %v", err)
+	}
+	if linked.Id() != original.Id() || linked.Links() != 2 || original.Links() != 2 {
+		t.Errorf("link = %s with %d links, original %s with %d, want the same id and 2 each", linked.Id(), linked.Links(), original.Id(), original.Links())
+	}
+
+	// Changes through one show through the other
+	if err := writeTestFile(t, fs, "/dir/link", []byte("changed!")); err != nil {
+		t.Fatalf("Write() to link error = %v", err)
+	}
+	if got := readTestFile(t, fs, "/file"); got != "changed!" {
+		t.Errorf("ReadAll(/file) = %q, want %q", got, "changed!")
+	}
+	if info, _ := fs.Stat("/file"); info.Size() != 8 {
+		t.Errorf("Stat(/file) size = %d, want 8", info.Size())
+	}
+
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if got := readTestFile(t, fs, "/dir/link"); got != "changed!" {
+		t.Errorf("ReadAll(/dir/link) after removing the other = %q, want %q", got, "changed!")
+	}
+	if info, _ := fs.meta.Stat("/dir/link"); info.Links() != 1 {
+		t.Errorf("Links() after removing the other = %d, want 1", info.Links())
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		wantErr  error
+	}{
+		{name: "existing", old: "/dir/link", new: "/dir/link", wantErr: internal.ErrAlreadyExist},
+		{name: "directory", old: "/dir", new: "/dir2", wantErr: internal.ErrIsDir},
+		{name: "missing", old: "/missing", new: "/link", wantErr: internal.ErrNotFound},
+		{name: "missing parent", old: "/dir/link", new: "/nowhere/link", wantErr: internal.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fs.Link(tt.old, tt.new); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Link() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChmodChown(t *testing.T) {
+	fs := setupTestFs(t, nil)
+	if err := writeTestFile(t, fs, "/file", []byte("content")); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := fs.SymlinkIfPossible("/file", "/symlink"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	// Both follow the link to the file
+	if err := fs.Chmod("/symlink", 0640); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := fs.Chown("/symlink", 1000, 100); err != nil {
+		t.Fatalf("Chown() error = %v", err)
+	}
+	file, err := fs.meta.Stat("/file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if file.Mode() != 0640 || file.Uid() != 1000 || file.Gid() != 100 {
+		t.Errorf("Stat(/file) = %v %d:%d, want -rw-r----- 1000:100", file.Mode(), file.Uid(), file.Gid())
+	}
+	if link, _ := fs.meta.Stat("/symlink"); !link.IsSymlink() || link.Uid() != 0 {
+		t.Errorf("Stat(/symlink) = %v %d, want a link still owned by root", link.Mode(), link.Uid())
+	}
+
+	// Content survives a chmod and the mode survives a write
+	if err := writeTestFile(t, fs, "/file", []byte("changed")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if info, _ := fs.Stat("/file"); info.Mode() != 0640 {
+		t.Errorf("Stat(/file) mode after write = %v, want -rw-r-----", info.Mode())
+	}
+	if err := fs.Chmod("/missing", 0600); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Chmod() of a missing file error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	meta, err := bolt.NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer meta.Close()
+	// /team itself is one of the 3 entries
+	limits := []config.Quota{{Path: "/team", Bytes: 100, Files: 3}}
+	fs := &Fs{driver: memory.NewDriver(), meta: meta, quota: quota.New(meta.(internal.UsageCounter), limits)}
+
+	if err := fs.Mkdir("/team", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := writeTestFile(t, fs, "/team/a", bytes.Repeat([]byte("a"), 60)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := writeTestFile(t, fs, "/team/b", bytes.Repeat([]byte("b"), 50)); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("Write() past the bytes error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if _, err := fs.Create("/team/c"); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("Create() past the files error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+
+	// Replacing counts only what the file grows by
+	if err := writeTestFile(t, fs, "/team/a", bytes.Repeat([]byte("a"), 100)); err != nil {
+		t.Errorf("Write() replacing error = %v", err)
+	}
+	f, err := fs.OpenFile("/team/a", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if _, err := f.WriteAt([]byte("a"), 100); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("WriteAt() past the end error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if err := f.Truncate(101); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("Truncate() growing error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if err := f.Truncate(10); err != nil {
+		t.Errorf("Truncate() shrinking error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := writeTestFile(t, fs, "/big", bytes.Repeat([]byte("b"), 200)); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := fs.Rename("/big", "/team/big"); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("Rename() into the quota error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if err := fs.Copy("/team/a", "/copy"); err != nil {
+		t.Errorf("Copy() out of the quota error = %v", err)
+	}
+	if err := fs.Remove("/team/b"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := fs.Link("/big", "/team/link"); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("Link() into the quota error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if err := fs.Rename("/copy", "/team/copy"); err != nil {
+		t.Errorf("Rename() of what fits error = %v", err)
+	}
+
+	// The destination stays when its replacement doesn't fit
+	if err := fs.CopyOver("/big", "/team/a"); !errors.Is(err, internal.ErrQuotaExceeded) {
+		t.Errorf("CopyOver() into the quota error = %v, want %v", err, internal.ErrQuotaExceeded)
+	}
+	if node, err := fs.meta.Stat("/team/a"); err != nil || node.Size() != 10 {
+		t.Errorf("Stat() of what CopyOver left = %v, %v, want the 10 bytes from before", node, err)
+	}
+}
@@ -0,0 +1,202 @@
+package ftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserverlib"
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"fafda/config"
+	"fafda/internal/chaos"
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+// startServer serves fs to the user "user" on a loopback port.
+func startServer(t *testing.T, fs afero.Fs) string {
+	t.Helper()
+	driver := &Driver{
+		NewFs:  func(config.FTPUser) afero.Fs { return fs },
+		Users:  []config.FTPUser{{Username: "user", Password: "pass"}},
+		logger: zerolog.Nop(),
+		Settings: &ftpserver.Settings{
+			ListenAddr:          "127.0.0.1:0",
+			DefaultTransferType: ftpserver.TransferTypeBinary,
+			IdleTimeout:         10,
+		},
+	}
+	server := ftpserver.NewFtpServer(driver)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go func() { _ = server.Serve() }()
+	t.Cleanup(func() { _ = server.Stop() })
+	return server.Addr()
+}
+
+// ftpClient speaks just enough FTP to move files in passive mode.
+type ftpClient struct {
+	t    *testing.T
+	host string
+	conn *textproto.Conn
+}
+
+func dialFTP(t *testing.T, addr string) *ftpClient {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	host, _, _ := net.SplitHostPort(addr)
+	c := &ftpClient{t: t, host: host, conn: conn}
+	c.reply()
+	c.cmd("USER user")
+	c.cmd("PASS pass")
+	if code, msg := c.cmd("TYPE I"); code != ftpserver.StatusOK {
+		t.Fatalf("TYPE I = %d %s", code, msg)
+	}
+	return c
+}
+
+func (c *ftpClient) reply() (int, string) {
+	c.t.Helper()
+	code, msg, err := c.conn.ReadResponse(0)
+	if err != nil {
+		c.t.Fatalf("ReadResponse() error = %v", err)
+	}
+	return code, msg
+}
+
+func (c *ftpClient) cmd(line string) (int, string) {
+	c.t.Helper()
+	if err := c.conn.PrintfLine("%s", line); err != nil {
+		c.t.Fatalf("PrintfLine() error = %v", err)
+	}
+	return c.reply()
+}
+
+// transfer runs line over a new data connection, sending data when it is
+// not nil, and returns the final reply code with what was received.
+func (c *ftpClient) transfer(line string, data []byte) (int, []byte) {
+	c.t.Helper()
+	code, msg := c.cmd("EPSV")
+	if code != ftpserver.StatusEnteringEPSV {
+		c.t.Fatalf("EPSV = %d %s", code, msg)
+	}
+	var port int
+	if _, err := fmt.Sscanf(msg[strings.Index(msg, "(|||"):], "(|||%d|)", &port); err != nil {
+		c.t.Fatalf("EPSV reply %q: %v", msg, err)
+	}
+	dataConn, err := net.Dial("tcp", net.JoinHostPort(c.host, fmt.Sprint(port)))
+	if err != nil {
+		c.t.Fatalf("Dial() error = %v", err)
+	}
+	defer dataConn.Close()
+
+	if code, _ = c.cmd(line); code != ftpserver.StatusFileStatusOK {
+		return code, nil
+	}
+
+	var received []byte
+	if data != nil {
+		// The server may give up halfway, what it says about it comes after
+		_, _ = dataConn.Write(data)
+		_ = dataConn.Close()
+	} else {
+		received, _ = io.ReadAll(dataConn)
+	}
+	code, _ = c.reply()
+	return code, received
+}
+
+func TestTransferWithFaults(t *testing.T) {
+	data := bytes.Repeat([]byte("fafda"), 20000)
+
+	tests := []struct {
+		name       string
+		rule       chaos.Rule
+		failUpload bool
+		failRetr   bool
+	}{
+		{
+			name: "slow writes",
+			rule: chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultLatency, Every: 1, Delay: time.Millisecond},
+		},
+		{
+			name: "short reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultShortRead, Every: 1},
+		},
+		{
+			name: "flaky slow reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultLatency, Probability: 0.5, Delay: time.Millisecond},
+		},
+		{
+			name:       "writer fails",
+			rule:       chaos.Rule{Op: chaos.OpGetWriter, Fault: chaos.FaultError, Every: 1},
+			failUpload: true,
+		},
+		{
+			name:       "write fails",
+			rule:       chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultError, Every: 1},
+			failUpload: true,
+		},
+		{
+			name:       "close fails",
+			rule:       chaos.Rule{Op: chaos.OpClose, Fault: chaos.FaultError, Every: 1},
+			failUpload: true,
+		},
+		{
+			name:     "read fails",
+			rule:     chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultError, Every: 1, After: 1},
+			failRetr: true,
+		},
+		{
+			name:     "reader fails",
+			rule:     chaos.Rule{Op: chaos.OpGetReader, Fault: chaos.FaultError, Every: 1},
+			failRetr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := chaos.NewDriver(memory.NewDriver(), chaos.NewInjector(1, tt.rule))
+			fs := filesystem.New(driver, memory.NewMetaFs(), nil, nil)
+			c := dialFTP(t, startServer(t, fs))
+
+			code, _ := c.transfer("STOR /file.bin", data)
+			if tt.failUpload {
+				if code == ftpserver.StatusClosingDataConn {
+					t.Fatalf("STOR = %d, want a failure", code)
+				}
+				return
+			}
+			if code != ftpserver.StatusClosingDataConn {
+				t.Fatalf("STOR = %d, want %d", code, ftpserver.StatusClosingDataConn)
+			}
+
+			code, got := c.transfer("RETR /file.bin", nil)
+			if tt.failRetr {
+				if code == ftpserver.StatusClosingDataConn {
+					t.Fatalf("RETR = %d with %d bytes, want a failure", code, len(got))
+				}
+				return
+			}
+			if code != ftpserver.StatusClosingDataConn {
+				t.Fatalf("RETR = %d, want %d", code, ftpserver.StatusClosingDataConn)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("RETR returned %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package ftp
+
+import (
+	"errors"
+	"path"
+
+	"github.com/fclairamb/ftpserverlib"
+)
+
+// Site answers SITE CPFR and SITE CPTO, the copy commands of ProFTPD's
+// mod_copy. The library only hands them over to logged in clients.
+func (cd *ClientDriver) Site(command, param string) (int, string, bool) {
+	if command != "CPFR" && command != "CPTO" {
+		return 0, "", false
+	}
+	if param == "" {
+		return ftpserver.StatusSyntaxErrorParameters, "Missing path", true
+	}
+	p := param
+	if !path.IsAbs(p) {
+		p = path.Join(cd.cwd.Path(), p)
+	}
+	p = path.Clean(p)
+
+	if command == "CPFR" {
+		cd.copyFrom = ""
+		if _, err := cd.Stat(p); err != nil {
+			return ftpserver.StatusActionNotTaken, err.Error(), true
+		}
+		cd.copyFrom = p
+		return ftpserver.StatusFileActionPending, "File exists, ready for destination name", true
+	}
+
+	from := cd.copyFrom
+	cd.copyFrom = ""
+	if from == "" {
+		return ftpserver.StatusBadCommandSequence, "Bad sequence of commands, send SITE CPFR first", true
+	}
+	err := errors.ErrUnsupported
+	if copier, ok := cd.Fs.(interface{ Copy(src, dst string) error }); ok {
+		err = copier.Copy(from, p)
+	}
+	if err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error(), true
+	}
+	return ftpserver.StatusFileOK, "Copy successful", true
+}
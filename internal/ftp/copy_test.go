@@ -0,0 +1,56 @@
+package ftp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+type cwd string
+
+func (c cwd) Path() string { return string(c) }
+
+func TestSiteCopy(t *testing.T) {
+	fs := filesystem.New(memory.NewDriver(), memory.NewMetaFs(), nil, nil)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := afero.WriteFile(fs, "/dir/file", []byte("content"), 0644); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	cd := &ClientDriver{Fs: fs, cwd: cwd("/dir")}
+
+	tests := []struct {
+		line string
+		want int
+	}{
+		{line: "CPTO copy", want: 503},
+		{line: "CPFR missing", want: 550},
+		{line: "CPFR file", want: 350},
+		{line: "CPTO /dir/copy", want: 250},
+		{line: "CPFR /dir/file", want: 350},
+		{line: "CPTO copy", want: 550},
+		{line: "CPFR", want: 501},
+	}
+	for _, tt := range tests {
+		command, param, _ := strings.Cut(tt.line, " ")
+		code, message, handled := cd.Site(command, param)
+		if !handled || code != tt.want {
+			t.Errorf("SITE %s = %d %q, %v, want %d", tt.line, code, message, handled, tt.want)
+		}
+	}
+
+	data, err := afero.ReadFile(fs, "/dir/copy")
+	if err != nil || string(data) != "content" {
+		t.Errorf("ReadFile() of the copy = %q, %v, want %q", data, err, "content")
+	}
+
+	// Everything else is left to the library
+	if _, _, handled := cd.Site("CHMOD", "600 file"); handled {
+		t.Error("SITE CHMOD handled, want it left to the library")
+	}
+}
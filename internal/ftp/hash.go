@@ -0,0 +1,79 @@
+package ftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+)
+
+var ErrUnknownHash = errors.New("unknown hash algorithm")
+
+// ClientDriver answers HASH and X<ALGO> commands from the digests recorded
+// at upload time, and only reads the file back when none is available.
+type ClientDriver struct {
+	afero.Fs
+
+	cwd      interface{ Path() string }
+	copyFrom string // path given by the last SITE CPFR
+}
+
+func (cd *ClientDriver) ComputeHash(name string, algo ftpserver.HASHAlgo, start, end int64) (string, error) {
+	info, err := cd.Stat(name)
+	if err != nil {
+		return "", err
+	}
+
+	if node, ok := info.(*internal.Node); ok && start == 0 && end == node.Size() {
+		digest := node.Digest()
+		switch {
+		case algo == ftpserver.HASHAlgoSHA256 && digest.SHA256 != "":
+			return digest.SHA256, nil
+		case algo == ftpserver.HASHAlgoMD5 && digest.MD5 != "":
+			return digest.MD5, nil
+		case algo == ftpserver.HASHAlgoCRC32 && digest.CRC32 != "":
+			return digest.CRC32, nil
+		}
+	}
+
+	var h hash.Hash
+	switch algo {
+	case ftpserver.HASHAlgoCRC32:
+		h = crc32.NewIEEE()
+	case ftpserver.HASHAlgoMD5:
+		h = md5.New()
+	case ftpserver.HASHAlgoSHA1:
+		h = sha1.New()
+	case ftpserver.HASHAlgoSHA256:
+		h = sha256.New()
+	case ftpserver.HASHAlgoSHA512:
+		h = sha512.New()
+	default:
+		return "", ErrUnknownHash
+	}
+
+	file, err := cd.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(h, file, end-start); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,53 @@
+package ftp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+func TestComputeHash(t *testing.T) {
+	metafs := memory.NewMetaFs()
+	fs := filesystem.New(memory.NewDriver(), metafs, nil, nil)
+	if err := afero.WriteFile(fs, "/file.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Pretend the upload recorded a different digest, to tell both paths apart
+	if err := metafs.SetDigest("/file.txt", internal.Digest{SHA256: "recorded"}); err != nil {
+		t.Fatalf("SetDigest() error = %v", err)
+	}
+
+	sha1Sum := sha1.Sum([]byte("lo wo"))
+
+	tests := []struct {
+		name  string
+		algo  ftpserver.HASHAlgo
+		start int64
+		end   int64
+		want  string
+	}{
+		{name: "recorded sha256", algo: ftpserver.HASHAlgoSHA256, end: 11, want: "recorded"},
+		{name: "computed range", algo: ftpserver.HASHAlgoSHA1, start: 3, end: 8, want: hex.EncodeToString(sha1Sum[:])},
+	}
+
+	cd := &ClientDriver{Fs: fs}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cd.ComputeHash("/file.txt", tt.algo, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("ComputeHash() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ComputeHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package ftp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"fafda/internal"
+)
+
+// MLSxFacts adds the mode, owner and extended attributes of a file to its
+// MLST and MLSD entries (RFC 3659). The content-type attribute is the
+// media type, the others go as X.xattr.<name> unless they cannot be
+// written as a fact.
+func (cd *ClientDriver) MLSxFacts(info os.FileInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "UNIX.mode=%04o;", info.Mode().Perm())
+
+	if node, ok := info.(*internal.Node); ok {
+		fmt.Fprintf(&b, "UNIX.uid=%d;UNIX.gid=%d;", node.Uid(), node.Gid())
+		for _, name := range node.XattrNames() {
+			value, _ := node.Xattr(name)
+			switch {
+			case strings.ContainsAny(name, "=; ") || strings.ContainsAny(value, ";\r\n"):
+			case name == internal.XattrContentType:
+				fmt.Fprintf(&b, "Media-Type=%s;", value)
+			default:
+				fmt.Fprintf(&b, "X.xattr.%s=%s;", name, value)
+			}
+		}
+	}
+	return b.String()
+}
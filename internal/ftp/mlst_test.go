@@ -0,0 +1,33 @@
+package ftp
+
+import (
+	"testing"
+	"time"
+
+	"fafda/internal"
+)
+
+func TestMLSxFacts(t *testing.T) {
+	modTime := time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC)
+	node := internal.NewNode("/dir/report.csv", false).
+		SetSize(42).
+		SetModTime(modTime).
+		Chmod(0640).
+		Chown(1000, 100).
+		SetXattr(internal.XattrContentType, "text/csv").
+		SetXattr("origin", "upload").
+		SetXattr("bad;name", "value").
+		SetXattr("note", "not;representable")
+
+	cd := &ClientDriver{}
+	want := "UNIX.mode=0640;UNIX.uid=1000;UNIX.gid=100;Media-Type=text/csv;X.xattr.origin=upload;"
+	if got := cd.MLSxFacts(node); got != want {
+		t.Errorf("MLSxFacts() = %q, want %q", got, want)
+	}
+
+	dir := internal.NewNode("/dir", true).SetModTime(modTime)
+	want = "UNIX.mode=0755;UNIX.uid=0;UNIX.gid=0;"
+	if got := cd.MLSxFacts(dir); got != want {
+		t.Errorf("MLSxFacts() of a directory = %q, want %q", got, want)
+	}
+}
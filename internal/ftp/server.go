@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/afero"
 
 	"fafda/config"
+	"fafda/internal/perm"
 )
 
 const IPResolveURL = "https://ipinfo.io/ip"
@@ -21,11 +22,12 @@ var (
 	ErrBadUserNameOrPassword = errors.New("bad username or password")
 )
 
-func Serv(cfg config.FTPServer, fs afero.Fs) error {
+// Serv serves the filesystem newFs returns for each user that logs in.
+func Serv(cfg config.FTPServer, newFs func(user config.FTPUser) afero.Fs) error {
 	logger := log.With().Str("component", "ftpserver").Logger()
 
 	driver := &Driver{
-		Fs:     fs,
+		NewFs:  newFs,
 		Debug:  true,
 		Users:  cfg.Users,
 		logger: logger,
@@ -33,7 +35,9 @@ func Serv(cfg config.FTPServer, fs afero.Fs) error {
 			ListenAddr:          cfg.Addr,
 			DefaultTransferType: ftpserver.TransferTypeBinary,
 			IdleTimeout:         86400, // 24 hour
+			EnableHASH:          true,
 		},
+		EnforcePermissions: cfg.EnforcePermissions,
 	}
 
 	// Optionally resolve public IP
@@ -57,11 +61,14 @@ func Serv(cfg config.FTPServer, fs afero.Fs) error {
 }
 
 type Driver struct {
-	Fs       afero.Fs
+	NewFs    func(user config.FTPUser) afero.Fs
 	Debug    bool
 	Settings *ftpserver.Settings
 	Users    []config.FTPUser
 	logger   zerolog.Logger
+
+	// EnforcePermissions checks mode bits against the uid and gid of users
+	EnforcePermissions bool
 }
 
 func (d *Driver) ClientConnected(cc ftpserver.ClientContext) (string, error) {
@@ -89,7 +96,11 @@ func (d *Driver) AuthUser(cc ftpserver.ClientContext, user, pass string) (ftpser
 				Uint32("sessionId", cc.ID()).
 				Str("user", user).
 				Msg("authentication successful")
-			return d.Fs, nil
+			fs := d.NewFs(u)
+			if d.EnforcePermissions {
+				fs = perm.New(fs, perm.Identity{Uid: u.Uid, Gid: u.Gid})
+			}
+			return &ClientDriver{Fs: fs, cwd: cc}, nil
 		}
 	}
 	d.logger.Warn().
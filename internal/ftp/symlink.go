@@ -0,0 +1,16 @@
+package ftp
+
+import (
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// Symlink answers SITE SYMLINK. The library hands both names over made
+// absolute, so links made this way never point relative to themselves.
+func (cd *ClientDriver) Symlink(oldname, newname string) error {
+	if linker, ok := cd.Fs.(afero.Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+}
@@ -3,34 +3,64 @@ package github
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
 
 	"go.etcd.io/bbolt"
+
+	"fafda/internal/bolt"
 )
 
 type Asset struct {
-	Id         int
-	Name       string
-	Username   string
-	Repository string
-	ReleaseId  int
-	ReleaseTag string
-	Size       int
-	Number     int
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	Username   string `json:"username"`
+	Repository string `json:"repository"`
+	ReleaseId  int    `json:"releaseId"`
+	ReleaseTag string `json:"releaseTag"`
+	Size       int    `json:"size"`
+	Number     int    `json:"number"`
+	Checksum   string `json:"checksum"` // hex encoded SHA-256 of the part
 
 	client *Client
 }
 
+// newHole is a part of size zeros that is never uploaded, it is how a
+// file grows without storing anything.
+func newHole(number int, size int) *Asset {
+	return &Asset{Number: number, Size: size}
+}
+
+// isHole reports whether the asset stands for zeros, every uploaded asset
+// has an id and a name.
+func (a *Asset) isHole() bool {
+	return a.Id == 0 && a.Name == ""
+}
+
 func (a *Asset) GetSize() int {
 	return a.Size
 }
 
+func (a *Asset) GetChecksum() string {
+	return a.Checksum
+}
+
 func (a *Asset) GetReader(start, end int) (io.ReadCloser, error) {
+	if a.isHole() {
+		return io.NopCloser(io.LimitReader(zeros{}, int64(end-start+1))), nil
+	}
 	return a.client.DownloadAsset(a, start, end)
 }
 
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 func (a *Asset) url() string {
 	return fmt.Sprintf(
 		"%s/repos/%s/%s/releases/assets/%d",
@@ -45,12 +75,27 @@ func (a *Asset) publicURL() string {
 	)
 }
 
+// Assets persists which assets make up each file.
+type Assets interface {
+	Write(fileId string, assets []*Asset) error
+	Get(fileId string) ([]Asset, error)
+	Size(fileId string) (int64, error)
+	Delete(fileId string) error
+
+	// Refs - how many files list each uploaded asset of fileId, copies
+	// and snapshots share the assets of the file they were made of
+	Refs(fileId string) (map[int]int, error)
+
+	// Unused - those of assets no file lists anymore, holes left out
+	Unused(assets []*Asset) ([]*Asset, error)
+}
+
 type AssetStore struct {
-	db         *bbolt.DB
+	db         bolt.DB
 	bucketName []byte
 }
 
-func NewAssetStore(db *bbolt.DB) (*AssetStore, error) {
+func NewAssetStore(db bolt.DB) (*AssetStore, error) {
 
 	err := db.Update(func(tx *bbolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(assetBucket)
@@ -82,6 +127,12 @@ func (ass *AssetStore) Write(fileId string, assets []*Asset) error {
 		}
 
 		key := []byte(fileId)
+		if err := bolt.CountRefs(bucket, bucket.Get(key), -1); err != nil {
+			return err
+		}
+		if err := bolt.CountRefs(bucket, buf.Bytes(), 1); err != nil {
+			return err
+		}
 		return bucket.Put(key, buf.Bytes())
 	})
 }
@@ -121,6 +172,78 @@ func (ass *AssetStore) Size(fileId string) (int64, error) {
 	return size, err
 }
 
+func (ass *AssetStore) Refs(fileId string) (map[int]int, error) {
+	assets, err := ass.Get(fileId)
+	if err != nil || len(assets) == 0 {
+		return nil, err
+	}
+
+	refs := map[int]int{}
+	err = ass.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(ass.bucketName)
+		for _, asset := range assets {
+			if !asset.isHole() {
+				refs[asset.Id] = bolt.RefCount(bucket, asset.Id)
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func (ass *AssetStore) Unused(assets []*Asset) ([]*Asset, error) {
+	var unused []*Asset
+	err := ass.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(ass.bucketName)
+		seen := map[int]bool{}
+		for _, asset := range assets {
+			if asset.isHole() || seen[asset.Id] {
+				continue
+			}
+			seen[asset.Id] = true
+			if bucket == nil || bolt.RefCount(bucket, asset.Id) == 0 {
+				unused = append(unused, asset)
+			}
+		}
+		return nil
+	})
+	return unused, err
+}
+
+// Dump calls fn with the JSON encoded asset list of every file.
+func (ass *AssetStore) Dump(fn func(fileId string, record json.RawMessage) error) error {
+	return ass.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(ass.bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil // the refs bucket
+			}
+			var assets []*Asset
+			if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&assets); err != nil {
+				return fmt.Errorf("decode assets of %s: %w", k, err)
+			}
+			record, err := json.Marshal(assets)
+			if err != nil {
+				return err
+			}
+			return fn(string(k), record)
+		})
+	})
+}
+
+// Load stores an asset list produced by Dump.
+func (ass *AssetStore) Load(fileId string, record json.RawMessage) error {
+	var assets []*Asset
+	if err := json.Unmarshal(record, &assets); err != nil {
+		return err
+	}
+	return ass.Write(fileId, assets)
+}
+
 func (ass *AssetStore) Delete(fileId string) error {
 	return ass.db.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(ass.bucketName)
@@ -128,6 +251,10 @@ func (ass *AssetStore) Delete(fileId string) error {
 			return nil
 		}
 
-		return bucket.Delete([]byte(fileId))
+		key := []byte(fileId)
+		if err := bolt.CountRefs(bucket, bucket.Get(key), -1); err != nil {
+			return err
+		}
+		return bucket.Delete(key)
 	})
 }
@@ -0,0 +1,88 @@
+package github
+
+import (
+	"fmt"
+	"io"
+
+	"fafda/config"
+	"fafda/internal"
+)
+
+// BackupStore keeps metadata snapshots as assets of a release that is not
+// used for file content, addressed by asset name.
+type BackupStore struct {
+	client  *Client
+	release config.GitHubRelease
+}
+
+func NewBackupStore(release config.GitHubRelease) (*BackupStore, error) {
+	if release.ReadOnly {
+		return nil, fmt.Errorf("backup release %d is read only", release.ReleaseId)
+	}
+
+	client, err := NewClient(config.GitHub{Releases: []config.GitHubRelease{release}})
+	if err != nil {
+		return nil, err
+	}
+	return &BackupStore{client: client, release: release}, nil
+}
+
+func (bs *BackupStore) Upload(name string, data []byte) error {
+	_, err := bs.client.UploadAsset(name, int64(len(data)), data)
+	return err
+}
+
+func (bs *BackupStore) List() ([]string, error) {
+	assets, err := bs.assets()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(assets))
+	for i, asset := range assets {
+		names[i] = asset.Name
+	}
+	return names, nil
+}
+
+func (bs *BackupStore) Download(name string) (io.ReadCloser, error) {
+	asset, err := bs.find(name)
+	if err != nil {
+		return nil, err
+	}
+	return bs.client.DownloadAsset(asset, 0, asset.Size-1)
+}
+
+func (bs *BackupStore) Delete(name string) error {
+	asset, err := bs.find(name)
+	if err != nil {
+		return err
+	}
+	return bs.client.DeleteAsset(asset)
+}
+
+func (bs *BackupStore) find(name string) (*Asset, error) {
+	assets, err := bs.assets()
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		if asset.Name == name {
+			return asset, nil
+		}
+	}
+	return nil, fmt.Errorf("asset %s: %w", name, internal.ErrDataMissing)
+}
+
+func (bs *BackupStore) assets() ([]*Asset, error) {
+	assets, err := bs.client.ListAssets(bs.release)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		asset.Username = bs.release.Username
+		asset.Repository = bs.release.Repository
+		asset.ReleaseId = bs.release.ReleaseId
+		asset.ReleaseTag = bs.release.ReleaseTag
+	}
+	return assets, nil
+}
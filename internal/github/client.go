@@ -140,3 +140,110 @@ func (c *Client) DownloadAsset(asset *Asset, start, end int) (io.ReadCloser, err
 
 	return resp.Body, nil
 }
+
+// StatAsset fetches the asset's current metadata from the API, it returns
+// internal.ErrDataMissing when GitHub no longer knows about it.
+func (c *Client) StatAsset(asset *Asset) (*Asset, error) {
+	token := c.resources.GetUserToken(asset.Username)
+
+	if token == "" {
+		return nil, fmt.Errorf("token not found for given asset username:%s", asset.Username)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, asset.url(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set(internal.HeaderAuthorization, "Bearer "+token)
+	req.Header.Set(internal.HeaderAccept, internal.MediaTypeGithubJSON)
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("stat asset: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, internal.ErrDataMissing
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("stat asset failed: %s", string(body))
+	}
+
+	var remote Asset
+	if err := json.NewDecoder(resp.Body).Decode(&remote); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &remote, nil
+}
+
+// ListAssets returns every asset attached to the release.
+func (c *Client) ListAssets(release config.GitHubRelease) ([]*Asset, error) {
+	var all []*Asset
+	for page := 1; ; page++ {
+		url := fmt.Sprintf(
+			"%s/repos/%s/%s/releases/%d/assets?per_page=100&page=%d",
+			apiURL, release.Username, release.Repository, release.ReleaseId, page,
+		)
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set(internal.HeaderAuthorization, "Bearer "+release.AuthToken)
+		req.Header.Set(internal.HeaderAccept, internal.MediaTypeGithubJSON)
+
+		resp, err := c.doRequest(req)
+		if err != nil {
+			return nil, fmt.Errorf("list assets: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("list assets failed: %s", string(body))
+		}
+
+		var assets []*Asset
+		err = json.NewDecoder(resp.Body).Decode(&assets)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+
+		all = append(all, assets...)
+		if len(assets) < 100 {
+			return all, nil
+		}
+	}
+}
+
+func (c *Client) DeleteAsset(asset *Asset) error {
+	token := c.resources.GetUserToken(asset.Username)
+
+	if token == "" {
+		return fmt.Errorf("token not found for given asset username:%s", asset.Username)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, asset.url(), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set(internal.HeaderAuthorization, "Bearer "+token)
+	req.Header.Set(internal.HeaderAccept, internal.MediaTypeGithubJSON)
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return fmt.Errorf("delete asset: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete asset failed: %s", string(body))
+	}
+	return nil
+}
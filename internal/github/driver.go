@@ -1,35 +1,43 @@
 package github
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 
-	"go.etcd.io/bbolt"
+	"github.com/rs/zerolog/log"
 
 	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/partedio"
 )
 
 const MaxPartSize = (2 * 1024 * 1024 * 1024) - 429496729 // 2GB - 20%
 
 type Driver struct {
 	client *Client
-	ass    *AssetStore
+	ass    Assets
 
 	partSize    int64
 	concurrency int
+	checksums   []string
+	releases    []config.GitHubRelease
 }
 
-func NewDriver(cfg config.GitHub, db *bbolt.DB) (*Driver, error) {
+func NewDriver(cfg config.GitHub, ass Assets) (*Driver, error) {
 
 	if cfg.PartSize <= 0 || cfg.PartSize > MaxPartSize {
 		return nil, fmt.Errorf("partSize must be positive and under ")
 	}
 
-	client, err := NewClient(cfg)
-	if err != nil {
+	// Fail early on unknown algorithms rather than on first upload
+	if _, err := internal.NewHasher(cfg.Checksums...); err != nil {
 		return nil, err
 	}
-	ass, err := NewAssetStore(db)
+
+	client, err := NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +47,8 @@ func NewDriver(cfg config.GitHub, db *bbolt.DB) (*Driver, error) {
 		client:      client,
 		partSize:    cfg.PartSize,
 		concurrency: cfg.Concurrency,
+		checksums:   cfg.Checksums,
+		releases:    cfg.Releases,
 	}, nil
 }
 
@@ -50,10 +60,263 @@ func (d *Driver) GetWriter(fileId string) (io.WriteCloser, error) {
 	return NewWriter(fileId, d)
 }
 
+// GetAppender writes new parts after the stored ones, nothing already
+// uploaded is touched.
+func (d *Driver) GetAppender(fileId string) (io.WriteCloser, error) {
+	assets, err := d.ass.Get(fileId)
+	if err != nil {
+		return nil, err
+	}
+	prior := make([]*Asset, len(assets))
+	for i := range assets {
+		prior[i] = &assets[i]
+	}
+	return newWriter(fileId, d, prior)
+}
+
+func (d *Driver) GetWriterAt(fileId string) (internal.WriteAtCloser, error) {
+	return &WriterAt{fileId: fileId, drvr: d}, nil
+}
+
 func (d *Driver) GetSize(fileId string) (int64, error) {
 	return d.ass.Size(fileId)
 }
 
+// Truncate empties the file, its parts are deleted once no file lists
+// them.
 func (d *Driver) Truncate(fileId string) error {
+	assets, err := d.ass.Get(fileId)
+	if err != nil {
+		return err
+	}
+	if err := d.ass.Delete(fileId); err != nil {
+		return err
+	}
+	d.release(fileId, dropped(assets, nil))
+	return nil
+}
+
+// Move records the parts of fromId under toId, nothing is uploaded.
+func (d *Driver) Move(fromId, toId string) error {
+	assets, err := d.ass.Get(fromId)
+	if err != nil || len(assets) == 0 {
+		return err
+	}
+	moved := make([]*Asset, len(assets))
+	for i := range assets {
+		moved[i] = &assets[i]
+	}
+	if err := d.ass.Write(toId, moved); err != nil {
+		return err
+	}
+	return d.ass.Delete(fromId)
+}
+
+// Copy records the parts of fromId under toId as well, nothing is
+// uploaded and the parts are only deleted once neither lists them.
+func (d *Driver) Copy(fromId, toId string) error {
+	assets, err := d.ass.Get(fromId)
+	if err != nil || len(assets) == 0 {
+		return err
+	}
+	copied := make([]*Asset, len(assets))
+	for i := range assets {
+		copied[i] = &assets[i]
+	}
+	return d.ass.Write(toId, copied)
+}
+
+// Purge deletes the parts of the file from their releases, except those
+// other files still list. Parts that failed to go stay recorded, so a
+// later Purge can retry them.
+func (d *Driver) Purge(fileId string) error {
+	assets, err := d.ass.Get(fileId)
+	if err != nil {
+		return err
+	}
+	refs, err := d.ass.Refs(fileId)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var kept []*Asset
+	for i := range assets {
+		asset := &assets[i]
+		if asset.isHole() || refs[asset.Id] > 1 {
+			continue
+		}
+		if err := d.client.DeleteAsset(asset); err != nil {
+			errs = append(errs, fmt.Errorf("part %d (%s): %w", asset.Number, asset.Name, err))
+			kept = append(kept, asset)
+		}
+	}
+
+	if len(kept) > 0 {
+		if err := d.ass.Write(fileId, kept); err != nil {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
 	return d.ass.Delete(fileId)
 }
+
+// Resize keeps the parts before size and rewrites the one it falls into.
+// Growing appends holes of at most partSize each, so a later WriteAt only
+// has to fill in the one it touches. The parts cut off or rewritten are
+// deleted once no file lists them.
+func (d *Driver) Resize(fileId, path string, size int64) error {
+	if size == 0 {
+		return d.Truncate(fileId)
+	}
+	assets, err := d.ass.Get(fileId)
+	if err != nil {
+		return err
+	}
+
+	var end int64
+	last := 0
+	resized := make([]*Asset, 0, len(assets))
+	for i := range assets {
+		asset := &assets[i]
+		if end >= size {
+			break
+		}
+		start := end
+		end += int64(asset.Size)
+		last = asset.Number
+
+		if end > size {
+			if asset, err = d.cutPart(asset, int(size-start)); err != nil {
+				return err
+			}
+			end = size
+		}
+		resized = append(resized, asset)
+	}
+	for end < size {
+		n := min(size-end, d.partSize)
+		last++
+		resized = append(resized, newHole(last, int(n)))
+		end += n
+	}
+
+	if err := d.ass.Write(fileId, resized); err != nil {
+		return err
+	}
+	d.release(fileId, dropped(assets, resized))
+	d.uploadManifest(fileId, path, internal.Digest{}, resized)
+	return nil
+}
+
+// dropped is what of before is not in after.
+func dropped(before []Asset, after []*Asset) []*Asset {
+	kept := map[int]bool{}
+	for _, asset := range after {
+		kept[asset.Id] = true
+	}
+	var gone []*Asset
+	for i := range before {
+		if asset := &before[i]; !asset.isHole() && !kept[asset.Id] {
+			gone = append(gone, asset)
+		}
+	}
+	return gone
+}
+
+// release deletes those of the parts a file dropped from its asset list
+// that no other file lists either. A part that fails to go is only left
+// unused, so that is logged rather than failing what dropped it.
+func (d *Driver) release(fileId string, parts []*Asset) {
+	if len(parts) == 0 {
+		return
+	}
+	unused, err := d.ass.Unused(parts)
+	if err != nil {
+		log.Warn().Err(err).Str("component", "github").Str("fileId", fileId).Msg("failed to count part references")
+		return
+	}
+	for _, asset := range unused {
+		if err := d.client.DeleteAsset(asset); err != nil {
+			log.Warn().
+				Err(err).
+				Str("component", "github").
+				Str("fileId", fileId).
+				Str("asset", asset.Name).
+				Msg("failed to delete dropped part")
+		}
+	}
+}
+
+// cutPart is asset with only its first n bytes, uploaded as a new asset.
+func (d *Driver) cutPart(asset *Asset, n int) (*Asset, error) {
+	if asset.isHole() {
+		return newHole(asset.Number, n), nil
+	}
+	data, err := d.readPart(asset)
+	if err != nil {
+		return nil, err
+	}
+	return d.uploadPart(asset.Number, data[:n])
+}
+
+func (d *Driver) uploadPart(number int, data []byte) (*Asset, error) {
+	sum := sha256.Sum256(data)
+	asset, err := d.client.UploadAsset(getRandomAssetName(), int64(len(data)), data)
+	if err != nil {
+		return nil, err
+	}
+	asset.Number = number
+	asset.Checksum = hex.EncodeToString(sum[:])
+	return asset, nil
+}
+
+func (d *Driver) readPart(asset *Asset) ([]byte, error) {
+	asset.client = d.client
+	return partedio.ReadPart(asset)
+}
+
+// uploadManifest records the file's current parts, it is skipped when the
+// path is unknown. The parts are safe already, a missing manifest only
+// weakens recovery.
+func (d *Driver) uploadManifest(fileId, path string, digest internal.Digest, assets []*Asset) {
+	if path == "" {
+		return
+	}
+	m := newManifest(fileId, path, digest, assets)
+	if err := d.client.uploadManifest(m); err != nil {
+		log.Warn().
+			Err(err).
+			Str("component", "github").
+			Str("fileId", fileId).
+			Msg("failed to upload manifest")
+	}
+}
+
+// Verify checks every part of the file still exists on GitHub with the
+// size recorded when it was uploaded.
+func (d *Driver) Verify(fileId string) error {
+	assets, err := d.ass.Get(fileId)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, asset := range assets {
+		if asset.isHole() {
+			continue
+		}
+		remote, err := d.client.StatAsset(&asset)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("part %d (%s): %w", asset.Number, asset.Name, err))
+			continue
+		}
+		if remote.Size != asset.Size {
+			errs = append(errs, fmt.Errorf(
+				"part %d (%s): size %d, expected %d: %w",
+				asset.Number, asset.Name, remote.Size, asset.Size, internal.ErrDataCorrupt,
+			))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,200 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/config"
+	"fafda/internal"
+)
+
+// Every written file gets a small sidecar asset describing its parts, so
+// the namespace and asset map can be rebuilt from the releases alone.
+const manifestPrefix = "fafda-manifest-"
+
+const manifestVersion = 1
+
+type Manifest struct {
+	Version int             `json:"version"`
+	FileId  string          `json:"fileId"`
+	Path    string          `json:"path"`
+	Size    int64           `json:"size"`
+	ModTime time.Time       `json:"modTime"`
+	Digest  internal.Digest `json:"digest"`
+	Parts   []ManifestPart  `json:"parts"`
+}
+
+type ManifestPart struct {
+	Number     int    `json:"number"`
+	Offset     int64  `json:"offset"`
+	Size       int    `json:"size"`
+	Checksum   string `json:"checksum"`
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	Username   string `json:"username"`
+	Repository string `json:"repository"`
+}
+
+func newManifest(fileId, filePath string, digest internal.Digest, assets []*Asset) *Manifest {
+	sorted := make([]*Asset, len(assets))
+	copy(sorted, assets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Number < sorted[j].Number
+	})
+
+	m := &Manifest{
+		Version: manifestVersion,
+		FileId:  fileId,
+		Path:    filePath,
+		ModTime: time.Now().UTC(),
+		Digest:  digest,
+		Parts:   make([]ManifestPart, len(sorted)),
+	}
+	for i, asset := range sorted {
+		m.Parts[i] = ManifestPart{
+			Number:     asset.Number,
+			Offset:     m.Size,
+			Size:       asset.Size,
+			Checksum:   asset.Checksum,
+			Id:         asset.Id,
+			Name:       asset.Name,
+			Username:   asset.Username,
+			Repository: asset.Repository,
+		}
+		m.Size += int64(asset.Size)
+	}
+	return m
+}
+
+func (m *Manifest) name() string {
+	return fmt.Sprintf("%s%s-%d.json", manifestPrefix, m.FileId, m.ModTime.UnixNano())
+}
+
+func (m *Manifest) assets() []*Asset {
+	assets := make([]*Asset, len(m.Parts))
+	for i, part := range m.Parts {
+		assets[i] = &Asset{
+			Id:         part.Id,
+			Name:       part.Name,
+			Username:   part.Username,
+			Repository: part.Repository,
+			Size:       part.Size,
+			Number:     part.Number,
+			Checksum:   part.Checksum,
+		}
+	}
+	return assets
+}
+
+func (c *Client) uploadManifest(m *Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = c.UploadAsset(m.name(), int64(len(data)), data)
+	return err
+}
+
+// manifests downloads every manifest attached to the given releases.
+func (c *Client) manifests(releases []config.GitHubRelease) ([]*Manifest, error) {
+	var manifests []*Manifest
+	for _, release := range releases {
+		assets, err := c.ListAssets(release)
+		if err != nil {
+			return nil, fmt.Errorf("release %d: %w", release.ReleaseId, err)
+		}
+
+		for _, asset := range assets {
+			if !strings.HasPrefix(asset.Name, manifestPrefix) || asset.Size == 0 {
+				continue
+			}
+			asset.Username = release.Username
+			asset.Repository = release.Repository
+
+			m, err := c.downloadManifest(asset)
+			if err != nil {
+				return nil, fmt.Errorf("manifest %s: %w", asset.Name, err)
+			}
+			manifests = append(manifests, m)
+		}
+	}
+	return manifests, nil
+}
+
+func (c *Client) downloadManifest(asset *Asset) (*Manifest, error) {
+	rc, err := c.DownloadAsset(asset, 0, asset.Size-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(rc, int64(asset.Size))).Decode(&m); err != nil {
+		return nil, err
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// Rebuild recreates file nodes and their asset records from the manifests
+// found in every configured release, meta should be empty. When several
+// manifests claim a file id or a path, the newest one wins. Renames and
+// deletes after upload are not recorded, so those files come back at
+// their original path.
+func (d *Driver) Rebuild(meta internal.MetaFileSystem) (int, error) {
+	manifests, err := d.client.manifests(d.releases)
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].ModTime.After(manifests[j].ModTime)
+	})
+
+	seenIds := map[string]bool{}
+	seenPaths := map[string]bool{}
+	restored := 0
+	for _, m := range manifests {
+		if seenIds[m.FileId] {
+			continue
+		}
+		seenIds[m.FileId] = true
+
+		if seenPaths[m.Path] {
+			log.Warn().
+				Str("component", "rebuild").
+				Str("path", m.Path).
+				Str("fileId", m.FileId).
+				Msg("path taken by a newer file, skipping")
+			continue
+		}
+		seenPaths[m.Path] = true
+
+		if err := meta.MkdirAll(path.Dir(m.Path)); err != nil {
+			return restored, fmt.Errorf("%s: %w", m.Path, err)
+		}
+
+		node := internal.NewNode(m.Path, false).
+			SetId(m.FileId).
+			SetSize(m.Size).
+			SetModTime(m.ModTime).
+			SetDigest(m.Digest)
+		if err := meta.Put(node); err != nil {
+			return restored, fmt.Errorf("%s: %w", m.Path, err)
+		}
+		if err := d.ass.Write(m.FileId, m.assets()); err != nil {
+			return restored, fmt.Errorf("%s: %w", m.Path, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
@@ -3,23 +3,46 @@ package github
 import (
 	"io"
 	"math/rand"
+	"sync"
 
+	"fafda/internal"
 	"fafda/internal/partedio"
 )
 
 type Writer struct {
 	fileId string
+	path   string
 	drvr   *Driver
 	writer io.WriteCloser
+	hasher *internal.Hasher
+	prior  []*Asset // parts already stored when appending
+	first  int      // number of the last prior part
 	assets []*Asset
+	mu     sync.Mutex
 }
 
 func NewWriter(fileId string, drvr *Driver) (*Writer, error) {
+	return newWriter(fileId, drvr, nil)
+}
+
+// newWriter numbers its parts after the prior ones and stores them all
+// together on Close.
+func newWriter(fileId string, drvr *Driver, prior []*Asset) (*Writer, error) {
+	hasher, err := internal.NewHasher(drvr.checksums...)
+	if err != nil {
+		return nil, err
+	}
+
 	writer := &Writer{
 		fileId: fileId,
 		drvr:   drvr,
+		hasher: hasher,
+		prior:  prior,
 		assets: make([]*Asset, 0),
 	}
+	for _, asset := range prior {
+		writer.first = max(writer.first, asset.Number)
+	}
 
 	partSize := randomPartSize(drvr.partSize, 20)
 	w, err := partedio.NewNWriter(partSize, drvr.concurrency, writer.processor)
@@ -35,26 +58,51 @@ func (w *Writer) Assets() []*Asset {
 	return w.assets
 }
 
+// Digest of everything written so far, complete once Close returns. When
+// appending it only covers the appended content.
+func (w *Writer) Digest() internal.Digest {
+	return w.hasher.Digest()
+}
+
+// processor is called concurrently by the parted writer.
 func (w *Writer) processor(partNum int, partSize int64, data []byte) error {
-	assetName := getRandomAssetName()
-	asset, err := w.drvr.client.UploadAsset(assetName, partSize, data)
+	asset, err := w.drvr.uploadPart(w.first+partNum, data[:partSize])
 	if err != nil {
 		return err
 	}
-	asset.Number = partNum
+
+	w.mu.Lock()
 	w.assets = append(w.assets, asset)
+	w.mu.Unlock()
 	return nil
 }
 
 func (w *Writer) Write(p []byte) (int, error) {
-	return w.writer.Write(p)
+	n, err := w.writer.Write(p)
+	_, _ = w.hasher.Write(p[:n])
+	return n, err
+}
+
+// SetPath enables the recovery manifest, it is uploaded on Close.
+func (w *Writer) SetPath(path string) {
+	w.path = path
 }
 
 func (w *Writer) Close() error {
 	if err := w.writer.Close(); err != nil {
 		return err
 	}
-	return w.drvr.ass.Write(w.fileId, w.assets)
+	assets := append(w.prior[:len(w.prior):len(w.prior)], w.assets...)
+	if err := w.drvr.ass.Write(w.fileId, assets); err != nil {
+		return err
+	}
+
+	digest := w.Digest()
+	if len(w.prior) > 0 {
+		digest = internal.Digest{}
+	}
+	w.drvr.uploadManifest(w.fileId, w.path, digest, assets)
+	return nil
 }
 
 func randomPartSize(baseNumber int64, percentageRange int) int64 {
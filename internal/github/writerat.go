@@ -0,0 +1,146 @@
+package github
+
+import (
+	"bytes"
+
+	"fafda/internal"
+	"fafda/internal/partedio"
+)
+
+// WriterAt collects writes and rewrites only the parts they touch. It is
+// copy-on-write: a changed part is uploaded as a new asset and the asset
+// map is switched over at once, so a failure midway leaves the file as it
+// was. Part boundaries never move, which keeps reader offsets valid. The
+// parts replaced, or uploaded for a switch that failed, are deleted once
+// no file lists them.
+type WriterAt struct {
+	fileId   string
+	path     string
+	drvr     *Driver
+	patches  partedio.Patches
+	buffered int64
+	flushed  bool
+	closed   bool
+}
+
+func (w *WriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if w.closed {
+		return 0, partedio.ErrClosed
+	}
+	w.patches = append(w.patches, partedio.Patch{Off: off, Data: bytes.Clone(p)})
+	w.buffered += int64(len(p))
+
+	// Bound memory use, each flush rewrites the touched parts once
+	if w.buffered >= w.drvr.partSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// SetPath enables the recovery manifest, it is uploaded on Close.
+func (w *WriterAt) SetPath(path string) {
+	w.path = path
+}
+
+func (w *WriterAt) flush() error {
+	if len(w.patches) == 0 {
+		return nil
+	}
+
+	assets, err := w.drvr.ass.Get(w.fileId)
+	if err != nil {
+		return err
+	}
+
+	if uploaded, err := w.rewrite(assets); err != nil {
+		w.drvr.release(w.fileId, uploaded)
+		return err
+	}
+	w.patches = nil
+	w.buffered = 0
+	w.flushed = true
+	return nil
+}
+
+// rewrite uploads the parts the patches touch and switches the asset list
+// of the file over to them. The new parts are returned on failure too.
+func (w *WriterAt) rewrite(assets []Asset) ([]*Asset, error) {
+	var uploaded []*Asset
+	var size int64
+	last := 0
+	updated := make([]*Asset, 0, len(assets))
+	for i := range assets {
+		asset := &assets[i]
+		start, end := size, size+int64(asset.Size)
+		size = end
+		last = max(last, asset.Number)
+
+		if !w.patches.Overlaps(start, end) {
+			updated = append(updated, asset)
+			continue
+		}
+
+		data, err := w.drvr.readPart(asset)
+		if err != nil {
+			return uploaded, err
+		}
+		w.patches.Apply(data, start)
+		replaced, err := w.drvr.uploadPart(asset.Number, data)
+		if err != nil {
+			return uploaded, err
+		}
+		uploaded = append(uploaded, replaced)
+		updated = append(updated, replaced)
+	}
+
+	// Whatever lands past the last part becomes new parts
+	if end := w.patches.End(); end > size {
+		tail := make([]byte, end-size)
+		w.patches.Apply(tail, size)
+		for len(tail) > 0 {
+			n := min(int64(len(tail)), w.drvr.partSize)
+			last++
+			asset, err := w.drvr.uploadPart(last, tail[:n])
+			if err != nil {
+				return uploaded, err
+			}
+			uploaded = append(uploaded, asset)
+			updated = append(updated, asset)
+			tail = tail[n:]
+		}
+	}
+
+	if err := w.drvr.ass.Write(w.fileId, updated); err != nil {
+		return uploaded, err
+	}
+	w.drvr.release(w.fileId, dropped(assets, updated))
+	return uploaded, nil
+}
+
+func (w *WriterAt) Close() error {
+	if w.closed {
+		return partedio.ErrClosed
+	}
+	w.closed = true
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if !w.flushed {
+		return nil
+	}
+
+	assets, err := w.drvr.ass.Get(w.fileId)
+	if err != nil {
+		return err
+	}
+	current := make([]*Asset, len(assets))
+	for i := range assets {
+		current[i] = &assets[i]
+	}
+	// A rewritten file has no whole-file digest until it is read again
+	w.drvr.uploadManifest(w.fileId, w.path, internal.Digest{}, current)
+	return nil
+}
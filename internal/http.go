@@ -5,6 +5,8 @@ const (
 	HeaderContentType   = "Content-Type"
 	HeaderContentLength = "Content-Length"
 	HeaderAuthorization = "Authorization"
+	HeaderDigest        = "Digest"
+	HeaderETag          = "ETag"
 )
 
 const (
@@ -0,0 +1,271 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/cache"
+	"fafda/internal/quota"
+	"fafda/internal/trash"
+	"fafda/internal/versions"
+)
+
+const adminPrefix = "/_admin/"
+
+// adminHandler serves the maintenance endpoints to requests carrying the
+// configured bearer token. db, snaps and quotas are nil when the bolt store
+// isn't in use, tr when the trash is disabled and vs when versioning is.
+func adminHandler(
+	token string,
+	db *bolt.Handle,
+	meta internal.MetaFileSystem,
+	tr *trash.Trash,
+	vs *versions.Store,
+	snaps *bolt.Snapshots,
+	quotas *quota.Quotas,
+) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		if snaps == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		infos, err := snaps.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if infos == nil {
+			infos = []bolt.SnapshotInfo{}
+		}
+		writeJSON(w, infos)
+	})
+	mux.HandleFunc("POST /_admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		if snaps == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		query := r.URL.Query()
+		root := query.Get("path")
+		if root == "" {
+			root = "/"
+		}
+		info, err := snaps.Create(query.Get("name"), root)
+		if err != nil {
+			snapshotError(w, err)
+			return
+		}
+		log.Info().
+			Str("component", "httpserver").
+			Str("name", info.Name).
+			Str("path", info.Path).
+			Msg("snapshot created")
+		writeJSON(w, info)
+	})
+	mux.HandleFunc("DELETE /_admin/snapshots/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if snaps == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		if err := snaps.Delete(r.PathValue("name")); err != nil {
+			snapshotError(w, err)
+			return
+		}
+		writeJSON(w, map[string]string{"name": r.PathValue("name")})
+	})
+	mux.HandleFunc("POST /_admin/snapshots/{name}/restore", func(w http.ResponseWriter, r *http.Request) {
+		if snaps == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("to") == "" {
+			http.Error(w, "to is required", http.StatusBadRequest)
+			return
+		}
+		var limiter internal.Quota
+		if quotas != nil {
+			limiter = quotas
+		}
+		restored, err := snaps.Restore(r.PathValue("name"), query.Get("path"), meta, query.Get("to"), limiter)
+		if err != nil {
+			snapshotError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{"to": query.Get("to"), "restored": restored})
+	})
+	mux.HandleFunc("POST /_admin/versions/restore", func(w http.ResponseWriter, r *http.Request) {
+		if vs == nil {
+			http.Error(w, "versioning is disabled", http.StatusNotImplemented)
+			return
+		}
+		query := r.URL.Query()
+		if err := vs.Restore(query.Get("path"), query.Get("version")); err != nil {
+			versionError(w, err)
+			return
+		}
+		writeJSON(w, map[string]string{"path": query.Get("path"), "version": query.Get("version")})
+	})
+	mux.HandleFunc("GET /_admin/trash", func(w http.ResponseWriter, r *http.Request) {
+		if tr == nil {
+			http.Error(w, "trash is disabled", http.StatusNotImplemented)
+			return
+		}
+		entries, err := tr.List(r.URL.Query().Get("user"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []trash.Entry{}
+		}
+		writeJSON(w, entries)
+	})
+	mux.HandleFunc("POST /_admin/trash/restore", func(w http.ResponseWriter, r *http.Request) {
+		if tr == nil {
+			http.Error(w, "trash is disabled", http.StatusNotImplemented)
+			return
+		}
+		original, err := tr.Restore(r.URL.Query().Get("path"))
+		switch {
+		case errors.Is(err, trash.ErrNotEntry), errors.Is(err, internal.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, internal.ErrAlreadyExist):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"path": original})
+	})
+	mux.HandleFunc("GET /_admin/usage", func(w http.ResponseWriter, r *http.Request) {
+		if quotas == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		p := r.URL.Query().Get("path")
+		if p == "" {
+			p = "/"
+		}
+		usage, err := quotas.Usage(p)
+		switch {
+		case errors.Is(err, internal.ErrNotFound):
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, usage)
+	})
+	mux.HandleFunc("GET /_admin/quotas", func(w http.ResponseWriter, r *http.Request) {
+		if quotas == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		statuses, err := quotas.Report()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, statuses)
+	})
+	mux.HandleFunc("GET /_admin/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		cached, ok := unwrap(meta).(*cache.MetaFs)
+		if !ok {
+			http.Error(w, "metadata cache is disabled", http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, cached.Stats())
+	})
+	mux.HandleFunc("GET /_admin/db/stats", func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, stats)
+	})
+	mux.HandleFunc("POST /_admin/db/compact", func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			http.Error(w, "only available with the bolt store", http.StatusNotImplemented)
+			return
+		}
+		log.Info().Str("component", "httpserver").Msg("compacting db, writes wait until it is done")
+		result, err := db.Compact()
+		if err != nil {
+			log.Error().Str("component", "httpserver").Err(err).Msg("compaction failed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info().
+			Str("component", "httpserver").
+			Int64("before", result.Before).
+			Int64("after", result.After).
+			Dur("took", result.Took).
+			Dur("swap", result.Swap).
+			Msg("db compacted, writes waited for all of it and reads for the swap")
+		writeJSON(w, result)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r carries token as its bearer token.
+func authorized(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func snapshotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, internal.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, internal.ErrAlreadyExist):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, bolt.ErrSnapshotName), errors.Is(err, internal.ErrIsNotDir):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, internal.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// unwrap peels the layers such as the snapshot view off meta.
+func unwrap(meta internal.MetaFileSystem) internal.MetaFileSystem {
+	type unwrapper interface {
+		Unwrap() internal.MetaFileSystem
+	}
+	for {
+		wrapper, ok := meta.(unwrapper)
+		if !ok {
+			return meta
+		}
+		meta = wrapper.Unwrap()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set(internal.HeaderContentType, internal.MediaTypeJOSN)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/cache"
+	"fafda/internal/memory"
+	"fafda/internal/quota"
+)
+
+func TestAdmin(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	handle := bolt.NewHandle(db, nil)
+	meta, err := bolt.NewMetaFs(handle)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer meta.Close()
+	snaps, err := bolt.NewSnapshots(handle, memory.NewDriver())
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	view := bolt.WithSnapshots(cache.New(meta, 10, 10), snaps)
+	quotas := quota.New(meta.(internal.UsageCounter), []config.Quota{{Path: "/restored", Files: 100}})
+
+	tests := []struct {
+		name   string
+		db     *bolt.Handle
+		method string
+		url    string
+		token  string
+		want   int
+	}{
+		{name: "stats", db: handle, method: http.MethodGet, url: "/_admin/db/stats", token: "secret", want: http.StatusOK},
+		{name: "compact", db: handle, method: http.MethodPost, url: "/_admin/db/compact", token: "secret", want: http.StatusOK},
+		{name: "compact needs post", db: handle, method: http.MethodGet, url: "/_admin/db/compact", token: "secret", want: http.StatusMethodNotAllowed},
+		{name: "wrong token", db: handle, method: http.MethodGet, url: "/_admin/db/stats", token: "guess", want: http.StatusUnauthorized},
+		{name: "no token", db: handle, method: http.MethodGet, url: "/_admin/db/stats", want: http.StatusUnauthorized},
+		{name: "no bolt", method: http.MethodGet, url: "/_admin/db/stats", token: "secret", want: http.StatusNotImplemented},
+		{name: "no trash", db: handle, method: http.MethodGet, url: "/_admin/trash", token: "secret", want: http.StatusNotImplemented},
+		{name: "cache stats", db: handle, method: http.MethodGet, url: "/_admin/cache/stats", token: "secret", want: http.StatusOK},
+		{name: "create snapshot", db: handle, method: http.MethodPost, url: "/_admin/snapshots?name=daily", token: "secret", want: http.StatusOK},
+		{name: "snapshot exists", db: handle, method: http.MethodPost, url: "/_admin/snapshots?name=daily", token: "secret", want: http.StatusConflict},
+		{name: "snapshots", db: handle, method: http.MethodGet, url: "/_admin/snapshots", token: "secret", want: http.StatusOK},
+		{name: "restore snapshot", db: handle, method: http.MethodPost, url: "/_admin/snapshots/daily/restore?to=/restored", token: "secret", want: http.StatusOK},
+		{name: "restore missing snapshot", db: handle, method: http.MethodPost, url: "/_admin/snapshots/weekly/restore?to=/other", token: "secret", want: http.StatusNotFound},
+		{name: "usage", db: handle, method: http.MethodGet, url: "/_admin/usage?path=/restored", token: "secret", want: http.StatusOK},
+		{name: "usage of missing", db: handle, method: http.MethodGet, url: "/_admin/usage?path=/missing", token: "secret", want: http.StatusNotFound},
+		{name: "quotas", db: handle, method: http.MethodGet, url: "/_admin/quotas", token: "secret", want: http.StatusOK},
+		{name: "delete snapshot", db: handle, method: http.MethodDelete, url: "/_admin/snapshots/daily", token: "secret", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			adminHandler("secret", tt.db, view, nil, nil, snaps, quotas).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.url, rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code == http.StatusOK && !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("%s %s body is not JSON: %s", tt.method, tt.url, rec.Body)
+			}
+		})
+	}
+
+	if err := meta.Touch("/after-compact"); err != nil {
+		t.Errorf("Touch() after compact error = %v", err)
+	}
+}
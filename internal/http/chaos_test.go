@@ -0,0 +1,103 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal/chaos"
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+func TestDownloadWithFaults(t *testing.T) {
+	data := bytes.Repeat([]byte("fafda"), 20000)
+
+	tests := []struct {
+		name       string
+		rule       chaos.Rule
+		failUpload bool
+		fail       bool
+	}{
+		{
+			name: "slow writes",
+			rule: chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultLatency, Every: 1, Delay: time.Millisecond},
+		},
+		{
+			name: "short reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultShortRead, Every: 1},
+		},
+		{
+			name: "flaky slow reads",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultLatency, Probability: 0.5, Delay: time.Millisecond},
+		},
+		{
+			name:       "write fails",
+			rule:       chaos.Rule{Op: chaos.OpWrite, Fault: chaos.FaultError, Every: 1},
+			failUpload: true,
+		},
+		{
+			name: "read fails",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultError, Every: 1, After: 1},
+			fail: true,
+		},
+		{
+			name: "reader fails",
+			rule: chaos.Rule{Op: chaos.OpGetReader, Fault: chaos.FaultError, Every: 1},
+			fail: true,
+		},
+		{
+			name: "stream ends early",
+			rule: chaos.Rule{Op: chaos.OpRead, Fault: chaos.FaultTruncate, Every: 1, After: 1},
+			fail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := chaos.NewDriver(memory.NewDriver(), chaos.NewInjector(1, tt.rule))
+			fs := filesystem.New(driver, memory.NewMetaFs(), nil, nil)
+			err := afero.WriteFile(fs, "/file.bin", data, 0644)
+			if tt.failUpload {
+				if err == nil {
+					t.Fatal("WriteFile() succeeded, want a failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			server := httptest.NewServer(withDigest(fs, http.FileServer(afero.NewHttpFs(fs).Dir("/"))))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/file.bin")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+
+			if tt.fail {
+				if resp.StatusCode == http.StatusOK && err == nil && bytes.Equal(got, data) {
+					t.Fatal("download succeeded, want a failure")
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("download returned %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
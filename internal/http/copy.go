@@ -0,0 +1,108 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+)
+
+const methodCopy = "COPY"
+
+// copier copies files sharing their content, CopyOver replaces a file
+// only once the copy made it.
+type copier interface {
+	Copy(src, dst string) error
+	CopyOver(src, dst string) error
+}
+
+// withCopy answers WebDAV COPY (RFC 4918) of a single file, the copy
+// shares the stored parts of the original. Only requests carrying the
+// admin token may copy, there is no copying without one.
+func withCopy(fs afero.Fs, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != methodCopy {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token == "" {
+			http.Error(w, "copying needs an admin token", http.StatusNotImplemented)
+			return
+		}
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		copier, ok := fs.(copier)
+		if !ok {
+			http.Error(w, "copying is not supported", http.StatusNotImplemented)
+			return
+		}
+
+		destination, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil || destination.Path == "" {
+			http.Error(w, "a Destination header is required", http.StatusBadRequest)
+			return
+		}
+		src := path.Clean("/" + r.URL.Path)
+		dst := path.Clean("/" + destination.Path)
+		if src == dst {
+			http.Error(w, "source and destination are the same", http.StatusForbidden)
+			return
+		}
+
+		info, err := fs.Stat(src)
+		if err != nil {
+			copyError(w, err)
+			return
+		}
+		if info.IsDir() {
+			http.Error(w, "copying directories is not supported", http.StatusForbidden)
+			return
+		}
+
+		status := http.StatusCreated
+		copyFile := copier.Copy
+		if existing, err := fs.Stat(dst); err == nil {
+			if r.Header.Get("Overwrite") == "F" {
+				http.Error(w, "destination exists", http.StatusPreconditionFailed)
+				return
+			}
+			if existing.IsDir() {
+				http.Error(w, "destination is a directory", http.StatusConflict)
+				return
+			}
+			copyFile = copier.CopyOver
+			status = http.StatusNoContent
+		}
+
+		if err := copyFile(src, dst); err != nil {
+			if errors.Is(err, internal.ErrNotFound) || errors.Is(err, internal.ErrIsNotDir) {
+				http.Error(w, "destination directory does not exist", http.StatusConflict)
+				return
+			}
+			copyError(w, err)
+			return
+		}
+		w.WriteHeader(status)
+	})
+}
+
+func copyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, internal.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, internal.ErrAlreadyExist):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, internal.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+func TestCopy(t *testing.T) {
+	meta := memory.NewMetaFs()
+	fs := filesystem.New(memory.NewDriver(), meta, nil, nil)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for name, content := range map[string]string{"/file": "content", "/taken": "old"} {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		url         string
+		destination string
+		overwrite   string
+		want        int
+	}{
+		{name: "copy", token: "secret", url: "/file", destination: "http://example.com/dir/copy", want: http.StatusCreated},
+		{name: "path destination", token: "secret", url: "/file", destination: "/dir/other", want: http.StatusCreated},
+		{name: "overwrite", token: "secret", url: "/file", destination: "/taken", want: http.StatusNoContent},
+		{name: "no overwrite", token: "secret", url: "/file", destination: "/dir/copy", overwrite: "F", want: http.StatusPreconditionFailed},
+		{name: "onto a directory", token: "secret", url: "/file", destination: "/dir", want: http.StatusConflict},
+		{name: "missing parent", token: "secret", url: "/file", destination: "/nowhere/copy", want: http.StatusConflict},
+		{name: "missing source", token: "secret", url: "/missing", destination: "/copy", want: http.StatusNotFound},
+		{name: "directory", token: "secret", url: "/dir", destination: "/dir2", want: http.StatusForbidden},
+		{name: "onto itself", token: "secret", url: "/file", destination: "/file", want: http.StatusForbidden},
+		{name: "no destination", token: "secret", url: "/file", want: http.StatusBadRequest},
+		{name: "wrong token", token: "guess", url: "/file", destination: "/copy", want: http.StatusUnauthorized},
+	}
+
+	handler := withCopy(fs, "secret", fallback)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(methodCopy, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.destination != "" {
+				req.Header.Set("Destination", tt.destination)
+			}
+			if tt.overwrite != "" {
+				req.Header.Set("Overwrite", tt.overwrite)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("COPY %s to %s = %d, want %d: %s", tt.url, tt.destination, rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	for _, p := range []string{"/dir/copy", "/dir/other", "/taken"} {
+		data, err := afero.ReadFile(fs, p)
+		if err != nil || string(data) != "content" {
+			t.Errorf("ReadFile(%s) = %q, %v, want the original's content", p, data, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	withCopy(fs, "", fallback).ServeHTTP(rec, httptest.NewRequest(methodCopy, "/file", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("COPY without an admin token configured = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/file", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("GET not passed through, status %d", rec.Code)
+	}
+}
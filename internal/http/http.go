@@ -1,21 +1,86 @@
 package http
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
+	"path"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/afero"
 
 	"fafda/config"
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/perm"
+	"fafda/internal/quota"
+	"fafda/internal/trash"
+	"fafda/internal/versions"
 )
 
-func Serv(cfg config.HTTPServer, fs afero.Fs) error {
-	httpFs := afero.NewHttpFs(fs)
+func Serv(
+	cfg config.HTTPServer,
+	users []config.FTPUser,
+	fs afero.Fs,
+	meta internal.MetaFileSystem,
+	db *bolt.Handle,
+	tr *trash.Trash,
+	vs *versions.Store,
+	snaps *bolt.Snapshots,
+	quotas *quota.Quotas,
+) error {
+	// Files are served as anybody may read them, the admin token only
+	// gets past the mode bits for COPY, listings, versions and attributes
+	readFs := fs
+	if cfg.EnforcePermissions {
+		readFs = perm.New(fs, perm.Anonymous)
+	}
+	httpFs := afero.NewHttpFs(readFs)
 	fileServer := http.FileServer(httpFs.Dir("/"))
-	http.Handle("/", fileServer)
+	handler := withXattrs(meta, cfg.AdminToken, withListing(meta, withVersions(vs, withDigest(fs, fileServer))))
+	if cfg.EnforcePermissions {
+		handler = withPermissions(readFs, cfg.AdminToken, handler)
+	}
+	http.Handle("/", withCopy(fs, cfg.AdminToken, handler))
+	if tr != nil {
+		http.Handle(trashPath, trashHandler(users, tr))
+	}
+	if cfg.AdminToken != "" {
+		http.Handle(adminPrefix, adminHandler(cfg.AdminToken, db, meta, tr, vs, snaps, quotas))
+	}
 	log.Info().
 		Str("component", "httpserver").
 		Str("address", cfg.Addr).
 		Msg("starting server")
 	return http.ListenAndServe(cfg.Addr, nil)
 }
+
+// withDigest sets ETag and Digest (RFC 3230) from the SHA-256 recorded at
+// upload time, which also makes conditional requests work.
+func withDigest(fs afero.Fs, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := fs.Stat(path.Clean("/" + r.URL.Path))
+		if err == nil {
+			if node, ok := info.(*internal.Node); ok && node.Digest().SHA256 != "" {
+				setDigestHeaders(w.Header(), node.Digest())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setDigestHeaders(header http.Header, digest internal.Digest) {
+	header.Set(internal.HeaderETag, `"`+digest.SHA256+`"`)
+
+	sum, err := hex.DecodeString(digest.SHA256)
+	if err != nil {
+		return
+	}
+	value := "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+	if digest.MD5 != "" {
+		if sum, err := hex.DecodeString(digest.MD5); err == nil {
+			value += ",md5=" + base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+	header.Set(internal.HeaderDigest, value)
+}
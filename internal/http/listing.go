@@ -0,0 +1,78 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"fafda/internal"
+)
+
+const defaultListLimit = 1000
+
+type listEntry struct {
+	Name    string            `json:"name"`
+	IsDir   bool              `json:"isDir"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"modTime"`
+	Target  string            `json:"target,omitempty"` // of a symbolic link
+	Xattrs  map[string]string `json:"xattrs,omitempty"`
+}
+
+type listing struct {
+	Entries []listEntry `json:"entries"`
+	Next    string      `json:"next,omitempty"`
+}
+
+// withListing serves directories as paged JSON for ?format=json, pass the
+// returned next value as ?cursor= to continue.
+func withListing(meta internal.MetaFileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("format") != "json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := defaultListLimit
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, defaultListLimit)
+		}
+
+		nodes, cursor, err := meta.LsCursor(path.Clean("/"+r.URL.Path), limit, query.Get("cursor"))
+		switch {
+		case errors.Is(err, internal.ErrNotFound):
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case errors.Is(err, internal.ErrIsNotDir), errors.Is(err, internal.ErrInvalidCursor):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		body := listing{Entries: make([]listEntry, len(nodes)), Next: cursor}
+		for i, node := range nodes {
+			body.Entries[i] = listEntry{
+				Name:    node.Name(),
+				IsDir:   node.IsDir(),
+				Size:    node.Size(),
+				ModTime: node.ModTime(),
+				Target:  node.Target(),
+				Xattrs:  node.Xattrs(),
+			}
+		}
+
+		w.Header().Set(internal.HeaderContentType, internal.MediaTypeJOSN)
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fafda/internal/memory"
+)
+
+func TestListing(t *testing.T) {
+	meta := memory.NewMetaFs()
+	if err := meta.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := meta.Touch("/dir/" + name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := meta.SetXattr("/dir/a", "user.tag", "red"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := withListing(meta, fallback)
+
+	get := func(url string) (*httptest.ResponseRecorder, listing) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		var body listing
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode %s: %v", url, err)
+			}
+		}
+		return rec, body
+	}
+
+	if rec, _ := get("/dir/"); rec.Code != http.StatusTeapot {
+		t.Errorf("plain request not passed through, status %d", rec.Code)
+	}
+
+	rec, first := get("/dir/?format=json&limit=2")
+	if rec.Code != http.StatusOK || len(first.Entries) != 2 || first.Next == "" {
+		t.Fatalf("first page = %d %+v, want 2 entries and a cursor", rec.Code, first)
+	}
+	if got := first.Entries[0].Xattrs["user.tag"]; got != "red" || first.Entries[1].Xattrs != nil {
+		t.Errorf("xattrs = %v and %v, want user.tag=red on a only", first.Entries[0].Xattrs, first.Entries[1].Xattrs)
+	}
+	rec, second := get("/dir/?format=json&limit=2&cursor=" + first.Next)
+	if rec.Code != http.StatusOK || len(second.Entries) != 1 || second.Entries[0].Name != "c" || second.Next != "" {
+		t.Fatalf("second page = %d %+v, want only c", rec.Code, second)
+	}
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{url: "/missing?format=json", want: http.StatusNotFound},
+		{url: "/dir/a?format=json", want: http.StatusBadRequest},
+		{url: "/dir?format=json&limit=0", want: http.StatusBadRequest},
+		{url: "/dir?format=json&cursor=%21%21", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec, _ := get(tt.url); rec.Code != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.url, rec.Code, tt.want)
+		}
+	}
+}
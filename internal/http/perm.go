@@ -0,0 +1,31 @@
+package http
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// withPermissions refuses requests for what afs does not let its user
+// read, before listings, versions and attributes, which go around the mode
+// bits, get to answer them. The admin token acts as root.
+func withPermissions(afs afero.Fs, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && authorized(r, token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		file, err := afs.Open(path.Clean("/" + r.URL.Path))
+		if errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err == nil {
+			_ = file.Close()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
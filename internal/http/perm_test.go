@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+	"fafda/internal/perm"
+)
+
+func TestPermissions(t *testing.T) {
+	fs := filesystem.New(memory.NewDriver(), memory.NewMetaFs(), nil, nil)
+	if err := fs.Mkdir("/private", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"/public", "/secret", "/private/file"} {
+		if err := afero.WriteFile(fs, name, []byte("content"), 0644); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	for name, mode := range map[string]os.FileMode{"/secret": 0600, "/private": 0700} {
+		if err := fs.Chmod(name, mode); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := withPermissions(perm.New(fs, perm.Anonymous), "secret", fallback)
+
+	tests := []struct {
+		url   string
+		token string
+		want  int
+	}{
+		{url: "/public", want: http.StatusTeapot},
+		{url: "/missing", want: http.StatusTeapot},
+		{url: "/secret", want: http.StatusForbidden},
+		{url: "/secret?versions", want: http.StatusForbidden},
+		{url: "/private/?format=json", want: http.StatusForbidden},
+		{url: "/private/file", want: http.StatusForbidden},
+		{url: "/private/file", token: "wrong", want: http.StatusForbidden},
+		{url: "/private/file", token: "secret", want: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.url, rec.Code, tt.want)
+		}
+	}
+}
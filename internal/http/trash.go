@@ -0,0 +1,52 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"fafda/config"
+	"fafda/internal/trash"
+)
+
+const trashPath = "/_trash"
+
+// trashHandler lists the trash of the user logging in with basic auth,
+// the FTP users and their passwords. Nobody gets to see the trash of
+// someone else here, that is left to the admin API.
+func trashHandler(users []config.FTPUser, tr *trash.Trash) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, ok := basicUser(r, users)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fafda"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		entries, err := tr.List(user.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []trash.Entry{}
+		}
+		writeJSON(w, entries)
+	})
+}
+
+// basicUser returns the user whose name and password r carries.
+func basicUser(r *http.Request, users []config.FTPUser) (config.FTPUser, bool) {
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return config.FTPUser{}, false
+	}
+	for _, u := range users {
+		if u.Username == name && subtle.ConstantTimeCompare([]byte(u.Password), []byte(pass)) == 1 {
+			return u, true
+		}
+	}
+	return config.FTPUser{}, false
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fafda/config"
+	"fafda/internal/memory"
+	"fafda/internal/trash"
+)
+
+func TestTrash(t *testing.T) {
+	tr := trash.New(memory.NewMetaFs(), memory.NewDriver(), time.Hour)
+	for _, user := range []string{"alice", "bob"} {
+		meta := tr.For(user, 0, 0)
+		if _, err := meta.Create("/"+user, false); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if err := meta.Remove("/" + user); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	users := []config.FTPUser{{Username: "alice", Password: "a"}, {Username: "bob", Password: "b"}}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		want     int
+	}{
+		{name: "alice", user: "alice", password: "a", want: http.StatusOK},
+		{name: "bob", user: "bob", password: "b", want: http.StatusOK},
+		{name: "wrong password", user: "alice", password: "b", want: http.StatusUnauthorized},
+		{name: "no credentials", want: http.StatusUnauthorized},
+	}
+
+	handler := trashHandler(users, tr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, trashPath, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var entries []trash.Entry
+			if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if len(entries) != 1 || entries[0].User != tt.user || entries[0].Original != "/"+tt.user {
+				t.Errorf("entries = %+v, want only the one of %s", entries, tt.user)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"fafda/internal"
+	"fafda/internal/versions"
+)
+
+// withVersions lists the earlier versions of a file for ?versions and
+// serves one of them for ?version=<id>. store is nil when versioning is
+// disabled.
+func withVersions(store *versions.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		id := query.Get("version")
+		if !query.Has("versions") && id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if store == nil {
+			http.Error(w, "versioning is disabled", http.StatusNotImplemented)
+			return
+		}
+
+		p := path.Clean("/" + r.URL.Path)
+		if id == "" {
+			list, err := store.List(p)
+			if err != nil {
+				versionError(w, err)
+				return
+			}
+			if list == nil {
+				list = []versions.Version{}
+			}
+			writeJSON(w, list)
+			return
+		}
+
+		file, err := store.Open(p, id)
+		if err != nil {
+			versionError(w, err)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			versionError(w, err)
+			return
+		}
+		http.ServeContent(w, r, path.Base(p), info.ModTime(), file)
+	})
+}
+
+func versionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, internal.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, internal.ErrIsDir):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
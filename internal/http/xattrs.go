@@ -0,0 +1,95 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+
+	"fafda/internal"
+)
+
+// withXattrs serves the extended attributes of a path, all of them as a
+// JSON object for ?xattrs and the value of one for GET ?xattr=<name>.
+// PUT ?xattr=<name> sets it to the request body and DELETE removes it,
+// both need the admin token. Files are served with their content-type
+// attribute as Content-Type. Paths are taken as they are, symbolic links
+// have attributes of their own.
+func withXattrs(meta internal.MetaFileSystem, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		query := r.URL.Query()
+		name := query.Get("xattr")
+		if !query.Has("xattrs") && name == "" {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				if value, err := meta.GetXattr(p, internal.XattrContentType); err == nil {
+					w.Header().Set(internal.HeaderContentType, value)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			node, err := meta.Stat(p)
+			if err != nil {
+				xattrError(w, err)
+				return
+			}
+			xattrs := node.Xattrs()
+			if xattrs == nil {
+				xattrs = map[string]string{}
+			}
+			writeJSON(w, xattrs)
+		case r.Method == http.MethodGet:
+			value, err := meta.GetXattr(p, name)
+			if err != nil {
+				xattrError(w, err)
+				return
+			}
+			w.Header().Set(internal.HeaderContentType, "text/plain; charset=utf-8")
+			_, _ = io.WriteString(w, value)
+		case r.Method != http.MethodPut && r.Method != http.MethodDelete:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		case token == "":
+			http.Error(w, "changing attributes needs an admin token", http.StatusNotImplemented)
+		case !authorized(r, token):
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case name == "":
+			http.Error(w, "an xattr name is required", http.StatusBadRequest)
+		case r.Method == http.MethodDelete:
+			if err := meta.RemoveXattr(p, name); err != nil {
+				xattrError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			value, err := io.ReadAll(io.LimitReader(r.Body, internal.MaxXattrValue+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := meta.SetXattr(p, name, string(value)); err != nil {
+				xattrError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+func xattrError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, internal.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, internal.ErrNoXattr):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, internal.ErrInvalidXattr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, internal.ErrNotSupported):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
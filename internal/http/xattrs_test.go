@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fafda/internal/memory"
+)
+
+func TestXattrs(t *testing.T) {
+	meta := memory.NewMetaFs()
+	if err := meta.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := withXattrs(meta, "secret", fallback)
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		method, url, token, body string
+		want                     int
+	}{
+		{method: http.MethodPut, url: "/file?xattr=origin", body: "upload", want: http.StatusUnauthorized},
+		{method: http.MethodPut, url: "/file?xattr=origin", token: "secret", body: "upload", want: http.StatusNoContent},
+		{method: http.MethodPut, url: "/file?xattr=content-type", token: "secret", body: "text/csv", want: http.StatusNoContent},
+		{method: http.MethodPut, url: "/missing?xattr=origin", token: "secret", want: http.StatusNotFound},
+		{method: http.MethodPut, url: "/file?xattrs", token: "secret", want: http.StatusBadRequest},
+		{method: http.MethodPost, url: "/file?xattr=origin", token: "secret", want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, url: "/file?xattr=missing", want: http.StatusNotFound},
+		{method: http.MethodDelete, url: "/file?xattr=missing", token: "secret", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.method, tt.url, tt.token, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.url, rec.Code, tt.want)
+		}
+	}
+
+	rec := do(http.MethodGet, "/file?xattrs", "", "")
+	var xattrs map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&xattrs); err != nil || len(xattrs) != 2 || xattrs["origin"] != "upload" {
+		t.Errorf("GET ?xattrs = %v, %v, want origin and content-type", xattrs, err)
+	}
+	if rec := do(http.MethodGet, "/file?xattr=origin", "", ""); rec.Body.String() != "upload" {
+		t.Errorf("GET ?xattr=origin = %q, want %q", rec.Body.String(), "upload")
+	}
+	if rec := do(http.MethodGet, "/file", "", ""); rec.Code != http.StatusTeapot || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("GET /file = %d with Content-Type %q, want it passed on with text/csv", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if rec := do(http.MethodDelete, "/file?xattr=origin", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE ?xattr=origin status = %d", rec.Code)
+	}
+	if _, err := meta.GetXattr("/file", "origin"); err == nil {
+		t.Errorf("GetXattr() after DELETE found the attribute")
+	}
+}
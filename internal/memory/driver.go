@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"fafda/internal"
+	"fafda/internal/partedio"
+)
+
+var ErrClosed = errors.New("is closed")
+
+// Driver stores file contents in memory. Like the GitHub driver, content
+// written through a writer only becomes visible once the writer is closed.
+type Driver struct {
+	files map[string][]byte
+	mu    sync.RWMutex
+}
+
+func NewDriver() internal.StorageDriver {
+	return &Driver{files: map[string][]byte{}}
+}
+
+func (d *Driver) GetReader(fileId string, pos int64) (io.ReadCloser, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	data := d.files[fileId]
+	if pos < 0 || pos > int64(len(data)) {
+		return nil, io.EOF
+	}
+	return io.NopCloser(bytes.NewReader(data[pos:])), nil
+}
+
+func (d *Driver) GetWriter(fileId string) (io.WriteCloser, error) {
+	hasher, err := internal.NewHasher(internal.DigestMD5, internal.DigestCRC32)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{fileId: fileId, drvr: d, hasher: hasher}, nil
+}
+
+func (d *Driver) GetAppender(fileId string) (io.WriteCloser, error) {
+	hasher, err := internal.NewHasher(internal.DigestMD5, internal.DigestCRC32)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{fileId: fileId, drvr: d, hasher: hasher, appending: true}, nil
+}
+
+func (d *Driver) GetWriterAt(fileId string) (internal.WriteAtCloser, error) {
+	return &WriterAt{fileId: fileId, drvr: d}, nil
+}
+
+func (d *Driver) GetSize(fileId string) (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return int64(len(d.files[fileId])), nil
+}
+
+func (d *Driver) Truncate(fileId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.files, fileId)
+	return nil
+}
+
+func (d *Driver) Resize(fileId, _ string, size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing := d.files[fileId]
+	data := make([]byte, size)
+	copy(data, existing)
+	d.files[fileId] = data
+	return nil
+}
+
+func (d *Driver) Move(fromId, toId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if data, ok := d.files[fromId]; ok {
+		d.files[toId] = data
+		delete(d.files, fromId)
+	}
+	return nil
+}
+
+// Copy shares the content, writers never change it in place.
+func (d *Driver) Copy(fromId, toId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if data, ok := d.files[fromId]; ok {
+		d.files[toId] = data
+	}
+	return nil
+}
+
+type Writer struct {
+	fileId    string
+	buf       bytes.Buffer
+	closed    bool
+	appending bool
+	drvr      *Driver
+	hasher    *internal.Hasher
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	n, err := w.buf.Write(p)
+	_, _ = w.hasher.Write(p[:n])
+	return n, err
+}
+
+func (w *Writer) Digest() internal.Digest {
+	return w.hasher.Digest()
+}
+
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+
+	w.drvr.mu.Lock()
+	defer w.drvr.mu.Unlock()
+	if w.appending {
+		existing := w.drvr.files[w.fileId]
+		w.drvr.files[w.fileId] = append(existing[:len(existing):len(existing)], w.buf.Bytes()...)
+		return nil
+	}
+	w.drvr.files[w.fileId] = w.buf.Bytes()
+	return nil
+}
+
+// WriterAt patches a copy of the content and swaps it in on Close.
+type WriterAt struct {
+	fileId  string
+	patches partedio.Patches
+	closed  bool
+	drvr    *Driver
+}
+
+func (w *WriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	w.patches = append(w.patches, partedio.Patch{Off: off, Data: bytes.Clone(p)})
+	return len(p), nil
+}
+
+func (w *WriterAt) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+
+	w.drvr.mu.Lock()
+	defer w.drvr.mu.Unlock()
+
+	existing := w.drvr.files[w.fileId]
+	data := make([]byte, max(int64(len(existing)), w.patches.End()))
+	copy(data, existing)
+	w.patches.Apply(data, 0)
+	w.drvr.files[w.fileId] = data
+	return nil
+}
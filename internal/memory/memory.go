@@ -0,0 +1,502 @@
+package memory
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fafda/internal"
+)
+
+// MetaFs keeps the namespace in a map keyed by path. It follows the same
+// contract as bolt.MetaFs and is meant for tests and scratch mounts.
+type MetaFs struct {
+	nodes map[string]internal.Node
+	mu    sync.RWMutex
+}
+
+func NewMetaFs() internal.MetaFileSystem {
+	return &MetaFs{
+		nodes: map[string]internal.Node{
+			"/": *internal.NewNode("/", true),
+		},
+	}
+}
+
+func (mf *MetaFs) Name() string {
+	return "memory"
+}
+
+func (mf *MetaFs) get(path string) (*internal.Node, error) {
+	node, ok := mf.nodes[path]
+	if !ok {
+		return nil, internal.ErrNotFound
+	}
+	return &node, nil
+}
+
+func (mf *MetaFs) checkParentDir(pathStr string) error {
+	parent := path.Dir(pathStr)
+	if parent == "/" {
+		return nil
+	}
+
+	node, ok := mf.nodes[parent]
+	if !ok || !node.IsDir() {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// put stores node at its path, a file with hard links has the other
+// entries follow along.
+func (mf *MetaFs) put(node *internal.Node) {
+	mf.nodes[node.Path()] = *node
+	if node.IsDir() || node.Links() < 2 {
+		return
+	}
+	for p, other := range mf.nodes {
+		if p != node.Path() && !other.IsDir() && other.Id() == node.Id() {
+			shared := *node
+			mf.nodes[p] = *shared.SetPath(p)
+		}
+	}
+}
+
+// unlink drops the entry at p, the other entries of a file with hard
+// links lose one link.
+func (mf *MetaFs) unlink(p string) {
+	node := mf.nodes[p]
+	delete(mf.nodes, p)
+	if node.IsDir() || node.Links() < 2 {
+		return
+	}
+	for q, other := range mf.nodes {
+		if !other.IsDir() && other.Id() == node.Id() {
+			mf.nodes[q] = *other.SetLinks(other.Links() - 1)
+		}
+	}
+}
+
+// descendants returns the sorted paths strictly below dir.
+func (mf *MetaFs) descendants(dir string) []string {
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+
+	var paths []string
+	for p := range mf.nodes {
+		if p != "/" && strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (mf *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	file := internal.NewNode(pathStr, isDir)
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	if _, ok := mf.nodes[pathStr]; ok {
+		return nil, internal.ErrAlreadyExist
+	}
+	if err := mf.checkParentDir(pathStr); err != nil {
+		return nil, err
+	}
+
+	mf.nodes[pathStr] = *file
+	return file, nil
+}
+
+func (mf *MetaFs) Stat(path string) (*internal.Node, error) {
+	if path == "" {
+		path = "/"
+	}
+
+	mf.mu.RLock()
+	defer mf.mu.RUnlock()
+	return mf.get(path)
+}
+
+func (mf *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	info, err := mf.Stat(pathStr)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, internal.ErrIsNotDir
+	}
+
+	cleanPath := path.Clean(pathStr)
+
+	mf.mu.RLock()
+	defer mf.mu.RUnlock()
+
+	var files []internal.Node
+	skipped := 0
+	for _, p := range mf.descendants(cleanPath) {
+		if path.Dir(p) != cleanPath {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if limit > 0 && len(files) >= limit {
+			break
+		}
+		files = append(files, mf.nodes[p])
+	}
+	return files, nil
+}
+
+func (mf *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	after, err := internal.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info, err := mf.Stat(pathStr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !info.IsDir() {
+		return nil, "", internal.ErrIsNotDir
+	}
+
+	cleanPath := path.Clean(pathStr)
+
+	mf.mu.RLock()
+	defer mf.mu.RUnlock()
+
+	var files []internal.Node
+	for _, p := range mf.descendants(cleanPath) {
+		if path.Dir(p) != cleanPath || path.Base(p) <= after {
+			continue
+		}
+		if limit > 0 && len(files) >= limit {
+			return files, internal.EncodeCursor(files[len(files)-1].Name()), nil
+		}
+		files = append(files, mf.nodes[p])
+	}
+	return files, "", nil
+}
+
+func (mf *MetaFs) Chtimes(path string, mtime time.Time) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	mf.put(node.SetModTime(mtime))
+	return nil
+}
+
+func (mf *MetaFs) Touch(path string) error {
+	_, err := mf.Stat(path)
+	if errors.Is(err, internal.ErrNotFound) {
+		_, err = mf.Create(path, false)
+	}
+	return err
+}
+
+func (mf *MetaFs) Mkdir(path string) error {
+	_, err := mf.Create(path, true)
+	return err
+}
+
+func (mf *MetaFs) MkdirAll(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return nil
+	}
+
+	if _, err := mf.Stat(pathStr); err == nil {
+		return nil
+	}
+
+	if err := mf.MkdirAll(path.Dir(pathStr)); err != nil {
+		return err
+	}
+
+	_, err := mf.Create(pathStr, true)
+	if err != nil && !errors.Is(err, internal.ErrAlreadyExist) {
+		return err
+	}
+	return nil
+}
+
+func (mf *MetaFs) Rename(oldpath, newpath string) error {
+	oldpath = path.Clean(oldpath)
+	newpath = path.Clean(newpath)
+
+	if oldpath == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	if strings.HasPrefix(newpath, oldpath+"/") {
+		return internal.ErrInvalidOperation
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	if _, ok := mf.nodes[newpath]; ok {
+		return internal.ErrAlreadyExist
+	}
+	node, err := mf.get(oldpath)
+	if err != nil {
+		return err
+	}
+	if err := mf.checkParentDir(newpath); err != nil {
+		return err
+	}
+
+	for _, p := range mf.descendants(oldpath) {
+		child := mf.nodes[p]
+		newChildPath := newpath + strings.TrimPrefix(p, oldpath)
+		delete(mf.nodes, p)
+		mf.nodes[newChildPath] = *child.SetPath(newChildPath)
+	}
+	delete(mf.nodes, oldpath)
+	mf.nodes[newpath] = *node.SetPath(newpath)
+	return nil
+}
+
+func (mf *MetaFs) Remove(path string) error {
+	if path == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	if node.IsDir() && len(mf.descendants(path)) > 0 {
+		return internal.ErrNotEmpty
+	}
+
+	mf.unlink(path)
+	return nil
+}
+
+func (mf *MetaFs) RemoveAll(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	if _, ok := mf.nodes[pathStr]; !ok {
+		return nil
+	}
+	for _, p := range mf.descendants(pathStr) {
+		mf.unlink(p)
+	}
+	mf.unlink(pathStr)
+	return nil
+}
+
+func (mf *MetaFs) Sync(path string, size int64) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	if !node.IsDir() {
+		node.SetSize(size).SetDigest(internal.Digest{})
+	}
+	mf.put(node.SetModTime(time.Now()))
+	return nil
+}
+
+func (mf *MetaFs) SetDigest(path string, digest internal.Digest) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	if node.IsDir() {
+		return internal.ErrIsDir
+	}
+	mf.put(node.SetDigest(digest))
+	return nil
+}
+
+func (mf *MetaFs) Chmod(path string, mode os.FileMode) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	mf.put(node.Chmod(mode))
+	return nil
+}
+
+func (mf *MetaFs) Chown(path string, uid, gid int) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	mf.put(node.Chown(uid, gid))
+	return nil
+}
+
+func (mf *MetaFs) GetXattr(path, name string) (string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return node.Xattr(name)
+}
+
+func (mf *MetaFs) SetXattr(path, name, value string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	if err := internal.CheckXattr(node, name, value); err != nil {
+		return err
+	}
+	mf.put(node.SetXattr(name, value))
+	return nil
+}
+
+func (mf *MetaFs) ListXattr(path string) ([]string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return node.XattrNames(), nil
+}
+
+func (mf *MetaFs) RemoveXattr(path, name string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(path)
+	if err != nil {
+		return err
+	}
+	if _, err := node.Xattr(name); err != nil {
+		return err
+	}
+	mf.put(node.RemoveXattr(name))
+	return nil
+}
+
+func (mf *MetaFs) Put(node *internal.Node) error {
+	pathStr := node.Path()
+	if pathStr == "/" && !node.IsDir() {
+		return internal.ErrInvalidRootOperation
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	if existing, ok := mf.nodes[pathStr]; ok && existing.IsDir() != node.IsDir() {
+		return internal.ErrAlreadyExist
+	}
+	if err := mf.checkParentDir(pathStr); err != nil {
+		return err
+	}
+
+	mf.nodes[pathStr] = *node
+	return nil
+}
+
+func (mf *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(src)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return nil, internal.ErrIsDir
+	}
+	if _, ok := mf.nodes[dst]; ok {
+		return nil, internal.ErrAlreadyExist
+	}
+	if err := mf.checkParentDir(dst); err != nil {
+		return nil, err
+	}
+
+	file := node.CopyTo(dst)
+	mf.nodes[dst] = *file
+	return file, nil
+}
+
+func (mf *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	link := internal.NewSymlink(pathStr, target)
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	if _, ok := mf.nodes[pathStr]; ok {
+		return nil, internal.ErrAlreadyExist
+	}
+	if err := mf.checkParentDir(pathStr); err != nil {
+		return nil, err
+	}
+
+	mf.nodes[pathStr] = *link
+	return link, nil
+}
+
+func (mf *MetaFs) Link(oldpath, newpath string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	node, err := mf.get(oldpath)
+	if err != nil {
+		return err
+	}
+	if node.IsDir() {
+		return internal.ErrIsDir
+	}
+	if node.IsSymlink() {
+		return internal.ErrNotSupported
+	}
+	if _, ok := mf.nodes[newpath]; ok {
+		return internal.ErrAlreadyExist
+	}
+	if err := mf.checkParentDir(newpath); err != nil {
+		return err
+	}
+
+	mf.put(node.SetPath(newpath).SetLinks(node.Links() + 1))
+	return nil
+}
+
+func (mf *MetaFs) Close() error {
+	return nil
+}
@@ -0,0 +1,328 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"fafda/internal"
+)
+
+func TestCreate(t *testing.T) {
+	provider := NewMetaFs()
+
+	tests := []struct {
+		name    string
+		path    string
+		isDir   bool
+		wantErr error
+	}{
+		{name: "create directory", path: "/test", isDir: true},
+		{name: "create file in directory", path: "/test/file.txt"},
+		{name: "create duplicate", path: "/test", isDir: true, wantErr: internal.ErrAlreadyExist},
+		{name: "create in missing directory", path: "/missing/file.txt", wantErr: internal.ErrNotFound},
+		{name: "create below a file", path: "/test/file.txt/x", wantErr: internal.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := provider.Create(tt.path, tt.isDir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if node.IsDir() != tt.isDir {
+				t.Errorf("IsDir() = %v, want %v", node.IsDir(), tt.isDir)
+			}
+			if !tt.isDir && node.Id() == "" {
+				t.Error("file created without id")
+			}
+		})
+	}
+}
+
+func TestLs(t *testing.T) {
+	provider := NewMetaFs()
+
+	for _, dir := range []string{"/test", "/test/sub", "/testing"} {
+		if err := provider.Mkdir(dir); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	for _, file := range []string{"/test/c.txt", "/test/a.txt", "/test/b.txt", "/test/sub/d.txt", "/root.txt"} {
+		if err := provider.Touch(file); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		limit   int
+		offset  int
+		want    []string
+		wantErr error
+	}{
+		{name: "root", path: "/", want: []string{"root.txt", "test", "testing"}},
+		{name: "directory", path: "/test", want: []string{"a.txt", "b.txt", "c.txt", "sub"}},
+		{name: "limit", path: "/test", limit: 2, want: []string{"a.txt", "b.txt"}},
+		{name: "offset", path: "/test", limit: 2, offset: 3, want: []string{"sub"}},
+		{name: "unlimited", path: "/test", limit: -1, offset: 1, want: []string{"b.txt", "c.txt", "sub"}},
+		{name: "file", path: "/root.txt", wantErr: internal.ErrIsNotDir},
+		{name: "missing", path: "/missing", wantErr: internal.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := provider.Ls(tt.path, tt.limit, tt.offset)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Ls() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(nodes) != len(tt.want) {
+				t.Fatalf("Ls() returned %d nodes, want %d", len(nodes), len(tt.want))
+			}
+			for i := range nodes {
+				if nodes[i].Name() != tt.want[i] {
+					t.Errorf("Ls()[%d] = %q, want %q", i, nodes[i].Name(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLsCursor(t *testing.T) {
+	provider := NewMetaFs()
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"b", "d", "f"} {
+		if err := provider.Touch("/dir/" + name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	nodes, cursor, err := provider.LsCursor("/dir", 2, "")
+	if err != nil || len(nodes) != 2 || cursor == "" {
+		t.Fatalf("LsCursor() = %d nodes, %q, %v, want 2 nodes and a cursor", len(nodes), cursor, err)
+	}
+	if err := provider.Touch("/dir/a"); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+
+	nodes, cursor, err = provider.LsCursor("/dir", 2, cursor)
+	if err != nil || len(nodes) != 1 || nodes[0].Name() != "f" || cursor != "" {
+		t.Fatalf("LsCursor() = %v, %q, %v, want only f and no cursor", nodes, cursor, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	provider := NewMetaFs()
+	for _, dir := range []string{"/a", "/a/b", "/c"} {
+		if err := provider.Mkdir(dir); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if err := provider.Touch("/a/b/file.txt"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		oldpath string
+		newpath string
+		wantErr error
+	}{
+		{name: "root", oldpath: "/", newpath: "/x", wantErr: internal.ErrInvalidRootOperation},
+		{name: "into itself", oldpath: "/a", newpath: "/a/b/a", wantErr: internal.ErrInvalidOperation},
+		{name: "onto existing", oldpath: "/a", newpath: "/c", wantErr: internal.ErrAlreadyExist},
+		{name: "missing source", oldpath: "/missing", newpath: "/x", wantErr: internal.ErrNotFound},
+		{name: "missing parent", oldpath: "/a", newpath: "/missing/a", wantErr: internal.ErrNotFound},
+		{name: "directory with children", oldpath: "/a", newpath: "/c/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := provider.Rename(tt.oldpath, tt.newpath); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rename() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	node, err := provider.Stat("/c/a/b/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() moved descendant error = %v", err)
+	}
+	if node.Path() != "/c/a/b/file.txt" {
+		t.Errorf("Path() = %q after rename", node.Path())
+	}
+	if _, err := provider.Stat("/a/b/file.txt"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() old path error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	provider := NewMetaFs()
+	if err := provider.MkdirAll("/a/b"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/a/b/file.txt"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/ab"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := provider.Remove("/a"); !errors.Is(err, internal.ErrNotEmpty) {
+		t.Errorf("Remove() non-empty error = %v, want %v", err, internal.ErrNotEmpty)
+	}
+	if err := provider.Remove("/missing"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Remove() missing error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if err := provider.RemoveAll("/"); !errors.Is(err, internal.ErrInvalidRootOperation) {
+		t.Errorf("RemoveAll() root error = %v, want %v", err, internal.ErrInvalidRootOperation)
+	}
+	if err := provider.RemoveAll("/a"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := provider.Stat("/a/b/file.txt"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() removed descendant error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if _, err := provider.Stat("/ab"); err != nil {
+		t.Errorf("Stat() sibling sharing prefix error = %v", err)
+	}
+}
+
+func TestSyncAndChtimes(t *testing.T) {
+	provider := NewMetaFs()
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := provider.Sync("/file", 42); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := provider.Chtimes("/file", mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	node, err := provider.Stat("/file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Size() != 42 {
+		t.Errorf("Size() = %d, want 42", node.Size())
+	}
+	if !node.ModTime().Equal(mtime) {
+		t.Errorf("ModTime() = %v, want %v", node.ModTime(), mtime)
+	}
+
+	// Returned nodes are copies
+	node.SetSize(1)
+	if again, _ := provider.Stat("/file"); again.Size() != 42 {
+		t.Errorf("mutating a returned node changed the store")
+	}
+}
+
+func TestPut(t *testing.T) {
+	provider := NewMetaFs()
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		node    *internal.Node
+		wantErr error
+	}{
+		{name: "new file", node: internal.NewNode("/dir/file", false).SetId("restored-id")},
+		{name: "missing parent", node: internal.NewNode("/missing/file", false), wantErr: internal.ErrNotFound},
+		{name: "file over directory", node: internal.NewNode("/dir", false), wantErr: internal.ErrAlreadyExist},
+		{name: "file as root", node: internal.NewNode("/", false), wantErr: internal.ErrInvalidRootOperation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := provider.Put(tt.node); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Put() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if node, err := provider.Stat("/dir/file"); err != nil || node.Id() != "restored-id" {
+		t.Errorf("Stat() = %v, %v, want id %q", node, err, "restored-id")
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	provider := NewMetaFs()
+
+	var wg sync.WaitGroup
+	var created sync.Map
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.Create("/same", false); err == nil {
+				created.Store(i, true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	created.Range(func(_, _ any) bool { count++; return true })
+	if count != 1 {
+		t.Errorf("%d concurrent creates succeeded, want 1", count)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	driver := NewDriver()
+
+	w, err := driver.GetWriter("id")
+	if err != nil {
+		t.Fatalf("GetWriter() error = %v", err)
+	}
+	if _, err := w.Write([]byte("hello world")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if size, _ := driver.GetSize("id"); size != 0 {
+		t.Errorf("GetSize() = %d before Close, want 0", size)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close() error = %v, want %v", err, ErrClosed)
+	}
+
+	if size, _ := driver.GetSize("id"); size != 11 {
+		t.Errorf("GetSize() = %d, want 11", size)
+	}
+
+	r, err := driver.GetReader("id", 6)
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, []byte("world")) {
+		t.Errorf("ReadAll() = %q, %v, want %q", got, err, "world")
+	}
+
+	if _, err := driver.GetReader("id", 12); err != io.EOF {
+		t.Errorf("GetReader() past end error = %v, want EOF", err)
+	}
+
+	if err := driver.Truncate("id"); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if size, _ := driver.GetSize("id"); size != 0 {
+		t.Errorf("GetSize() = %d after Truncate, want 0", size)
+	}
+}
@@ -0,0 +1,164 @@
+// Package metaio moves metadata in and out of a MetaFileSystem as JSON
+// lines, one record per line, so it can be inspected, diffed and loaded
+// into another instance.
+package metaio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"fafda/internal"
+)
+
+const Version = 1
+
+const (
+	TypeHeader = "header"
+	TypeNode   = "node"
+	TypeAssets = "assets"
+)
+
+var (
+	ErrNotEmpty      = errors.New("import target is not empty")
+	ErrBadVersion    = errors.New("unsupported export version")
+	ErrMissingHeader = errors.New("export header missing")
+	ErrUnknownRecord = errors.New("unknown record type")
+)
+
+// AssetStore is the driver side record of where file content lives,
+// assets are carried verbatim as the driver encodes them.
+type AssetStore interface {
+	Dump(fn func(fileId string, record json.RawMessage) error) error
+	Load(fileId string, record json.RawMessage) error
+}
+
+type Record struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version,omitempty"`
+	Source  string          `json:"source,omitempty"`
+	Node    *internal.Node  `json:"node,omitempty"`
+	FileId  string          `json:"fileId,omitempty"`
+	Assets  json.RawMessage `json:"assets,omitempty"`
+}
+
+type Stats struct {
+	Nodes  int
+	Assets int
+}
+
+// Export writes a header, then every node parents first, then the asset
+// list of every file. assets may be nil to export the namespace only.
+func Export(w io.Writer, meta internal.MetaFileSystem, assets AssetStore) (Stats, error) {
+	var stats Stats
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(Record{Type: TypeHeader, Version: Version, Source: meta.Name()}); err != nil {
+		return stats, err
+	}
+
+	root, err := meta.Stat("/")
+	if err != nil {
+		return stats, err
+	}
+	if err := enc.Encode(Record{Type: TypeNode, Node: root}); err != nil {
+		return stats, err
+	}
+	stats.Nodes++
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		nodes, err := meta.Ls(dir, 0, 0)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", dir, err)
+		}
+		for i := range nodes {
+			if err := enc.Encode(Record{Type: TypeNode, Node: &nodes[i]}); err != nil {
+				return err
+			}
+			stats.Nodes++
+			if nodes[i].IsDir() {
+				if err := walk(path.Join(dir, nodes[i].Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("/"); err != nil {
+		return stats, err
+	}
+
+	if assets != nil {
+		err := assets.Dump(func(fileId string, record json.RawMessage) error {
+			stats.Assets++
+			return enc.Encode(Record{Type: TypeAssets, FileId: fileId, Assets: record})
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, bw.Flush()
+}
+
+// Import loads an export into meta, which must hold nothing but the root.
+// Asset records are skipped when assets is nil.
+func Import(r io.Reader, meta internal.MetaFileSystem, assets AssetStore) (Stats, error) {
+	var stats Stats
+
+	existing, err := meta.Ls("/", 1, 0)
+	if err != nil {
+		return stats, err
+	}
+	if len(existing) > 0 {
+		return stats, ErrNotEmpty
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var record Record
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		if line == 1 {
+			if record.Type != TypeHeader {
+				return stats, ErrMissingHeader
+			}
+			if record.Version != Version {
+				return stats, fmt.Errorf("%w %d", ErrBadVersion, record.Version)
+			}
+			continue
+		}
+
+		switch record.Type {
+		case TypeNode:
+			if record.Node == nil {
+				return stats, fmt.Errorf("record %d: node missing", line)
+			}
+			if err := meta.Put(record.Node); err != nil {
+				return stats, fmt.Errorf("record %d: %s: %w", line, record.Node.Path(), err)
+			}
+			stats.Nodes++
+		case TypeAssets:
+			if assets == nil {
+				continue
+			}
+			if err := assets.Load(record.FileId, record.Assets); err != nil {
+				return stats, fmt.Errorf("record %d: %s: %w", line, record.FileId, err)
+			}
+			stats.Assets++
+		default:
+			return stats, fmt.Errorf("record %d: %w %q", line, ErrUnknownRecord, record.Type)
+		}
+	}
+
+	return stats, nil
+}
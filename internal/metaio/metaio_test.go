@@ -0,0 +1,166 @@
+package metaio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"fafda/internal"
+	"fafda/internal/bolt"
+	"fafda/internal/memory"
+	"fafda/internal/sqlite"
+)
+
+type memAssets map[string]json.RawMessage
+
+func (m memAssets) Dump(fn func(fileId string, record json.RawMessage) error) error {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := fn(id, m[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m memAssets) Load(fileId string, record json.RawMessage) error {
+	m[fileId] = record
+	return nil
+}
+
+func setupSource(t *testing.T) (internal.MetaFileSystem, memAssets) {
+	t.Helper()
+	meta := memory.NewMetaFs()
+	assets := memAssets{}
+
+	if err := meta.MkdirAll("/docs/old"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for i, p := range []string{"/docs/a.txt", "/docs/old/b.txt", "/top.bin"} {
+		node, err := meta.Create(p, false)
+		if err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if err := meta.Sync(p, int64(len(p))); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if err := meta.SetDigest(p, internal.Digest{SHA256: "sum-of-" + p}); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		assets[node.Id()] = json.RawMessage(fmt.Sprintf(`[{"id":%d,"number":1}]`, i))
+	}
+	if err := meta.Chtimes("/docs", time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return meta, assets
+}
+
+func setupBolt(t *testing.T) internal.MetaFileSystem {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	meta, err := bolt.NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = meta.Close() })
+	return meta
+}
+
+func setupSQLite(t *testing.T) internal.MetaFileSystem {
+	t.Helper()
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	meta, err := sqlite.NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = meta.Close() })
+	return meta
+}
+
+// body drops the header line, which names the source
+func body(export []byte) string {
+	_, rest, _ := strings.Cut(string(export), "\n")
+	return rest
+}
+
+func TestRoundTrip(t *testing.T) {
+	source, sourceAssets := setupSource(t)
+
+	var first bytes.Buffer
+	stats, err := Export(&first, source, sourceAssets)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if stats.Nodes != 6 || stats.Assets != 3 {
+		t.Errorf("Export() stats = %+v, want 6 nodes and 3 assets", stats)
+	}
+
+	targets := map[string]internal.MetaFileSystem{
+		"memory": memory.NewMetaFs(),
+		"bolt":   setupBolt(t),
+		"sqlite": setupSQLite(t),
+	}
+	for name, target := range targets {
+		t.Run(name, func(t *testing.T) {
+			targetAssets := memAssets{}
+			if _, err := Import(bytes.NewReader(first.Bytes()), target, targetAssets); err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+
+			var second bytes.Buffer
+			if _, err := Export(&second, target, targetAssets); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			if body(second.Bytes()) != body(first.Bytes()) {
+				t.Errorf("round trip mismatch\ngot:\n%s\nwant:\n%s", body(second.Bytes()), body(first.Bytes()))
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	source, sourceAssets := setupSource(t)
+	var export bytes.Buffer
+	if _, err := Export(&export, source, sourceAssets); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		target  internal.MetaFileSystem
+		wantErr error
+	}{
+		{name: "target not empty", input: export.String(), target: source, wantErr: ErrNotEmpty},
+		{name: "no header", input: `{"type":"node","node":{"path":"/x"}}`, target: memory.NewMetaFs(), wantErr: ErrMissingHeader},
+		{name: "future version", input: `{"type":"header","version":99}`, target: memory.NewMetaFs(), wantErr: ErrBadVersion},
+		{name: "unknown record", input: `{"type":"header","version":1}` + "\n" + `{"type":"xattr"}`, target: memory.NewMetaFs(), wantErr: ErrUnknownRecord},
+		{name: "orphan node", input: `{"type":"header","version":1}` + "\n" + `{"type":"node","node":{"path":"/a/b"}}`, target: memory.NewMetaFs(), wantErr: internal.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Import(strings.NewReader(tt.input), tt.target, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,9 +3,14 @@ package internal
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"time"
+
+	nanoid "github.com/matoous/go-nanoid/v2"
 )
 
 type Node struct {
@@ -17,6 +22,59 @@ type Node struct {
 	mode      os.FileMode
 	createdAt time.Time
 	modTime   time.Time
+	digest    Digest
+	target    string
+	links     int
+	uid       int
+	gid       int
+	xattrs    map[string]string
+}
+
+// NewNode returns a fresh node, files get a new id to store content under.
+func NewNode(path string, isDir bool) *Node {
+	now := time.Now()
+	mode := os.FileMode(0644)
+	if isDir {
+		mode = os.FileMode(0755) | os.ModeDir
+	}
+
+	node := &Node{}
+
+	if !isDir {
+		node.SetId(nanoid.Must())
+	}
+
+	return node.
+		SetPath(path).
+		SetIsDir(isDir).
+		SetSize(0).
+		SetMode(mode).
+		SetCreatedAt(now).
+		SetModTime(now)
+}
+
+// MaxHops is how many symbolic links resolving a path may go through.
+const MaxHops = 40
+
+// NewSymlink returns a symbolic link to target, it has no content and
+// so no id.
+func NewSymlink(path, target string) *Node {
+	now := time.Now()
+	return (&Node{}).
+		SetPath(path).
+		SetSize(int64(len(target))).
+		SetMode(os.FileMode(0777) | os.ModeSymlink).
+		SetTarget(target).
+		SetCreatedAt(now).
+		SetModTime(now)
+}
+
+// CopyTo is a new file node at path with the content of n.
+func (n *Node) CopyTo(path string) *Node {
+	if n.IsSymlink() {
+		return NewSymlink(path, n.target)
+	}
+	return NewNode(path, false).SetSize(n.size).SetMode(n.mode).SetDigest(n.digest).SetXattrs(n.xattrs)
 }
 
 func (n *Node) Id() string                 { return n.id }
@@ -28,6 +86,44 @@ func (n *Node) IsDir() bool                { return n.isDir }
 func (n *Node) Sys() interface{}           { return nil }
 func (n *Node) Stat() (os.FileInfo, error) { return n, nil }
 func (n *Node) Path() string               { return n.path }
+func (n *Node) Digest() Digest             { return n.digest }
+func (n *Node) CreatedAt() time.Time       { return n.createdAt }
+func (n *Node) Target() string             { return n.target }
+func (n *Node) IsSymlink() bool            { return n.mode&os.ModeSymlink != 0 }
+func (n *Node) Uid() int                   { return n.uid }
+func (n *Node) Gid() int                   { return n.gid }
+
+// Xattrs are the extended attributes of the node, the map is shared and
+// must not be changed.
+func (n *Node) Xattrs() map[string]string { return n.xattrs }
+
+// Xattr returns the value of the extended attribute name.
+func (n *Node) Xattr(name string) (string, error) {
+	value, ok := n.xattrs[name]
+	if !ok {
+		return "", ErrNoXattr
+	}
+	return value, nil
+}
+
+// XattrNames returns the names of the extended attributes in order.
+func (n *Node) XattrNames() []string {
+	names := make([]string, 0, len(n.xattrs))
+	for name := range n.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Links is how many entries the node is reached by, hard links of a file
+// share its id and count.
+func (n *Node) Links() int {
+	if n.links < 1 {
+		return 1
+	}
+	return n.links
+}
 
 func (n *Node) SetId(id string) *Node          { n.id = id; return n }
 func (n *Node) SetPath(path string) *Node      { n.path = path; return n }
@@ -36,21 +132,114 @@ func (n *Node) SetSize(size int64) *Node       { n.size = size; return n }
 func (n *Node) SetMode(mode os.FileMode) *Node { n.mode = mode; return n }
 func (n *Node) SetCreatedAt(t time.Time) *Node { n.createdAt = t; return n }
 func (n *Node) SetModTime(t time.Time) *Node   { n.modTime = t; return n }
+func (n *Node) SetDigest(d Digest) *Node       { n.digest = d; return n }
+func (n *Node) SetTarget(target string) *Node  { n.target = target; return n }
+func (n *Node) SetLinks(links int) *Node       { n.links = links; return n }
+func (n *Node) SetUid(uid int) *Node           { n.uid = uid; return n }
+func (n *Node) SetGid(gid int) *Node           { n.gid = gid; return n }
+
+// SetXattrs replaces the extended attributes with a copy of xattrs.
+func (n *Node) SetXattrs(xattrs map[string]string) *Node {
+	n.xattrs = nil
+	if len(xattrs) > 0 {
+		n.xattrs = make(map[string]string, len(xattrs))
+		for name, value := range xattrs {
+			n.xattrs[name] = value
+		}
+	}
+	return n
+}
+
+// SetXattr sets one extended attribute. Nodes are copied by value, so the
+// map is replaced rather than changed in place.
+func (n *Node) SetXattr(name, value string) *Node {
+	n.SetXattrs(n.xattrs)
+	if n.xattrs == nil {
+		n.xattrs = map[string]string{}
+	}
+	n.xattrs[name] = value
+	return n
+}
+
+// RemoveXattr drops one extended attribute, see SetXattr.
+func (n *Node) RemoveXattr(name string) *Node {
+	if _, ok := n.xattrs[name]; ok {
+		n.SetXattrs(n.xattrs)
+		delete(n.xattrs, name)
+		if len(n.xattrs) == 0 {
+			n.xattrs = nil
+		}
+	}
+	return n
+}
+
+// Limits on extended attributes, they live in the metadata store and are
+// handed out with every Stat.
+const (
+	MaxXattrName  = 255
+	MaxXattrValue = 64 << 10
+	MaxXattrs     = 64
+)
+
+// XattrContentType is the attribute served as the Content-Type of a file
+// over http and as its media-type fact over FTP.
+const XattrContentType = "content-type"
+
+// CheckXattr tells whether name and value may be stored on node.
+func CheckXattr(node *Node, name, value string) error {
+	if name == "" || len(name) > MaxXattrName || strings.ContainsAny(name, "\x00\r\n") || len(value) > MaxXattrValue {
+		return ErrInvalidXattr
+	}
+	if _, ok := node.xattrs[name]; !ok && len(node.xattrs) >= MaxXattrs {
+		return ErrInvalidXattr
+	}
+	return nil
+}
+
+// ChmodBits are the bits of a mode that Chmod changes.
+const ChmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Chmod replaces the ChmodBits of the node's mode, the type stays.
+func (n *Node) Chmod(mode os.FileMode) *Node {
+	n.mode = n.mode&^ChmodBits | mode&ChmodBits
+	return n
+}
+
+// Chown sets the owner and group of the node, -1 keeps either as it is.
+func (n *Node) Chown(uid, gid int) *Node {
+	if uid >= 0 {
+		n.uid = uid
+	}
+	if gid >= 0 {
+		n.gid = gid
+	}
+	return n
+}
 
 type nodeAlias struct {
-	Id        string
-	Path      string
-	Name      string
-	IsDir     bool
-	Size      int64
-	Mode      os.FileMode
-	CreatedAt time.Time
-	ModTime   time.Time
+	Id        string            `json:"id,omitempty"`
+	Path      string            `json:"path"`
+	Name      string            `json:"name,omitempty"`
+	IsDir     bool              `json:"isDir"`
+	Size      int64             `json:"size"`
+	Mode      os.FileMode       `json:"mode"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ModTime   time.Time         `json:"modTime"`
+	Digest    Digest            `json:"digest"`
+	Target    string            `json:"target,omitempty"`
+	Links     int               `json:"links,omitempty"`
+	Uid       int               `json:"uid,omitempty"`
+	Gid       int               `json:"gid,omitempty"`
+	Xattrs    map[string]string `json:"xattrs,omitempty"`
 }
 
-func (n *Node) GobEncode() ([]byte, error) {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(nodeAlias{
+func (n *Node) alias() nodeAlias {
+	// A single link is what every node has, it is left out
+	links := n.links
+	if links == 1 {
+		links = 0
+	}
+	return nodeAlias{
 		Id:        n.id,
 		Path:      n.path,
 		Name:      n.name,
@@ -59,18 +248,16 @@ func (n *Node) GobEncode() ([]byte, error) {
 		Mode:      n.mode,
 		CreatedAt: n.createdAt,
 		ModTime:   n.modTime,
-	}); err != nil {
-		return nil, err
+		Digest:    n.digest,
+		Target:    n.target,
+		Links:     links,
+		Uid:       n.uid,
+		Gid:       n.gid,
+		Xattrs:    n.xattrs,
 	}
-	return buf.Bytes(), nil
 }
 
-func (n *Node) GobDecode(data []byte) error {
-	var alias nodeAlias
-	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&alias); err != nil {
-		return err
-	}
-
+func (n *Node) setAlias(alias nodeAlias) {
 	n.id = alias.Id
 	n.path = alias.Path
 	n.name = alias.Name
@@ -79,6 +266,40 @@ func (n *Node) GobDecode(data []byte) error {
 	n.mode = alias.Mode
 	n.createdAt = alias.CreatedAt
 	n.modTime = alias.ModTime
+	n.digest = alias.Digest
+	n.target = alias.Target
+	n.links = alias.Links
+	n.uid = alias.Uid
+	n.gid = alias.Gid
+	n.xattrs = alias.Xattrs
+}
 
+func (n *Node) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(n.alias()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (n *Node) GobDecode(data []byte) error {
+	var alias nodeAlias
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&alias); err != nil {
+		return err
+	}
+	n.setAlias(alias)
+	return nil
+}
+
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.alias())
+}
+
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var alias nodeAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	n.setAlias(alias)
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 )
 
 var (
-	ErrClosed  = errors.New("is closed")
-	ErrNoParts = errors.New("no parts provided")
+	ErrClosed           = errors.New("is closed")
+	ErrNoParts          = errors.New("no parts provided")
+	ErrChecksumMismatch = errors.New("part checksum mismatch")
+	ErrPartSize         = errors.New("part size mismatch")
 )
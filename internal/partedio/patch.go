@@ -0,0 +1,71 @@
+package partedio
+
+import "io"
+
+// Patch is a write of Data at Off into a parted file.
+type Patch struct {
+	Off  int64
+	Data []byte
+}
+
+// Patches are applied in order, later ones win where they overlap.
+type Patches []Patch
+
+// End is the size the file grows to, at least.
+func (ps Patches) End() int64 {
+	var end int64
+	for _, p := range ps {
+		end = max(end, p.Off+int64(len(p.Data)))
+	}
+	return end
+}
+
+// Overlaps reports whether any patch touches the range [start, end).
+func (ps Patches) Overlaps(start, end int64) bool {
+	for _, p := range ps {
+		if p.Off < end && p.Off+int64(len(p.Data)) > start {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply writes the patches into buf, which holds the file from base on.
+func (ps Patches) Apply(buf []byte, base int64) {
+	for _, p := range ps {
+		start := max(p.Off, base)
+		end := min(p.Off+int64(len(p.Data)), base+int64(len(buf)))
+		if start < end {
+			copy(buf[start-base:end-base], p.Data[start-p.Off:end-p.Off])
+		}
+	}
+}
+
+// ReadPart reads a whole part, verifying it when it carries a checksum.
+func ReadPart(part PartReader) ([]byte, error) {
+	if part.GetSize() == 0 {
+		return []byte{}, nil
+	}
+
+	reader, err := part.GetReader(0, part.GetSize()-1)
+	if err != nil {
+		return nil, err
+	}
+	if cp, ok := part.(ChecksumPart); ok && cp.GetChecksum() != "" {
+		reader = newVerifyReader(reader, cp.GetChecksum())
+	}
+	defer reader.Close()
+
+	data := make([]byte, part.GetSize())
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	// Reach EOF so the checksum gets compared
+	if _, err := reader.Read(make([]byte, 1)); err != io.EOF {
+		if err == nil {
+			err = ErrPartSize
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -84,6 +84,11 @@ func (r *Reader) Read(p []byte) (int, error) {
 		}
 
 		if err == io.EOF {
+			// Part body ended before its advertised size
+			if r.pos <= r.partEnds[r.curIdx] {
+				return totalRead, io.ErrUnexpectedEOF
+			}
+
 			r.curIdx++
 			if r.curIdx >= len(r.parts) {
 				return totalRead, io.EOF
@@ -131,11 +136,16 @@ func (r *Reader) readNextPart() error {
 		start = int(r.pos - r.partStarts[r.curIdx])
 	}
 
-	reader, err := r.parts[r.curIdx].GetReader(start, r.parts[r.curIdx].GetSize()-1)
+	part := r.parts[r.curIdx]
+	reader, err := part.GetReader(start, part.GetSize()-1)
 	if err != nil {
 		return err
 	}
 
+	if cp, ok := part.(ChecksumPart); ok && start == 0 && cp.GetChecksum() != "" {
+		reader = newVerifyReader(reader, cp.GetChecksum())
+	}
+
 	r.reader = reader
 	return nil
 }
@@ -2,9 +2,15 @@ package partedio
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
+	"strings"
 	"testing"
+	"time"
+
+	"fafda/internal/chaos"
 )
 
 type mockReader struct {
@@ -211,3 +217,146 @@ func TestEmptyRead(t *testing.T) {
 		t.Errorf("Read() empty buffer got = %v, %v, want 0, nil", n, err)
 	}
 }
+
+func newChaosParts(inj *chaos.Injector, data ...[]byte) []PartReader {
+	parts := make([]PartReader, len(data))
+	for i := range data {
+		d := data[i]
+		parts[i] = chaos.NewPart(&mockPart{
+			size: len(d),
+			reader: func(start, end int) (io.ReadCloser, error) {
+				return &mockReader{data: d[start : end+1]}, nil
+			},
+		}, inj)
+	}
+	return parts
+}
+
+func TestReaderWithFaults(t *testing.T) {
+	part1Data := bytes.Repeat([]byte("a"), 100)
+	part2Data := bytes.Repeat([]byte("b"), 50)
+	want := append(append([]byte{}, part1Data...), part2Data...)
+
+	tests := []struct {
+		name    string
+		rules   []chaos.Rule
+		want    []byte
+		wantErr error
+	}{
+		{
+			name:  "short reads are retried",
+			rules: []chaos.Rule{{Op: chaos.OpRead, Fault: chaos.FaultShortRead, Every: 1}},
+			want:  want,
+		},
+		{
+			name:  "slow parts",
+			rules: []chaos.Rule{{Op: chaos.OpGetReader, Fault: chaos.FaultLatency, Every: 1, Delay: time.Millisecond}},
+			want:  want,
+		},
+		{
+			name:    "truncated part body",
+			rules:   []chaos.Rule{{Op: chaos.OpRead, Fault: chaos.FaultTruncate, Every: 1, After: 1}},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "second part unavailable",
+			rules:   []chaos.Rule{{Op: chaos.OpGetReader, Fault: chaos.FaultError, Every: 1, After: 1}},
+			wantErr: chaos.ErrInjected,
+		},
+		{
+			name:    "read error mid part",
+			rules:   []chaos.Rule{{Op: chaos.OpRead, Fault: chaos.FaultError, Every: 1, After: 1}},
+			wantErr: chaos.ErrInjected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inj := chaos.NewInjector(1, tt.rules...)
+			r, err := NewReader(newChaosParts(inj, part1Data, part2Data), 0)
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			defer r.Close()
+
+			buf := make([]byte, 64)
+			var got []byte
+			for {
+				n, err := r.Read(buf)
+				got = append(got, buf[:n]...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("Read() error = %v, wantErr %v", err, tt.wantErr)
+					}
+					return
+				}
+			}
+
+			if tt.wantErr != nil {
+				t.Fatalf("Read() finished with %d bytes, wantErr %v", len(got), tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Read() got %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+type checksumPart struct {
+	mockPart
+	checksum string
+}
+
+func (c *checksumPart) GetChecksum() string {
+	return c.checksum
+}
+
+func TestReaderVerifiesChecksum(t *testing.T) {
+	data := []byte("hello world")
+	sum := sha256.Sum256(data)
+
+	newPart := func(checksum string) PartReader {
+		return &checksumPart{
+			mockPart: mockPart{
+				size: len(data),
+				reader: func(start, end int) (io.ReadCloser, error) {
+					return &mockReader{data: data[start : end+1]}, nil
+				},
+			},
+			checksum: checksum,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		pos      int64
+		wantErr  error
+	}{
+		{name: "matching checksum", checksum: hex.EncodeToString(sum[:])},
+		{name: "no checksum", checksum: ""},
+		{name: "corrupt part", checksum: strings.Repeat("0", 64), wantErr: ErrChecksumMismatch},
+		{name: "partial reads are not verified", checksum: strings.Repeat("0", 64), pos: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader([]PartReader{newPart(tt.checksum)}, tt.pos)
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			defer r.Close()
+
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, data[tt.pos:]) {
+				t.Errorf("ReadAll() = %q, want %q", got, data[tt.pos:])
+			}
+		})
+	}
+}
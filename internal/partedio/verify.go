@@ -0,0 +1,37 @@
+package partedio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// ChecksumPart is implemented by parts that know the hex encoded SHA-256
+// of their content. Parts read from their first byte are verified.
+type ChecksumPart interface {
+	GetChecksum() string
+}
+
+type verifyReader struct {
+	reader io.ReadCloser
+	hash   hash.Hash
+	want   string
+}
+
+func newVerifyReader(reader io.ReadCloser, want string) io.ReadCloser {
+	return &verifyReader{reader: reader, hash: sha256.New(), want: want}
+}
+
+func (vr *verifyReader) Read(p []byte) (int, error) {
+	n, err := vr.reader.Read(p)
+	vr.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(vr.hash.Sum(nil)) != vr.want {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+func (vr *verifyReader) Close() error {
+	return vr.reader.Close()
+}
@@ -0,0 +1,438 @@
+package perm
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+)
+
+// Identity is who a frontend acts for, it is matched against the owner
+// and group of whatever it touches.
+type Identity struct {
+	Uid int
+	Gid int
+}
+
+// Anonymous owns nothing and is in no group, it gets what others may do.
+var Anonymous = Identity{Uid: -1, Gid: -1}
+
+const (
+	read   os.FileMode = 4
+	write  os.FileMode = 2
+	search os.FileMode = 1
+)
+
+type owned interface {
+	Uid() int
+	Gid() int
+}
+
+type copier interface {
+	Copy(src, dst string) error
+}
+
+type linker interface {
+	Link(oldname, newname string) error
+}
+
+// Fs checks the permission bits of what a call uses against its identity
+// before passing the call on, and hands what it creates to that identity.
+// Directories need search on the way to a path, and write and search to
+// have entries added or removed. Paths are resolved here, symbolic links
+// included, and passed on resolved so the checks hold for what the call
+// actually reaches.
+type Fs struct {
+	afero.Fs
+	id Identity
+}
+
+// New wraps fs for id, root is never refused anything so it gets fs as is.
+func New(fs afero.Fs, id Identity) afero.Fs {
+	if id.Uid == 0 {
+		return fs
+	}
+	return &Fs{Fs: fs, id: id}
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// components splits an absolute path into its names.
+func components(p string) []string {
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+func (fs *Fs) owns(info os.FileInfo) bool {
+	o, ok := info.(owned)
+	return ok && o.Uid() == fs.id.Uid
+}
+
+// allowed reports whether the owner, group or other bits of info, the
+// first that apply to the identity, grant all of want.
+func (fs *Fs) allowed(info os.FileInfo, want os.FileMode) bool {
+	bits := info.Mode().Perm()
+	if o, ok := info.(owned); ok {
+		switch {
+		case o.Uid() == fs.id.Uid:
+			bits >>= 6
+		case o.Gid() == fs.id.Gid:
+			bits >>= 3
+		}
+	}
+	return bits&want == want
+}
+
+// resolve walks name one component at a time the way filesystem.Fs does,
+// checking every directory it goes through, links followed, may be
+// searched. The last link is only followed with follow, the part past a
+// path that does not exist is kept as is.
+func (fs *Fs) resolve(name string, follow bool) (string, error) {
+	resolved := "/"
+	rest := components(clean(name))
+	for hops := 0; len(rest) > 0; {
+		dir, err := fs.Fs.Stat(resolved)
+		if err != nil {
+			return "", err
+		}
+		if !fs.allowed(dir, search) {
+			return "", internal.ErrPermission
+		}
+
+		next := path.Join(resolved, rest[0])
+		rest = rest[1:]
+		if len(rest) == 0 && !follow {
+			return next, nil
+		}
+
+		info, err := fs.lstat(next)
+		if errors.Is(err, os.ErrNotExist) {
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > internal.MaxHops {
+			return "", internal.ErrTooManyLinks
+		}
+		target, err := fs.readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		resolved = "/"
+		rest = append(components(clean(target)), rest...)
+	}
+	return resolved, nil
+}
+
+func (fs *Fs) lstat(name string) (os.FileInfo, error) {
+	if lstater, ok := fs.Fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(name)
+		return info, err
+	}
+	return fs.Fs.Stat(name)
+}
+
+func (fs *Fs) readlink(name string) (string, error) {
+	reader, ok := fs.Fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(name)
+}
+
+// lookup resolves name following every link and stats what it leads to.
+func (fs *Fs) lookup(name string) (string, os.FileInfo, error) {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := fs.Fs.Stat(p)
+	return p, info, err
+}
+
+// may resolves name and checks it grants want.
+func (fs *Fs) may(name string, want os.FileMode) (string, error) {
+	p, info, err := fs.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !fs.allowed(info, want) {
+		return "", internal.ErrPermission
+	}
+	return p, nil
+}
+
+// mayChange resolves name and checks entries may be added to or removed
+// from the directory it ends up in.
+func (fs *Fs) mayChange(name string, follow bool) (string, error) {
+	p, err := fs.resolve(name, follow)
+	if err != nil {
+		return "", err
+	}
+	dir, err := fs.Fs.Stat(path.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	if !fs.allowed(dir, write|search) {
+		return "", internal.ErrPermission
+	}
+	return p, nil
+}
+
+func (fs *Fs) exists(name string) bool {
+	_, err := fs.Fs.Stat(name)
+	return err == nil
+}
+
+func (fs *Fs) own(name string) error {
+	return fs.Fs.Chown(name, fs.id.Uid, fs.id.Gid)
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	p, err := fs.may(name, read)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Fs.Open(p)
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	var want os.FileMode
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		want = write
+	case os.O_RDWR:
+		want = read | write
+	default:
+		want = read
+	}
+	if flag&os.O_TRUNC != 0 {
+		want |= write
+	}
+
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	created := flag&os.O_CREATE != 0 && !fs.exists(p)
+	if created {
+		_, err = fs.mayChange(p, true)
+	} else {
+		_, err = fs.may(p, want)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := fs.Fs.OpenFile(p, flag, perm)
+	if err != nil || !created {
+		return file, err
+	}
+	if err := fs.own(p); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	p, err := fs.mayChange(name, false)
+	if err != nil {
+		return err
+	}
+	if err := fs.Fs.Mkdir(p, perm); err != nil {
+		return err
+	}
+	return fs.own(p)
+}
+
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	p, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	// Only the directory the first missing one goes into has to allow it,
+	// the rest go into directories of our own
+	var missing []string
+	for dir := p; dir != "/" && !fs.exists(dir); dir = path.Dir(dir) {
+		missing = append(missing, dir)
+	}
+	if len(missing) == 0 {
+		_, _, err := fs.lookup(p)
+		return err
+	}
+	if _, err := fs.mayChange(missing[len(missing)-1], false); err != nil {
+		return err
+	}
+	if err := fs.Fs.MkdirAll(p, perm); err != nil {
+		return err
+	}
+	for _, dir := range missing {
+		if err := fs.own(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) Remove(name string) error {
+	p, err := fs.mayChange(name, false)
+	if err != nil {
+		return err
+	}
+	return fs.Fs.Remove(p)
+}
+
+func (fs *Fs) RemoveAll(name string) error {
+	p, err := fs.mayChange(name, false)
+	if err != nil {
+		return err
+	}
+	return fs.Fs.RemoveAll(p)
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	oldpath, err := fs.mayChange(oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := fs.mayChange(newname, false)
+	if err != nil {
+		return err
+	}
+	return fs.Fs.Rename(oldpath, newpath)
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	_, info, err := fs.lookup(name)
+	return info, err
+}
+
+// Chmod is for the owner only.
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	p, info, err := fs.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !fs.owns(info) {
+		return internal.ErrPermission
+	}
+	return fs.Fs.Chmod(p, mode)
+}
+
+// Chown gives nothing away, the owner may only hand its file to its own
+// group.
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	p, info, err := fs.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !fs.owns(info) || (uid != -1 && uid != fs.id.Uid) || (gid != -1 && gid != fs.id.Gid) {
+		return internal.ErrPermission
+	}
+	return fs.Fs.Chown(p, uid, gid)
+}
+
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	p, info, err := fs.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !fs.owns(info) && !fs.allowed(info, write) {
+		return internal.ErrPermission
+	}
+	return fs.Fs.Chtimes(p, atime, mtime)
+}
+
+// Copy needs src readable and makes dst ours.
+func (fs *Fs) Copy(src, dst string) error {
+	copier, ok := fs.Fs.(copier)
+	if !ok {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errors.ErrUnsupported}
+	}
+	src, err := fs.may(src, read)
+	if err != nil {
+		return err
+	}
+	if dst, err = fs.mayChange(dst, true); err != nil {
+		return err
+	}
+	if err := copier.Copy(src, dst); err != nil {
+		return err
+	}
+	return fs.own(dst)
+}
+
+// Link needs oldname readable, the new entry shares its owner.
+func (fs *Fs) Link(oldname, newname string) error {
+	linker, ok := fs.Fs.(linker)
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	oldpath, err := fs.may(oldname, read)
+	if err != nil {
+		return err
+	}
+	newpath, err := fs.mayChange(newname, false)
+	if err != nil {
+		return err
+	}
+	return linker.Link(oldpath, newpath)
+}
+
+// SymlinkIfPossible leaves the link with root, its own bits are never
+// looked at and chowning it would chown its target. The target is kept as
+// given, whoever follows the link is checked on the way.
+func (fs *Fs) SymlinkIfPossible(oldname, newname string) error {
+	linker, ok := fs.Fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	}
+	p, err := fs.mayChange(newname, false)
+	if err != nil {
+		return err
+	}
+	return linker.SymlinkIfPossible(oldname, p)
+}
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	return fs.readlink(p)
+}
+
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	p, err := fs.resolve(name, false)
+	if err != nil {
+		return nil, false, err
+	}
+	if lstater, ok := fs.Fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(p)
+	}
+	info, err := fs.Fs.Stat(p)
+	return info, false, err
+}
@@ -0,0 +1,176 @@
+package perm
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+var (
+	alice = Identity{Uid: 1000, Gid: 100}
+	bob   = Identity{Uid: 1001, Gid: 100}
+	eve   = Identity{Uid: 1002, Gid: 200}
+)
+
+// setupPerm gives alice a home of her own in a tree otherwise root's.
+func setupPerm(t *testing.T) afero.Fs {
+	t.Helper()
+	root := filesystem.New(memory.NewDriver(), memory.NewMetaFs(), nil, nil)
+	if err := root.MkdirAll("/home/alice", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := root.Chown("/home/alice", alice.Uid, alice.Gid); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return root
+}
+
+func wantPermission(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("%s error = %v, want %v", op, err, fs.ErrPermission)
+	}
+}
+
+func TestNewRoot(t *testing.T) {
+	root := setupPerm(t)
+	if got := New(root, Identity{}); got != root {
+		t.Errorf("New() for root = %T, want the fs as is", got)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	root := setupPerm(t)
+	fs := New(root, alice)
+
+	if err := afero.WriteFile(fs, "/home/alice/notes", []byte("notes"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := fs.MkdirAll("/home/alice/a/b", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for _, p := range []string{"/home/alice/notes", "/home/alice/a", "/home/alice/a/b"} {
+		info, err := root.Stat(p)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", p, err)
+		}
+		node := info.(*internal.Node)
+		if node.Uid() != alice.Uid || node.Gid() != alice.Gid {
+			t.Errorf("Stat(%s) owner = %d:%d, want %d:%d", p, node.Uid(), node.Gid(), alice.Uid, alice.Gid)
+		}
+	}
+
+	wantPermission(t, "Create() in /", afero.WriteFile(fs, "/notes", nil, 0644))
+	wantPermission(t, "Mkdir() in /home", fs.Mkdir("/home/bob", 0755))
+	wantPermission(t, "Chown() to bob", fs.Chown("/home/alice/notes", bob.Uid, -1))
+	wantPermission(t, "Chmod() of /home", fs.Chmod("/home", 0777))
+	if err := fs.Chown("/home/alice/notes", -1, alice.Gid); err != nil {
+		t.Errorf("Chown() to own group error = %v", err)
+	}
+}
+
+func TestModeBits(t *testing.T) {
+	root := setupPerm(t)
+	fs := New(root, alice)
+	if err := afero.WriteFile(fs, "/home/alice/notes", []byte("notes"), 0644); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	// 0644 lets the group and others read only
+	for _, id := range []Identity{bob, eve} {
+		other := New(root, id)
+		if _, err := afero.ReadFile(other, "/home/alice/notes"); err != nil {
+			t.Errorf("ReadFile() as %d error = %v", id.Uid, err)
+		}
+		wantPermission(t, "WriteFile()", afero.WriteFile(other, "/home/alice/notes", nil, 0644))
+		wantPermission(t, "Remove()", other.Remove("/home/alice/notes"))
+		wantPermission(t, "Chmod()", other.Chmod("/home/alice/notes", 0666))
+	}
+
+	if err := fs.Chmod("/home/alice/notes", 0640); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if _, err := afero.ReadFile(New(root, bob), "/home/alice/notes"); err != nil {
+		t.Errorf("ReadFile() by the group error = %v", err)
+	}
+	_, err := afero.ReadFile(New(root, eve), "/home/alice/notes")
+	wantPermission(t, "ReadFile() by others", err)
+
+	// Without search on the directory nothing in it can be reached
+	if err := fs.Chmod("/home/alice", 0700); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	_, err = New(root, bob).Stat("/home/alice/notes")
+	wantPermission(t, "Stat() below 0700", err)
+	if _, err := fs.Stat("/home/alice/notes"); err != nil {
+		t.Errorf("Stat() by the owner error = %v", err)
+	}
+}
+
+func TestAnonymous(t *testing.T) {
+	root := setupPerm(t)
+	if err := afero.WriteFile(root, "/public", []byte("public"), 0644); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	fs := New(root, Anonymous)
+
+	if _, err := afero.ReadFile(fs, "/public"); err != nil {
+		t.Errorf("ReadFile() error = %v", err)
+	}
+	if err := root.Chmod("/public", 0600); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	_, err := fs.Open("/public")
+	wantPermission(t, "Open()", err)
+	wantPermission(t, "Create()", afero.WriteFile(fs, "/home/alice/anything", nil, 0644))
+}
+
+func TestSymlinks(t *testing.T) {
+	root := setupPerm(t)
+	if err := afero.WriteFile(root, "/home/alice/secret", []byte("secret"), 0644); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := root.Chmod("/home/alice", 0700); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := root.MkdirAll("/pub", 0755); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := root.Chmod("/pub", 0777); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	linker := root.(afero.Linker)
+	for link, target := range map[string]string{
+		"/pub/secret": "/home/alice/secret",
+		"/pub/home":   "../home/alice",
+	} {
+		if err := linker.SymlinkIfPossible(target, link); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	fs := New(root, bob)
+	_, err := afero.ReadFile(fs, "/pub/secret")
+	wantPermission(t, "ReadFile() through a link", err)
+	_, err = fs.Stat("/pub/home/secret")
+	wantPermission(t, "Stat() through a linked directory", err)
+	wantPermission(t, "WriteFile() through a linked directory", afero.WriteFile(fs, "/pub/home/planted", nil, 0644))
+	wantPermission(t, "Remove() through a linked directory", fs.Remove("/pub/home/secret"))
+
+	// The link itself is in a directory bob may change
+	if target, err := fs.(afero.LinkReader).ReadlinkIfPossible("/pub/secret"); err != nil || target != "/home/alice/secret" {
+		t.Errorf("ReadlinkIfPossible() = %q, %v, want /home/alice/secret", target, err)
+	}
+	if err := fs.Remove("/pub/secret"); err != nil {
+		t.Errorf("Remove() of the link error = %v", err)
+	}
+	if got, err := afero.ReadFile(New(root, alice), "/pub/home/secret"); err != nil || string(got) != "secret" {
+		t.Errorf("ReadFile() by the owner = %q, %v, want secret", got, err)
+	}
+}
@@ -0,0 +1,132 @@
+package quota
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"fafda/config"
+	"fafda/internal"
+)
+
+// Quotas caps what the configured directories hold all the way down. The
+// usage they are checked against is only what was committed, writes in
+// flight on other handles can take a directory a little past its quota.
+type Quotas struct {
+	usage  internal.UsageCounter
+	limits []config.Quota
+}
+
+// Status is a quota and what its directory takes up, a directory that
+// doesn't exist takes up nothing.
+type Status struct {
+	Path     string         `json:"path"`
+	MaxBytes int64          `json:"maxBytes"`
+	MaxFiles int64          `json:"maxFiles"`
+	Usage    internal.Usage `json:"usage"`
+}
+
+func New(usage internal.UsageCounter, limits []config.Quota) *Quotas {
+	cleaned := make([]config.Quota, len(limits))
+	for i, limit := range limits {
+		limit.Path = path.Clean("/" + limit.Path)
+		cleaned[i] = limit
+	}
+	return &Quotas{usage: usage, limits: cleaned}
+}
+
+// within reports whether p is dir or inside it.
+func within(dir, p string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func (q *Quotas) Usage(p string) (internal.Usage, error) {
+	return q.usage.Usage(path.Clean("/" + p))
+}
+
+// used is the usage of the directory of a quota.
+func (q *Quotas) used(dir string) (internal.Usage, error) {
+	usage, err := q.usage.Usage(dir)
+	if errors.Is(err, internal.ErrNotFound) {
+		return internal.Usage{}, nil
+	}
+	return usage, err
+}
+
+// exceeds reports whether add takes usage over limit, only what grows is
+// held against it. Files counts directories too, the one of the quota
+// included.
+func exceeds(limit config.Quota, usage, add internal.Usage) bool {
+	if limit.Bytes > 0 && add.Bytes > 0 && usage.Bytes+add.Bytes > limit.Bytes {
+		return true
+	}
+	entries := add.Files + add.Dirs
+	return limit.Files > 0 && entries > 0 && usage.Files+usage.Dirs+entries > limit.Files
+}
+
+func (q *Quotas) Check(p string, add internal.Usage) error {
+	p = path.Clean("/" + p)
+	for _, limit := range q.limits {
+		if !within(limit.Path, p) {
+			continue
+		}
+		if err := q.check(limit, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMove holds what is at oldpath against the quotas newpath is in and
+// oldpath is not, moving within a quota changes nothing for it.
+func (q *Quotas) CheckMove(oldpath, newpath string) error {
+	oldpath = path.Clean("/" + oldpath)
+	newpath = path.Clean("/" + newpath)
+
+	var moved *internal.Usage
+	for _, limit := range q.limits {
+		if !within(limit.Path, newpath) || within(limit.Path, oldpath) {
+			continue
+		}
+		if moved == nil {
+			usage, err := q.usage.Usage(oldpath)
+			if err != nil {
+				return err
+			}
+			moved = &usage
+		}
+		if err := q.check(limit, *moved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Quotas) check(limit config.Quota, add internal.Usage) error {
+	usage, err := q.used(limit.Path)
+	if err != nil {
+		return err
+	}
+	if exceeds(limit, usage, add) {
+		return internal.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Report is every quota with what its directory holds.
+func (q *Quotas) Report() ([]Status, error) {
+	statuses := make([]Status, 0, len(q.limits))
+	for _, limit := range q.limits {
+		usage, err := q.used(limit.Path)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, Status{
+			Path:     limit.Path,
+			MaxBytes: limit.Bytes,
+			MaxFiles: limit.Files,
+			Usage:    usage,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,103 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+
+	"fafda/config"
+	"fafda/internal"
+)
+
+type usageMap map[string]internal.Usage
+
+func (m usageMap) Usage(p string) (internal.Usage, error) {
+	usage, ok := m[p]
+	if !ok {
+		return internal.Usage{}, internal.ErrNotFound
+	}
+	return usage, nil
+}
+
+func setupQuotas() *Quotas {
+	usage := usageMap{
+		"/":                 {Bytes: 1000, Files: 10, Dirs: 4},
+		"/teams":            {Bytes: 900, Files: 8, Dirs: 3},
+		"/teams/design":     {Bytes: 900, Files: 8, Dirs: 2},
+		"/teams/design/big": {Bytes: 500, Files: 1},
+		"/other":            {Bytes: 100, Files: 2, Dirs: 1},
+		"/other/dir":        {Bytes: 100, Files: 2, Dirs: 1},
+	}
+	return New(usage, []config.Quota{
+		{Path: "teams/design/", Bytes: 1000, Files: 12},
+		{Path: "/teams/empty", Files: 2},
+	})
+}
+
+func TestCheck(t *testing.T) {
+	q := setupQuotas()
+
+	tests := []struct {
+		name    string
+		path    string
+		add     internal.Usage
+		wantErr error
+	}{
+		{name: "fits", path: "/teams/design/file", add: internal.Usage{Bytes: 100}},
+		{name: "too many bytes", path: "/teams/design/file", add: internal.Usage{Bytes: 101}, wantErr: internal.ErrQuotaExceeded},
+		{name: "last entry", path: "/teams/design/a/b", add: internal.Usage{Files: 1, Dirs: 1}},
+		{name: "too many entries", path: "/teams/design/a/b", add: internal.Usage{Files: 2, Dirs: 1}, wantErr: internal.ErrQuotaExceeded},
+		{name: "shrinking", path: "/teams/design/big", add: internal.Usage{Bytes: -500, Files: -1}},
+		{name: "outside", path: "/teams/designs", add: internal.Usage{Bytes: 1 << 40}},
+		{name: "missing quota directory", path: "/teams/empty", add: internal.Usage{Dirs: 1}},
+		{name: "unlimited bytes", path: "/teams/empty/file", add: internal.Usage{Bytes: 1 << 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := q.Check(tt.path, tt.add); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckMove(t *testing.T) {
+	q := setupQuotas()
+
+	tests := []struct {
+		name     string
+		old, new string
+		wantErr  error
+	}{
+		{name: "within", old: "/teams/design/big", new: "/teams/design/a/big"},
+		{name: "out of", old: "/teams/design/big", new: "/big"},
+		{name: "into", old: "/other/dir", new: "/teams/design/dir", wantErr: internal.ErrQuotaExceeded},
+		{name: "onto", old: "/other/dir", new: "/teams/empty", wantErr: internal.ErrQuotaExceeded},
+		{name: "missing", old: "/missing", new: "/teams/design/missing", wantErr: internal.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := q.CheckMove(tt.old, tt.new); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckMove() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	statuses, err := setupQuotas().Report()
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	want := []Status{
+		{Path: "/teams/design", MaxBytes: 1000, MaxFiles: 12, Usage: internal.Usage{Bytes: 900, Files: 8, Dirs: 2}},
+		{Path: "/teams/empty", MaxFiles: 2},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("Report() = %+v, want %+v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("Report()[%d] = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
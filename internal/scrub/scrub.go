@@ -0,0 +1,196 @@
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/internal"
+	"fafda/internal/partedio"
+)
+
+type Kind string
+
+const (
+	KindMissing Kind = "missing"
+	KindCorrupt Kind = "corrupt"
+	KindSize    Kind = "size"
+	KindError   Kind = "error"
+)
+
+type Problem struct {
+	Path   string `json:"path"`
+	FileId string `json:"fileId"`
+	Kind   Kind   `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type Report struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Files    int       `json:"files"`
+	Bytes    int64     `json:"bytes"`
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) OK() bool { return len(r.Problems) == 0 }
+
+func (r *Report) WriteText(w io.Writer) error {
+	for _, p := range r.Problems {
+		if _, err := fmt.Fprintf(w, "%-8s %s (%s): %s\n", p.Kind, p.Path, p.FileId, p.Detail); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(
+		w, "scrubbed %d files (%d bytes) in %s, %d problems\n",
+		r.Files, r.Bytes, r.Finished.Sub(r.Started).Round(time.Millisecond), len(r.Problems),
+	)
+	return err
+}
+
+type Options struct {
+	// Deep re-downloads every file, verifying part checksums and the
+	// whole-file SHA-256 recorded at upload time.
+	Deep bool
+}
+
+// Run walks every file node in meta and checks the content the driver
+// holds for it. Problems with individual files end up in the report,
+// an error is only returned when the walk itself fails.
+func Run(ctx context.Context, meta internal.MetaFileSystem, driver internal.StorageDriver, opts Options) (*Report, error) {
+	report := &Report{Started: time.Now()}
+	err := walk(ctx, meta, "/", func(node internal.Node) {
+		report.Files++
+		report.Bytes += node.Size()
+		if problem := check(node, driver, opts); problem != nil {
+			report.Problems = append(report.Problems, *problem)
+		}
+	})
+	report.Finished = time.Now()
+	return report, err
+}
+
+func walk(ctx context.Context, meta internal.MetaFileSystem, dir string, fn func(internal.Node)) error {
+	nodes, err := meta.Ls(dir, 0, 0)
+	if err != nil {
+		return fmt.Errorf("list %s: %w", dir, err)
+	}
+	for _, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if node.IsDir() {
+			if err := walk(ctx, meta, path.Join(dir, node.Name()), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if node.IsSymlink() {
+			continue
+		}
+		fn(node)
+	}
+	return nil
+}
+
+func check(node internal.Node, driver internal.StorageDriver, opts Options) *Problem {
+	problem := func(kind Kind, format string, args ...any) *Problem {
+		return &Problem{
+			Path:   node.Path(),
+			FileId: node.Id(),
+			Kind:   kind,
+			Detail: fmt.Sprintf(format, args...),
+		}
+	}
+
+	size, err := driver.GetSize(node.Id())
+	if err != nil {
+		return problem(KindError, "get size: %v", err)
+	}
+	if size != node.Size() {
+		return problem(KindSize, "stored %d bytes, metadata says %d", size, node.Size())
+	}
+
+	if verifier, ok := driver.(internal.Verifier); ok {
+		if err := verifier.Verify(node.Id()); err != nil {
+			return problem(kindOf(err), "%v", err)
+		}
+	}
+
+	if !opts.Deep || size == 0 {
+		return nil
+	}
+
+	reader, err := driver.GetReader(node.Id(), 0)
+	if err != nil {
+		return problem(kindOf(err), "open: %v", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return problem(kindOf(err), "read: %v", err)
+	}
+
+	want := node.Digest().SHA256
+	if got := hex.EncodeToString(hash.Sum(nil)); want != "" && got != want {
+		return problem(KindCorrupt, "sha256 %s, expected %s", got, want)
+	}
+
+	return nil
+}
+
+func kindOf(err error) Kind {
+	switch {
+	case errors.Is(err, internal.ErrDataMissing):
+		return KindMissing
+	case errors.Is(err, internal.ErrDataCorrupt),
+		errors.Is(err, partedio.ErrChecksumMismatch),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return KindCorrupt
+	}
+	return KindError
+}
+
+// Schedule runs a scrub every interval until ctx is done, logging each
+// report. Runs never overlap, a slow scrub delays the next one.
+func Schedule(ctx context.Context, interval time.Duration, meta internal.MetaFileSystem, driver internal.StorageDriver, opts Options) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := Run(ctx, meta, driver, opts)
+		if err != nil {
+			log.Error().Err(err).Str("component", "scrub").Msg("scrub failed")
+			continue
+		}
+		for _, p := range report.Problems {
+			log.Warn().
+				Str("component", "scrub").
+				Str("path", p.Path).
+				Str("fileId", p.FileId).
+				Str("kind", string(p.Kind)).
+				Msg(p.Detail)
+		}
+		log.Info().
+			Str("component", "scrub").
+			Int("files", report.Files).
+			Int64("bytes", report.Bytes).
+			Int("problems", len(report.Problems)).
+			Dur("took", report.Finished.Sub(report.Started)).
+			Msg("scrub finished")
+	}
+}
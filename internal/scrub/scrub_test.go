@@ -0,0 +1,140 @@
+package scrub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"fafda/internal"
+	"fafda/internal/memory"
+)
+
+// verifyingDriver reports the listed file ids as missing from the backend.
+type verifyingDriver struct {
+	internal.StorageDriver
+	missing map[string]bool
+}
+
+func (d *verifyingDriver) Verify(fileId string) error {
+	if d.missing[fileId] {
+		return fmt.Errorf("part 1: %w", internal.ErrDataMissing)
+	}
+	return nil
+}
+
+func put(t *testing.T, meta internal.MetaFileSystem, driver internal.StorageDriver, path, data string) *internal.Node {
+	t.Helper()
+	node, err := meta.Create(path, false)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	writer, err := driver.GetWriter(node.Id())
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := io.WriteString(writer, data); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := meta.Sync(path, int64(len(data))); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := meta.SetDigest(path, writer.(internal.Digester).Digest()); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return node
+}
+
+func overwrite(t *testing.T, driver internal.StorageDriver, fileId, data string) {
+	t.Helper()
+	writer, err := driver.GetWriter(fileId)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	_, _ = io.WriteString(writer, data)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	meta := memory.NewMetaFs()
+	driver := &verifyingDriver{StorageDriver: memory.NewDriver(), missing: map[string]bool{}}
+
+	if err := meta.MkdirAll("/a/b"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	put(t, meta, driver, "/ok.txt", "fine")
+	put(t, meta, driver, "/a/b/empty.txt", "")
+	short := put(t, meta, driver, "/a/short.txt", "hello world")
+	corrupt := put(t, meta, driver, "/a/b/corrupt.txt", "hello world")
+	missing := put(t, meta, driver, "/missing.txt", "gone")
+
+	overwrite(t, driver, short.Id(), "hello")
+	overwrite(t, driver, corrupt.Id(), "HELLO WORLD")
+	driver.missing[missing.Id()] = true
+
+	tests := []struct {
+		name string
+		deep bool
+		want map[string]Kind
+	}{
+		{
+			name: "shallow",
+			want: map[string]Kind{
+				"/a/short.txt": KindSize,
+				"/missing.txt": KindMissing,
+			},
+		},
+		{
+			name: "deep",
+			deep: true,
+			want: map[string]Kind{
+				"/a/short.txt":     KindSize,
+				"/a/b/corrupt.txt": KindCorrupt,
+				"/missing.txt":     KindMissing,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Run(context.Background(), meta, driver, Options{Deep: tt.deep})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if report.Files != 5 {
+				t.Errorf("Files = %d, want 5", report.Files)
+			}
+
+			got := map[string]Kind{}
+			for _, p := range report.Problems {
+				got[p.Path] = p.Kind
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("problems = %v, want %v", got, tt.want)
+			}
+			for path, kind := range tt.want {
+				if got[path] != kind {
+					t.Errorf("%s: kind = %q, want %q", path, got[path], kind)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	meta := memory.NewMetaFs()
+	driver := memory.NewDriver()
+	put(t, meta, driver, "/file.txt", "data")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Run(ctx, meta, driver, Options{}); err != context.Canceled {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,161 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"fafda/internal/github"
+)
+
+const assetColumns = `id, name, username, repository, release_id, release_tag, size, number, checksum`
+
+// AssetStore is github.Assets on SQLite, one row per part.
+type AssetStore struct {
+	db *sql.DB
+}
+
+func NewAssetStore(db *sql.DB) *AssetStore {
+	return &AssetStore{db: db}
+}
+
+func (as *AssetStore) Write(fileId string, assets []*github.Asset) error {
+	tx, err := as.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DELETE FROM assets WHERE file_id = ?`, fileId); err != nil {
+		return err
+	}
+	for _, a := range assets {
+		_, err := tx.Exec(
+			`INSERT INTO assets (file_id, `+assetColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fileId, a.Id, a.Name, a.Username, a.Repository, a.ReleaseId, a.ReleaseTag, a.Size, a.Number, a.Checksum,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (as *AssetStore) Get(fileId string) ([]github.Asset, error) {
+	rows, err := as.db.Query(`SELECT `+assetColumns+` FROM assets WHERE file_id = ? ORDER BY number`, fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []github.Asset
+	for rows.Next() {
+		var a github.Asset
+		if err := rows.Scan(&a.Id, &a.Name, &a.Username, &a.Repository, &a.ReleaseId, &a.ReleaseTag, &a.Size, &a.Number, &a.Checksum); err != nil {
+			return nil, err
+		}
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+func (as *AssetStore) Size(fileId string) (int64, error) {
+	var size int64
+	err := as.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM assets WHERE file_id = ?`, fileId).Scan(&size)
+	return size, err
+}
+
+func (as *AssetStore) Delete(fileId string) error {
+	_, err := as.db.Exec(`DELETE FROM assets WHERE file_id = ?`, fileId)
+	return err
+}
+
+func (as *AssetStore) Refs(fileId string) (map[int]int, error) {
+	rows, err := as.db.Query(
+		`SELECT a.id, COUNT(DISTINCT b.file_id) FROM assets a JOIN assets b ON a.id = b.id AND a.username = b.username
+		WHERE a.file_id = ? AND a.id != 0 GROUP BY a.id`,
+		fileId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := map[int]int{}
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		refs[id] = count
+	}
+	return refs, rows.Err()
+}
+
+func (as *AssetStore) Unused(assets []*github.Asset) ([]*github.Asset, error) {
+	var unused []*github.Asset
+	seen := map[int]bool{}
+	for _, a := range assets {
+		if a.Id == 0 && a.Name == "" || seen[a.Id] {
+			continue
+		}
+		seen[a.Id] = true
+
+		var count int
+		err := as.db.QueryRow(`SELECT COUNT(*) FROM assets WHERE id = ? AND username = ?`, a.Id, a.Username).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			unused = append(unused, a)
+		}
+	}
+	return unused, nil
+}
+
+// Dump calls fn with the JSON encoded asset list of every file.
+func (as *AssetStore) Dump(fn func(fileId string, record json.RawMessage) error) error {
+	var ids []string
+	rows, err := as.db.Query(`SELECT DISTINCT file_id FROM assets ORDER BY file_id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		assets, err := as.Get(id)
+		if err != nil {
+			return err
+		}
+		record, err := json.Marshal(assets)
+		if err != nil {
+			return err
+		}
+		if err := fn(id, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load stores an asset list produced by Dump.
+func (as *AssetStore) Load(fileId string, record json.RawMessage) error {
+	var assets []*github.Asset
+	if err := json.Unmarshal(record, &assets); err != nil {
+		return err
+	}
+	return as.Write(fileId, assets)
+}
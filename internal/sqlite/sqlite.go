@@ -0,0 +1,772 @@
+// Package sqlite keeps metadata in a SQLite database so it can be queried
+// with plain SQL, e.g. the largest files:
+//
+//	SELECT name, size FROM nodes WHERE is_dir = 0 ORDER BY size DESC LIMIT 10
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"fafda/internal"
+)
+
+const schemaVersion = 4
+
+const rootIno = 1
+
+// Nodes form a tree through parent, paths are never stored so a rename
+// only updates one row. Times are unix nanoseconds. Hard links are rows
+// sharing an id, each one carries the count and a copy of the metadata.
+const schema = `
+CREATE TABLE IF NOT EXISTS nodes (
+	ino        INTEGER PRIMARY KEY,
+	parent     INTEGER,
+	name       TEXT    NOT NULL,
+	id         TEXT    NOT NULL DEFAULT '',
+	is_dir     INTEGER NOT NULL,
+	size       INTEGER NOT NULL DEFAULT 0,
+	mode       INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	mod_time   INTEGER NOT NULL,
+	sha256     TEXT    NOT NULL DEFAULT '',
+	md5        TEXT    NOT NULL DEFAULT '',
+	crc32      TEXT    NOT NULL DEFAULT '',
+	target     TEXT    NOT NULL DEFAULT '',
+	links      INTEGER NOT NULL DEFAULT 1,
+	uid        INTEGER NOT NULL DEFAULT 0,
+	gid        INTEGER NOT NULL DEFAULT 0,
+	xattrs     TEXT NOT NULL DEFAULT '',
+	UNIQUE (parent, name)
+);
+CREATE INDEX IF NOT EXISTS nodes_name ON nodes (name);
+CREATE INDEX IF NOT EXISTS nodes_mod_time ON nodes (mod_time);
+CREATE INDEX IF NOT EXISTS nodes_id ON nodes (id);
+
+CREATE TABLE IF NOT EXISTS assets (
+	file_id     TEXT    NOT NULL,
+	number      INTEGER NOT NULL,
+	id          INTEGER NOT NULL,
+	name        TEXT    NOT NULL,
+	username    TEXT    NOT NULL,
+	repository  TEXT    NOT NULL,
+	release_id  INTEGER NOT NULL,
+	release_tag TEXT    NOT NULL,
+	size        INTEGER NOT NULL,
+	checksum    TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (file_id, number)
+);
+CREATE INDEX IF NOT EXISTS assets_release ON assets (username, repository, release_id);
+`
+
+// upgrades[i] brings a database at version i+1 to the next one, append
+// only.
+var upgrades = []string{
+	`ALTER TABLE nodes ADD COLUMN target TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE nodes ADD COLUMN links INTEGER NOT NULL DEFAULT 1;`,
+	`ALTER TABLE nodes ADD COLUMN uid INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE nodes ADD COLUMN gid INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE nodes ADD COLUMN xattrs TEXT NOT NULL DEFAULT '';`,
+}
+
+const nodeColumns = `ino, name, id, is_dir, size, mode, created_at, mod_time, sha256, md5, crc32, target, links, uid, gid, xattrs`
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// Open opens or creates the database at file and makes sure the schema
+// is in place.
+func Open(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+file+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serialises writers, SQLite allows only one anyway
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if version > schemaVersion {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: %d > %d", ErrSchemaTooNew, version, schemaVersion)
+	}
+
+	// A new database starts at 0 and gets the whole schema right away
+	for v := max(version, 1); version > 0 && v < schemaVersion; v++ {
+		if _, err := db.Exec(upgrades[v-1]); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("upgrade schema to %d: %w", v+1, err)
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+type MetaFs struct {
+	db *sql.DB
+}
+
+func NewMetaFs(db *sql.DB) (internal.MetaFileSystem, error) {
+	root := internal.NewNode("/", true)
+	_, err := db.Exec(
+		`INSERT OR IGNORE INTO nodes (ino, parent, name, is_dir, mode, created_at, mod_time)
+		 VALUES (?, NULL, '', 1, ?, ?, ?)`,
+		rootIno, uint32(root.Mode()), time.Now().UnixNano(), time.Now().UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root %w", err)
+	}
+	return &MetaFs{db: db}, nil
+}
+
+func (mf *MetaFs) Name() string {
+	return "sqlite"
+}
+
+// queryer is what lookups need, satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (mf *MetaFs) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := mf.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func lookup(q queryer, pathStr string) (int64, error) {
+	pathStr = path.Clean("/" + pathStr)
+	if pathStr == "/" {
+		return rootIno, nil
+	}
+
+	ino := int64(rootIno)
+	for _, name := range strings.Split(pathStr[1:], "/") {
+		err := q.QueryRow(`SELECT ino FROM nodes WHERE parent = ? AND name = ?`, ino, name).Scan(&ino)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, internal.ErrNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return ino, nil
+}
+
+func resolve(q queryer, pathStr string) (int64, *internal.Node, error) {
+	ino, err := lookup(q, pathStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	row := q.QueryRow(`SELECT `+nodeColumns+` FROM nodes WHERE ino = ?`, ino)
+	_, _, node, err := scanNode(row)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ino, node.SetPath(path.Clean("/" + pathStr)), nil
+}
+
+// parentOf resolves the directory that holds pathStr, which must exist.
+func parentOf(q queryer, pathStr string) (int64, string, error) {
+	pathStr = path.Clean("/" + pathStr)
+	ino, node, err := resolve(q, path.Dir(pathStr))
+	if err != nil || !node.IsDir() {
+		return 0, "", internal.ErrNotFound
+	}
+	return ino, path.Base(pathStr), nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanNode reads nodeColumns, the caller sets the node's path.
+func scanNode(row scanner) (int64, string, *internal.Node, error) {
+	var (
+		ino                         int64
+		name, id                    string
+		isDir                       bool
+		size                        int64
+		mode                        uint32
+		createdAt, modTime          int64
+		sha256sum, md5sum, crc32sum string
+		target                      string
+		links, uid, gid             int
+		xattrs                      string
+	)
+	err := row.Scan(&ino, &name, &id, &isDir, &size, &mode, &createdAt, &modTime, &sha256sum, &md5sum, &crc32sum, &target, &links, &uid, &gid, &xattrs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil, internal.ErrNotFound
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	node := (&internal.Node{}).
+		SetId(id).
+		SetIsDir(isDir).
+		SetSize(size).
+		SetMode(os.FileMode(mode)).
+		SetCreatedAt(time.Unix(0, createdAt)).
+		SetModTime(time.Unix(0, modTime)).
+		SetDigest(internal.Digest{SHA256: sha256sum, MD5: md5sum, CRC32: crc32sum}).
+		SetTarget(target).
+		SetLinks(links).
+		SetUid(uid).
+		SetGid(gid)
+	if xattrs != "" {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(xattrs), &m); err != nil {
+			return 0, "", nil, fmt.Errorf("decode xattrs of %q: %w", name, err)
+		}
+		node.SetXattrs(m)
+	}
+	return ino, name, node, nil
+}
+
+// encodeXattrs stores the extended attributes as a JSON object, which
+// json_extract(xattrs, '$.name') can query, or empty when there are none.
+func encodeXattrs(node *internal.Node) string {
+	if len(node.Xattrs()) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(node.Xattrs())
+	return string(data)
+}
+
+func insert(tx *sql.Tx, parent int64, name string, node *internal.Node) error {
+	digest := node.Digest()
+	_, err := tx.Exec(
+		`INSERT INTO nodes (parent, name, id, is_dir, size, mode, created_at, mod_time, sha256, md5, crc32, target, links, uid, gid, xattrs)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		parent, name, node.Id(), node.IsDir(), node.Size(), uint32(node.Mode()),
+		node.CreatedAt().UnixNano(), node.ModTime().UnixNano(),
+		digest.SHA256, digest.MD5, digest.CRC32, node.Target(), node.Links(), node.Uid(), node.Gid(), encodeXattrs(node),
+	)
+	return err
+}
+
+func update(tx *sql.Tx, ino int64, node *internal.Node) error {
+	digest := node.Digest()
+	_, err := tx.Exec(
+		`UPDATE nodes SET id = ?, is_dir = ?, size = ?, mode = ?, created_at = ?, mod_time = ?,
+		 sha256 = ?, md5 = ?, crc32 = ?, target = ?, links = ?, uid = ?, gid = ?, xattrs = ? WHERE ino = ?`,
+		node.Id(), node.IsDir(), node.Size(), uint32(node.Mode()),
+		node.CreatedAt().UnixNano(), node.ModTime().UnixNano(),
+		digest.SHA256, digest.MD5, digest.CRC32, node.Target(), node.Links(), node.Uid(), node.Gid(), encodeXattrs(node), ino,
+	)
+	return err
+}
+
+// share copies the metadata of a file with hard links to its other rows.
+func share(tx *sql.Tx, ino int64, node *internal.Node) error {
+	if node.IsDir() || node.Links() < 2 {
+		return nil
+	}
+	digest := node.Digest()
+	_, err := tx.Exec(
+		`UPDATE nodes SET size = ?, mode = ?, created_at = ?, mod_time = ?,
+		 sha256 = ?, md5 = ?, crc32 = ?, uid = ?, gid = ?, xattrs = ? WHERE id = ? AND ino != ?`,
+		node.Size(), uint32(node.Mode()),
+		node.CreatedAt().UnixNano(), node.ModTime().UnixNano(),
+		digest.SHA256, digest.MD5, digest.CRC32, node.Uid(), node.Gid(), encodeXattrs(node), node.Id(), ino,
+	)
+	return err
+}
+
+func (mf *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	pathStr = path.Clean(pathStr)
+	file := internal.NewNode(pathStr, isDir)
+
+	err := mf.tx(func(tx *sql.Tx) error {
+		if _, err := lookup(tx, pathStr); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := parentOf(tx, pathStr)
+		if err != nil {
+			return err
+		}
+		return insert(tx, parent, name, file)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (mf *MetaFs) Stat(pathStr string) (*internal.Node, error) {
+	_, node, err := resolve(mf.db, pathStr)
+	return node, err
+}
+
+func (mf *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	var files []internal.Node
+	err := mf.tx(func(tx *sql.Tx) error {
+		var err error
+		files, err = children(tx, pathStr,
+			`SELECT `+nodeColumns+` FROM nodes WHERE parent = ? ORDER BY name LIMIT ? OFFSET ?`,
+			limit, offset,
+		)
+		return err
+	})
+	return files, err
+}
+
+func (mf *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	after, err := internal.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Fetch one extra row to know whether a cursor is needed
+	fetch := -1
+	if limit > 0 {
+		fetch = limit + 1
+	}
+
+	var files []internal.Node
+	err = mf.tx(func(tx *sql.Tx) error {
+		var err error
+		files, err = children(tx, pathStr,
+			`SELECT `+nodeColumns+` FROM nodes WHERE parent = ? AND name > ? ORDER BY name LIMIT ?`,
+			after, fetch,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if limit > 0 && len(files) > limit {
+		files = files[:limit]
+		return files, internal.EncodeCursor(files[limit-1].Name()), nil
+	}
+	return files, "", nil
+}
+
+// children runs query with the directory's ino as first argument.
+func children(tx *sql.Tx, pathStr string, query string, args ...any) ([]internal.Node, error) {
+	ino, dir, err := resolve(tx, pathStr)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir() {
+		return nil, internal.ErrIsNotDir
+	}
+
+	rows, err := tx.Query(query, append([]any{ino}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []internal.Node
+	for rows.Next() {
+		_, name, node, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *node.SetPath(path.Join(dir.Path(), name)))
+	}
+	return files, rows.Err()
+}
+
+func (mf *MetaFs) Chtimes(path string, mtime time.Time) error {
+	return mf.update(path, func(node *internal.Node) error {
+		node.SetModTime(mtime)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Touch(path string) error {
+	_, err := mf.Stat(path)
+	if errors.Is(err, internal.ErrNotFound) {
+		_, err = mf.Create(path, false)
+	}
+	return err
+}
+
+func (mf *MetaFs) Mkdir(path string) error {
+	_, err := mf.Create(path, true)
+	return err
+}
+
+func (mf *MetaFs) MkdirAll(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return nil
+	}
+
+	if _, err := mf.Stat(pathStr); err == nil {
+		return nil
+	}
+
+	parent := path.Dir(pathStr)
+	if parent != "/" {
+		if err := mf.MkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	_, err := mf.Create(pathStr, true)
+	if err != nil && !errors.Is(err, internal.ErrAlreadyExist) {
+		return err
+	}
+	return nil
+}
+
+func (mf *MetaFs) Rename(oldpath, newpath string) error {
+	oldpath = path.Clean(oldpath)
+	newpath = path.Clean(newpath)
+
+	if oldpath == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	if strings.HasPrefix(newpath, oldpath+"/") {
+		return internal.ErrInvalidOperation
+	}
+
+	return mf.tx(func(tx *sql.Tx) error {
+		if _, err := lookup(tx, newpath); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		ino, err := lookup(tx, oldpath)
+		if err != nil {
+			return err
+		}
+
+		newParent, newName, err := parentOf(tx, newpath)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE nodes SET parent = ?, name = ? WHERE ino = ?`, newParent, newName, ino)
+		return err
+	})
+}
+
+func (mf *MetaFs) Remove(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	return mf.tx(func(tx *sql.Tx) error {
+		ino, node, err := resolve(tx, pathStr)
+		if err != nil {
+			return err
+		}
+
+		if node.IsDir() {
+			var children int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM (SELECT 1 FROM nodes WHERE parent = ? LIMIT 1)`, ino).Scan(&children); err != nil {
+				return err
+			}
+			if children > 0 {
+				return internal.ErrNotEmpty
+			}
+		}
+
+		if _, err := tx.Exec(`DELETE FROM nodes WHERE ino = ?`, ino); err != nil {
+			return err
+		}
+		if node.IsDir() || node.Links() < 2 {
+			return nil
+		}
+		_, err = tx.Exec(`UPDATE nodes SET links = links - 1 WHERE id = ?`, node.Id())
+		return err
+	})
+}
+
+func (mf *MetaFs) RemoveAll(pathStr string) error {
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		return internal.ErrInvalidRootOperation
+	}
+
+	return mf.tx(func(tx *sql.Tx) error {
+		ino, err := lookup(tx, pathStr)
+		if errors.Is(err, internal.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		linked, err := linkedIds(tx, ino)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			WITH RECURSIVE subtree (ino) AS (
+				SELECT ?
+				UNION ALL
+				SELECT nodes.ino FROM nodes JOIN subtree ON nodes.parent = subtree.ino
+			)
+			DELETE FROM nodes WHERE ino IN subtree`, ino); err != nil {
+			return err
+		}
+
+		// Recount what is left of the files with hard links outside
+		for _, id := range linked {
+			if _, err := tx.Exec(
+				`UPDATE nodes SET links = (SELECT COUNT(*) FROM nodes AS l WHERE l.id = nodes.id) WHERE id = ?`, id,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// linkedIds returns the ids of the files with hard links in the tree at ino.
+func linkedIds(tx *sql.Tx, ino int64) ([]string, error) {
+	rows, err := tx.Query(`
+		WITH RECURSIVE subtree (ino) AS (
+			SELECT ?
+			UNION ALL
+			SELECT nodes.ino FROM nodes JOIN subtree ON nodes.parent = subtree.ino
+		)
+		SELECT DISTINCT id FROM nodes WHERE ino IN subtree AND links > 1 AND is_dir = 0`, ino)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (mf *MetaFs) Sync(path string, size int64) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if !node.IsDir() {
+			node.SetSize(size).SetDigest(internal.Digest{})
+		}
+		node.SetModTime(time.Now())
+		return nil
+	})
+}
+
+func (mf *MetaFs) SetDigest(path string, digest internal.Digest) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		node.SetDigest(digest)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Chmod(path string, mode os.FileMode) error {
+	return mf.update(path, func(node *internal.Node) error {
+		node.Chmod(mode)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Chown(path string, uid, gid int) error {
+	return mf.update(path, func(node *internal.Node) error {
+		node.Chown(uid, gid)
+		return nil
+	})
+}
+
+func (mf *MetaFs) GetXattr(path, name string) (string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return node.Xattr(name)
+}
+
+func (mf *MetaFs) SetXattr(path, name, value string) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if err := internal.CheckXattr(node, name, value); err != nil {
+			return err
+		}
+		node.SetXattr(name, value)
+		return nil
+	})
+}
+
+func (mf *MetaFs) ListXattr(path string) ([]string, error) {
+	node, err := mf.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return node.XattrNames(), nil
+}
+
+func (mf *MetaFs) RemoveXattr(path, name string) error {
+	return mf.update(path, func(node *internal.Node) error {
+		if _, err := node.Xattr(name); err != nil {
+			return err
+		}
+		node.RemoveXattr(name)
+		return nil
+	})
+}
+
+func (mf *MetaFs) Put(node *internal.Node) error {
+	pathStr := path.Clean(node.Path())
+	if pathStr == "/" && !node.IsDir() {
+		return internal.ErrInvalidRootOperation
+	}
+
+	return mf.tx(func(tx *sql.Tx) error {
+		if ino, existing, err := resolve(tx, pathStr); err == nil {
+			if existing.IsDir() != node.IsDir() {
+				return internal.ErrAlreadyExist
+			}
+			return update(tx, ino, node)
+		}
+
+		parent, name, err := parentOf(tx, pathStr)
+		if err != nil {
+			return err
+		}
+		return insert(tx, parent, name, node)
+	})
+}
+
+func (mf *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	dst = path.Clean(dst)
+
+	var file *internal.Node
+	err := mf.tx(func(tx *sql.Tx) error {
+		_, node, err := resolve(tx, src)
+		if err != nil {
+			return err
+		}
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		if _, err := lookup(tx, dst); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := parentOf(tx, dst)
+		if err != nil {
+			return err
+		}
+		file = node.CopyTo(dst)
+		return insert(tx, parent, name, file)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (mf *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	pathStr = path.Clean(pathStr)
+	link := internal.NewSymlink(pathStr, target)
+
+	err := mf.tx(func(tx *sql.Tx) error {
+		if _, err := lookup(tx, pathStr); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := parentOf(tx, pathStr)
+		if err != nil {
+			return err
+		}
+		return insert(tx, parent, name, link)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func (mf *MetaFs) Link(oldpath, newpath string) error {
+	return mf.tx(func(tx *sql.Tx) error {
+		_, node, err := resolve(tx, oldpath)
+		if err != nil {
+			return err
+		}
+		if node.IsDir() {
+			return internal.ErrIsDir
+		}
+		if node.IsSymlink() {
+			return internal.ErrNotSupported
+		}
+		if _, err := lookup(tx, newpath); err == nil {
+			return internal.ErrAlreadyExist
+		}
+
+		parent, name, err := parentOf(tx, newpath)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE nodes SET links = links + 1 WHERE id = ?`, node.Id()); err != nil {
+			return err
+		}
+		return insert(tx, parent, name, node.SetLinks(node.Links()+1))
+	})
+}
+
+func (mf *MetaFs) Close() error {
+	return mf.db.Close()
+}
+
+// update applies fn to the node at path and stores it.
+func (mf *MetaFs) update(pathStr string, fn func(node *internal.Node) error) error {
+	return mf.tx(func(tx *sql.Tx) error {
+		ino, node, err := resolve(tx, pathStr)
+		if err != nil {
+			return err
+		}
+		if err := fn(node); err != nil {
+			return err
+		}
+		if err := update(tx, ino, node); err != nil {
+			return err
+		}
+		return share(tx, ino, node)
+	})
+}
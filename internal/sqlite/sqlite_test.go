@@ -0,0 +1,492 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fafda/internal"
+	"fafda/internal/github"
+)
+
+func setupTestDB(t *testing.T) internal.MetaFileSystem {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+	return provider
+}
+
+func names(nodes []internal.Node) string {
+	var got []string
+	for _, node := range nodes {
+		got = append(got, node.Name())
+	}
+	return strings.Join(got, ",")
+}
+
+func TestCreate(t *testing.T) {
+	provider := setupTestDB(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		isDir   bool
+		wantErr error
+	}{
+		{name: "create directory", path: "/test", isDir: true},
+		{name: "create file in directory", path: "/test/file.txt"},
+		{name: "create duplicate directory", path: "/test", isDir: true, wantErr: internal.ErrAlreadyExist},
+		{name: "create in missing directory", path: "/missing/file.txt", wantErr: internal.ErrNotFound},
+		{name: "create under file", path: "/test/file.txt/child", wantErr: internal.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := provider.Create(tt.path, tt.isDir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := provider.Stat(tt.path)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if got.Id() != node.Id() || got.IsDir() != tt.isDir || got.Path() != tt.path {
+				t.Errorf("Stat() = %+v, want %+v", got, node)
+			}
+		})
+	}
+}
+
+func TestLs(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.MkdirAll("/dir/sub"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"c", "a", "b"} {
+		if err := provider.Touch("/dir/" + name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		limit   int
+		offset  int
+		want    string
+		wantErr error
+	}{
+		{name: "all", path: "/dir", want: "a,b,c,sub"},
+		{name: "limit", path: "/dir", limit: 2, want: "a,b"},
+		{name: "offset", path: "/dir", limit: 2, offset: 2, want: "c,sub"},
+		{name: "root", path: "/", want: "dir"},
+		{name: "file", path: "/dir/a", wantErr: internal.ErrIsNotDir},
+		{name: "missing", path: "/missing", wantErr: internal.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := provider.Ls(tt.path, tt.limit, tt.offset)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Ls() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := names(nodes); got != tt.want {
+				t.Errorf("Ls() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLsCursor(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, name := range []string{"b", "d", "f", "h"} {
+		if err := provider.Touch("/dir/" + name); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for page := 0; ; page++ {
+		nodes, next, err := provider.LsCursor("/dir", 2, cursor)
+		if err != nil {
+			t.Fatalf("LsCursor() error = %v", err)
+		}
+		got = append(got, names(nodes))
+		if page == 0 {
+			// Entries before the cursor must not shift the listing
+			for _, name := range []string{"a", "c", "g"} {
+				if err := provider.Touch("/dir/" + name); err != nil {
+					t.Fatalf("Touch() error = %v", err)
+				}
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	want := "b,d,f,g,h"
+	if strings.Join(got, ",") != want {
+		t.Errorf("LsCursor() pages = %v, want %v", strings.Join(got, ","), want)
+	}
+	if _, _, err := provider.LsCursor("/dir", 2, "not base64!"); !errors.Is(err, internal.ErrInvalidCursor) {
+		t.Errorf("LsCursor() bad cursor error = %v, want %v", err, internal.ErrInvalidCursor)
+	}
+}
+
+func TestRename(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.MkdirAll("/a/b/c"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/a/b/c/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/taken"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		oldpath string
+		newpath string
+		wantErr error
+	}{
+		{name: "root", oldpath: "/", newpath: "/x", wantErr: internal.ErrInvalidRootOperation},
+		{name: "into itself", oldpath: "/a", newpath: "/a/b/x", wantErr: internal.ErrInvalidOperation},
+		{name: "existing target", oldpath: "/a", newpath: "/taken", wantErr: internal.ErrAlreadyExist},
+		{name: "missing source", oldpath: "/missing", newpath: "/x", wantErr: internal.ErrNotFound},
+		{name: "missing target parent", oldpath: "/a", newpath: "/missing/a", wantErr: internal.ErrNotFound},
+		{name: "subtree", oldpath: "/a", newpath: "/z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := provider.Rename(tt.oldpath, tt.newpath); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rename() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	node, err := provider.Stat("/z/b/c/file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Path() != "/z/b/c/file" {
+		t.Errorf("Path() = %q, want %q", node.Path(), "/z/b/c/file")
+	}
+	if _, err := provider.Stat("/a/b/c/file"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() old path error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	provider := setupTestDB(t)
+
+	for i := 0; i < 3; i++ {
+		if err := provider.MkdirAll(fmt.Sprintf("/tree/%d/deep", i)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if err := provider.Touch(fmt.Sprintf("/tree/%d/deep/file", i)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	if err := provider.Remove("/tree"); !errors.Is(err, internal.ErrNotEmpty) {
+		t.Errorf("Remove() non-empty error = %v, want %v", err, internal.ErrNotEmpty)
+	}
+	if err := provider.Remove("/tree/0/deep/file"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if err := provider.Remove("/"); !errors.Is(err, internal.ErrInvalidRootOperation) {
+		t.Errorf("Remove() root error = %v, want %v", err, internal.ErrInvalidRootOperation)
+	}
+
+	if err := provider.RemoveAll("/tree"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := provider.RemoveAll("/tree"); err != nil {
+		t.Errorf("RemoveAll() missing error = %v", err)
+	}
+	if nodes, err := provider.Ls("/", 0, 0); err != nil || len(nodes) != 0 {
+		t.Errorf("Ls() after RemoveAll = %v, %v, want empty", names(nodes), err)
+	}
+
+	// Paths must be free again, no orphaned rows may block them
+	if err := provider.MkdirAll("/tree/0/deep"); err != nil {
+		t.Errorf("MkdirAll() after RemoveAll error = %v", err)
+	}
+}
+
+func TestPutAndSync(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	digest := internal.Digest{SHA256: "abc", MD5: "def"}
+	file := internal.NewNode("/dir/file.txt", false).SetId("restored-id").SetSize(42).SetModTime(mtime).SetDigest(digest)
+
+	if err := provider.Put(file); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := provider.Put(internal.NewNode("/dir", false)); !errors.Is(err, internal.ErrAlreadyExist) {
+		t.Errorf("Put() file over directory error = %v, want %v", err, internal.ErrAlreadyExist)
+	}
+	if err := provider.Put(internal.NewNode("/missing/file", false)); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Put() missing parent error = %v, want %v", err, internal.ErrNotFound)
+	}
+
+	node, err := provider.Stat("/dir/file.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Id() != "restored-id" || node.Size() != 42 || !node.ModTime().Equal(mtime) || node.Digest() != digest {
+		t.Errorf("Stat() = %+v, want %+v", node, file)
+	}
+
+	if err := provider.Sync("/dir/file.txt", 7); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	node, _ = provider.Stat("/dir/file.txt")
+	if node.Size() != 7 || node.Digest() != (internal.Digest{}) {
+		t.Errorf("Sync() left size %d digest %+v, want 7 and no digest", node.Size(), node.Digest())
+	}
+	if err := provider.SetDigest("/dir", digest); !errors.Is(err, internal.ErrIsDir) {
+		t.Errorf("SetDigest() on dir error = %v, want %v", err, internal.ErrIsDir)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.Mkdir("/dir"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, p := range []string{"/dir/link", "/other"} {
+		if err := provider.Link("/file", p); err != nil {
+			t.Fatalf("Link() error = %v", err)
+		}
+	}
+
+	// Every row of the file follows a change made through one of them
+	if err := provider.Sync("/dir/link", 42); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	file, err := provider.Stat("/file")
+	if err != nil || file.Size() != 42 || file.Links() != 3 {
+		t.Fatalf("Stat() = %v, %v, want 42 bytes and 3 links", file, err)
+	}
+
+	if err := provider.RemoveAll("/dir"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := provider.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	other, err := provider.Stat("/other")
+	if err != nil || other.Id() != file.Id() || other.Size() != 42 || other.Links() != 1 {
+		t.Errorf("Stat() of the last link = %v, %v, want id %s, 42 bytes and 1 link", other, err, file.Id())
+	}
+	if err := provider.Link("/", "/root"); !errors.Is(err, internal.ErrIsDir) {
+		t.Errorf("Link() of a directory error = %v, want %v", err, internal.ErrIsDir)
+	}
+
+	if _, err := provider.Symlink("/other", "/symlink"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	link, err := provider.Stat("/symlink")
+	if err != nil || !link.IsSymlink() || link.Target() != "/other" {
+		t.Errorf("Stat() of a symlink = %+v, %v, want one to /other", link, err)
+	}
+}
+
+func TestChmodChown(t *testing.T) {
+	provider := setupTestDB(t)
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/file", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := provider.Chmod("/file", 0600|os.ModeSetuid); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := provider.Chown("/file", 1000, 100); err != nil {
+		t.Fatalf("Chown() error = %v", err)
+	}
+	if err := provider.Chown("/file", -1, 200); err != nil {
+		t.Fatalf("Chown() error = %v", err)
+	}
+	for _, p := range []string{"/file", "/link"} {
+		node, err := provider.Stat(p)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		if node.Mode() != 0600|os.ModeSetuid || node.Uid() != 1000 || node.Gid() != 200 {
+			t.Errorf("Stat(%s) = %v %d:%d, want %v 1000:200", p, node.Mode(), node.Uid(), node.Gid(), 0600|os.ModeSetuid)
+		}
+	}
+
+	if err := provider.Chmod("/", 0700); err != nil {
+		t.Fatalf("Chmod() of a directory error = %v", err)
+	}
+	if root, _ := provider.Stat("/"); root.Mode() != 0700|os.ModeDir {
+		t.Errorf("Stat(/) mode = %v, want %v", root.Mode(), 0700|os.ModeDir)
+	}
+	if err := provider.Chmod("/missing", 0600); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Chmod() of a missing file error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	// The nodes table as version 1 made it
+	_, err = db.Exec(`
+		CREATE TABLE nodes (
+			ino INTEGER PRIMARY KEY, parent INTEGER, name TEXT NOT NULL, id TEXT NOT NULL DEFAULT '',
+			is_dir INTEGER NOT NULL, size INTEGER NOT NULL DEFAULT 0, mode INTEGER NOT NULL,
+			created_at INTEGER NOT NULL, mod_time INTEGER NOT NULL, sha256 TEXT NOT NULL DEFAULT '',
+			md5 TEXT NOT NULL DEFAULT '', crc32 TEXT NOT NULL DEFAULT '', UNIQUE (parent, name)
+		);
+		INSERT INTO nodes (ino, parent, name, is_dir, mode, created_at, mod_time) VALUES (1, NULL, '', 1, 0, 0, 0);
+		INSERT INTO nodes (parent, name, id, is_dir, size, mode, created_at, mod_time) VALUES (1, 'old', 'old-id', 0, 5, 420, 0, 0);
+		PRAGMA user_version = 1;`)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	_ = db.Close()
+
+	db, err = Open(file)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	provider, err := NewMetaFs(db)
+	if err != nil {
+		t.Fatalf("NewMetaFs() error = %v", err)
+	}
+	defer provider.Close()
+
+	node, err := provider.Stat("/old")
+	if err != nil || node.Id() != "old-id" || node.Links() != 1 || node.Target() != "" || node.Uid() != 0 {
+		t.Errorf("Stat() after upgrade = %+v, %v", node, err)
+	}
+}
+
+func TestAssetStore(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := NewMetaFs(db); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	store := NewAssetStore(db)
+
+	parts := []*github.Asset{
+		{Id: 2, Name: "b", Number: 2, Size: 5},
+		{Id: 1, Name: "a", Number: 1, Size: 10},
+	}
+	if err := store.Write("file", parts); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got, err := store.Get("file")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Number != 1 || got[1].Number != 2 {
+		t.Errorf("Get() = %+v, want parts ordered by number", got)
+	}
+	if size, _ := store.Size("file"); size != 15 {
+		t.Errorf("Size() = %d, want 15", size)
+	}
+
+	if err := store.Delete("file"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got, _ := store.Get("file"); len(got) != 0 {
+		t.Errorf("Get() after Delete = %+v, want none", got)
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	provider := setupTestDB(t)
+
+	if err := provider.Touch("/file"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := provider.Link("/file", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for name, value := range map[string]string{"origin": "https://example.com", "content-type": "text/plain"} {
+		if err := provider.SetXattr("/file", name, value); err != nil {
+			t.Fatalf("SetXattr() error = %v", err)
+		}
+	}
+	if err := provider.Rename("/file", "/renamed"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	// Renames keep the attributes and hard links share them
+	for _, p := range []string{"/renamed", "/link"} {
+		value, err := provider.GetXattr(p, "origin")
+		if err != nil || value != "https://example.com" {
+			t.Errorf("GetXattr(%s) = %q, %v, want %q", p, value, err, "https://example.com")
+		}
+	}
+	if err := provider.RemoveXattr("/link", "origin"); err != nil {
+		t.Fatalf("RemoveXattr() error = %v", err)
+	}
+	names, err := provider.ListXattr("/renamed")
+	if err != nil || len(names) != 1 || names[0] != "content-type" {
+		t.Errorf("ListXattr() = %v, %v, want [content-type]", names, err)
+	}
+
+	if _, err := provider.GetXattr("/renamed", "origin"); !errors.Is(err, internal.ErrNoXattr) {
+		t.Errorf("GetXattr() of a removed one error = %v, want %v", err, internal.ErrNoXattr)
+	}
+	if err := provider.RemoveXattr("/renamed", "origin"); !errors.Is(err, internal.ErrNoXattr) {
+		t.Errorf("RemoveXattr() of a removed one error = %v, want %v", err, internal.ErrNoXattr)
+	}
+	if err := provider.SetXattr("/renamed", "", "value"); !errors.Is(err, internal.ErrInvalidXattr) {
+		t.Errorf("SetXattr() without a name error = %v, want %v", err, internal.ErrInvalidXattr)
+	}
+	if err := provider.SetXattr("/missing", "origin", "value"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("SetXattr() of a missing file error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
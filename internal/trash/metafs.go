@@ -0,0 +1,314 @@
+package trash
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"fafda/internal"
+)
+
+// MetaFs moves what it is asked to delete into the trash of its user,
+// everything else goes straight to the wrapped MetaFileSystem. Users only
+// get to the trash of their own, /.trash itself is left out of listings
+// and the trash of others looks like it doesn't exist.
+type MetaFs struct {
+	internal.MetaFileSystem
+
+	trash    *Trash
+	user     string
+	uid, gid int
+}
+
+func (m *MetaFs) Unwrap() internal.MetaFileSystem { return m.MetaFileSystem }
+
+// own reports whether p is the trash of the user or inside it. Without a
+// user, as over HTTP, there is no trash to look at.
+func (m *MetaFs) own(p string) bool {
+	if m.user == "" {
+		return false
+	}
+	dir := userDir(m.user)
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// check cleans pathStr and refuses it when it is in the trash of someone
+// else. /.trash itself can only be looked at.
+func (m *MetaFs) check(pathStr string, write bool) (string, error) {
+	p := clean(pathStr)
+	switch {
+	case !inTrash(p) || m.own(p):
+		return p, nil
+	case p != Dir:
+		return p, internal.ErrNotFound
+	case write:
+		return p, internal.ErrPermission
+	}
+	return p, nil
+}
+
+// hidden reports whether the child name of dir is left out of listings.
+func (m *MetaFs) hidden(dir, name string) bool {
+	p := path.Join(dir, name)
+	return p == Dir || inTrash(p) && !m.own(p)
+}
+
+func (m *MetaFs) Stat(pathStr string) (*internal.Node, error) {
+	p, err := m.check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Stat(p)
+}
+
+func (m *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	p, err := m.check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := m.MetaFileSystem.Ls(p, limit, offset)
+	return m.visible(p, nodes), err
+}
+
+func (m *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	p, err := m.check(pathStr, false)
+	if err != nil {
+		return nil, "", err
+	}
+	nodes, next, err := m.MetaFileSystem.LsCursor(p, limit, cursor)
+	return m.visible(p, nodes), next, err
+}
+
+// visible drops the hidden ones of nodes, the children of dir. A page of
+// a listing can come out short or even empty for it.
+func (m *MetaFs) visible(dir string, nodes []internal.Node) []internal.Node {
+	if dir != "/" && dir != Dir {
+		return nodes
+	}
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if !m.hidden(dir, node.Name()) {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+func (m *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Create(p, isDir)
+}
+
+func (m *MetaFs) Chtimes(pathStr string, mtime time.Time) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chtimes(p, mtime)
+}
+
+func (m *MetaFs) Touch(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Touch(p)
+}
+
+func (m *MetaFs) Mkdir(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Mkdir(p)
+}
+
+func (m *MetaFs) MkdirAll(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.MkdirAll(p)
+}
+
+func (m *MetaFs) Rename(oldpath, newpath string) error {
+	oldp, err := m.check(oldpath, true)
+	if err != nil {
+		return err
+	}
+	newp, err := m.check(newpath, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Rename(oldp, newp)
+}
+
+func (m *MetaFs) Sync(pathStr string, size int64) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Sync(p, size)
+}
+
+func (m *MetaFs) SetDigest(pathStr string, digest internal.Digest) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.SetDigest(p, digest)
+}
+
+func (m *MetaFs) Put(node *internal.Node) error {
+	if _, err := m.check(node.Path(), true); err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Put(node)
+}
+
+func (m *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	srcp, err := m.check(src, false)
+	if err != nil {
+		return nil, err
+	}
+	dstp, err := m.check(dst, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Copy(srcp, dstp)
+}
+
+func (m *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Symlink(target, p)
+}
+
+func (m *MetaFs) Link(oldpath, newpath string) error {
+	oldp, err := m.check(oldpath, false)
+	if err != nil {
+		return err
+	}
+	newp, err := m.check(newpath, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Link(oldp, newp)
+}
+
+func (m *MetaFs) Chmod(pathStr string, mode os.FileMode) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chmod(p, mode)
+}
+
+func (m *MetaFs) Chown(pathStr string, uid, gid int) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chown(p, uid, gid)
+}
+
+func (m *MetaFs) GetXattr(pathStr, name string) (string, error) {
+	p, err := m.check(pathStr, false)
+	if err != nil {
+		return "", err
+	}
+	return m.MetaFileSystem.GetXattr(p, name)
+}
+
+func (m *MetaFs) SetXattr(pathStr, name, value string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.SetXattr(p, name, value)
+}
+
+func (m *MetaFs) ListXattr(pathStr string) ([]string, error) {
+	p, err := m.check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.ListXattr(p)
+}
+
+func (m *MetaFs) RemoveXattr(pathStr, name string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.RemoveXattr(p, name)
+}
+
+func (m *MetaFs) Remove(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return m.MetaFileSystem.Remove(p)
+	}
+	if inTrash(p) {
+		return m.trash.delete(p, m.MetaFileSystem.Remove)
+	}
+
+	node, err := m.Stat(p)
+	if err != nil {
+		return err
+	}
+	if node.IsDir() {
+		children, _, err := m.LsCursor(p, 1, "")
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return internal.ErrNotEmpty
+		}
+	}
+	return m.trash.move(m.user, m.uid, m.gid, p)
+}
+
+func (m *MetaFs) RemoveAll(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return m.MetaFileSystem.RemoveAll(p)
+	}
+	if inTrash(p) {
+		return m.trash.delete(p, m.MetaFileSystem.RemoveAll)
+	}
+
+	if _, err := m.Stat(p); err != nil {
+		if errors.Is(err, internal.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return m.trash.move(m.user, m.uid, m.gid, p)
+}
+
+// Discard deletes pathStr and everything below it for good instead of
+// moving it to the trash, for undoing what was only just made.
+func (m *MetaFs) Discard(pathStr string) error {
+	p, err := m.check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.RemoveAll(p)
+}
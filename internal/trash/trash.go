@@ -0,0 +1,266 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"fafda/internal"
+)
+
+// Dir holds a directory per user with what they deleted. Each entry is
+// named after when it was deleted and the path it was deleted from, e.g.
+// /.trash/fafda/20261018T101500.000000000Z_%2Fdocs%2Freport.pdf
+const Dir = "/.trash"
+
+const stampLayout = "20060102T150405.000000000Z"
+
+var ErrNotEntry = errors.New("not a trash entry")
+
+// Trash moves deleted paths aside and deletes them for good, content
+// included, once they are older than the retention.
+type Trash struct {
+	meta      internal.MetaFileSystem
+	driver    internal.StorageDriver
+	retention time.Duration
+	now       func() time.Time
+}
+
+type Entry struct {
+	Path     string    `json:"path"`     // where it is in the trash
+	Original string    `json:"original"` // where it was deleted from
+	User     string    `json:"user"`
+	Deleted  time.Time `json:"deleted"`
+	IsDir    bool      `json:"isDir"`
+	Size     int64     `json:"size"`
+}
+
+func New(meta internal.MetaFileSystem, driver internal.StorageDriver, retention time.Duration) *Trash {
+	return &Trash{meta: meta, driver: driver, retention: retention, now: time.Now}
+}
+
+// For is the wrapped MetaFileSystem with deletes going to the trash of
+// user, which is owned by uid and gid. Deleting something that is in the
+// trash already is final.
+func (t *Trash) For(user string, uid, gid int) internal.MetaFileSystem {
+	return &MetaFs{MetaFileSystem: t.meta, trash: t, user: user, uid: uid, gid: gid}
+}
+
+func entryName(deleted time.Time, original string) string {
+	return deleted.UTC().Format(stampLayout) + "_" + url.PathEscape(original)
+}
+
+func parseEntry(name string) (time.Time, string, bool) {
+	stamp, escaped, ok := strings.Cut(name, "_")
+	if !ok {
+		return time.Time{}, "", false
+	}
+	deleted, err := time.Parse(stampLayout, stamp)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	original, err := url.PathUnescape(escaped)
+	if err != nil || !path.IsAbs(original) {
+		return time.Time{}, "", false
+	}
+	return deleted, original, true
+}
+
+func clean(pathStr string) string {
+	return path.Clean("/" + pathStr)
+}
+
+func inTrash(p string) bool {
+	return p == Dir || strings.HasPrefix(p, Dir+"/")
+}
+
+func userDir(user string) string {
+	if user == "" {
+		user = "_"
+	}
+	return path.Join(Dir, url.PathEscape(user))
+}
+
+// move puts p into the trash of user, the parents it leaves behind stay.
+func (t *Trash) move(user string, uid, gid int, p string) error {
+	dir, err := t.userTrash(user, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	deleted := t.now()
+	for {
+		err := t.meta.Rename(p, path.Join(dir, entryName(deleted, p)))
+		if !errors.Is(err, internal.ErrAlreadyExist) {
+			return err
+		}
+		// Deleted twice within the same nanosecond
+		deleted = deleted.Add(time.Nanosecond)
+	}
+}
+
+// userTrash returns the trash directory of user, creating it for them
+// alone. /.trash lets everyone through to theirs but not list it.
+func (t *Trash) userTrash(user string, uid, gid int) (string, error) {
+	dir := userDir(user)
+	if _, err := t.meta.Stat(dir); !errors.Is(err, internal.ErrNotFound) {
+		return dir, err
+	}
+
+	if _, err := t.meta.Stat(Dir); errors.Is(err, internal.ErrNotFound) {
+		if err := t.meta.Mkdir(Dir); err != nil && !errors.Is(err, internal.ErrAlreadyExist) {
+			return "", err
+		}
+		if err := t.meta.Chmod(Dir, 0711); err != nil {
+			return "", err
+		}
+	}
+	if err := t.meta.Mkdir(dir); err != nil && !errors.Is(err, internal.ErrAlreadyExist) {
+		return "", err
+	}
+	if err := t.meta.Chown(dir, uid, gid); err != nil {
+		return "", err
+	}
+	return dir, t.meta.Chmod(dir, 0700)
+}
+
+// delete removes p with remove and then the content of every file that
+// was below it. Metadata goes first, so a failure leaves unused content
+// behind rather than files without content.
+func (t *Trash) delete(p string, remove func(string) error) error {
+	ids, err := internal.RemovedIds(t.meta, p)
+	if err != nil && !errors.Is(err, internal.ErrNotFound) {
+		return err
+	}
+	if err := remove(p); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := internal.DropContent(t.driver, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// List returns the entries in the trash of user, or of everyone when user
+// is empty, oldest first.
+func (t *Trash) List(user string) ([]Entry, error) {
+	var dirs []string
+	if user != "" {
+		dirs = []string{userDir(user)}
+	} else {
+		users, _, err := t.meta.LsCursor(Dir, 0, "")
+		if errors.Is(err, internal.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			dirs = append(dirs, path.Join(Dir, u.Name()))
+		}
+	}
+
+	var entries []Entry
+	for _, dir := range dirs {
+		owner, err := url.PathUnescape(path.Base(dir))
+		if err != nil {
+			owner = path.Base(dir)
+		}
+
+		nodes, _, err := t.meta.LsCursor(dir, 0, "")
+		if errors.Is(err, internal.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			deleted, original, ok := parseEntry(node.Name())
+			if !ok {
+				continue
+			}
+			entries = append(entries, Entry{
+				Path:     path.Join(dir, node.Name()),
+				Original: original,
+				User:     owner,
+				Deleted:  deleted,
+				IsDir:    node.IsDir(),
+				Size:     node.Size(),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Restore moves the entry at p back to where it was deleted from, which
+// has to be free. Missing parent directories are created again.
+func (t *Trash) Restore(p string) (string, error) {
+	p = clean(p)
+	_, original, ok := parseEntry(path.Base(p))
+	if !ok || path.Dir(path.Dir(p)) != Dir {
+		return "", ErrNotEntry
+	}
+
+	if err := t.meta.MkdirAll(path.Dir(original)); err != nil {
+		return "", err
+	}
+	if err := t.meta.Rename(p, original); err != nil {
+		return "", err
+	}
+	return original, nil
+}
+
+// Purge deletes the entries older than the retention for good and
+// returns how many went.
+func (t *Trash) Purge() (int, error) {
+	entries, err := t.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := t.now().Add(-t.retention)
+	purged := 0
+	var errs []error
+	for _, entry := range entries {
+		if entry.Deleted.After(cutoff) {
+			continue
+		}
+		if err := t.delete(entry.Path, t.meta.RemoveAll); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(errs...)
+}
+
+// Schedule purges expired entries every interval until ctx is done.
+func (t *Trash) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := t.Purge()
+		if err != nil {
+			log.Error().Err(err).Str("component", "trash").Msg("purge failed")
+		}
+		if purged > 0 {
+			log.Info().Str("component", "trash").Int("entries", purged).Msg("trash purged")
+		}
+	}
+}
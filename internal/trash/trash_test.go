@@ -0,0 +1,309 @@
+package trash
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"fafda/internal"
+	"fafda/internal/memory"
+)
+
+func setupTrash(t *testing.T) (*Trash, internal.MetaFileSystem) {
+	t.Helper()
+	tr := New(memory.NewMetaFs(), memory.NewDriver(), time.Hour)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+	return tr, tr.For("fafda", 1000, 1000)
+}
+
+func writeFile(t *testing.T, tr *Trash, p, content string) *internal.Node {
+	t.Helper()
+	node, err := tr.meta.Create(p, false)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	w, err := tr.driver.GetWriter(node.Id())
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := tr.meta.Sync(p, int64(len(content))); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return node
+}
+
+func TestRemoveAndRestore(t *testing.T) {
+	tr, meta := setupTrash(t)
+	if err := meta.MkdirAll("/docs/old"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	node := writeFile(t, tr, "/docs/old/report.txt", "report")
+
+	if err := meta.RemoveAll("/docs"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := meta.Stat("/docs"); !errors.Is(err, internal.ErrNotFound) {
+		t.Fatalf("Stat() after RemoveAll error = %v, want %v", err, internal.ErrNotFound)
+	}
+
+	entries, err := tr.List("fafda")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("List() = %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Original != "/docs" || !entry.IsDir || entry.User != "fafda" || !entry.Deleted.Equal(tr.now()) {
+		t.Errorf("List() = %+v", entry)
+	}
+
+	original, err := tr.Restore(entry.Path)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if original != "/docs" {
+		t.Errorf("Restore() = %q, want %q", original, "/docs")
+	}
+	restored, err := meta.Stat("/docs/old/report.txt")
+	if err != nil {
+		t.Fatalf("Stat() after Restore error = %v", err)
+	}
+	if restored.Id() != node.Id() {
+		t.Errorf("restored id = %q, want %q", restored.Id(), node.Id())
+	}
+	if size, _ := tr.driver.GetSize(node.Id()); size != int64(len("report")) {
+		t.Errorf("content size = %d, want %d", size, len("report"))
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	tr, meta := setupTrash(t)
+	writeFile(t, tr, "/file", "old")
+	if err := meta.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	writeFile(t, tr, "/file", "new")
+
+	entries, err := tr.List("")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("List() = %v, %v", entries, err)
+	}
+	if _, err := tr.Restore(entries[0].Path); !errors.Is(err, internal.ErrAlreadyExist) {
+		t.Errorf("Restore() error = %v, want %v", err, internal.ErrAlreadyExist)
+	}
+	if _, err := tr.Restore("/file"); !errors.Is(err, ErrNotEntry) {
+		t.Errorf("Restore() outside the trash error = %v, want %v", err, ErrNotEntry)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		remove  func(meta internal.MetaFileSystem) error
+		wantErr error
+		entries int
+	}{
+		{
+			name:    "file",
+			remove:  func(meta internal.MetaFileSystem) error { return meta.Remove("/dir/file") },
+			entries: 1,
+		},
+		{
+			name:    "non-empty directory",
+			remove:  func(meta internal.MetaFileSystem) error { return meta.Remove("/dir") },
+			wantErr: internal.ErrNotEmpty,
+		},
+		{
+			name:    "missing",
+			remove:  func(meta internal.MetaFileSystem) error { return meta.Remove("/missing") },
+			wantErr: internal.ErrNotFound,
+		},
+		{
+			name:   "missing with RemoveAll",
+			remove: func(meta internal.MetaFileSystem) error { return meta.RemoveAll("/missing") },
+		},
+		{
+			name:    "root",
+			remove:  func(meta internal.MetaFileSystem) error { return meta.RemoveAll("/") },
+			wantErr: internal.ErrInvalidRootOperation,
+		},
+		{
+			name: "same path twice",
+			remove: func(meta internal.MetaFileSystem) error {
+				if err := meta.Remove("/dir/file"); err != nil {
+					return err
+				}
+				if _, err := meta.Create("/dir/file", false); err != nil {
+					return err
+				}
+				return meta.Remove("/dir/file")
+			},
+			entries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, meta := setupTrash(t)
+			if err := meta.Mkdir("/dir"); err != nil {
+				t.Fatalf("setup failed: %v", err)
+			}
+			writeFile(t, tr, "/dir/file", "content")
+
+			if err := tt.remove(meta); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("remove error = %v, want %v", err, tt.wantErr)
+			}
+			entries, err := tr.List("fafda")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(entries) != tt.entries {
+				t.Errorf("List() = %d entries, want %d", len(entries), tt.entries)
+			}
+		})
+	}
+}
+
+func TestDeleteFromTrashIsFinal(t *testing.T) {
+	tr, meta := setupTrash(t)
+	node := writeFile(t, tr, "/file", "content")
+	if err := meta.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	if err := meta.RemoveAll(userDir("fafda")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := meta.Stat(userDir("fafda")); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if size, _ := tr.driver.GetSize(node.Id()); size != 0 {
+		t.Errorf("content size = %d, want content dropped", size)
+	}
+}
+
+func TestDeleteKeepsLinkedContent(t *testing.T) {
+	tr, meta := setupTrash(t)
+	node := writeFile(t, tr, "/file", "content")
+	if err := meta.Link("/file", "/link"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if err := meta.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	if err := meta.RemoveAll(userDir("fafda")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if size, _ := tr.driver.GetSize(node.Id()); size != 7 {
+		t.Errorf("content size = %d, want 7 kept for /link", size)
+	}
+
+	if err := meta.Remove("/link"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := meta.RemoveAll(userDir("fafda")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if size, _ := tr.driver.GetSize(node.Id()); size != 0 {
+		t.Errorf("content size = %d, want content dropped with the last link", size)
+	}
+}
+
+func TestOwnTrashOnly(t *testing.T) {
+	tr, meta := setupTrash(t)
+	writeFile(t, tr, "/file", "content")
+	if err := meta.Remove("/file"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	dir, err := meta.Stat(userDir("fafda"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if dir.Uid() != 1000 || dir.Gid() != 1000 || dir.Mode().Perm() != 0700 {
+		t.Errorf("trash of user = %d:%d %v, want 1000:1000 0700", dir.Uid(), dir.Gid(), dir.Mode().Perm())
+	}
+	nodes, err := meta.Ls("/", 0, 0)
+	if err != nil {
+		t.Fatalf("Ls() error = %v", err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("Ls(/) = %d nodes, want %s left out", len(nodes), Dir)
+	}
+	if err := meta.RemoveAll(Dir); !errors.Is(err, internal.ErrPermission) {
+		t.Errorf("RemoveAll(%s) error = %v, want %v", Dir, err, internal.ErrPermission)
+	}
+
+	tests := []struct {
+		name string
+		meta internal.MetaFileSystem
+	}{
+		{name: "other user", meta: tr.For("other", 1001, 1001)},
+		{name: "no user", meta: tr.For("", 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.meta.Stat(userDir("fafda")); !errors.Is(err, internal.ErrNotFound) {
+				t.Errorf("Stat() error = %v, want %v", err, internal.ErrNotFound)
+			}
+			if err := tt.meta.Rename(userDir("fafda"), "/stolen"); !errors.Is(err, internal.ErrNotFound) {
+				t.Errorf("Rename() error = %v, want %v", err, internal.ErrNotFound)
+			}
+			nodes, err := tt.meta.Ls(Dir, 0, 0)
+			if err != nil {
+				t.Fatalf("Ls() error = %v", err)
+			}
+			if len(nodes) != 0 {
+				t.Errorf("Ls(%s) = %d nodes, want none", Dir, len(nodes))
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	tr, meta := setupTrash(t)
+	expired := writeFile(t, tr, "/expired", "old")
+	kept := writeFile(t, tr, "/kept", "new")
+
+	if err := meta.Remove("/expired"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	deleted := tr.now()
+	tr.now = func() time.Time { return deleted.Add(30 * time.Minute) }
+	if err := meta.Remove("/kept"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	tr.now = func() time.Time { return deleted.Add(time.Hour) }
+	purged, err := tr.Purge()
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Purge() = %d, want 1", purged)
+	}
+
+	entries, err := tr.List("")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Original != "/kept" {
+		t.Errorf("List() after Purge = %+v, want only /kept", entries)
+	}
+	if size, _ := tr.driver.GetSize(expired.Id()); size != 0 {
+		t.Errorf("purged content size = %d, want 0", size)
+	}
+	if size, _ := tr.driver.GetSize(kept.Id()); size != int64(len("new")) {
+		t.Errorf("kept content size = %d, want %d", size, len("new"))
+	}
+}
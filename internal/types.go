@@ -2,6 +2,7 @@ package internal
 
 import (
 	"io"
+	"os"
 	"time"
 )
 
@@ -10,6 +11,12 @@ type MetaFileSystem interface {
 	Create(path string, isDir bool) (*Node, error)
 	Stat(path string) (*Node, error)
 	Ls(path string, limit int, offset int) ([]Node, error)
+
+	// LsCursor - list up to limit children (all when limit <= 0) after the
+	// opaque cursor, empty to start. The returned cursor resumes after the
+	// last entry and is empty once the listing is complete. Entries added
+	// or removed between calls do not shift the ones already returned
+	LsCursor(path string, limit int, cursor string) ([]Node, string, error)
 	Chtimes(path string, mtime time.Time) error
 	Touch(path string) error
 	Mkdir(path string) error
@@ -19,8 +26,50 @@ type MetaFileSystem interface {
 	Rename(oldpath, newpath string) error
 	Close() error
 
-	// Sync - update node's
+	// Sync - update node's size and mtime after its content changed, any
+	// stored digest is dropped as it no longer matches
 	Sync(path string, size int64) error
+	SetDigest(path string, digest Digest) error
+
+	// Put - store node as is at its path, replacing a node of the same
+	// kind. Used to restore metadata from elsewhere, the parent must exist
+	Put(node *Node) error
+
+	// Copy - create a file at dst with the size and digest of the one at
+	// src under a new id, the storage driver then shares its content
+	Copy(src, dst string) (*Node, error)
+
+	// Symlink - create a symbolic link at path to target, which is stored
+	// as given and never followed here
+	Symlink(target, path string) (*Node, error)
+
+	// Link - make newpath another entry of the file at oldpath, both share
+	// its id, size and times until all but one are removed
+	Link(oldpath, newpath string) error
+
+	// Chmod - replace the permission, setuid, setgid and sticky bits of
+	// the node at path, the rest of its mode stays
+	Chmod(path string, mode os.FileMode) error
+
+	// Chown - change the owner and group of the node at path, -1 keeps
+	// what is there
+	Chown(path string, uid, gid int) error
+
+	// GetXattr - value of the extended attribute name of the node at
+	// path, ErrNoXattr when it has none by that name
+	GetXattr(path, name string) (string, error)
+
+	// SetXattr - set the extended attribute name, hard links share their
+	// attributes and renames keep them
+	SetXattr(path, name, value string) error
+
+	// ListXattr - names of the extended attributes of the node at path in
+	// order
+	ListXattr(path string) ([]string, error)
+
+	// RemoveXattr - drop the extended attribute name, ErrNoXattr when
+	// there is none
+	RemoveXattr(path, name string) error
 }
 
 type StorageDriver interface {
@@ -29,3 +78,95 @@ type StorageDriver interface {
 	GetSize(fileId string) (int64, error)
 	Truncate(fileId string) error
 }
+
+// Verifier is implemented by storage drivers that can check the content
+// backing a file still exists as recorded, without downloading it.
+type Verifier interface {
+	Verify(fileId string) error
+}
+
+// Appender is implemented by storage drivers that can add content after
+// what is already stored for a file, without rewriting it.
+type Appender interface {
+	GetAppender(fileId string) (io.WriteCloser, error)
+}
+
+// RangeWriter is implemented by storage drivers that can change part of a
+// stored file in place. Writes show up once the returned writer is closed,
+// writes past the end grow the file and leave any gap zero filled.
+type RangeWriter interface {
+	GetWriterAt(fileId string) (WriteAtCloser, error)
+}
+
+// Resizer is implemented by storage drivers that can cut a stored file
+// short or grow it with zeros. path is recorded for recovery like
+// PathSetter does, empty when unknown.
+type Resizer interface {
+	Resize(fileId, path string, size int64) error
+}
+
+// Purger is implemented by storage drivers whose Truncate only forgets
+// the content of a file, Purge deletes it from the backend as well.
+type Purger interface {
+	Purge(fileId string) error
+}
+
+// Mover is implemented by storage drivers that can hand the content of a
+// file over to another id without copying it.
+type Mover interface {
+	Move(fromId, toId string) error
+}
+
+// Copier is implemented by storage drivers that can let another id refer
+// to the content of a file too, sharing what is stored instead of copying.
+type Copier interface {
+	Copy(fromId, toId string) error
+}
+
+// Usage is what an entry takes up, for a directory all the way down.
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+	Dirs  int64 `json:"dirs"`
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{Bytes: u.Bytes + other.Bytes, Files: u.Files + other.Files, Dirs: u.Dirs + other.Dirs}
+}
+
+func (u Usage) Neg() Usage {
+	return Usage{Bytes: -u.Bytes, Files: -u.Files, Dirs: -u.Dirs}
+}
+
+// UsageCounter is implemented by stores that keep the usage of every
+// directory up to date, so asking costs a single read. A directory counts
+// itself along with everything below it.
+type UsageCounter interface {
+	Usage(path string) (Usage, error)
+}
+
+// Quota is asked before what is below a directory grows.
+type Quota interface {
+	// Check - ErrQuotaExceeded when adding add at path would take a
+	// directory it is in over its quota
+	Check(path string, add Usage) error
+
+	// CheckMove - the same for moving what is at oldpath to newpath
+	CheckMove(oldpath, newpath string) error
+}
+
+// Versioner keeps the content a file has before it is replaced.
+type Versioner interface {
+	Save(node *Node) error
+}
+
+type WriteAtCloser interface {
+	io.WriterAt
+	io.Closer
+}
+
+// PathSetter is implemented by writers that record which path their
+// content belongs to, so metadata can be recovered from the backend alone.
+type PathSetter interface {
+	SetPath(path string)
+}
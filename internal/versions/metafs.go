@@ -0,0 +1,290 @@
+package versions
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"fafda/internal"
+)
+
+// MetaFs is the wrapped MetaFileSystem without Dir, versions are only
+// there for the Store. Looking into Dir finds nothing and writing to it
+// is refused, whatever path leads there.
+type MetaFs struct {
+	internal.MetaFileSystem
+}
+
+// Hide leaves Dir out of what meta shows and takes.
+func Hide(meta internal.MetaFileSystem) *MetaFs {
+	return &MetaFs{MetaFileSystem: meta}
+}
+
+func (m *MetaFs) Unwrap() internal.MetaFileSystem { return m.MetaFileSystem }
+
+func clean(pathStr string) string {
+	return path.Clean("/" + pathStr)
+}
+
+func inDir(p string) bool {
+	return p == Dir || strings.HasPrefix(p, Dir+"/")
+}
+
+// check cleans pathStr and refuses it when it is in Dir.
+func check(pathStr string, write bool) (string, error) {
+	p := clean(pathStr)
+	switch {
+	case !inDir(p):
+		return p, nil
+	case write:
+		return p, internal.ErrPermission
+	}
+	return p, internal.ErrNotFound
+}
+
+func (m *MetaFs) Stat(pathStr string) (*internal.Node, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Stat(p)
+}
+
+func (m *MetaFs) Ls(pathStr string, limit int, offset int) ([]internal.Node, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := m.MetaFileSystem.Ls(p, limit, offset)
+	return visible(p, nodes), err
+}
+
+func (m *MetaFs) LsCursor(pathStr string, limit int, cursor string) ([]internal.Node, string, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return nil, "", err
+	}
+	nodes, next, err := m.MetaFileSystem.LsCursor(p, limit, cursor)
+	return visible(p, nodes), next, err
+}
+
+// visible drops Dir from nodes, the children of dir. A page of a listing
+// of the root can come out short or even empty for it.
+func visible(dir string, nodes []internal.Node) []internal.Node {
+	if dir != "/" {
+		return nodes
+	}
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if path.Join(dir, node.Name()) != Dir {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+func (m *MetaFs) Create(pathStr string, isDir bool) (*internal.Node, error) {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Create(p, isDir)
+}
+
+func (m *MetaFs) Chtimes(pathStr string, mtime time.Time) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chtimes(p, mtime)
+}
+
+func (m *MetaFs) Touch(pathStr string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Touch(p)
+}
+
+func (m *MetaFs) Mkdir(pathStr string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Mkdir(p)
+}
+
+func (m *MetaFs) MkdirAll(pathStr string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.MkdirAll(p)
+}
+
+func (m *MetaFs) Remove(pathStr string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Remove(p)
+}
+
+func (m *MetaFs) RemoveAll(pathStr string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.RemoveAll(p)
+}
+
+func (m *MetaFs) Rename(oldpath, newpath string) error {
+	oldp, err := check(oldpath, true)
+	if err != nil {
+		return err
+	}
+	newp, err := check(newpath, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Rename(oldp, newp)
+}
+
+func (m *MetaFs) Sync(pathStr string, size int64) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Sync(p, size)
+}
+
+func (m *MetaFs) SetDigest(pathStr string, digest internal.Digest) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.SetDigest(p, digest)
+}
+
+func (m *MetaFs) Put(node *internal.Node) error {
+	if _, err := check(node.Path(), true); err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Put(node)
+}
+
+func (m *MetaFs) Copy(src, dst string) (*internal.Node, error) {
+	srcp, err := check(src, false)
+	if err != nil {
+		return nil, err
+	}
+	dstp, err := check(dst, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Copy(srcp, dstp)
+}
+
+func (m *MetaFs) Symlink(target, pathStr string) (*internal.Node, error) {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.Symlink(target, p)
+}
+
+func (m *MetaFs) Link(oldpath, newpath string) error {
+	oldp, err := check(oldpath, false)
+	if err != nil {
+		return err
+	}
+	newp, err := check(newpath, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Link(oldp, newp)
+}
+
+func (m *MetaFs) Chmod(pathStr string, mode os.FileMode) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chmod(p, mode)
+}
+
+func (m *MetaFs) Chown(pathStr string, uid, gid int) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.Chown(p, uid, gid)
+}
+
+func (m *MetaFs) GetXattr(pathStr, name string) (string, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return "", err
+	}
+	return m.MetaFileSystem.GetXattr(p, name)
+}
+
+func (m *MetaFs) SetXattr(pathStr, name, value string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.SetXattr(p, name, value)
+}
+
+func (m *MetaFs) ListXattr(pathStr string) ([]string, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.MetaFileSystem.ListXattr(p)
+}
+
+func (m *MetaFs) RemoveXattr(pathStr, name string) error {
+	p, err := check(pathStr, true)
+	if err != nil {
+		return err
+	}
+	return m.MetaFileSystem.RemoveXattr(p, name)
+}
+
+// usage is a UsageCounter that leaves out what Dir takes up.
+type usage struct {
+	internal.UsageCounter
+}
+
+// Usage is counter without the versions, so they count against no quota.
+func Usage(counter internal.UsageCounter) internal.UsageCounter {
+	return usage{UsageCounter: counter}
+}
+
+func (u usage) Usage(pathStr string) (internal.Usage, error) {
+	p, err := check(pathStr, false)
+	if err != nil {
+		return internal.Usage{}, err
+	}
+	total, err := u.UsageCounter.Usage(p)
+	if err != nil || p != "/" {
+		return total, err
+	}
+	versions, err := u.UsageCounter.Usage(Dir)
+	if errors.Is(err, internal.ErrNotFound) {
+		return total, nil
+	}
+	if err != nil {
+		return internal.Usage{}, err
+	}
+	return internal.Usage{
+		Bytes: total.Bytes - versions.Bytes,
+		Files: total.Files - versions.Files,
+		Dirs:  total.Dirs - versions.Dirs,
+	}, nil
+}
@@ -0,0 +1,360 @@
+package versions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+	"fafda/internal/filesystem"
+)
+
+// Dir holds a directory per file id with the content that file had before
+// each overwrite, named after when it was replaced:
+// /.versions/<file id>/20261018T101500.000000000Z
+// The id survives renames, so versions follow the file around.
+const Dir = "/.versions"
+
+const stampLayout = "20060102T150405.000000000Z"
+
+// Store keeps the last Keep versions of every file and drops versions older
+// than Window, zero turns either limit off.
+type Store struct {
+	meta   internal.MetaFileSystem
+	driver internal.StorageDriver
+	mover  internal.Mover
+	keep   int
+	window time.Duration
+	now    func() time.Time
+}
+
+type Version struct {
+	Id       string          `json:"id"`
+	Size     int64           `json:"size"`
+	ModTime  time.Time       `json:"modTime"`  // when this content was written
+	Replaced time.Time       `json:"replaced"` // when it was overwritten
+	Digest   internal.Digest `json:"digest"`
+}
+
+func New(meta internal.MetaFileSystem, driver internal.StorageDriver, keep int, window time.Duration) (*Store, error) {
+	mover, ok := driver.(internal.Mover)
+	if !ok {
+		return nil, fmt.Errorf("storage driver cannot keep versions")
+	}
+	return &Store{
+		meta:   meta,
+		driver: driver,
+		mover:  mover,
+		keep:   keep,
+		window: window,
+		now:    time.Now,
+	}, nil
+}
+
+func dirOf(fileId string) string {
+	return path.Join(Dir, fileId)
+}
+
+// Save moves the content of node aside as its newest version, the caller
+// replaces it right after. Empty files have nothing worth keeping.
+func (s *Store) Save(node *internal.Node) error {
+	if err := s.save(node); err != nil {
+		return err
+	}
+	return s.prune(node.Id(), s.now())
+}
+
+func (s *Store) save(node *internal.Node) error {
+	if node.IsDir() || node.Size() == 0 {
+		return nil
+	}
+	dir := dirOf(node.Id())
+	if err := s.meta.MkdirAll(dir); err != nil {
+		return err
+	}
+
+	replaced := s.now().UTC()
+	for {
+		_, err := s.meta.Stat(path.Join(dir, replaced.Format(stampLayout)))
+		if errors.Is(err, internal.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Replaced twice within the same nanosecond
+		replaced = replaced.Add(time.Nanosecond)
+	}
+
+	version := internal.NewNode(path.Join(dir, replaced.Format(stampLayout)), false).
+		SetSize(node.Size()).
+		SetModTime(node.ModTime()).
+		SetDigest(node.Digest()).
+		SetXattrs(node.Xattrs())
+	if err := s.mover.Move(node.Id(), version.Id()); err != nil {
+		return err
+	}
+	return s.meta.Put(version)
+}
+
+// file is the file at p, which can't be a version itself.
+func (s *Store) file(p string) (*internal.Node, error) {
+	if inDir(clean(p)) {
+		return nil, internal.ErrNotFound
+	}
+	node, err := s.meta.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return nil, internal.ErrIsDir
+	}
+	return node, nil
+}
+
+// List returns the versions of the file at p, newest first.
+func (s *Store) List(p string) ([]Version, error) {
+	node, err := s.file(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.list(node.Id())
+}
+
+func (s *Store) list(fileId string) ([]Version, error) {
+	nodes, _, err := s.meta.LsCursor(dirOf(fileId), 0, "")
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version, 0, len(nodes))
+	for _, node := range nodes {
+		replaced, err := time.Parse(stampLayout, node.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, Version{
+			Id:       node.Name(),
+			Size:     node.Size(),
+			ModTime:  node.ModTime(),
+			Replaced: replaced,
+			Digest:   node.Digest(),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Replaced.After(versions[j].Replaced) })
+	return versions, nil
+}
+
+// version returns the file at p and its version id.
+func (s *Store) version(p, id string) (*internal.Node, *internal.Node, error) {
+	node, err := s.file(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.Contains(id, "/") {
+		return nil, nil, internal.ErrNotFound
+	}
+	version, err := s.meta.Stat(path.Join(dirOf(node.Id()), id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, version, nil
+}
+
+// Open opens version id of the file at p for reading, versions can't be
+// changed other than by restoring them.
+func (s *Store) Open(p, id string) (afero.File, error) {
+	_, version, err := s.version(p, id)
+	if err != nil {
+		return nil, err
+	}
+	return filesystem.NewFile(os.O_RDONLY, version, s.meta, s.driver, nil, nil), nil
+}
+
+// Restore makes version id the content of the file at p again. What the
+// file held until then is kept as its newest version, so a restore can be
+// undone the same way.
+func (s *Store) Restore(p, id string) error {
+	node, version, err := s.version(p, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.save(node); err != nil {
+		return err
+	}
+	if err := s.mover.Move(version.Id(), node.Id()); err != nil {
+		return err
+	}
+	if err := s.meta.Remove(version.Path()); err != nil {
+		return err
+	}
+	if err := s.meta.Sync(p, version.Size()); err != nil {
+		return err
+	}
+	if err := s.meta.SetDigest(p, version.Digest()); err != nil {
+		return err
+	}
+	return s.prune(node.Id(), s.now())
+}
+
+// prune drops the versions of fileId beyond the limits.
+func (s *Store) prune(fileId string, now time.Time) error {
+	versions, err := s.list(fileId)
+	if err != nil {
+		return err
+	}
+
+	dropped := 0
+	var errs []error
+	for i, v := range versions {
+		tooMany := s.keep > 0 && i >= s.keep
+		tooOld := s.window > 0 && now.Sub(v.Replaced) > s.window
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := s.drop(path.Join(dirOf(fileId), v.Id)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dropped++
+	}
+	if len(versions) > 0 && dropped == len(versions) {
+		// Every version went, the directory is not needed anymore
+		errs = append(errs, s.meta.Remove(dirOf(fileId)))
+	}
+	return errors.Join(errs...)
+}
+
+// drop deletes a version along with its content.
+func (s *Store) drop(versionPath string) error {
+	version, err := s.meta.Stat(versionPath)
+	if err != nil {
+		return err
+	}
+	if err := s.meta.Remove(version.Path()); err != nil {
+		return err
+	}
+	return internal.DropContent(s.driver, version.Id())
+}
+
+// Prune applies the limits to every file and drops the versions of files
+// that no longer exist. It walks the whole namespace to find those.
+func (s *Store) Prune(ctx context.Context) (int, error) {
+	live := map[string]bool{}
+	if err := walk(ctx, s.meta, "/", func(node *internal.Node) {
+		live[node.Id()] = true
+	}); err != nil {
+		return 0, err
+	}
+
+	dirs, _, err := s.meta.LsCursor(Dir, 0, "")
+	if errors.Is(err, internal.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	var errs []error
+	now := s.now()
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return dropped, err
+		}
+		fileId := dir.Name()
+		before, err := s.list(fileId)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if live[fileId] {
+			err = s.prune(fileId, now)
+		} else {
+			err = s.dropAll(fileId, before)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		after, _ := s.list(fileId)
+		dropped += len(before) - len(after)
+	}
+	return dropped, errors.Join(errs...)
+}
+
+func (s *Store) dropAll(fileId string, versions []Version) error {
+	var errs []error
+	for _, v := range versions {
+		errs = append(errs, s.drop(path.Join(dirOf(fileId), v.Id)))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return s.meta.Remove(dirOf(fileId))
+}
+
+// walk calls fn for every file outside Dir.
+func walk(ctx context.Context, meta internal.MetaFileSystem, dir string, fn func(*internal.Node)) error {
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		nodes, next, err := meta.LsCursor(dir, 1000, cursor)
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			p := path.Join(dir, nodes[i].Name())
+			switch {
+			case p == Dir, nodes[i].IsSymlink():
+			case nodes[i].IsDir():
+				if err := walk(ctx, meta, p, fn); err != nil {
+					return err
+				}
+			default:
+				fn(&nodes[i])
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Schedule prunes every interval until ctx is done.
+func (s *Store) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		dropped, err := s.Prune(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("component", "versions").Msg("prune failed")
+		}
+		if dropped > 0 {
+			log.Info().Str("component", "versions").Int("versions", dropped).Msg("versions pruned")
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package versions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"fafda/internal"
+	"fafda/internal/filesystem"
+	"fafda/internal/memory"
+)
+
+type setup struct {
+	store *Store
+	meta  internal.MetaFileSystem
+	fs    afero.Fs
+	now   time.Time
+}
+
+func newSetup(t *testing.T, keep int, window time.Duration) *setup {
+	t.Helper()
+	meta := memory.NewMetaFs()
+	driver := memory.NewDriver()
+	store, err := New(meta, driver, keep, window)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	s := &setup{store: store, meta: meta, fs: filesystem.New(driver, Hide(meta), store, nil)}
+	s.now = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store.now = func() time.Time { return s.now }
+	return s
+}
+
+func (s *setup) write(t *testing.T, p, content string) {
+	t.Helper()
+	if err := afero.WriteFile(s.fs, p, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(%s) error = %v", p, err)
+	}
+	s.now = s.now.Add(time.Minute)
+}
+
+func (s *setup) read(t *testing.T, p string) string {
+	t.Helper()
+	data, err := afero.ReadFile(s.fs, p)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error = %v", p, err)
+	}
+	return string(data)
+}
+
+func (s *setup) versions(t *testing.T, p string) []string {
+	t.Helper()
+	list, err := s.store.List(p)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var contents []string
+	for _, v := range list {
+		file, err := s.store.Open(p, v.Id)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOverwriteKeepsVersions(t *testing.T) {
+	s := newSetup(t, 2, 0)
+	for _, content := range []string{"one", "two", "three", "four"} {
+		s.write(t, "/file", content)
+	}
+
+	if got := s.read(t, "/file"); got != "four" {
+		t.Errorf("content = %q, want %q", got, "four")
+	}
+	if got, want := s.versions(t, "/file"), []string{"three", "two"}; !equal(got, want) {
+		t.Errorf("versions = %q, want %q", got, want)
+	}
+
+	// Versions follow the file
+	if err := s.fs.Rename("/file", "/moved"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if got, want := s.versions(t, "/moved"), []string{"three", "two"}; !equal(got, want) {
+		t.Errorf("versions after rename = %q, want %q", got, want)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s := newSetup(t, 0, 0)
+	s.write(t, "/file", "one")
+	s.write(t, "/file", "two")
+
+	list, err := s.store.List("/file")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %v, %v", list, err)
+	}
+	if err := s.store.Restore("/file", list[0].Id); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if got := s.read(t, "/file"); got != "one" {
+		t.Errorf("content = %q, want %q", got, "one")
+	}
+	node, err := s.meta.Stat("/file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if node.Size() != 3 || node.Digest() != list[0].Digest {
+		t.Errorf("restored node size %d digest %+v, want 3 and %+v", node.Size(), node.Digest(), list[0].Digest)
+	}
+	if got, want := s.versions(t, "/file"), []string{"two"}; !equal(got, want) {
+		t.Errorf("versions = %q, want %q", got, want)
+	}
+
+	if err := s.store.Restore("/file", "20000101T000000.000000000Z"); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Restore() of a missing version error = %v, want %v", err, internal.ErrNotFound)
+	}
+}
+
+func TestTruncateFlagKeepsVersion(t *testing.T) {
+	s := newSetup(t, 0, 0)
+	s.write(t, "/file", "one")
+
+	f, err := s.fs.OpenFile("/file", os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if _, err := io.WriteString(f, "two"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got, want := s.versions(t, "/file"), []string{"one"}; !equal(got, want) {
+		t.Errorf("versions = %q, want %q", got, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := newSetup(t, 0, time.Hour)
+	s.write(t, "/old", "one")
+	s.write(t, "/old", "two")
+	s.write(t, "/deleted", "one")
+	s.write(t, "/deleted", "two")
+	if err := s.fs.Remove("/deleted"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	s.now = s.now.Add(30 * time.Minute)
+	s.write(t, "/recent", "one")
+	s.write(t, "/recent", "two")
+
+	s.now = s.now.Add(31 * time.Minute)
+	dropped, err := s.store.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if dropped != 2 {
+		t.Errorf("Prune() = %d, want 2", dropped)
+	}
+	if got := s.versions(t, "/old"); len(got) != 0 {
+		t.Errorf("versions of /old = %q, want none", got)
+	}
+	if got, want := s.versions(t, "/recent"), []string{"one"}; !equal(got, want) {
+		t.Errorf("versions of /recent = %q, want %q", got, want)
+	}
+	entries, _, err := s.meta.LsCursor(Dir, 0, "")
+	if err != nil {
+		t.Fatalf("LsCursor() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%s holds %d files, want only /recent", Dir, len(entries))
+	}
+}
+
+func TestHidden(t *testing.T) {
+	s := newSetup(t, 0, 0)
+	s.write(t, "/file", "one")
+	s.write(t, "/file", "two")
+	node, err := s.meta.Stat("/file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	list, err := s.store.List("/file")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %v, %v", list, err)
+	}
+	versionPath := dirOf(node.Id()) + "/" + list[0].Id
+
+	names, err := afero.ReadDir(s.fs, "/")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(names) != 1 || names[0].Name() != "file" {
+		t.Errorf("ReadDir(/) = %d entries, want only file", len(names))
+	}
+	if _, err := s.fs.Stat(versionPath); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("Stat() error = %v, want %v", err, internal.ErrNotFound)
+	}
+	if err := afero.WriteFile(s.fs, versionPath, []byte("changed"), 0644); !errors.Is(err, internal.ErrPermission) {
+		t.Errorf("WriteFile() error = %v, want %v", err, internal.ErrPermission)
+	}
+	if err := s.fs.Rename("/file", Dir+"/file"); !errors.Is(err, internal.ErrPermission) {
+		t.Errorf("Rename() into %s error = %v, want %v", Dir, err, internal.ErrPermission)
+	}
+	if err := s.fs.RemoveAll(Dir); !errors.Is(err, internal.ErrPermission) {
+		t.Errorf("RemoveAll() error = %v, want %v", err, internal.ErrPermission)
+	}
+	if _, err := s.store.List(versionPath); !errors.Is(err, internal.ErrNotFound) {
+		t.Errorf("List() of a version error = %v, want %v", err, internal.ErrNotFound)
+	}
+
+	file, err := s.store.Open("/file", list[0].Id)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte("changed")); err == nil {
+		t.Errorf("Write() to a version succeeded")
+	}
+}
+
+func TestHiddenPaged(t *testing.T) {
+	s := newSetup(t, 0, 0)
+	s.write(t, "/file", "one")
+	s.write(t, "/file", "two")
+
+	dir, err := s.fs.Open("/")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer dir.Close()
+	var names []string
+	for {
+		page, err := dir.Readdirnames(1)
+		names = append(names, page...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames() error = %v", err)
+		}
+	}
+	if len(names) != 1 || names[0] != "file" {
+		t.Errorf("Readdirnames() one at a time = %v, want [file]", names)
+	}
+}
+
+type usageCounter map[string]internal.Usage
+
+func (c usageCounter) Usage(p string) (internal.Usage, error) {
+	usage, ok := c[p]
+	if !ok {
+		return internal.Usage{}, internal.ErrNotFound
+	}
+	return usage, nil
+}
+
+func TestUsage(t *testing.T) {
+	counter := usageCounter{
+		"/":         {Bytes: 100, Files: 4, Dirs: 4},
+		"/dir":      {Bytes: 40, Files: 1, Dirs: 1},
+		Dir:         {Bytes: 60, Files: 3, Dirs: 2},
+		Dir + "/id": {Bytes: 60, Files: 3, Dirs: 1},
+	}
+	tests := []struct {
+		path    string
+		want    internal.Usage
+		wantErr error
+	}{
+		{path: "/", want: internal.Usage{Bytes: 40, Files: 1, Dirs: 2}},
+		{path: "/dir", want: internal.Usage{Bytes: 40, Files: 1, Dirs: 1}},
+		{path: Dir, wantErr: internal.ErrNotFound},
+		{path: Dir + "/id", wantErr: internal.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := Usage(counter).Usage(tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Usage() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Usage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
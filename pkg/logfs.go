@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -47,12 +48,11 @@ func (lff *LogFile) logOperation(err error, operation string, fields map[string]
 		event = lff.logger.Error().Err(err)
 	}
 
-	fields["name"] = lff.name
 	for key, value := range fields {
 		event = event.Interface(key, value)
 	}
 
-	event.Str("operation", operation).Send()
+	event.Str("name", lff.name).Str("operation", operation).Send()
 }
 
 func (lf *LogFS) newLogFile(file afero.File, err error) (afero.File, error) {
@@ -127,6 +127,76 @@ func (lf *LogFS) Rename(oldname, newname string) error {
 	return err
 }
 
+// Copy is passed on to filesystems that can copy files themselves.
+func (lf *LogFS) Copy(src, dst string) error {
+	var err error = &os.LinkError{Op: "copy", Old: src, New: dst, Err: errors.ErrUnsupported}
+	if copier, ok := lf.src.(interface{ Copy(src, dst string) error }); ok {
+		err = copier.Copy(src, dst)
+	}
+	lf.logOperation(err, "COPY", map[string]interface{}{
+		"src": src,
+		"dst": dst,
+	})
+	return err
+}
+
+// CopyOver is passed on to filesystems that can copy over a file safely.
+func (lf *LogFS) CopyOver(src, dst string) error {
+	var err error = &os.LinkError{Op: "copy", Old: src, New: dst, Err: errors.ErrUnsupported}
+	if copier, ok := lf.src.(interface{ CopyOver(src, dst string) error }); ok {
+		err = copier.CopyOver(src, dst)
+	}
+	lf.logOperation(err, "COPY_OVER", map[string]interface{}{
+		"src": src,
+		"dst": dst,
+	})
+	return err
+}
+
+type linker interface {
+	Link(oldname, newname string) error
+}
+
+// Link is passed on to filesystems that can hard link files.
+func (lf *LogFS) Link(oldname, newname string) error {
+	var err error = &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	if linker, ok := lf.src.(linker); ok {
+		err = linker.Link(oldname, newname)
+	}
+	lf.logOperation(err, "LINK", map[string]interface{}{
+		"oldname": oldname,
+		"newname": newname,
+	})
+	return err
+}
+
+func (lf *LogFS) SymlinkIfPossible(oldname, newname string) error {
+	var err error = &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	if linker, ok := lf.src.(afero.Linker); ok {
+		err = linker.SymlinkIfPossible(oldname, newname)
+	}
+	lf.logOperation(err, "SYMLINK", map[string]interface{}{
+		"oldname": oldname,
+		"newname": newname,
+	})
+	return err
+}
+
+func (lf *LogFS) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := lf.src.(afero.LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+}
+
+func (lf *LogFS) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lstater, ok := lf.src.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	info, err := lf.src.Stat(name)
+	return info, false, err
+}
+
 func (lff *LogFile) Close() error {
 	err := lff.src.Close()
 	lff.logOperation(err, "CLOSE", map[string]interface{}{
@@ -0,0 +1,9 @@
+module github.com/fclairamb/ftpserverlib
+
+go 1.21
+
+require (
+	github.com/fclairamb/go-log v0.5.0
+	github.com/spf13/afero v1.11.0
+	golang.org/x/sys v0.28.0
+)